@@ -6,7 +6,9 @@ require (
 	github.com/gorilla/mux v1.8.1
 	github.com/joho/godotenv v1.5.1
 	github.com/mholt/archives v0.1.5
+	golang.org/x/image v0.25.0
 	golang.org/x/sys v0.47.0
+	golang.org/x/text v0.39.0
 )
 
 require (
@@ -27,5 +29,4 @@ require (
 	github.com/spf13/afero v1.15.0 // indirect
 	github.com/ulikunitz/xz v0.5.15 // indirect
 	go4.org v0.0.0-20230225012048-214862532bf5 // indirect
-	golang.org/x/text v0.39.0 // indirect
 )
@@ -23,6 +23,9 @@ func Run(args []string) int {
 	if len(args) > 0 && args[0] == "healthcheck" {
 		return health.Run()
 	}
+	if len(args) > 0 && args[0] == "thumbnails" {
+		return runThumbnails(args[1:], os.Stdout, os.Stderr)
+	}
 
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	cfg := config.Load(logger)
@@ -38,6 +41,7 @@ func Run(args []string) int {
 
 	logger.Info("Server starting", "port", cfg.Port)
 	logger.Info("Storage directory", "path", cfg.StorageDir)
+	logger.Info("Thumbnail cache", "path", cfg.ThumbnailDir, "maxSizeMB", cfg.ThumbnailMaxSizeMB, "maxFiles", cfg.ThumbnailMaxFiles)
 	if len(cfg.MountDirs) > 0 {
 		logger.Info("Mount directories", "paths", cfg.MountDirs)
 	}
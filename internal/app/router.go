@@ -23,19 +23,50 @@ func NewRouter(cfg *types.Config, handler *handlers.Handler) http.Handler {
 	api.HandleFunc("/files/upload-sessions/{id}", handler.AbortUpload).Methods("DELETE")
 	api.HandleFunc("/files/upload-sessions/{id}/chunks", handler.UploadChunk).Methods("PUT")
 	api.HandleFunc("/files/upload-sessions/{id}/complete", handler.CompleteUpload).Methods("POST")
+	api.HandleFunc("/files/tus", handler.TusOptions).Methods("OPTIONS")
+	api.HandleFunc("/files/tus", handler.TusCreate).Methods("POST")
+	api.HandleFunc("/files/tus/{id}", handler.TusOptions).Methods("OPTIONS")
+	api.HandleFunc("/files/tus/{id}", handler.TusHead).Methods("HEAD")
+	api.HandleFunc("/files/tus/{id}", handler.TusPatch).Methods("PATCH")
+	api.HandleFunc("/files/tus/{id}", handler.TusTerminate).Methods("DELETE")
+	api.HandleFunc("/files/tus/{id}", handler.TusMethodOverride).Methods("POST")
 	api.HandleFunc("/files/download", handler.DownloadFile).Methods("GET")
 	api.HandleFunc("/files/content", handler.GetFileContent).Methods("GET")
 	api.HandleFunc("/files/download-zip", handler.DownloadZip).Methods("POST")
 	api.HandleFunc("/files/download-zip/{token}", handler.DownloadPreparedZip).Methods("GET")
 	api.HandleFunc("/files/save", handler.SaveFile).Methods("POST")
 	api.HandleFunc("/files/delete", handler.DeleteMultipleFiles).Methods("POST")
+	api.HandleFunc("/trash", handler.ListTrash).Methods("GET")
+	api.HandleFunc("/trash", handler.EmptyTrash).Methods("DELETE")
+	api.HandleFunc("/trash/restore", handler.RestoreTrash).Methods("POST")
+	api.HandleFunc("/trash/purge", handler.PurgeTrash).Methods("POST")
 	api.HandleFunc("/files/mkdir", handler.CreateDirectory).Methods("POST")
 	api.HandleFunc("/files/move", handler.MoveFile).Methods("POST")
+	api.HandleFunc("/files/copy", handler.CopyFile).Methods("POST")
 	api.HandleFunc("/files/create", handler.CreateFile).Methods("POST")
 	api.HandleFunc("/files/extract", handler.ExtractFile).Methods("POST")
+	api.HandleFunc("/jobs", handler.ListJobs).Methods("GET")
+	api.HandleFunc("/jobs/{id}", handler.GetJob).Methods("GET")
+	api.HandleFunc("/jobs/{id}", handler.CancelJob).Methods("DELETE")
 	api.HandleFunc("/files/thumbnail", handler.Thumbnail).Methods("GET")
+	api.HandleFunc("/files/storyboard", handler.Storyboard).Methods("GET")
+	api.HandleFunc("/files/storyboard/sprite", handler.StoryboardSprite).Methods("GET")
+	api.HandleFunc("/files/media-info", handler.MediaInfo).Methods("GET")
+	api.HandleFunc("/files/exif", handler.ImageMetadata).Methods("GET")
+	api.HandleFunc("/files/subtitles", handler.Subtitles).Methods("GET")
+	api.HandleFunc("/files/subtitles/vtt", handler.SubtitleTrack).Methods("GET")
+	api.HandleFunc("/playlists", handler.OpenPlaylist).Methods("GET")
+	api.HandleFunc("/playlists/export", handler.ExportPlaylist).Methods("GET", "POST")
+	api.HandleFunc("/thumbnails/stats", handler.ThumbnailStats).Methods("GET")
+	api.HandleFunc("/thumbnails/purge", handler.PurgeThumbnails).Methods("POST")
+	api.HandleFunc("/thumbnails/warm", handler.ThumbnailWarmStatus).Methods("GET")
+	api.HandleFunc("/thumbnails/warm", handler.StartThumbnailWarm).Methods("POST")
+	api.HandleFunc("/thumbnails/warm", handler.StopThumbnailWarm).Methods("DELETE")
+	api.HandleFunc("/thumbnails/warm/pause", handler.PauseThumbnailWarm).Methods("POST")
+	api.HandleFunc("/thumbnails/warm/resume", handler.ResumeThumbnailWarm).Methods("POST")
 	api.HandleFunc("/config", handler.GetConfig).Methods("GET")
 	api.HandleFunc("/search", handler.SearchFiles).Methods("POST")
+	api.HandleFunc("/search/stream", handler.SearchFilesStream).Methods("POST")
 	api.HandleFunc("/storage-info", handler.GetStorageInfo).Methods("GET")
 	api.HandleFunc("/specific-dirs", handler.GetSpecificDirs).Methods("GET")
 	api.HandleFunc("/health", handler.HealthCheck).Methods("GET")
@@ -46,6 +77,9 @@ func NewRouter(cfg *types.Config, handler *handlers.Handler) http.Handler {
 		api.HandleFunc("/system/aria2c/control", handler.ControlAria2cDownload).Methods("POST")
 	}
 
+	// Subsonic clients call /rest/<method>.view outside the /api prefix.
+	r.HandleFunc("/rest/{method}", handler.Subsonic).Methods("GET", "POST")
+
 	staticFileHandler := http.StripPrefix("/static/", middleware.StaticCache(http.FileServer(http.Dir("./static/"))))
 	r.PathPrefix("/static/").Handler(staticFileHandler)
 	indexETag := ""
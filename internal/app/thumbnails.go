@@ -0,0 +1,60 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"puremania/internal/config"
+	"puremania/internal/handlers"
+)
+
+const thumbnailsUsage = "usage: puremania thumbnails warm [-sizes 160,320,640] <path>"
+
+// runThumbnails handles "puremania thumbnails warm <path>", which fills the
+// thumbnail cache from the command line with the server's configuration.
+func runThumbnails(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "warm" {
+		_, _ = fmt.Fprintln(stderr, thumbnailsUsage)
+		return 2
+	}
+	flags := flag.NewFlagSet("thumbnails warm", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	sizeList := flags.String("sizes", "", "comma-separated thumbnail sizes (default 320)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		_, _ = fmt.Fprintln(stderr, thumbnailsUsage)
+		return 2
+	}
+	var sizes []int
+	for _, value := range strings.Split(*sizeList, ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "invalid size %q\n", value)
+			return 2
+		}
+		sizes = append(sizes, size)
+	}
+
+	logger := slog.New(slog.NewJSONHandler(stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	cfg := config.Load(logger)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := handlers.WarmThumbnails(ctx, cfg, logger, flags.Arg(0), sizes, stdout); err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"log/slog"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -18,6 +20,13 @@ const (
 	defaultZipTimeout                  = 300
 	defaultMaxZipSize            int64 = 1024
 	defaultUploadSessionTTLHours       = 168
+	defaultTrashTTLHours               = 720
+	defaultTrashMaxSizeMB        int64 = 0
+	defaultSearchIndexDir              = ".cache/search-index"
+	defaultThumbnailDir                = ".cache/thumbnails"
+	defaultThumbnailMaxSizeMB    int64 = 256
+	defaultThumbnailMaxFiles           = 4096
+	defaultSubsonicMusicDir            = "/"
 	maxConfigSizeMB              int64 = (1<<63 - 1) / (1 << 20)
 	maxDurationSeconds           int64 = (1<<63 - 1) / int64(time.Second)
 	maxDurationHours             int   = (1<<63 - 1) / int(time.Hour)
@@ -37,6 +46,14 @@ func Load(logger *slog.Logger) *types.Config {
 		SpecificDirs:          getEnvAsStringSlice("SPECIFIC_DIRS", []string{}),
 		UploadSessionTTLHours: getEnvAsInt(logger, "UPLOAD_SESSION_TTL_HOURS", defaultUploadSessionTTLHours),
 		PreallocateUploads:    getEnvAsBool("UPLOAD_PREALLOCATE", true),
+		TrashTTLHours:         getEnvAsInt(logger, "TRASH_TTL_HOURS", defaultTrashTTLHours),
+		TrashMaxSizeMB:        getEnvAsInt64(logger, "TRASH_MAX_SIZE_MB", defaultTrashMaxSizeMB),
+		SearchIndexDir:        getEnv("SEARCH_INDEX_DIR", defaultSearchIndexDir),
+		ThumbnailDir:          getEnv("THUMBNAIL_DIR", defaultThumbnailDir),
+		ThumbnailMaxSizeMB:    getEnvAsInt64(logger, "THUMBNAIL_MAX_SIZE_MB", defaultThumbnailMaxSizeMB),
+		ThumbnailMaxFiles:     getEnvAsInt(logger, "THUMBNAIL_MAX_FILES", defaultThumbnailMaxFiles),
+		SubsonicMusicDir:      getEnv("SUBSONIC_MUSIC_DIR", defaultSubsonicMusicDir),
+		PublicURL:             getEnv("PUBLIC_URL", ""),
 	}
 	validateConfig(logger, config)
 	config.Aria2cEnabled = strings.EqualFold(getEnv("ARIA2C", "disable"), "enable")
@@ -76,6 +93,47 @@ func validateConfig(logger *slog.Logger, config *types.Config) {
 		logger.Warn("Invalid UPLOAD_SESSION_TTL_HOURS; using fallback", "value", config.UploadSessionTTLHours, "fallback", defaultUploadSessionTTLHours)
 		config.UploadSessionTTLHours = defaultUploadSessionTTLHours
 	}
+	if config.TrashTTLHours <= 0 || config.TrashTTLHours > maxDurationHours {
+		logger.Warn("Invalid TRASH_TTL_HOURS; using fallback", "value", config.TrashTTLHours, "fallback", defaultTrashTTLHours)
+		config.TrashTTLHours = defaultTrashTTLHours
+	}
+	if config.TrashMaxSizeMB < 0 || config.TrashMaxSizeMB > maxConfigSizeMB {
+		logger.Warn("Invalid TRASH_MAX_SIZE_MB; using fallback", "value", config.TrashMaxSizeMB, "fallback", defaultTrashMaxSizeMB)
+		config.TrashMaxSizeMB = defaultTrashMaxSizeMB
+	}
+	// Like the thumbnail cache, the index must not move with the working
+	// directory of a later start.
+	if config.SearchIndexDir != "" {
+		if absolute, err := filepath.Abs(config.SearchIndexDir); err == nil {
+			config.SearchIndexDir = absolute
+		}
+	}
+	if config.ThumbnailDir == "" {
+		config.ThumbnailDir = defaultThumbnailDir
+	}
+	// Resolve the cache once, so it does not depend on the working directory
+	// of later callers.
+	if absolute, err := filepath.Abs(config.ThumbnailDir); err == nil {
+		config.ThumbnailDir = absolute
+	}
+	if config.ThumbnailMaxSizeMB <= 0 || config.ThumbnailMaxSizeMB > maxConfigSizeMB {
+		logger.Warn("Invalid THUMBNAIL_MAX_SIZE_MB; using fallback", "value", config.ThumbnailMaxSizeMB, "fallback", defaultThumbnailMaxSizeMB)
+		config.ThumbnailMaxSizeMB = defaultThumbnailMaxSizeMB
+	}
+	if config.ThumbnailMaxFiles <= 0 {
+		logger.Warn("Invalid THUMBNAIL_MAX_FILES; using fallback", "value", config.ThumbnailMaxFiles, "fallback", defaultThumbnailMaxFiles)
+		config.ThumbnailMaxFiles = defaultThumbnailMaxFiles
+	}
+	// SUBSONIC_MUSIC_DIR is a virtual path such as /Music, not a directory on disk.
+	config.SubsonicMusicDir = path.Clean("/" + config.SubsonicMusicDir)
+	if config.PublicURL != "" {
+		parsed, err := url.Parse(config.PublicURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.User != nil || parsed.RawQuery != "" || parsed.Fragment != "" {
+			logger.Warn("Invalid PUBLIC_URL; playlist export is disabled", "value", config.PublicURL)
+			config.PublicURL = ""
+		}
+		config.PublicURL = strings.TrimRight(config.PublicURL, "/")
+	}
 }
 
 func getEnv(key, fallback string) string {
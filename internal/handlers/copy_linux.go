@@ -0,0 +1,15 @@
+//go:build linux
+
+package handlers
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile shares the source extents with dst on filesystems that support
+// reflinks (Btrfs, XFS, bcachefs). Any error means the caller must copy bytes.
+func cloneFile(dst, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
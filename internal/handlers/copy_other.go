@@ -0,0 +1,10 @@
+//go:build !linux
+
+package handlers
+
+import (
+	"errors"
+	"os"
+)
+
+func cloneFile(_, _ *os.File) error { return errors.ErrUnsupported }
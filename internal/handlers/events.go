@@ -4,16 +4,79 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// eventReplayBufferSize is how many published events the broker keeps for
+// clients that reconnect with Last-Event-ID.
+const eventReplayBufferSize = 1024
+
 type serverEvent struct {
+	id   uint64 // assigned by eventBroker.publish; zero for per-connection events
 	name string
 	key  string
 	data interface{}
 }
 
+// File mutation events name the virtual paths a request changed, so other tabs
+// and devices learn about them without polling.
+const (
+	eventFilesCreated     = "files-created"
+	eventFilesDeleted     = "files-deleted"
+	eventFilesMoved       = "files-moved"
+	eventFileSaved        = "file-saved"
+	eventArchiveExtracted = "archive-extracted"
+
+	// maxEventPaths bounds a merged event; beyond it the event only says that
+	// the directory changed.
+	maxEventPaths = 1000
+)
+
+// fileChange is the payload of a file mutation event. All paths are virtual
+// and share Directory as their parent. From holds the source of each path for
+// moves and extractions.
+type fileChange struct {
+	Directory string   `json:"directory"`
+	Paths     []string `json:"paths,omitempty"`
+	From      []string `json:"from,omitempty"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// merge combines two pending changes of the same kind in the same directory,
+// dropping repeated paths.
+func (c fileChange) merge(next fileChange) fileChange {
+	merged := fileChange{Directory: c.Directory}
+	if c.Truncated || next.Truncated {
+		merged.Truncated = true
+		return merged
+	}
+	seen := make(map[[2]string]struct{}, len(c.Paths)+len(next.Paths))
+	for _, change := range []fileChange{c, next} {
+		for i, changed := range change.Paths {
+			var from string
+			if i < len(change.From) {
+				from = change.From[i]
+			}
+			if _, ok := seen[[2]string{changed, from}]; ok {
+				continue
+			}
+			if len(merged.Paths) == maxEventPaths {
+				return fileChange{Directory: c.Directory, Truncated: true}
+			}
+			seen[[2]string{changed, from}] = struct{}{}
+			merged.Paths = append(merged.Paths, changed)
+			if from != "" {
+				merged.From = append(merged.From, from)
+			}
+		}
+	}
+	return merged
+}
+
 type eventSubscriber struct {
 	mu      sync.Mutex
 	pending map[string]serverEvent
@@ -30,6 +93,18 @@ func (s *eventSubscriber) enqueue(event serverEvent) {
 		key = event.name
 	}
 	s.mu.Lock()
+	if previous, ok := s.pending[key]; ok {
+		if change, ok := previous.data.(fileChange); ok {
+			if next, ok := event.data.(fileChange); ok {
+				event.data = change.merge(next)
+			}
+		}
+		// The combined event keeps the ID of the oldest event it absorbs. A
+		// client that resumes from that ID is replayed the newer events again,
+		// so nothing is lost if the connection drops before later events of
+		// the same drain are written.
+		event.id = previous.id
+	}
 	s.pending[key] = event
 	s.mu.Unlock()
 	select {
@@ -46,33 +121,73 @@ func (s *eventSubscriber) drain() []serverEvent {
 		events = append(events, event)
 		delete(s.pending, key)
 	}
+	// IDs must reach the client in increasing order, or a reconnect would
+	// resume from the wrong position.
+	sort.Slice(events, func(i, j int) bool { return events[i].id < events[j].id })
 	return events
 }
 
 type eventBroker struct {
-	mu          sync.RWMutex
+	mu          sync.Mutex
 	subscribers map[*eventSubscriber]struct{}
+	lastID      uint64
+	history     []serverEvent // ring buffer of the latest published events
+	next        int           // history index of the next write
 }
 
 func newEventBroker() *eventBroker {
-	return &eventBroker{subscribers: make(map[*eventSubscriber]struct{})}
+	return &eventBroker{
+		subscribers: make(map[*eventSubscriber]struct{}),
+		// IDs continue above any ID issued before a restart, whose history is
+		// gone; such clients fall outside the buffer and resynchronize.
+		lastID:  uint64(time.Now().UnixMicro()),
+		history: make([]serverEvent, 0, eventReplayBufferSize),
+	}
 }
 
 func (b *eventBroker) subscribe() (*eventSubscriber, func()) {
+	subscriber, unsubscribe, _, _ := b.subscribeAfter(0)
+	return subscriber, unsubscribe
+}
+
+// subscribeAfter registers a subscriber and queues every buffered event newer
+// than lastID. It returns the ID of the latest event at subscription, and
+// reports false when the events after lastID are no longer all buffered, in
+// which case the caller must resynchronize the client.
+func (b *eventBroker) subscribeAfter(lastID uint64) (*eventSubscriber, func(), uint64, bool) {
 	subscriber := newEventSubscriber()
 	b.mu.Lock()
+	replayed := false
+	if lastID != 0 && lastID <= b.lastID && b.lastID-lastID <= uint64(len(b.history)) {
+		replayed = true
+		for i := range b.history {
+			event := b.history[(b.next+i)%len(b.history)]
+			if event.id > lastID {
+				subscriber.enqueue(event)
+			}
+		}
+	}
 	b.subscribers[subscriber] = struct{}{}
+	current := b.lastID
 	b.mu.Unlock()
 	return subscriber, func() {
 		b.mu.Lock()
 		delete(b.subscribers, subscriber)
 		b.mu.Unlock()
-	}
+	}, current, replayed
 }
 
 func (b *eventBroker) publish(event serverEvent) {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	event.id = b.lastID
+	if len(b.history) < cap(b.history) {
+		b.history = append(b.history, event)
+	} else {
+		b.history[b.next] = event
+		b.next = (b.next + 1) % len(b.history)
+	}
 	for subscriber := range b.subscribers {
 		// Per-subscriber mailboxes coalesce repeated invalidations by key. A
 		// slow connection receives the latest change without blocking uploads.
@@ -85,6 +200,11 @@ func writeServerEvent(w http.ResponseWriter, event serverEvent) error {
 	if err != nil {
 		return err
 	}
+	if event.id != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", event.id); err != nil {
+			return err
+		}
+	}
 	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, data)
 	return err
 }
@@ -98,17 +218,29 @@ func (h *Handler) Events(w http.ResponseWriter, r *http.Request) {
 		h.respondError(w, "Streaming is unavailable", http.StatusInternalServerError)
 		return
 	}
+	// EventSource resends the last ID it saw as Last-Event-ID when it
+	// reconnects; the query parameter covers clients that reopen the stream.
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	lastID, _ := strconv.ParseUint(lastEventID, 10, 64)
 	// Subscribe before the initial sync so mutations racing with connection
-	// setup are queued and reconciled after the authoritative reload.
-	subscriber, unsubscribe := h.events.subscribe()
+	// setup are queued and reconciled after the authoritative reload. A client
+	// whose missed events are still buffered receives only those instead.
+	subscriber, unsubscribe, current, replayed := h.events.subscribeAfter(lastID)
 	defer unsubscribe()
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache, no-transform")
 	w.Header().Set("X-Accel-Buffering", "no")
 	w.WriteHeader(http.StatusOK)
 	_, _ = fmt.Fprint(w, ": connected\n\n")
-	if writeServerEvent(w, serverEvent{name: "sync", data: struct{}{}}) != nil {
-		return
+	if !replayed {
+		// The sync carries the position it reflects, so a client that
+		// reconnects afterwards resumes from there instead of syncing again.
+		if writeServerEvent(w, serverEvent{name: "sync", id: current, data: struct{}{}}) != nil {
+			return
+		}
 	}
 	flusher.Flush()
 
@@ -171,3 +303,36 @@ func (h *Handler) publishUploadState(session *uploadSession, _ bool) {
 		data: map[string]string{"uploadId": session.ID},
 	})
 }
+
+// publishFileChanges announces changed physical paths, grouped by parent
+// directory. from, when set, lists the source of each path.
+func (h *Handler) publishFileChanges(name string, paths []string, from []string) {
+	if h.searchIndex != nil {
+		for _, changed := range append(append([]string(nil), paths...), from...) {
+			h.searchIndex.refresh(changed)
+		}
+	}
+	changes := make(map[string]*fileChange)
+	var order []string
+	for i, changed := range paths {
+		virtualPath := h.cleanVirtualPath(changed)
+		directory := path.Dir(virtualPath)
+		change, ok := changes[directory]
+		if !ok {
+			change = &fileChange{Directory: directory}
+			changes[directory] = change
+			order = append(order, directory)
+		}
+		change.Paths = append(change.Paths, virtualPath)
+		if i < len(from) {
+			change.From = append(change.From, h.cleanVirtualPath(from[i]))
+		}
+	}
+	for _, directory := range order {
+		change := changes[directory]
+		if len(change.Paths) > maxEventPaths {
+			change = &fileChange{Directory: directory, Truncated: true}
+		}
+		h.events.publish(serverEvent{name: name, key: name + ":" + directory, data: *change})
+	}
+}
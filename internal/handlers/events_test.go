@@ -3,9 +3,13 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
+	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -98,3 +102,210 @@ func TestUploadEventDoesNotExposePaths(t *testing.T) {
 		t.Fatalf("upload event must be an invalidation only: %s", encoded)
 	}
 }
+
+func TestFileChangesCoalesceByDirectory(t *testing.T) {
+	broker := newEventBroker()
+	events, unsubscribe := broker.subscribe()
+	defer unsubscribe()
+	for _, name := range []string{"/dir/a", "/dir/b", "/dir/a"} {
+		broker.publish(serverEvent{name: eventFilesCreated, key: eventFilesCreated + ":/dir", data: fileChange{Directory: "/dir", Paths: []string{name}}})
+	}
+	<-events.ready
+	pending := events.drain()
+	if len(pending) != 1 {
+		t.Fatalf("expected one merged event, got %#v", pending)
+	}
+	change := pending[0].data.(fileChange)
+	if strings.Join(change.Paths, ",") != "/dir/a,/dir/b" {
+		t.Fatalf("merged paths = %v", change.Paths)
+	}
+}
+
+func TestFileChangeMergeTruncatesLargeBatches(t *testing.T) {
+	change := fileChange{Directory: "/"}
+	for i := 0; i <= maxEventPaths; i++ {
+		change = change.merge(fileChange{Directory: "/", Paths: []string{"/" + strconv.Itoa(i)}})
+	}
+	if !change.Truncated || change.Paths != nil {
+		t.Fatalf("expected a truncated change, got %d paths", len(change.Paths))
+	}
+}
+
+func TestMutationHandlersPublishTypedEvents(t *testing.T) {
+	handler := newContentTestHandler(t)
+	events, unsubscribe := handler.events.subscribe()
+	defer unsubscribe()
+
+	handler.CreateDirectory(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/files/mkdir", strings.NewReader(`{"path":"/","name":"docs"}`)))
+	handler.CreateFile(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/files/create", strings.NewReader(`{"path":"/docs","name":"a.txt"}`)))
+	handler.SaveFile(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/files/save", strings.NewReader(`{"path":"/docs/a.txt","content":"x"}`)))
+	handler.MoveFile(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/files/move", strings.NewReader(`{"sourcePath":"/docs/a.txt","targetPath":"/b.txt"}`)))
+	handler.DeleteMultipleFiles(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/files/delete", strings.NewReader(`{"paths":["/b.txt"],"permanent":true}`)))
+
+	got := make(map[string]fileChange)
+	for _, event := range events.drain() {
+		if change, ok := event.data.(fileChange); ok {
+			got[event.key] = change
+		}
+	}
+	want := map[string]fileChange{
+		"files-created:/":     {Directory: "/", Paths: []string{"/docs"}},
+		"files-created:/docs": {Directory: "/docs", Paths: []string{"/docs/a.txt"}},
+		"file-saved:/docs":    {Directory: "/docs", Paths: []string{"/docs/a.txt"}},
+		"files-moved:/":       {Directory: "/", Paths: []string{"/b.txt"}, From: []string{"/docs/a.txt"}},
+		"files-deleted:/":     {Directory: "/", Paths: []string{"/b.txt"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %#v, want %#v", got, want)
+	}
+}
+
+func TestEventBrokerReplaysMissedEvents(t *testing.T) {
+	broker := newEventBroker()
+	first, unsubscribe := broker.subscribe()
+	broker.publish(serverEvent{name: "upload", key: "upload:a", data: "a"})
+	<-first.ready
+	lastID := first.drain()[0].id
+	unsubscribe()
+
+	broker.publish(serverEvent{name: "upload", key: "upload:b", data: "b"})
+	broker.publish(serverEvent{name: "upload", key: "upload:c", data: "c"})
+	events, unsubscribe, _, replayed := broker.subscribeAfter(lastID)
+	defer unsubscribe()
+	if !replayed {
+		t.Fatal("expected buffered events to be replayed")
+	}
+	pending := events.drain()
+	if len(pending) != 2 || pending[0].data != "b" || pending[1].data != "c" || pending[0].id != lastID+1 || pending[1].id != lastID+2 {
+		t.Fatalf("unexpected replay: %#v", pending)
+	}
+}
+
+func TestEventBrokerRequiresSyncAfterOverflow(t *testing.T) {
+	broker := newEventBroker()
+	events, unsubscribe := broker.subscribe()
+	broker.publish(serverEvent{name: "upload", data: 0})
+	<-events.ready
+	lastID := events.drain()[0].id
+	unsubscribe()
+	for i := 0; i < eventReplayBufferSize+1; i++ {
+		broker.publish(serverEvent{name: "upload", key: "upload:" + strconv.Itoa(i), data: i})
+	}
+	if _, unsubscribe, _, replayed := broker.subscribeAfter(lastID); replayed {
+		unsubscribe()
+		t.Fatal("replayed across a buffer overflow")
+	}
+	if _, unsubscribe, _, replayed := broker.subscribeAfter(lastID + 1); !replayed {
+		unsubscribe()
+		t.Fatal("expected the still-buffered range to replay")
+	}
+}
+
+func TestEventsResumesFromLastEventIDWithoutSync(t *testing.T) {
+	handler := newContentTestHandler(t)
+	handler.events.publish(serverEvent{name: "upload", key: "upload:a", data: map[string]string{"uploadId": "a"}})
+	handler.events.publish(serverEvent{name: "upload", key: "upload:b", data: map[string]string{"uploadId": "b"}})
+	request := httptest.NewRequest("GET", "/api/events", nil)
+	request.Header.Set("Last-Event-ID", strconv.FormatUint(handler.events.lastID-1, 10))
+	ctx, cancel := context.WithCancel(request.Context())
+	response := &cancelAfterFlushes{ResponseRecorder: httptest.NewRecorder(), cancel: cancel, remaining: 2}
+
+	handler.Events(response, request.WithContext(ctx))
+
+	body := response.Body.String()
+	if strings.Contains(body, "event: sync") {
+		t.Fatalf("resumed stream sent a sync: %q", body)
+	}
+	want := "id: " + strconv.FormatUint(handler.events.lastID, 10) + "\nevent: upload\ndata: {\"uploadId\":\"b\"}\n\n"
+	if !strings.Contains(body, want) || strings.Contains(body, `"uploadId":"a"`) {
+		t.Fatalf("unexpected replay: %q", body)
+	}
+}
+
+func TestEventsSyncCarriesCurrentEventID(t *testing.T) {
+	handler := newContentTestHandler(t)
+	handler.events.publish(serverEvent{name: "upload", key: "upload:a", data: map[string]string{"uploadId": "a"}})
+	request := httptest.NewRequest("GET", "/api/events", nil)
+	ctx, cancel := context.WithCancel(request.Context())
+	response := &cancelOnFlushRecorder{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+
+	handler.Events(response, request.WithContext(ctx))
+
+	current := handler.events.lastID
+	want := "id: " + strconv.FormatUint(current, 10) + "\nevent: sync\ndata: {}\n\n"
+	if body := response.Body.String(); !strings.Contains(body, want) || strings.Contains(body, `"uploadId":"a"`) {
+		t.Fatalf("sync without the current ID: %q", body)
+	}
+	_, unsubscribe, _, replayed := handler.events.subscribeAfter(current)
+	unsubscribe()
+	if !replayed {
+		t.Fatal("reconnecting with the sync ID should resume without another sync")
+	}
+}
+
+// failAfterEvents accepts the given number of events and then fails every
+// write, as a dropped connection does.
+type failAfterEvents struct {
+	*httptest.ResponseRecorder
+	events int
+}
+
+func (r *failAfterEvents) Write(p []byte) (int, error) {
+	if strings.Count(r.Body.String(), "\nevent: ") >= r.events {
+		return 0, errors.New("connection dropped")
+	}
+	return r.ResponseRecorder.Write(p)
+}
+
+func (r *failAfterEvents) Flush() {}
+
+func TestEventsResumeAfterCoalescedEventLosesNothing(t *testing.T) {
+	handler := newContentTestHandler(t)
+	handler.events.publish(serverEvent{name: "upload", key: "upload:before", data: map[string]string{"uploadId": "before"}})
+	start := handler.events.lastID
+	handler.events.publish(serverEvent{name: eventFilesCreated, key: eventFilesCreated + ":/music", data: fileChange{Directory: "/music", Paths: []string{"/music/a.mp3"}}})
+	handler.events.publish(serverEvent{name: eventFilesDeleted, key: eventFilesDeleted + ":/docs", data: fileChange{Directory: "/docs", Paths: []string{"/docs/old.txt"}}})
+	handler.events.publish(serverEvent{name: eventFilesCreated, key: eventFilesCreated + ":/music", data: fileChange{Directory: "/music", Paths: []string{"/music/b.mp3"}}})
+
+	// The replay coalesces both /music events; the connection drops after the
+	// first event is written.
+	request := httptest.NewRequest("GET", "/api/events", nil)
+	request.Header.Set("Last-Event-ID", strconv.FormatUint(start, 10))
+	first := &failAfterEvents{ResponseRecorder: httptest.NewRecorder(), events: 1}
+	handler.Events(first, request)
+	received := first.Body.String()
+	index := strings.LastIndex(received, "id: ")
+	if index < 0 || strings.Count(received, "\nevent: ") != 1 {
+		t.Fatalf("unexpected first connection: %q", received)
+	}
+	lastID := strings.TrimSpace(strings.SplitN(received[index+len("id: "):], "\n", 2)[0])
+
+	request = httptest.NewRequest("GET", "/api/events", nil)
+	request.Header.Set("Last-Event-ID", lastID)
+	ctx, cancel := context.WithCancel(request.Context())
+	second := &cancelAfterFlushes{ResponseRecorder: httptest.NewRecorder(), cancel: cancel, remaining: 2}
+	handler.Events(second, request.WithContext(ctx))
+	received += second.Body.String()
+
+	if strings.Contains(received, "event: sync") {
+		t.Fatalf("resumed stream sent a sync: %q", received)
+	}
+	for _, path := range []string{"/music/a.mp3", "/music/b.mp3", "/docs/old.txt"} {
+		if !strings.Contains(received, path) {
+			t.Fatalf("%s was lost across the reconnect: %q", path, received)
+		}
+	}
+}
+
+type cancelAfterFlushes struct {
+	*httptest.ResponseRecorder
+	cancel    context.CancelFunc
+	remaining int
+}
+
+func (r *cancelAfterFlushes) Flush() {
+	r.remaining--
+	if r.remaining == 0 {
+		r.cancel()
+	}
+}
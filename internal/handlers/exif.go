@@ -0,0 +1,631 @@
+package handlers
+
+// EXIF metadata of photos. Every supported format embeds the same TIFF
+// structure: JPEG in an APP1 segment, PNG in an eXIf chunk, HEIC in an Exif
+// item of its ISO base media meta box, and TIFF files are one themselves.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"puremania/internal/cache"
+)
+
+const (
+	exifCachePrefix = "exif:"
+	exifTTL         = time.Hour
+	// maxExifValueBytes skips large values such as maker notes.
+	maxExifValueBytes = 4 << 10
+	maxExifIFDEntries = 1024
+	maxPNGChunks      = 1024
+	// maxHEIFMetaBytes bounds the meta box read into memory.
+	maxHEIFMetaBytes = 4 << 20
+	exifTimeLayout   = "2006:01:02 15:04:05"
+	// captureTimeLayout is a wall clock time. EXIF rarely records a zone.
+	captureTimeLayout = "2006-01-02T15:04:05"
+)
+
+var errNoExif = fmt.Errorf("image has no EXIF metadata")
+
+type gpsPosition struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
+
+type imageMetadata struct {
+	Path         string       `json:"path"`
+	Format       string       `json:"format"`
+	CaptureTime  string       `json:"captureTime,omitempty"`
+	TimeOffset   string       `json:"timeOffset,omitempty"`
+	Make         string       `json:"make,omitempty"`
+	Model        string       `json:"model,omitempty"`
+	LensModel    string       `json:"lensModel,omitempty"`
+	Orientation  int          `json:"orientation,omitempty"`
+	Width        int          `json:"width,omitempty"`
+	Height       int          `json:"height,omitempty"`
+	ExposureTime string       `json:"exposureTime,omitempty"`
+	FNumber      float64      `json:"fNumber,omitempty"`
+	ISO          int          `json:"iso,omitempty"`
+	FocalLength  float64      `json:"focalLength,omitempty"`
+	GPS          *gpsPosition `json:"gps,omitempty"`
+}
+
+// exifExtensions are the formats whose metadata is read.
+var exifExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".tif": true, ".tiff": true, ".heic": true, ".heif": true}
+
+// readImageMetadata detects the format of an image from its content and
+// decodes its EXIF metadata. An image without EXIF returns only its format.
+func readImageMetadata(r io.ReaderAt, size int64) (imageMetadata, error) {
+	var header [12]byte
+	n, _ := r.ReadAt(header[:], 0)
+	var section *io.SectionReader
+	var err error
+	meta := imageMetadata{}
+	switch {
+	case n >= 2 && header[0] == 0xFF && header[1] == 0xD8:
+		meta.Format = "jpeg"
+		section = jpegExifSection(r)
+	case n >= 8 && string(header[:8]) == "\x89PNG\r\n\x1a\n":
+		meta.Format = "png"
+		section = pngExifSection(r)
+	case n >= 4 && (string(header[:4]) == "II*\x00" || string(header[:4]) == "MM\x00*"):
+		meta.Format = "tiff"
+		section = io.NewSectionReader(r, 0, size)
+	case n >= 12 && string(header[4:8]) == "ftyp":
+		meta.Format = "heif"
+		section, err = heifExifSection(r, size)
+		if err != nil {
+			return meta, err
+		}
+	default:
+		return meta, fmt.Errorf("unsupported image format")
+	}
+	if section == nil {
+		return meta, nil
+	}
+	if err := decodeExif(section, &meta); err != nil && err != errNoExif {
+		return meta, err
+	}
+	return meta, nil
+}
+
+// jpegExifSection returns the TIFF structure of a JPEG's EXIF segment.
+func jpegExifSection(r io.ReaderAt) *io.SectionReader {
+	var marker [4]byte
+	if _, err := r.ReadAt(marker[:2], 0); err != nil || marker[0] != 0xFF || marker[1] != 0xD8 {
+		return nil
+	}
+	for offset := int64(2); ; {
+		if _, err := r.ReadAt(marker[:], offset); err != nil || marker[0] != 0xFF {
+			return nil
+		}
+		kind := marker[1]
+		length := int64(binary.BigEndian.Uint16(marker[2:]))
+		// Metadata segments precede the image data.
+		if kind == 0xDA || kind == 0xD9 || length < 2 {
+			return nil
+		}
+		if kind == 0xE1 && length > 14 {
+			var signature [6]byte
+			if _, err := r.ReadAt(signature[:], offset+4); err != nil {
+				return nil
+			}
+			if string(signature[:]) == "Exif\x00\x00" {
+				return io.NewSectionReader(r, offset+10, length-8)
+			}
+		}
+		offset += 2 + length
+	}
+}
+
+// pngExifSection returns the contents of the eXIf chunk. Writers place it
+// before the image data, so the search stops at the first IDAT chunk.
+func pngExifSection(r io.ReaderAt) *io.SectionReader {
+	var chunk [8]byte
+	offset := int64(8)
+	for i := 0; i < maxPNGChunks; i++ {
+		if _, err := r.ReadAt(chunk[:], offset); err != nil {
+			return nil
+		}
+		length := int64(binary.BigEndian.Uint32(chunk[:4]))
+		switch string(chunk[4:]) {
+		case "eXIf":
+			return io.NewSectionReader(r, offset+8, length)
+		case "IDAT", "IEND":
+			return nil
+		}
+		offset += 12 + length
+	}
+	return nil
+}
+
+// heifExifSection finds the Exif item of a HEIF image through the item
+// information (iinf) and item location (iloc) boxes of its meta box.
+func heifExifSection(r io.ReaderAt, size int64) (*io.SectionReader, error) {
+	meta, err := findBox(r, 0, size, "meta")
+	if err != nil {
+		return nil, err
+	}
+	if meta.Size() > maxHEIFMetaBytes {
+		return nil, fmt.Errorf("meta box is too large")
+	}
+	data := make([]byte, meta.Size())
+	if _, err := meta.ReadAt(data, 0); err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("invalid meta box")
+	}
+	// meta is a full box: children follow the version and flags.
+	children := data[4:]
+	iinf, iloc := boxPayload(children, "iinf"), boxPayload(children, "iloc")
+	if iinf == nil || iloc == nil {
+		return nil, nil
+	}
+	itemID, ok := heifExifItem(iinf)
+	if !ok {
+		return nil, nil
+	}
+	offset, length, ok := heifItemLocation(iloc, itemID)
+	if !ok || length < 4 || offset < 0 || offset+length > size {
+		return nil, nil
+	}
+	// The item starts with the offset of the TIFF header after a prefix,
+	// usually "Exif\0\0".
+	var skip [4]byte
+	if _, err := r.ReadAt(skip[:], offset); err != nil {
+		return nil, err
+	}
+	start := 4 + int64(binary.BigEndian.Uint32(skip[:]))
+	if start >= length {
+		return nil, nil
+	}
+	return io.NewSectionReader(r, offset+start, length-start), nil
+}
+
+// findBox returns the payload of the first top-level box of the given type.
+func findBox(r io.ReaderAt, offset, end int64, kind string) (*io.SectionReader, error) {
+	var header [16]byte
+	for offset+8 <= end {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, err
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header[:4])), int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:], offset+8); err != nil {
+				return nil, err
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if size < headerSize || size > end-offset {
+			return nil, fmt.Errorf("invalid %q box", string(header[4:8]))
+		}
+		if string(header[4:8]) == kind {
+			return io.NewSectionReader(r, offset+headerSize, size-headerSize), nil
+		}
+		offset += size
+	}
+	return nil, fmt.Errorf("no %q box", kind)
+}
+
+// boxPayload returns the payload of the first box of the given type in data.
+func boxPayload(data []byte, kind string) []byte {
+	for len(data) >= 8 {
+		size, headerSize := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+		if size == 1 {
+			if len(data) < 16 {
+				return nil
+			}
+			size, headerSize = binary.BigEndian.Uint64(data[8:]), 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return nil
+		}
+		if string(data[4:8]) == kind {
+			return data[headerSize:size]
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// heifExifItem returns the ID of the item whose type is Exif.
+func heifExifItem(iinf []byte) (uint32, bool) {
+	if len(iinf) < 6 {
+		return 0, false
+	}
+	entries := iinf[6:]
+	if iinf[0] != 0 {
+		if len(iinf) < 8 {
+			return 0, false
+		}
+		entries = iinf[8:]
+	}
+	for len(entries) >= 8 {
+		size := binary.BigEndian.Uint32(entries)
+		if size < 8 || uint64(size) > uint64(len(entries)) {
+			return 0, false
+		}
+		if infe := entries[8:size]; string(entries[4:8]) == "infe" && len(infe) >= 4 {
+			// Item types exist from version 2 on.
+			switch version := infe[0]; {
+			case version == 2 && len(infe) >= 12 && string(infe[8:12]) == "Exif":
+				return uint32(binary.BigEndian.Uint16(infe[4:])), true
+			case version == 3 && len(infe) >= 14 && string(infe[10:14]) == "Exif":
+				return binary.BigEndian.Uint32(infe[4:]), true
+			}
+		}
+		entries = entries[size:]
+	}
+	return 0, false
+}
+
+// heifItemLocation returns the file offset and length of a single-extent item
+// stored in the file itself.
+func heifItemLocation(iloc []byte, itemID uint32) (int64, int64, bool) {
+	if len(iloc) < 8 {
+		return 0, 0, false
+	}
+	version := iloc[0]
+	offsetSize, lengthSize := int(iloc[4]>>4), int(iloc[4]&0x0F)
+	baseOffsetSize, indexSize := int(iloc[5]>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(iloc[5] & 0x0F)
+	}
+	pos := 6
+	read := func(size int) (uint64, bool) {
+		if size == 0 {
+			return 0, true
+		}
+		if (size != 2 && size != 4 && size != 8) || pos+size > len(iloc) {
+			return 0, false
+		}
+		var value uint64
+		switch size {
+		case 2:
+			value = uint64(binary.BigEndian.Uint16(iloc[pos:]))
+		case 4:
+			value = uint64(binary.BigEndian.Uint32(iloc[pos:]))
+		case 8:
+			value = binary.BigEndian.Uint64(iloc[pos:])
+		}
+		pos += size
+		return value, true
+	}
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+	count, ok := read(idSize)
+	if !ok {
+		return 0, 0, false
+	}
+	for i := uint64(0); i < count; i++ {
+		id, ok := read(idSize)
+		if !ok {
+			return 0, 0, false
+		}
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			if method, ok = read(2); !ok {
+				return 0, 0, false
+			}
+			method &= 0x0F
+		}
+		if _, ok := read(2); !ok { // data_reference_index
+			return 0, 0, false
+		}
+		base, ok := read(baseOffsetSize)
+		if !ok {
+			return 0, 0, false
+		}
+		extents, ok := read(2)
+		if !ok {
+			return 0, 0, false
+		}
+		var offset, length uint64
+		for e := uint64(0); e < extents; e++ {
+			if _, ok := read(indexSize); !ok {
+				return 0, 0, false
+			}
+			if offset, ok = read(offsetSize); !ok {
+				return 0, 0, false
+			}
+			if length, ok = read(lengthSize); !ok {
+				return 0, 0, false
+			}
+		}
+		if uint32(id) == itemID {
+			if method != 0 || extents != 1 || base+offset > math.MaxInt64/2 || length > math.MaxInt64/2 {
+				return 0, 0, false
+			}
+			return int64(base + offset), int64(length), true
+		}
+	}
+	return 0, 0, false
+}
+
+type exifValue struct {
+	kind  uint16
+	count uint32
+	data  []byte
+}
+
+type tiffReader struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+}
+
+// exifTypeSizes are the byte sizes of the TIFF field types.
+var exifTypeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// readIFD returns the values of one image file directory.
+func (t tiffReader) readIFD(offset int64) (map[uint16]exifValue, error) {
+	var count [2]byte
+	if _, err := t.r.ReadAt(count[:], offset); err != nil {
+		return nil, err
+	}
+	n := int(t.order.Uint16(count[:]))
+	if n > maxExifIFDEntries {
+		return nil, fmt.Errorf("IFD has too many entries")
+	}
+	entries := make([]byte, 12*n)
+	if _, err := t.r.ReadAt(entries, offset+2); err != nil {
+		return nil, err
+	}
+	values := make(map[uint16]exifValue, n)
+	for i := 0; i < n; i++ {
+		entry := entries[12*i : 12*i+12]
+		tag, kind, count := t.order.Uint16(entry), t.order.Uint16(entry[2:]), t.order.Uint32(entry[4:])
+		size, known := exifTypeSizes[kind]
+		if !known || count == 0 || uint64(count)*uint64(size) > maxExifValueBytes {
+			continue
+		}
+		length := count * size
+		data := entry[8 : 8+min(length, 4)]
+		if length > 4 {
+			data = make([]byte, length)
+			if _, err := t.r.ReadAt(data, int64(t.order.Uint32(entry[8:]))); err != nil {
+				continue
+			}
+		}
+		values[tag] = exifValue{kind: kind, count: count, data: data}
+	}
+	return values, nil
+}
+
+func (t tiffReader) text(values map[uint16]exifValue, tag uint16) string {
+	value, ok := values[tag]
+	if !ok || value.kind != 2 {
+		return ""
+	}
+	text, _, _ := bytes.Cut(value.data, []byte{0})
+	return strings.TrimSpace(string(text))
+}
+
+// integer reads a BYTE, SHORT, or LONG value.
+func (t tiffReader) integer(values map[uint16]exifValue, tag uint16) (uint32, bool) {
+	value, ok := values[tag]
+	if !ok {
+		return 0, false
+	}
+	switch value.kind {
+	case 1:
+		return uint32(value.data[0]), true
+	case 3:
+		return uint32(t.order.Uint16(value.data)), true
+	case 4:
+		return t.order.Uint32(value.data), true
+	}
+	return 0, false
+}
+
+// rational reads element i of an unsigned RATIONAL value.
+func (t tiffReader) rational(values map[uint16]exifValue, tag uint16, i int) (uint32, uint32, bool) {
+	value, ok := values[tag]
+	if !ok || value.kind != 5 || uint32(i) >= value.count {
+		return 0, 0, false
+	}
+	numerator, denominator := t.order.Uint32(value.data[8*i:]), t.order.Uint32(value.data[8*i+4:])
+	return numerator, denominator, denominator != 0
+}
+
+func (t tiffReader) float(values map[uint16]exifValue, tag uint16, i int) (float64, bool) {
+	numerator, denominator, ok := t.rational(values, tag, i)
+	if !ok {
+		return 0, false
+	}
+	return float64(numerator) / float64(denominator), true
+}
+
+// decodeExif reads the TIFF header and the IFD0, Exif, and GPS directories.
+func decodeExif(section io.ReaderAt, meta *imageMetadata) error {
+	var header [8]byte
+	if _, err := section.ReadAt(header[:], 0); err != nil {
+		return errNoExif
+	}
+	t := tiffReader{r: section}
+	switch string(header[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return errNoExif
+	}
+	if t.order.Uint16(header[2:]) != 42 {
+		return errNoExif
+	}
+	ifd0, err := t.readIFD(int64(t.order.Uint32(header[4:])))
+	if err != nil {
+		return fmt.Errorf("invalid EXIF directory: %w", err)
+	}
+	meta.Make = t.text(ifd0, 0x010F)
+	meta.Model = t.text(ifd0, 0x0110)
+	if orientation, ok := t.integer(ifd0, 0x0112); ok && orientation >= 1 && orientation <= 8 {
+		meta.Orientation = int(orientation)
+	}
+	if width, ok := t.integer(ifd0, 0x0100); ok {
+		meta.Width = int(width)
+	}
+	if height, ok := t.integer(ifd0, 0x0101); ok {
+		meta.Height = int(height)
+	}
+	captureTime := t.text(ifd0, 0x0132)
+
+	if offset, ok := t.integer(ifd0, 0x8769); ok {
+		if exif, err := t.readIFD(int64(offset)); err == nil {
+			if original := t.text(exif, 0x9003); original != "" {
+				captureTime = original
+				meta.TimeOffset = t.text(exif, 0x9011)
+			}
+			meta.LensModel = t.text(exif, 0xA434)
+			if numerator, denominator, ok := t.rational(exif, 0x829A, 0); ok {
+				meta.ExposureTime = formatExposureTime(numerator, denominator)
+			}
+			meta.FNumber, _ = t.float(exif, 0x829D, 0)
+			meta.FocalLength, _ = t.float(exif, 0x920A, 0)
+			if iso, ok := t.integer(exif, 0x8827); ok {
+				meta.ISO = int(iso)
+			}
+			if width, ok := t.integer(exif, 0xA002); ok {
+				meta.Width = int(width)
+			}
+			if height, ok := t.integer(exif, 0xA003); ok {
+				meta.Height = int(height)
+			}
+		}
+	}
+	if parsed, err := time.Parse(exifTimeLayout, captureTime); err == nil {
+		meta.CaptureTime = parsed.Format(captureTimeLayout)
+	}
+	if offset, ok := t.integer(ifd0, 0x8825); ok {
+		if gps, err := t.readIFD(int64(offset)); err == nil {
+			meta.GPS = t.gpsPosition(gps)
+		}
+	}
+	return nil
+}
+
+// gpsPosition converts degrees, minutes, and seconds to signed decimal
+// degrees.
+func (t tiffReader) gpsPosition(gps map[uint16]exifValue) *gpsPosition {
+	coordinate := func(refTag, tag uint16, negative string) (float64, bool) {
+		var parts [3]float64
+		for i := range parts {
+			value, ok := t.float(gps, tag, i)
+			if !ok {
+				return 0, false
+			}
+			parts[i] = value
+		}
+		degrees := parts[0] + parts[1]/60 + parts[2]/3600
+		if t.text(gps, refTag) == negative {
+			degrees = -degrees
+		}
+		return degrees, true
+	}
+	latitude, ok := coordinate(1, 2, "S")
+	if !ok || math.Abs(latitude) > 90 {
+		return nil
+	}
+	longitude, ok := coordinate(3, 4, "W")
+	if !ok || math.Abs(longitude) > 180 {
+		return nil
+	}
+	position := &gpsPosition{Latitude: latitude, Longitude: longitude}
+	if altitude, ok := t.float(gps, 6, 0); ok {
+		// Reference 1 means below sea level.
+		if value, found := gps[5]; found && value.kind == 1 && value.data[0] == 1 {
+			altitude = -altitude
+		}
+		position.Altitude = &altitude
+	}
+	return position
+}
+
+// formatExposureTime writes fractions of a second as 1/N.
+func formatExposureTime(numerator, denominator uint32) string {
+	if numerator == 0 {
+		return "0"
+	}
+	if numerator < denominator {
+		return "1/" + strconv.FormatFloat(float64(denominator)/float64(numerator), 'f', -1, 64)
+	}
+	return strconv.FormatFloat(float64(numerator)/float64(denominator), 'f', -1, 64)
+}
+
+// imageMetadata reads the metadata of one version of a file, consulting the
+// cache first.
+func (h *Handler) imageMetadata(virtualPath string, source *os.File, info os.FileInfo) (imageMetadata, error) {
+	cacheKey := exifCachePrefix + fileVersionHash(virtualPath, info, "exif")
+	if cached, found := cache.Get(h.cache, cacheKey); found {
+		if meta, ok := cached.(imageMetadata); ok {
+			return meta, nil
+		}
+	}
+	meta, err := readImageMetadata(source, info.Size())
+	if err != nil {
+		return meta, err
+	}
+	meta.Path = virtualPath
+	cache.Set(h.cache, cacheKey, meta, 512, exifTTL)
+	return meta, nil
+}
+
+// ImageMetadata returns the capture time, camera, exposure, and GPS position
+// recorded in the EXIF metadata of a JPEG, HEIC, TIFF, or PNG image.
+func (h *Handler) ImageMetadata(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		h.respondError(w, "Path required", http.StatusBadRequest)
+		return
+	}
+	if len(path) > maxVirtualPathBytes {
+		h.respondError(w, "Path is too long", http.StatusBadRequest)
+		return
+	}
+	if !exifExtensions[strings.ToLower(filepath.Ext(path))] {
+		h.respondError(w, "Metadata is only available for JPEG, HEIC, TIFF, and PNG images", http.StatusBadRequest)
+		return
+	}
+	fullPath, err := h.convertToPhysicalPath(path)
+	if err != nil {
+		h.respondError(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	source, err := h.openAllowedPath(fullPath, os.O_RDONLY, 0)
+	if err != nil {
+		h.respondError(w, "Cannot open file", http.StatusNotFound)
+		return
+	}
+	defer func() { _ = source.Close() }()
+	info, err := source.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		h.respondError(w, "Cannot inspect file", http.StatusBadRequest)
+		return
+	}
+	meta, err := h.imageMetadata(path, source, info)
+	if err != nil {
+		h.logger.Warn("Failed to read image metadata", "path", fullPath, "error", err)
+		h.respondError(w, "Cannot read image metadata", http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	h.respondSuccess(w, meta)
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type tiffField struct {
+	tag, kind uint16
+	data      []byte
+}
+
+func asciiField(tag uint16, value string) tiffField {
+	return tiffField{tag, 2, append([]byte(value), 0)}
+}
+
+func shortField(tag, value uint16) tiffField {
+	return tiffField{tag, 3, binary.LittleEndian.AppendUint16(nil, value)}
+}
+
+func rationalField(tag uint16, pairs ...uint32) tiffField {
+	var data []byte
+	for _, value := range pairs {
+		data = binary.LittleEndian.AppendUint32(data, value)
+	}
+	return tiffField{tag, 5, data}
+}
+
+// buildTestTIFF lays out IFD0, the Exif IFD, and the GPS IFD, followed by
+// the values that do not fit in their entries.
+func buildTestTIFF(ifd0, exif, gps []tiffField) []byte {
+	order := binary.LittleEndian
+	ifdSize := func(entries int) int { return 2 + 12*entries + 4 }
+	exifOffset := 8 + ifdSize(len(ifd0)+2)
+	gpsOffset := exifOffset + ifdSize(len(exif))
+	dataOffset := gpsOffset + ifdSize(len(gps))
+	ifd0 = append(ifd0,
+		tiffField{0x8769, 4, order.AppendUint32(nil, uint32(exifOffset))},
+		tiffField{0x8825, 4, order.AppendUint32(nil, uint32(gpsOffset))})
+
+	out := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	var data []byte
+	for _, fields := range [][]tiffField{ifd0, exif, gps} {
+		out = order.AppendUint16(out, uint16(len(fields)))
+		for _, field := range fields {
+			out = order.AppendUint16(out, field.tag)
+			out = order.AppendUint16(out, field.kind)
+			out = order.AppendUint32(out, uint32(len(field.data))/exifTypeSizes[field.kind])
+			if len(field.data) <= 4 {
+				out = append(append(out, field.data...), make([]byte, 4-len(field.data))...)
+			} else {
+				out = order.AppendUint32(out, uint32(dataOffset+len(data)))
+				data = append(data, field.data...)
+			}
+		}
+		out = order.AppendUint32(out, 0)
+	}
+	return append(out, data...)
+}
+
+func testCameraTIFF(captureTime string) []byte {
+	return buildTestTIFF(
+		[]tiffField{asciiField(0x010F, "Acme"), asciiField(0x0110, "Shooter 1"), shortField(0x0112, 6), asciiField(0x0132, "2000:01:01 00:00:00")},
+		[]tiffField{
+			asciiField(0x9003, captureTime), asciiField(0x9011, "+09:00"),
+			rationalField(0x829A, 1, 125), rationalField(0x829D, 28, 10), shortField(0x8827, 200),
+			rationalField(0x920A, 50, 1), shortField(0xA002, 4000), shortField(0xA003, 3000),
+		},
+		[]tiffField{
+			asciiField(1, "S"), rationalField(2, 33, 1, 52, 1, 0, 1),
+			asciiField(3, "W"), rationalField(4, 151, 1, 12, 1, 36, 1),
+			{5, 1, []byte{1}}, rationalField(6, 10, 1),
+		})
+}
+
+func exifJPEGSegment(tiff []byte) []byte {
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(payload) + 2
+	return append([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)}, payload...)
+}
+
+func writeTestJPEG(t *testing.T, path string, segment []byte) {
+	t.Helper()
+	writeTestImage(t, path, image.NewGray(image.Rect(0, 0, 8, 8)), func(b *bytes.Buffer, img image.Image) error {
+		return jpeg.Encode(b, img, nil)
+	}, segment)
+}
+
+// testPNGWithExif inserts an eXIf chunk after IHDR.
+func testPNGWithExif(t *testing.T, tiff []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(tiff)))
+	chunk = append(append(chunk, "eXIf"...), tiff...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	return append(append(append([]byte(nil), encoded[:33]...), chunk...), encoded[33:]...)
+}
+
+func testBox(kind string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	return append(append(binary.BigEndian.AppendUint32(nil, uint32(8+len(body))), kind...), body...)
+}
+
+// testHEIF builds a HEIF file whose only item is an Exif block in mdat.
+func testHEIF(tiff []byte) []byte {
+	item := append(append([]byte{0, 0, 0, 6}, "Exif\x00\x00"...), tiff...)
+	ftyp := testBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	infe := testBox("infe", []byte{2, 0, 0, 0, 0, 1, 0, 0}, []byte("Exif\x00"))
+	iinf := testBox("iinf", []byte{0, 0, 0, 0, 0, 1}, infe)
+	meta := func(offset int) []byte {
+		location := []byte{0, 0, 0, 0, 0x44, 0x00, 0, 1, 0, 1, 0, 0, 0, 1}
+		location = binary.BigEndian.AppendUint32(location, uint32(offset))
+		location = binary.BigEndian.AppendUint32(location, uint32(len(item)))
+		return testBox("meta", []byte{0, 0, 0, 0}, iinf, testBox("iloc", location))
+	}
+	offset := len(ftyp) + len(meta(0)) + 8
+	return bytes.Join([][]byte{ftyp, meta(offset), testBox("mdat", item)}, nil)
+}
+
+func TestReadImageMetadataFromJPEG(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	writeTestJPEG(t, path, exifJPEGSegment(testCameraTIFF("2024:05:06 07:08:09")))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := readImageMetadata(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Format != "jpeg" || meta.Make != "Acme" || meta.Model != "Shooter 1" || meta.Orientation != 6 {
+		t.Fatalf("camera fields = %+v", meta)
+	}
+	if meta.CaptureTime != "2024-05-06T07:08:09" || meta.TimeOffset != "+09:00" {
+		t.Fatalf("capture time = %q %q, want DateTimeOriginal over DateTime", meta.CaptureTime, meta.TimeOffset)
+	}
+	if meta.ExposureTime != "1/125" || meta.FNumber != 2.8 || meta.ISO != 200 || meta.FocalLength != 50 || meta.Width != 4000 || meta.Height != 3000 {
+		t.Fatalf("exposure fields = %+v", meta)
+	}
+	if meta.GPS == nil || math.Abs(meta.GPS.Latitude+33.8667) > 1e-4 || math.Abs(meta.GPS.Longitude+151.21) > 1e-4 || meta.GPS.Altitude == nil || *meta.GPS.Altitude != -10 {
+		t.Fatalf("gps = %+v", meta.GPS)
+	}
+	if got := jpegOrientation(bytes.NewReader(data)); got != 6 {
+		t.Fatalf("jpegOrientation = %d", got)
+	}
+}
+
+func TestReadImageMetadataFromPNGTIFFAndHEIF(t *testing.T) {
+	tiff := testCameraTIFF("2021:02:03 04:05:06")
+	for format, data := range map[string][]byte{"png": testPNGWithExif(t, tiff), "tiff": tiff, "heif": testHEIF(tiff)} {
+		meta, err := readImageMetadata(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if meta.Format != format || meta.CaptureTime != "2021-02-03T04:05:06" || meta.Model != "Shooter 1" {
+			t.Fatalf("%s: metadata = %+v", format, meta)
+		}
+	}
+}
+
+func TestReadImageMetadataWithoutExif(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := readImageMetadata(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil || meta.Format != "png" || meta.CaptureTime != "" {
+		t.Fatalf("metadata = %+v, err = %v", meta, err)
+	}
+	if _, err := readImageMetadata(bytes.NewReader([]byte("plain text")), 10); err == nil {
+		t.Fatal("unknown formats must be rejected")
+	}
+	// A box claiming to extend past the file must not be followed.
+	broken := append(testBox("ftyp", []byte("heic\x00\x00\x00\x00")), 0xFF, 0xFF, 0xFF, 0xF0, 'm', 'e', 't', 'a')
+	if _, err := readImageMetadata(bytes.NewReader(broken), int64(len(broken))); err == nil {
+		t.Fatal("truncated HEIF must be rejected")
+	}
+}
+
+func TestImageMetadataEndpoint(t *testing.T) {
+	h := newContentTestHandler(t)
+	writeTestJPEG(t, filepath.Join(h.config.StorageDir, "photo.jpg"), exifJPEGSegment(testCameraTIFF("2024:05:06 07:08:09")))
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "notes.txt"), "text")
+
+	res := httptest.NewRecorder()
+	h.ImageMetadata(res, httptest.NewRequest(http.MethodGet, "/api/files/exif?path=/photo.jpg", nil))
+	var body struct {
+		Data imageMetadata `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil || res.Code != http.StatusOK {
+		t.Fatalf("status = %d, err = %v", res.Code, err)
+	}
+	if body.Data.Path != "/photo.jpg" || body.Data.Make != "Acme" || body.Data.GPS == nil {
+		t.Fatalf("metadata = %+v", body.Data)
+	}
+
+	res = httptest.NewRecorder()
+	h.ImageMetadata(res, httptest.NewRequest(http.MethodGet, "/api/files/exif?path=/notes.txt", nil))
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("text file status = %d", res.Code)
+	}
+}
+
+func TestTimelineGroupsImagesByCaptureDate(t *testing.T) {
+	h := newContentTestHandler(t)
+	dir := h.config.StorageDir
+	writeTestJPEG(t, filepath.Join(dir, "old.jpg"), exifJPEGSegment(testCameraTIFF("2023:05:01 10:00:00")))
+	writeTestJPEG(t, filepath.Join(dir, "new.jpg"), exifJPEGSegment(testCameraTIFF("2024:01:02 09:00:00")))
+	writeTestJPEG(t, filepath.Join(dir, "same-day.jpg"), exifJPEGSegment(testCameraTIFF("2024:01:02 18:30:00")))
+	writeTestImage(t, filepath.Join(dir, "scan.png"), halfAndHalf(4, 4, color.Black, color.White), func(b *bytes.Buffer, img image.Image) error { return png.Encode(b, img) }, nil)
+	modified := time.Date(2022, 3, 4, 12, 0, 0, 0, time.Local)
+	if err := os.Chtimes(filepath.Join(dir, "scan.png"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "notes.txt"), "not a photo")
+	if err := os.Mkdir(filepath.Join(dir, "album"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func(cursor string) timelinePage {
+		t.Helper()
+		res := httptest.NewRecorder()
+		h.ListFiles(res, httptest.NewRequest(http.MethodGet, "/api/files?path=/&mode=timeline&limit=2&cursor="+cursor, nil))
+		var body struct {
+			Data timelinePage `json:"data"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil || res.Code != http.StatusOK {
+			t.Fatalf("status = %d, err = %v", res.Code, err)
+		}
+		return body.Data
+	}
+
+	first := fetch("")
+	if first.Total != 4 || !first.HasMore || first.NextCursor != "2" || len(first.Data) != 2 {
+		t.Fatalf("first page = %+v", first)
+	}
+	if first.Data[0].Name != "same-day.jpg" || first.Data[1].Name != "new.jpg" || first.Data[0].DateSource != "exif" {
+		t.Fatalf("first page is not newest first: %+v", first.Data)
+	}
+	if len(first.Groups) != 1 || first.Groups[0] != (timelineGroup{Date: "2024-01-02", Count: 2}) {
+		t.Fatalf("first page groups = %+v", first.Groups)
+	}
+
+	second := fetch(first.NextCursor)
+	if second.HasMore || len(second.Data) != 2 || second.Data[0].Name != "old.jpg" || second.Data[1].Name != "scan.png" {
+		t.Fatalf("second page = %+v", second)
+	}
+	if scan := second.Data[1]; scan.DateSource != "mtime" || scan.Date != "2022-03-04" || scan.TakenAt != "2022-03-04T12:00:00" {
+		t.Fatalf("image without EXIF = %+v, want its modification time", scan)
+	}
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"puremania/internal/cache"
+	"puremania/internal/worker"
+	"strings"
+	"time"
+)
+
+// conflictPolicy decides what happens when an operation would create a path
+// that already exists.
+type conflictPolicy string
+
+const (
+	conflictFail      conflictPolicy = "fail"
+	conflictSkip      conflictPolicy = "skip"
+	conflictOverwrite conflictPolicy = "overwrite"
+	conflictRename    conflictPolicy = "rename"
+	// conflictNewerOnly replaces only files older than the client's copy; it
+	// applies to uploads, which carry a modification time.
+	conflictNewerOnly conflictPolicy = "newer-only"
+)
+
+const maxRenameAttempts = 10000
+
+var (
+	errTargetExists   = errors.New("target already exists")
+	errCopyIntoItself = errors.New("cannot copy a directory into itself")
+)
+
+func parseConflictPolicy(value string, fallback conflictPolicy) (conflictPolicy, error) {
+	switch policy := conflictPolicy(strings.ToLower(value)); policy {
+	case "":
+		return fallback, nil
+	case conflictFail, conflictSkip, conflictOverwrite, conflictRename:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid conflict policy: %s", value)
+	}
+}
+
+// nextAvailableName returns the first "name (n).ext" sibling that does not
+// exist. It only picks a name: callers publish with renameNoReplace and ask
+// again when another client took the name first.
+func nextAvailableName(path string) (string, error) {
+	dir := filepath.Dir(path)
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	if stem == "" {
+		stem, ext = base, ""
+	}
+	for n := 1; n <= maxRenameAttempts; n++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, n, ext))
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free name for %s", base)
+}
+
+// copyProgress is invoked after each regular file has been copied.
+type copyProgress func(files int, bytes int64)
+
+// copyTree copies source to target, which must not exist unless merge is set.
+// Directories are created before their contents and their modes and mtimes
+// are applied afterwards, so copying files cannot disturb the preserved times.
+// Only regular files and directories are copied; other entries such as
+// symlinks are skipped because they could point outside the allowed roots.
+func (h *Handler) copyTree(ctx context.Context, source, target string, merge bool, progress copyProgress) error {
+	type directoryTimes struct {
+		path string
+		info fs.FileInfo
+	}
+	var directories []directoryTimes
+	var files int
+	var bytes int64
+	err := filepath.WalkDir(source, func(path string, entry fs.DirEntry, walkErr error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if walkErr != nil {
+			return walkErr
+		}
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		destination, err := secureJoin(target, relative)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			if err := os.Mkdir(destination, 0700); err != nil && !(merge && os.IsExist(err)) {
+				return err
+			}
+			directories = append(directories, directoryTimes{path: destination, info: info})
+		case info.Mode().IsRegular():
+			if err := h.copyRegularFile(ctx, path, destination, info, merge); err != nil {
+				return err
+			}
+			files++
+			bytes += info.Size()
+			if progress != nil {
+				progress(files, bytes)
+			}
+		default:
+			h.logger.Warn("Skipping non-regular file during copy", "path", path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(directories) - 1; i >= 0; i-- {
+		directory := directories[i]
+		if err := os.Chmod(directory.path, directory.info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(directory.path, directory.info.ModTime(), directory.info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyRegularFile writes a sibling temporary file and renames it into place,
+// so an interrupted copy never leaves a truncated file under the target name.
+func (h *Handler) copyRegularFile(ctx context.Context, source, target string, info fs.FileInfo, overwrite bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !overwrite {
+		if _, err := os.Lstat(target); err == nil {
+			return errTargetExists
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	src, err := h.openAllowedPath(source, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+	suffix, err := newUploadID()
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(filepath.Dir(target), copyingPrefix+suffix)
+	dst, err := h.openAllowedPath(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+	copyErr := copyFileData(dst, src)
+	if copyErr == nil {
+		copyErr = dst.Chmod(info.Mode().Perm())
+	}
+	if copyErr == nil {
+		copyErr = dst.Sync()
+	}
+	if closeErr := dst.Close(); copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+	if err := os.Chtimes(tmpPath, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	if !overwrite {
+		// A file created since the check above is kept.
+		err := renameNoReplace(tmpPath, target)
+		if errors.Is(err, fs.ErrExist) {
+			return errTargetExists
+		}
+		return err
+	}
+	return os.Rename(tmpPath, target)
+}
+
+// copyFileData asks the filesystem for a reflink first. Otherwise
+// (*os.File).ReadFrom uses copy_file_range(2) on Linux and falls back to a
+// buffered copy elsewhere.
+func copyFileData(dst, src *os.File) error {
+	if cloneFile(dst, src) == nil {
+		return nil
+	}
+	_, err := dst.ReadFrom(src)
+	return err
+}
+
+// copyPath copies one file or directory tree according to policy and returns
+// the path that was written. A skipped copy returns an empty path.
+func (h *Handler) copyPath(ctx context.Context, source, target string, policy conflictPolicy, progress copyProgress) (string, error) {
+	sourceFile, err := h.openAllowedPath(source, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	info, err := sourceFile.Stat()
+	_ = sourceFile.Close()
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() && isPathWithin(source, target) {
+		return "", errCopyIntoItself
+	}
+	if _, err := os.Stat(filepath.Dir(target)); err != nil {
+		return "", fmt.Errorf("target directory does not exist")
+	}
+
+	existing, statErr := os.Lstat(target)
+	if statErr != nil && !os.IsNotExist(statErr) {
+		return "", statErr
+	}
+	if statErr == nil {
+		switch policy {
+		case conflictSkip:
+			return "", nil
+		case conflictRename:
+		case conflictOverwrite:
+			if existing.IsDir() != info.IsDir() {
+				return "", fmt.Errorf("cannot overwrite a %s with a %s", describeKind(existing), describeKind(info))
+			}
+			if info.IsDir() {
+				// Overwriting a directory merges into it; files with the same
+				// name are replaced one at a time and unrelated files are left
+				// alone. The target is journaled so that the sweep at startup
+				// finds files an interrupted merge did not publish.
+				forget, err := h.journalStaging(target)
+				if err != nil {
+					return "", err
+				}
+				defer forget()
+				return target, h.copyTree(ctx, source, target, true, progress)
+			}
+		default:
+			return "", errTargetExists
+		}
+	}
+
+	// Copies are staged beside the target and published with a single rename,
+	// so other clients never list a partially copied file or directory.
+	staging, cleanup, err := h.createStagingDir(filepath.Dir(target), copyStagingPrefix)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = cleanup() }()
+	staged := filepath.Join(staging, filepath.Base(target))
+	if err := h.copyTree(ctx, source, staged, false, progress); err != nil {
+		return "", err
+	}
+	return publishCopy(staged, target, info.IsDir(), policy)
+}
+
+// publishCopy renames a staged copy to target. Whether target exists is
+// decided by the rename itself, so a file created since the policy was
+// checked is never replaced unless the policy is overwrite.
+func publishCopy(staged, target string, isDir bool, policy conflictPolicy) (string, error) {
+	if policy == conflictOverwrite && !isDir {
+		return target, os.Rename(staged, target)
+	}
+	original := target
+	for attempt := 0; attempt < maxRenameAttempts; attempt++ {
+		err := renameNoReplace(staged, target)
+		if err == nil {
+			return target, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+		switch policy {
+		case conflictSkip:
+			return "", nil
+		case conflictRename:
+			if target, err = nextAvailableName(original); err != nil {
+				return "", err
+			}
+		default:
+			return "", errTargetExists
+		}
+	}
+	return "", errTargetExists
+}
+
+func describeKind(info fs.FileInfo) string {
+	if info.IsDir() {
+		return "directory"
+	}
+	return "file"
+}
+
+// CopyFile duplicates a file or directory tree between allowed roots.
+func (h *Handler) CopyFile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SourcePath string `json:"sourcePath"`
+		TargetPath string `json:"targetPath"`
+		OnConflict string `json:"onConflict"`
+		Async      bool   `json:"async"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode request body for copying file", "error", err)
+		h.respondError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.SourcePath == "" || req.TargetPath == "" {
+		h.respondError(w, "Source and target paths required", http.StatusBadRequest)
+		return
+	}
+	if len(req.SourcePath) > maxVirtualPathBytes || len(req.TargetPath) > maxVirtualPathBytes {
+		h.respondError(w, "Path is too long", http.StatusBadRequest)
+		return
+	}
+	policy, err := parseConflictPolicy(req.OnConflict, conflictFail)
+	if err != nil {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sourceFullPath, err := h.convertToPhysicalPath(req.SourcePath)
+	if err != nil {
+		h.logger.Error("Invalid source path for copying", "path", req.SourcePath, "error", err)
+		h.respondError(w, "Invalid source path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.isProtectedRoot(sourceFullPath) {
+		h.respondError(w, "Cannot copy a protected root", http.StatusBadRequest)
+		return
+	}
+	targetFullPath, err := h.convertToPhysicalPath(req.TargetPath)
+	if err != nil {
+		h.logger.Error("Invalid target path for copying", "path", req.TargetPath, "error", err)
+		h.respondError(w, "Invalid target path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.isProtectedRoot(targetFullPath) {
+		h.respondError(w, "Cannot overwrite a protected root", http.StatusBadRequest)
+		return
+	}
+	if req.Async {
+		h.startJob(w, "copy", func(ctx context.Context, j *job) (interface{}, error) {
+			if err := runGated(ctx, j, h.copyGate); err != nil {
+				return nil, err
+			}
+			defer release(h.copyGate)
+			written, err := h.copyPath(ctx, sourceFullPath, targetFullPath, policy, func(_ int, bytes int64) { j.setProgress(bytes, 0) })
+			if err != nil {
+				return nil, err
+			}
+			if written == "" {
+				return map[string]interface{}{"skipped": true}, nil
+			}
+			h.invalidateCopiedPath(written)
+			return map[string]interface{}{"path": h.convertToVirtualPath(written), "skipped": false}, nil
+		})
+		return
+	}
+	if !tryAcquire(h.copyGate) {
+		respondBusy(w)
+		return
+	}
+	defer release(h.copyGate)
+
+	resultChan := worker.SubmitWithResult(h.workerPool, func() interface{} {
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
+		defer cancel()
+		written, err := h.copyPath(ctx, sourceFullPath, targetFullPath, policy, nil)
+		if err != nil {
+			return err
+		}
+		return written
+	})
+
+	result := <-resultChan
+	if err, ok := result.(error); ok && err != nil {
+		h.logger.Error("Failed to copy file", "source", sourceFullPath, "target", targetFullPath, "error", err)
+		switch {
+		case errors.Is(err, errTargetExists):
+			h.respondError(w, "Target already exists", http.StatusConflict)
+		case errors.Is(err, errCopyIntoItself):
+			h.respondError(w, "Cannot copy a directory into itself", http.StatusBadRequest)
+		default:
+			h.respondError(w, "Cannot copy file: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	written, _ := result.(string)
+	if written == "" {
+		h.respondSuccess(w, map[string]interface{}{"message": "Target already exists; copy skipped", "skipped": true})
+		return
+	}
+
+	h.invalidateCopiedPath(written)
+	h.respondSuccess(w, map[string]interface{}{
+		"message": "File copied successfully",
+		"path":    h.convertToVirtualPath(written),
+		"skipped": false,
+	})
+}
+
+func (h *Handler) invalidateCopiedPath(written string) {
+	// キャッシュを無効化
+	h.invalidateFileCache(written)
+	cache.InvalidateByPrefix(h.cache, "list:"+filepath.Dir(h.convertToVirtualPath(written)))
+	cache.InvalidateByPrefix(h.cache, "search:")
+	h.publishFileChanges(eventFilesCreated, []string{written}, nil)
+}
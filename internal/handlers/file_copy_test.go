@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyFilePreservesTreeModesAndTimes(t *testing.T) {
+	h := newContentTestHandler(t)
+	source := filepath.Join(h.config.StorageDir, "album")
+	if err := os.MkdirAll(filepath.Join(source, "disc1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	track := filepath.Join(source, "disc1", "track.txt")
+	writeTestFile(t, track, "music")
+	if err := os.Chmod(track, 0640); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, path := range []string{track, filepath.Join(source, "disc1"), source} {
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res := httptest.NewRecorder()
+	h.CopyFile(res, httptest.NewRequest(http.MethodPost, "/api/files/copy", strings.NewReader(`{"sourcePath":"/album","targetPath":"/copy"}`)))
+	if res.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", res.Code, res.Body.String())
+	}
+	copied := filepath.Join(h.config.StorageDir, "copy", "disc1", "track.txt")
+	content, err := os.ReadFile(copied)
+	if err != nil || string(content) != "music" {
+		t.Fatalf("copied content = %q, err = %v", content, err)
+	}
+	for _, path := range []string{copied, filepath.Dir(copied), filepath.Join(h.config.StorageDir, "copy")} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(modified) {
+			t.Fatalf("%s mtime = %v, want %v", path, info.ModTime(), modified)
+		}
+	}
+	if info, _ := os.Stat(copied); info.Mode().Perm() != 0640 {
+		t.Fatalf("copied mode = %v, want 0640", info.Mode().Perm())
+	}
+	if _, err := os.Stat(track); err != nil {
+		t.Fatalf("source was modified: %v", err)
+	}
+}
+
+func TestCopyFileConflictPolicies(t *testing.T) {
+	h := newContentTestHandler(t)
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "a.txt"), "new")
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "b.txt"), "old")
+
+	copyWith := func(policy string) *httptest.ResponseRecorder {
+		t.Helper()
+		body := `{"sourcePath":"/a.txt","targetPath":"/b.txt","onConflict":"` + policy + `"}`
+		res := httptest.NewRecorder()
+		h.CopyFile(res, httptest.NewRequest(http.MethodPost, "/api/files/copy", strings.NewReader(body)))
+		return res
+	}
+	readTarget := func(name string) string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(h.config.StorageDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	if res := copyWith(""); res.Code != http.StatusConflict {
+		t.Fatalf("default policy status = %d", res.Code)
+	}
+	if res := copyWith("skip"); res.Code != http.StatusOK || readTarget("b.txt") != "old" {
+		t.Fatalf("skip status = %d, target = %q", res.Code, readTarget("b.txt"))
+	}
+	if res := copyWith("rename"); res.Code != http.StatusOK || readTarget("b (1).txt") != "new" {
+		t.Fatalf("rename status = %d, body = %s", res.Code, res.Body.String())
+	}
+	if res := copyWith("overwrite"); res.Code != http.StatusOK || readTarget("b.txt") != "new" {
+		t.Fatalf("overwrite status = %d, target = %q", res.Code, readTarget("b.txt"))
+	}
+	if res := copyWith("clobber"); res.Code != http.StatusBadRequest {
+		t.Fatalf("invalid policy status = %d", res.Code)
+	}
+}
+
+func TestCopyFileRejectsCopyIntoItself(t *testing.T) {
+	h := newContentTestHandler(t)
+	if err := os.Mkdir(filepath.Join(h.config.StorageDir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	res := httptest.NewRecorder()
+	h.CopyFile(res, httptest.NewRequest(http.MethodPost, "/api/files/copy", strings.NewReader(`{"sourcePath":"/dir","targetPath":"/dir/inner"}`)))
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, body = %s", res.Code, res.Body.String())
+	}
+}
+
+func TestPublishCopyNeverReplacesTargetsCreatedAfterTheCheck(t *testing.T) {
+	dir := t.TempDir()
+	staged := filepath.Join(dir, "staged.txt")
+	writeTestFile(t, filepath.Join(dir, "b.txt"), "old")
+	writeTestFile(t, filepath.Join(dir, "b (1).txt"), "other")
+
+	for _, policy := range []conflictPolicy{conflictFail, conflictSkip, conflictRename} {
+		writeTestFile(t, staged, "new")
+		written, err := publishCopy(staged, filepath.Join(dir, "b.txt"), false, policy)
+		switch policy {
+		case conflictFail:
+			if !errors.Is(err, errTargetExists) {
+				t.Fatalf("fail: written = %q, err = %v", written, err)
+			}
+		case conflictSkip:
+			if err != nil || written != "" {
+				t.Fatalf("skip: written = %q, err = %v", written, err)
+			}
+		case conflictRename:
+			if err != nil || filepath.Base(written) != "b (2).txt" {
+				t.Fatalf("rename: written = %q, err = %v", written, err)
+			}
+		}
+	}
+	for name, want := range map[string]string{"b.txt": "old", "b (1).txt": "other", "b (2).txt": "new"} {
+		if content, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(content) != want {
+			t.Fatalf("%s = %q, err = %v", name, content, err)
+		}
+	}
+}
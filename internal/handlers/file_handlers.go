@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -30,83 +31,9 @@ import (
 	"github.com/mholt/archives"
 )
 
-const (
-	thumbnailDir                   = ".cache/thumbnails"
-	thumbnailMaxBytes        int64 = 256 << 20
-	thumbnailMaxFiles              = 4096
-	thumbnailCleanupInterval       = 5 * time.Minute
-	thumbnailTempTTL               = 10 * time.Minute
-)
-
-type thumbnailCacheEntry struct {
-	path    string
-	size    int64
-	modTime time.Time
-}
-
-func cleanupThumbnailCache(dir string, maxBytes int64, maxFiles int) error {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return err
-	}
-
-	now := time.Now()
-	cacheEntries := make([]thumbnailCacheEntry, 0, len(entries))
-	for _, entry := range entries {
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
-		if strings.HasPrefix(entry.Name(), ".thumbnail-") {
-			if now.Sub(info.ModTime()) >= thumbnailTempTTL {
-				_ = os.Remove(filepath.Join(dir, entry.Name()))
-			}
-			continue
-		}
-		if !strings.HasSuffix(entry.Name(), ".jpg") || !info.Mode().IsRegular() {
-			continue
-		}
-		cacheEntries = append(cacheEntries, thumbnailCacheEntry{
-			path:    filepath.Join(dir, entry.Name()),
-			size:    info.Size(),
-			modTime: info.ModTime(),
-		})
-	}
-
-	sort.Slice(cacheEntries, func(i, j int) bool {
-		return cacheEntries[i].modTime.Before(cacheEntries[j].modTime)
-	})
-
-	var totalBytes int64
-	for _, entry := range cacheEntries {
-		totalBytes += entry.size
-	}
-	for len(cacheEntries) > 0 && (totalBytes > maxBytes || len(cacheEntries) > maxFiles) {
-		oldest := cacheEntries[0]
-		cacheEntries = cacheEntries[1:]
-		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
-			return err
-		}
-		totalBytes -= oldest.size
-	}
-	return nil
-}
-
-func (h *Handler) cleanupThumbnailCacheIfDue(dir string, force bool) error {
-	h.thumbnailCleanupMu.Lock()
-	defer h.thumbnailCleanupMu.Unlock()
-
-	now := time.Now()
-	if !force && now.Sub(h.lastThumbnailCleanup) < thumbnailCleanupInterval {
-		return nil
-	}
-	h.lastThumbnailCleanup = now
-	return cleanupThumbnailCache(dir, thumbnailMaxBytes, thumbnailMaxFiles)
-}
-
-func (h *Handler) generateThumbnail(ctx context.Context, videoPath, thumbnailPath string, extraFiles ...*os.File) error {
+func (h *Handler) generateThumbnail(ctx context.Context, videoPath, thumbnailPath string, size int, extraFiles ...*os.File) error {
 	// ffmpeg command to generate thumbnail
-	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", videoPath, "-ss", "00:00:01", "-vframes", "1", "-vf", "thumbnail,scale=320:-1", "-y", thumbnailPath)
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", videoPath, "-ss", "00:00:01", "-vframes", "1", "-vf", fmt.Sprintf("thumbnail,scale=%d:-1", size), "-y", thumbnailPath)
 	cmd.ExtraFiles = extraFiles
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -119,15 +46,67 @@ func (h *Handler) generateThumbnail(ctx context.Context, videoPath, thumbnailPat
 	return nil
 }
 
+// ensureThumbnail returns the cached thumbnail of fullPath, generating it
+// first when needed. Images are scaled in-process; other media go through
+// ffmpeg.
+func (h *Handler) ensureThumbnail(ctx context.Context, virtualPath, fullPath string, size int) (string, error) {
+	source, err := h.openAllowedPath(fullPath, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = source.Close() }()
+	info, err := source.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("cannot create a thumbnail of a directory")
+	}
+	thumbnailPath := h.thumbnails.path(virtualPath, info, size)
+	if h.thumbnails.touch(thumbnailPath) {
+		return thumbnailPath, nil
+	}
+
+	tmp, err := os.CreateTemp(h.thumbnails.dir, ".thumbnail-*.jpg")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmp.Name()
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+	if isNativeThumbnail(fullPath) {
+		err = generateImageThumbnail(source, tmpPath, size)
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		videoPath, extraFiles := childProcessFilePath(source, fullPath)
+		err = h.generateThumbnail(ctx, videoPath, tmpPath, size, extraFiles...)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpPath, thumbnailPath); err != nil {
+		return "", err
+	}
+	if err := h.thumbnails.cleanupIfDue(true); err != nil {
+		h.logger.Warn("Failed to enforce thumbnail cache limit", "path", h.thumbnails.dir, "error", err)
+	}
+	return thumbnailPath, nil
+}
+
+// Thumbnail serves a JPEG preview of an image or video. The optional size
+// parameter selects the longest side: 160, 320 (default), or 640.
 func (h *Handler) Thumbnail(w http.ResponseWriter, r *http.Request) {
 	// ensure thumbnail directory exists
-	if err := os.MkdirAll(thumbnailDir, 0755); err != nil {
-		h.logger.Error("Failed to create thumbnail directory", "path", thumbnailDir, "error", err)
+	if err := h.thumbnails.ensureDir(); err != nil {
+		h.logger.Error("Failed to create thumbnail directory", "path", h.thumbnails.dir, "error", err)
 		h.respondError(w, "Cannot create thumbnail directory", http.StatusInternalServerError)
 		return
 	}
-	if err := h.cleanupThumbnailCacheIfDue(thumbnailDir, false); err != nil {
-		h.logger.Warn("Failed to clean thumbnail cache", "path", thumbnailDir, "error", err)
+	if err := h.thumbnails.cleanupIfDue(false); err != nil {
+		h.logger.Warn("Failed to clean thumbnail cache", "path", h.thumbnails.dir, "error", err)
 	}
 
 	path := r.URL.Query().Get("path")
@@ -139,6 +118,15 @@ func (h *Handler) Thumbnail(w http.ResponseWriter, r *http.Request) {
 		h.respondError(w, "Path is too long", http.StatusBadRequest)
 		return
 	}
+	size := defaultThumbnailSize
+	if value := r.URL.Query().Get("size"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || !thumbnailSizes[parsed] {
+			h.respondError(w, "Invalid thumbnail size", http.StatusBadRequest)
+			return
+		}
+		size = parsed
+	}
 
 	fullPath, err := h.convertToPhysicalPath(path)
 	if err != nil {
@@ -150,81 +138,57 @@ func (h *Handler) Thumbnail(w http.ResponseWriter, r *http.Request) {
 		h.respondError(w, "Cannot upload directly to a protected root", http.StatusBadRequest)
 		return
 	}
-	if !tryAcquire(h.thumbnailGate) {
-		respondBusy(w)
-		return
-	}
-	defer release(h.thumbnailGate)
 	source, err := h.openAllowedPath(fullPath, os.O_RDONLY, 0)
 	if err != nil {
-		h.respondError(w, "Cannot inspect video", http.StatusBadRequest)
+		h.respondError(w, "Cannot inspect file", http.StatusBadRequest)
 		return
 	}
 	info, err := source.Stat()
 	_ = source.Close()
 	if err != nil || info.IsDir() {
-		h.respondError(w, "Cannot inspect video", http.StatusBadRequest)
+		h.respondError(w, "Cannot inspect file", http.StatusBadRequest)
 		return
 	}
 
-	// Include versioned file metadata so replacing a video cannot reuse stale art.
-	hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano())))
-	thumbnailFilename := hex.EncodeToString(hash[:]) + ".jpg"
-	thumbnailPath := filepath.Join(thumbnailDir, thumbnailFilename)
-
 	// check if thumbnail already exists
-	if _, err := os.Stat(thumbnailPath); err == nil {
+	thumbnailPath := h.thumbnails.path(path, info, size)
+	if h.thumbnails.lookup(thumbnailPath) {
 		http.ServeFile(w, r, thumbnailPath)
 		return
 	}
+	if !tryAcquire(h.thumbnailGate) {
+		respondBusy(w)
+		return
+	}
+	defer release(h.thumbnailGate)
 
 	// generate thumbnail
 	resultChan := worker.SubmitWithResult(h.workerPool, func() interface{} {
-		source, err := h.openAllowedPath(fullPath, os.O_RDONLY, 0)
+		thumbnailPath, err := h.ensureThumbnail(r.Context(), path, fullPath, size)
 		if err != nil {
 			return err
 		}
-		defer func() { _ = source.Close() }()
-		tmp, err := os.CreateTemp(thumbnailDir, ".thumbnail-*.jpg")
-		if err != nil {
-			return err
-		}
-		tmpPath := tmp.Name()
-		if err := tmp.Close(); err != nil {
-			return err
-		}
-		defer func() { _ = os.Remove(tmpPath) }()
-		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
-		defer cancel()
-		videoPath, extraFiles := childProcessFilePath(source, fullPath)
-		if err := h.generateThumbnail(ctx, videoPath, tmpPath, extraFiles...); err != nil {
-			return err
-		}
-		if err := os.Rename(tmpPath, thumbnailPath); err != nil {
-			return err
-		}
-		if err := h.cleanupThumbnailCacheIfDue(thumbnailDir, true); err != nil {
-			h.logger.Warn("Failed to enforce thumbnail cache limit", "path", thumbnailDir, "error", err)
-		}
-		return nil
+		return thumbnailPath
 	})
 
 	result := <-resultChan
-	if err, ok := result.(error); ok && err != nil {
-		h.logger.Error("Failed to generate thumbnail", "path", fullPath, "error", err)
+	generated, ok := result.(string)
+	if !ok {
+		h.logger.Error("Failed to generate thumbnail", "path", fullPath, "error", result)
 		// respond with placeholder image or error
 		h.respondError(w, "Cannot generate thumbnail", http.StatusInternalServerError)
 		return
 	}
 
 	// serve the generated thumbnail
-	http.ServeFile(w, r, thumbnailPath)
+	http.ServeFile(w, r, generated)
 }
 
 // ExtractFile - アーカイブファイルを解凍する
 func (h *Handler) ExtractFile(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Path string `json:"path"`
+		Path  string `json:"path"`
+		Async bool   `json:"async"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -258,6 +222,20 @@ func (h *Handler) ExtractFile(w http.ResponseWriter, r *http.Request) {
 		h.respondError(w, "Cannot inspect extraction destination", http.StatusInternalServerError)
 		return
 	}
+	if req.Async {
+		h.startJob(w, "extract", func(ctx context.Context, j *job) (interface{}, error) {
+			if err := runGated(ctx, j, h.extractGate); err != nil {
+				return nil, err
+			}
+			defer release(h.extractGate)
+			if err := h.extractArchive(ctx, sourcePath, destPath, func(files int) { j.setProgress(int64(files), 0) }); err != nil {
+				return nil, err
+			}
+			h.invalidateExtractedArchive(sourcePath, destPath)
+			return map[string]string{"path": h.convertToVirtualPath(destPath)}, nil
+		})
+		return
+	}
 	if !tryAcquire(h.extractGate) {
 		respondBusy(w)
 		return
@@ -268,116 +246,129 @@ func (h *Handler) ExtractFile(w http.ResponseWriter, r *http.Request) {
 	resultChan := worker.SubmitWithResult(h.workerPool, func() interface{} {
 		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute) // 30分タイムアウト
 		defer cancel()
-		tempPath, err := os.MkdirTemp(filepath.Dir(destPath), ".puremania-extract-*")
-		if err != nil {
-			return fmt.Errorf("cannot create extraction staging directory: %w", err)
-		}
-		defer func() { _ = os.RemoveAll(tempPath) }()
-		var extractedBytes int64
-		var extractedFiles int
-		maxBytes := h.config.MaxZipSize << 20
+		return h.extractArchive(ctx, sourcePath, destPath, nil)
+	})
 
-		source, err := os.Open(sourcePath)
-		if err != nil {
-			return fmt.Errorf("cannot open source file: %w", err)
-		}
-		defer func() {
-			if err := source.Close(); err != nil {
-				h.logger.Error("Failed to close source file", "path", sourcePath, "error", err)
-			}
-		}()
+	result := <-resultChan
+	if err, ok := result.(error); ok && err != nil {
+		h.logger.Error("Failed to extract file", "path", req.Path, "error", err)
+		h.respondError(w, "Cannot extract file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		format, stream, err := archives.Identify(ctx, sourcePath, source)
-		if err != nil {
-			return fmt.Errorf("could not identify archive format: %w", err)
-		}
+	h.invalidateExtractedArchive(sourcePath, destPath)
+	h.respondSuccess(w, map[string]string{"message": "File extracted successfully"})
+}
 
-		handler := func(ctx context.Context, f archives.FileInfo) error {
-			if ctx.Err() != nil {
-				return ctx.Err()
-			}
-			extractedFiles++
-			if extractedFiles > 10000 {
-				return fmt.Errorf("archive has too many files")
-			}
-			dest, err := secureJoin(tempPath, f.NameInArchive)
-			if err != nil {
-				return fmt.Errorf("unsafe file path in archive: %s", f.NameInArchive)
-			}
+func (h *Handler) invalidateExtractedArchive(sourcePath, destPath string) {
+	// キャッシュを無効化
+	parentDir := filepath.Dir(sourcePath)
+	cache.InvalidateByPrefix(h.cache, "list:"+h.convertToVirtualPath(parentDir))
+	cache.InvalidateByPrefix(h.cache, "search:")
+	h.publishFileChanges(eventArchiveExtracted, []string{destPath}, []string{sourcePath})
+}
 
-			if f.IsDir() {
-				return os.MkdirAll(dest, f.Mode())
-			}
+// extractArchive unpacks sourcePath into a staging directory and publishes it
+// as destPath with one rename. progress, when set, receives the entry count.
+func (h *Handler) extractArchive(ctx context.Context, sourcePath, destPath string, progress func(files int)) error {
+	tempPath, err := os.MkdirTemp(filepath.Dir(destPath), ".puremania-extract-*")
+	if err != nil {
+		return fmt.Errorf("cannot create extraction staging directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tempPath) }()
+	var extractedBytes int64
+	var extractedFiles int
+	maxBytes := h.config.MaxZipSize << 20
 
-			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
-				return err
-			}
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("cannot open source file: %w", err)
+	}
+	defer func() {
+		if err := source.Close(); err != nil {
+			h.logger.Error("Failed to close source file", "path", sourcePath, "error", err)
+		}
+	}()
 
-			file, err := f.Open()
-			if err != nil {
-				return fmt.Errorf("could not open file in archive: %w", err)
-			}
-			defer func() {
-				if err := file.Close(); err != nil {
-					h.logger.Error("Failed to close file in archive", "path", f.NameInArchive, "error", err)
-				}
-			}()
+	format, stream, err := archives.Identify(ctx, sourcePath, source)
+	if err != nil {
+		return fmt.Errorf("could not identify archive format: %w", err)
+	}
 
-			createdFile, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, f.Mode())
-			if err != nil {
-				return fmt.Errorf("could not create destination file: %w", err)
-			}
-			defer func() {
-				if err := createdFile.Close(); err != nil {
-					h.logger.Error("Failed to close destination file", "path", dest, "error", err)
-				}
-			}()
+	handler := func(ctx context.Context, f archives.FileInfo) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		extractedFiles++
+		if extractedFiles > 10000 {
+			return fmt.Errorf("archive has too many files")
+		}
+		if progress != nil {
+			progress(extractedFiles)
+		}
+		dest, err := secureJoin(tempPath, f.NameInArchive)
+		if err != nil {
+			return fmt.Errorf("unsafe file path in archive: %s", f.NameInArchive)
+		}
 
-			written, err := io.Copy(createdFile, io.LimitReader(file, maxBytes-extractedBytes+1))
-			extractedBytes += written
-			if extractedBytes > maxBytes {
-				return fmt.Errorf("archive exceeds extraction size limit")
-			}
-			return err
+		if f.IsDir() {
+			return os.MkdirAll(dest, f.Mode())
 		}
 
-		switch f := format.(type) {
-		case archives.Zip:
-			err = f.Extract(ctx, stream, handler)
-		case archives.Tar:
-			err = f.Extract(ctx, stream, handler)
-		case archives.SevenZip:
-			err = f.Extract(ctx, stream, handler)
-		case archives.Rar:
-			err = f.Extract(ctx, stream, handler)
-		case archives.CompressedArchive:
-			err = f.Extract(ctx, stream, handler)
-		default:
-			return fmt.Errorf("format %T is not a supported archive format for extraction", f)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
 		}
 
+		file, err := f.Open()
 		if err != nil {
-			return fmt.Errorf("extraction failed: %w", err)
+			return fmt.Errorf("could not open file in archive: %w", err)
 		}
-		if err := os.Rename(tempPath, destPath); err != nil {
-			return fmt.Errorf("cannot publish extraction: %w", err)
+		defer func() {
+			if err := file.Close(); err != nil {
+				h.logger.Error("Failed to close file in archive", "path", f.NameInArchive, "error", err)
+			}
+		}()
+
+		createdFile, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, f.Mode())
+		if err != nil {
+			return fmt.Errorf("could not create destination file: %w", err)
 		}
-		return nil
-	})
+		defer func() {
+			if err := createdFile.Close(); err != nil {
+				h.logger.Error("Failed to close destination file", "path", dest, "error", err)
+			}
+		}()
 
-	result := <-resultChan
-	if err, ok := result.(error); ok && err != nil {
-		h.logger.Error("Failed to extract file", "path", req.Path, "error", err)
-		h.respondError(w, "Cannot extract file: "+err.Error(), http.StatusInternalServerError)
-		return
+		written, err := io.Copy(createdFile, io.LimitReader(file, maxBytes-extractedBytes+1))
+		extractedBytes += written
+		if extractedBytes > maxBytes {
+			return fmt.Errorf("archive exceeds extraction size limit")
+		}
+		return err
 	}
 
-	// キャッシュを無効化
-	parentDir := filepath.Dir(sourcePath)
-	cache.InvalidateByPrefix(h.cache, "list:"+h.convertToVirtualPath(parentDir))
-	cache.InvalidateByPrefix(h.cache, "search:")
+	switch f := format.(type) {
+	case archives.Zip:
+		err = f.Extract(ctx, stream, handler)
+	case archives.Tar:
+		err = f.Extract(ctx, stream, handler)
+	case archives.SevenZip:
+		err = f.Extract(ctx, stream, handler)
+	case archives.Rar:
+		err = f.Extract(ctx, stream, handler)
+	case archives.CompressedArchive:
+		err = f.Extract(ctx, stream, handler)
+	default:
+		return fmt.Errorf("format %T is not a supported archive format for extraction", f)
+	}
 
-	h.respondSuccess(w, map[string]string{"message": "File extracted successfully"})
+	if err != nil {
+		return fmt.Errorf("extraction failed: %w", err)
+	}
+	if err := os.Rename(tempPath, destPath); err != nil {
+		return fmt.Errorf("cannot publish extraction: %w", err)
+	}
+	return nil
 }
 
 // Optimized buffer sizes for different operations
@@ -408,15 +399,25 @@ func (h *Handler) ListFiles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 1. 現在のディレクトリ状態からETagを生成
-	currentStateKey, err := h.generateDirectoryStateKey(path)
+	fullPath, err := h.convertToPhysicalPath(path)
+	if err != nil {
+		h.respondError(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	currentStateKey, err := h.directoryStateKey(path, fullPath)
 	if err != nil {
 		h.logger.Error("Failed to generate directory state key", "path", path, "error", err)
 		// ETag生成に失敗した場合は、キャッシュを使わずに通常処理
-		h.serveFreshFileList(w, path, "") // ETagなしで提供
+		h.serveFreshFileList(w, path, "", "") // ETagなしで提供
+		return
+	}
+	cacheKey := listCacheKey(h.cleanVirtualPath(fullPath), currentStateKey)
+	if r.URL.Query().Get("mode") == "timeline" {
+		h.serveTimeline(w, r, path, currentStateKey, cacheKey)
 		return
 	}
 	if limit, parseErr := strconv.Atoi(r.URL.Query().Get("limit")); parseErr == nil && limit > 0 {
-		h.servePaginatedFileList(w, r, path, currentStateKey, limit)
+		h.servePaginatedFileList(w, r, path, currentStateKey, cacheKey, limit)
 		return
 	}
 
@@ -431,7 +432,6 @@ func (h *Handler) ListFiles(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("ETag", currentStateKey)
 
 	// 4. ETagに基づいたキャッシュを確認
-	cacheKey := "list:" + currentStateKey // キーはETagだけで十分
 	if cached, found := cache.Get(h.cache, cacheKey); found {
 		if fileInfos, ok := cached.([]types.FileInfo); ok {
 			h.respondSuccess(w, fileInfos)
@@ -440,7 +440,7 @@ func (h *Handler) ListFiles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 5. キャッシュがない場合は、新しいファイルリストを生成
-	h.serveFreshFileList(w, path, currentStateKey)
+	h.serveFreshFileList(w, path, currentStateKey, cacheKey)
 }
 
 type directoryPage struct {
@@ -451,7 +451,7 @@ type directoryPage struct {
 	Total      int              `json:"total"`
 }
 
-func (h *Handler) servePaginatedFileList(w http.ResponseWriter, r *http.Request, path, stateKey string, limit int) {
+func (h *Handler) servePaginatedFileList(w http.ResponseWriter, r *http.Request, path, stateKey, cacheKey string, limit int) {
 	if limit > 500 {
 		limit = 500
 	}
@@ -476,7 +476,6 @@ func (h *Handler) servePaginatedFileList(w http.ResponseWriter, r *http.Request,
 	}
 	w.Header().Set("ETag", etag)
 
-	cacheKey := "list:" + stateKey
 	var files []types.FileInfo
 	if cached, found := cache.Get(h.cache, cacheKey); found {
 		files, _ = cached.([]types.FileInfo)
@@ -547,7 +546,7 @@ func fileSortType(file types.FileInfo) string {
 }
 
 // serveFreshFileList は新しいファイルリストを生成し、必要に応じてキャッシュに保存する
-func (h *Handler) serveFreshFileList(w http.ResponseWriter, path string, etag string) {
+func (h *Handler) serveFreshFileList(w http.ResponseWriter, path, etag, cacheKey string) {
 	fileInfos, err := h.getFileList(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -562,7 +561,6 @@ func (h *Handler) serveFreshFileList(w http.ResponseWriter, path string, etag st
 
 	// 結果をキャッシュ（ETagがあれば）
 	if etag != "" {
-		cacheKey := "list:" + etag
 		// size of fileInfos is roughly len(fileInfos) * 200 bytes
 		cache.Set(h.cache, cacheKey, fileInfos, int64(len(fileInfos)*200), CacheTTL)
 	}
@@ -619,9 +617,10 @@ func (h *Handler) processDirectoryEntries(entries []os.DirEntry, basePath string
 
 	// 並列処理でエントリーを処理
 	for _, entry := range entries {
-		// Resumable-upload parts and metadata are internal implementation data,
-		// never files the browser should present as user content.
-		if filepath.Clean(basePath) == filepath.Clean(h.config.StorageDir) && entry.Name() == resumableUploadDir {
+		// Upload sessions, the trash, and the staging areas of moves and copies
+		// are internal implementation data, never user content. Trashed items
+		// are listed through the trash API only.
+		if isInternalName(entry.Name()) {
 			continue
 		}
 		wg.Add(1)
@@ -734,6 +733,19 @@ func (h *Handler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		h.respondError(w, "Mismatch between files and relative paths", http.StatusBadRequest)
 		return
 	}
+	// Optional client modification times (Unix milliseconds), one per file.
+	lastModifiedValues := r.MultipartForm.Value["lastModified[]"]
+	if len(lastModifiedValues) != 0 && len(lastModifiedValues) != len(files) {
+		h.respondError(w, "Mismatch between files and modification times", http.StatusBadRequest)
+		return
+	}
+	lastModified := make([]int64, len(files))
+	for i, value := range lastModifiedValues {
+		if lastModified[i], err = strconv.ParseInt(value, 10, 64); err != nil || lastModified[i] < 0 {
+			h.respondError(w, "Invalid lastModified", http.StatusBadRequest)
+			return
+		}
+	}
 
 	// 並列アップロード処理
 	resultChan := make(chan types.UploadResult, len(files))
@@ -816,12 +828,14 @@ func (h *Handler) UploadFile(w http.ResponseWriter, r *http.Request) {
 				resultChan <- types.UploadResult{Path: relativePath, Success: false}
 				return
 			}
+			h.applyUploadModTime(targetPath, lastModified[index])
 			virtualPath := h.convertToVirtualPath(targetPath)
 			resultChan <- types.UploadResult{Path: virtualPath, Success: true}
 
 			// キャッシュクリア
 			cache.InvalidateByPrefix(h.cache, "list:"+filepath.Dir(h.convertToVirtualPath(targetDir)))
 			cache.InvalidateByPrefix(h.cache, "search:")
+			h.publishFileChanges(eventFilesCreated, []string{targetPath}, nil)
 		})
 	}
 
@@ -1021,17 +1035,52 @@ func (h *Handler) DownloadZip(w http.ResponseWriter, r *http.Request) {
 		h.respondError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if req.Async {
+		h.startJob(w, "zip", func(ctx context.Context, j *job) (interface{}, error) {
+			if err := runGated(ctx, j, h.zipGate); err != nil {
+				return nil, err
+			}
+			defer release(h.zipGate)
+			ctx, cancel := context.WithTimeout(ctx, time.Duration(h.config.ZipTimeout)*time.Second)
+			defer cancel()
+			downloadURL, err := h.prepareZipDownload(ctx, req.Paths, func(written int64) { j.setProgress(written, 0) })
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{"downloadUrl": downloadURL}, nil
+		})
+		return
+	}
 	if !tryAcquire(h.zipGate) {
 		respondBusy(w)
 		return
 	}
 	defer release(h.zipGate)
 
-	tmp, err := os.CreateTemp("", "puremania-download-*.zip")
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(h.config.ZipTimeout)*time.Second)
+	defer cancel()
+	downloadURL, err := h.prepareZipDownload(ctx, req.Paths, nil)
 	if err != nil {
-		h.respondError(w, "Cannot prepare archive", http.StatusInternalServerError)
+		h.logger.Error("Failed to prepare zip", "error", err)
+		if errors.Is(err, errTooManyPreparedZips) {
+			h.respondError(w, "Too many prepared downloads", http.StatusTooManyRequests)
+			return
+		}
+		h.respondError(w, "Cannot create archive", http.StatusInternalServerError)
 		return
 	}
+	h.respondSuccess(w, map[string]string{"downloadUrl": downloadURL})
+}
+
+var errTooManyPreparedZips = errors.New("too many prepared downloads")
+
+// prepareZipDownload writes the archive to a temporary file and registers a
+// one-time download URL for it. progress, when set, receives archive bytes.
+func (h *Handler) prepareZipDownload(ctx context.Context, paths []string, progress func(written int64)) (string, error) {
+	tmp, err := os.CreateTemp("", "puremania-download-*.zip")
+	if err != nil {
+		return "", err
+	}
 	tmpPath := tmp.Name()
 	removeTemp := true
 	defer func() {
@@ -1043,34 +1092,44 @@ func (h *Handler) DownloadZip(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(h.config.ZipTimeout)*time.Second)
-	defer cancel()
-	if err := h.createZipArchive(ctx, tmp, req.Paths); err != nil {
-		h.logger.Error("Failed to prepare zip", "error", err)
-		h.respondError(w, "Cannot create archive", http.StatusInternalServerError)
-		return
+	var output io.Writer = tmp
+	if progress != nil {
+		output = &progressWriter{w: tmp, report: progress}
+	}
+	if err := h.createZipArchive(ctx, output, paths); err != nil {
+		return "", err
 	}
 	if err := tmp.Sync(); err != nil {
-		h.respondError(w, "Cannot finalize archive", http.StatusInternalServerError)
-		return
+		return "", err
 	}
 
 	tokenBytes := make([]byte, 24)
 	if _, err := rand.Read(tokenBytes); err != nil {
-		h.respondError(w, "Cannot create download token", http.StatusInternalServerError)
-		return
+		return "", err
 	}
 	token := hex.EncodeToString(tokenBytes)
 	expiresAt := time.Now().Add(time.Hour)
 	if !h.storePreparedZip(token, preparedZip{path: tmpPath, expiresAt: expiresAt}) {
-		h.respondError(w, "Too many prepared downloads", http.StatusTooManyRequests)
-		return
+		return "", errTooManyPreparedZips
 	}
 	removeTemp = false
 	time.AfterFunc(time.Until(expiresAt), func() {
 		h.expirePreparedZip(token)
 	})
-	h.respondSuccess(w, map[string]string{"downloadUrl": "/api/files/download-zip/" + token})
+	return "/api/files/download-zip/" + token, nil
+}
+
+type progressWriter struct {
+	w       io.Writer
+	written int64
+	report  func(int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.written += int64(n)
+	w.report(w.written)
+	return n, err
 }
 
 func (h *Handler) DownloadPreparedZip(w http.ResponseWriter, r *http.Request) {
@@ -1356,12 +1415,13 @@ func (h *Handler) SaveFile(w http.ResponseWriter, r *http.Request) {
 	// キャッシュを無効化
 	h.invalidateFileCache(fullPath)
 	cache.InvalidateByPrefix(h.cache, "search:")
+	h.publishFileChanges(eventFileSaved, []string{fullPath}, nil)
 
 	h.respondSuccess(w, map[string]string{"message": "File saved successfully"})
 }
 
 func (h *Handler) DeleteMultipleFiles(w http.ResponseWriter, r *http.Request) {
-	var req types.BatchPathsRequest
+	var req types.DeleteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.logger.Error("Failed to decode request body for deleting files", "error", err)
 		h.respondError(w, "Invalid JSON", http.StatusBadRequest)
@@ -1372,54 +1432,104 @@ func (h *Handler) DeleteMultipleFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Async {
+		h.startJob(w, "delete", func(ctx context.Context, j *job) (interface{}, error) {
+			j.setRunning()
+			failures := h.deletePaths(ctx, req.Paths, req.Permanent, func(done int) { j.setProgress(int64(done), int64(len(req.Paths))) })
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if len(failures) > 0 {
+				return nil, errors.New(strings.Join(failures, "\n"))
+			}
+			return map[string]int{"deleted": len(req.Paths)}, nil
+		})
+		return
+	}
+
+	failures := h.deletePaths(r.Context(), req.Paths, req.Permanent, nil)
+	if len(failures) > 0 {
+		h.respondError(w, strings.Join(failures, "\n"), http.StatusInternalServerError)
+		return
+	}
+
+	h.respondSuccess(w, map[string]string{"message": "Selected items deleted successfully"})
+}
+
+// deletePaths trashes or permanently removes each virtual path and returns
+// one message per failure. progress, when set, receives the processed count.
+func (h *Handler) deletePaths(ctx context.Context, paths []string, permanent bool, progress func(done int)) []string {
 	// 並列処理で削除
-	var errors []string
+	var failures, deleted, trashed []string
+	var done int
 	var mu sync.Mutex
 	var wg sync.WaitGroup
+	fail := func(message string) {
+		mu.Lock()
+		failures = append(failures, message)
+		mu.Unlock()
+	}
 
-	for _, path := range req.Paths {
+	for _, path := range paths {
 		wg.Add(1)
 		worker.Submit(h.workerPool, func() {
 			defer wg.Done()
+			defer func() {
+				if progress != nil {
+					mu.Lock()
+					done++
+					progress(done)
+					mu.Unlock()
+				}
+			}()
+			if err := ctx.Err(); err != nil {
+				fail(fmt.Sprintf("Cannot delete %s: %v", path, err))
+				return
+			}
 
 			fullPath, err := h.convertToPhysicalPath(path)
 			if err != nil {
 				h.logger.Error("Invalid path for deletion", "path", path, "error", err)
-				mu.Lock()
-				errors = append(errors, fmt.Sprintf("Invalid path %s: %v", path, err))
-				mu.Unlock()
+				fail(fmt.Sprintf("Invalid path %s: %v", path, err))
 				return
 			}
 			if h.isProtectedRoot(fullPath) {
-				mu.Lock()
-				errors = append(errors, fmt.Sprintf("Cannot delete protected root %s", path))
-				mu.Unlock()
+				fail(fmt.Sprintf("Cannot delete protected root %s", path))
 				return
 			}
 
-			err = os.RemoveAll(fullPath)
+			if permanent {
+				err = os.RemoveAll(fullPath)
+			} else {
+				// 既定ではゴミ箱へ移動し、誤操作から復元できるようにする
+				var item *trashItem
+				if item, err = h.moveToTrash(ctx, fullPath); err == nil {
+					mu.Lock()
+					trashed = append(trashed, item.ID)
+					mu.Unlock()
+				}
+			}
 			if err != nil {
 				h.logger.Error("Failed to delete item", "path", fullPath, "error", err)
-				mu.Lock()
-				errors = append(errors, fmt.Sprintf("Cannot delete %s: %v", path, err))
-				mu.Unlock()
+				fail(fmt.Sprintf("Cannot delete %s: %v", path, err))
 			} else {
 				// キャッシュを無効化
 				h.invalidateFileCache(fullPath)
 				cache.InvalidateByPrefix(h.cache, "list:"+filepath.Dir(h.convertToVirtualPath(fullPath)))
 				cache.InvalidateByPrefix(h.cache, "search:")
+				mu.Lock()
+				deleted = append(deleted, fullPath)
+				mu.Unlock()
 			}
 		})
 	}
 
 	wg.Wait()
-
-	if len(errors) > 0 {
-		h.respondError(w, strings.Join(errors, "\n"), http.StatusInternalServerError)
-		return
+	h.publishFileChanges(eventFilesDeleted, deleted, nil)
+	if !permanent {
+		go h.measureTrashedItems(trashed)
 	}
-
-	h.respondSuccess(w, map[string]string{"message": "Selected items deleted successfully"})
+	return failures
 }
 
 func (h *Handler) CreateDirectory(w http.ResponseWriter, r *http.Request) {
@@ -1466,6 +1576,7 @@ func (h *Handler) CreateDirectory(w http.ResponseWriter, r *http.Request) {
 	// 親ディレクトリのキャッシュを無効化
 	cache.InvalidateByPrefix(h.cache, "list:"+h.convertToVirtualPath(parentPath))
 	cache.InvalidateByPrefix(h.cache, "search:")
+	h.publishFileChanges(eventFilesCreated, []string{newDirPath}, nil)
 
 	h.respondSuccess(w, map[string]string{"message": "Directory created successfully"})
 }
@@ -1475,6 +1586,7 @@ func (h *Handler) MoveFile(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		SourcePath string `json:"sourcePath"`
 		TargetPath string `json:"targetPath"`
+		Async      bool   `json:"async"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1514,15 +1626,22 @@ func (h *Handler) MoveFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Async {
+		h.startJob(w, "move", func(ctx context.Context, j *job) (interface{}, error) {
+			j.setRunning()
+			if err := h.moveFile(ctx, sourceFullPath, targetFullPath, func(_ int, bytes int64) { j.setProgress(bytes, 0) }); err != nil {
+				return nil, err
+			}
+			return map[string]string{"path": h.convertToVirtualPath(targetFullPath)}, nil
+		})
+		return
+	}
+
 	// 並列処理でファイル移動
+	// A move across filesystems takes as long as the copy, so it is bounded
+	// only by the client staying connected.
 	resultChan := worker.SubmitWithResult(h.workerPool, func() interface{} {
-		// ターゲットディレクトリが存在するか確認
-		if _, err := os.Stat(filepath.Dir(targetFullPath)); os.IsNotExist(err) {
-			return fmt.Errorf("target directory does not exist")
-		}
-
-		// ファイル移動
-		return os.Rename(sourceFullPath, targetFullPath)
+		return h.moveFile(r.Context(), sourceFullPath, targetFullPath, nil)
 	})
 
 	result := <-resultChan
@@ -1532,14 +1651,30 @@ func (h *Handler) MoveFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.respondSuccess(w, map[string]string{"message": "File moved successfully"})
+}
+
+// moveFile moves a validated source to a validated target and invalidates
+// the affected listings.
+func (h *Handler) moveFile(ctx context.Context, sourceFullPath, targetFullPath string, progress copyProgress) error {
+	// ターゲットディレクトリが存在するか確認
+	if _, err := os.Stat(filepath.Dir(targetFullPath)); os.IsNotExist(err) {
+		return fmt.Errorf("target directory does not exist")
+	}
+
+	// ファイル移動（別ファイルシステム間ではコピー後に削除）
+	if err := h.movePath(ctx, sourceFullPath, targetFullPath, progress); err != nil {
+		return err
+	}
+
 	// キャッシュを無効化
 	h.invalidateFileCache(sourceFullPath)
 	h.invalidateFileCache(targetFullPath)
 	cache.InvalidateByPrefix(h.cache, "list:"+filepath.Dir(h.convertToVirtualPath(sourceFullPath)))
 	cache.InvalidateByPrefix(h.cache, "list:"+filepath.Dir(h.convertToVirtualPath(targetFullPath)))
 	cache.InvalidateByPrefix(h.cache, "search:")
-
-	h.respondSuccess(w, map[string]string{"message": "File moved successfully"})
+	h.publishFileChanges(eventFilesMoved, []string{targetFullPath}, []string{sourceFullPath})
+	return nil
 }
 
 // 新規ファイル作成機能
@@ -1622,6 +1757,7 @@ func (h *Handler) CreateFile(w http.ResponseWriter, r *http.Request) {
 	// 親ディレクトリのキャッシュを無効化
 	cache.InvalidateByPrefix(h.cache, "list:"+h.convertToVirtualPath(parentPath))
 	cache.InvalidateByPrefix(h.cache, "search:")
+	h.publishFileChanges(eventFilesCreated, []string{newFilePath}, nil)
 
 	// 仮想パスを返す
 	virtualPath := h.convertToVirtualPath(newFilePath)
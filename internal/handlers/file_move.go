@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	moveProgressInterval = 250 * time.Millisecond
+	movedPrefix          = ".puremania-moved-"
+	copyStagingPrefix    = ".puremania-copy-"
+)
+
+// moveProgress is the SSE payload for a cross-device move. Paths are virtual
+// so the browser can label the operation without learning server layout.
+type moveProgress struct {
+	MoveID      string `json:"moveId"`
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Files       int    `json:"files"`
+	Bytes       int64  `json:"bytes"`
+	TotalFiles  int    `json:"totalFiles"`
+	TotalBytes  int64  `json:"totalBytes"`
+	Done        bool   `json:"done"`
+	Error       string `json:"error,omitempty"`
+	lastPublish time.Time
+}
+
+func isCrossDeviceError(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// movePath renames source to target and falls back to copy, verify, and
+// delete when the two paths live on different filesystems. progress is only
+// called by the fallback.
+func (h *Handler) movePath(ctx context.Context, source, target string, progress copyProgress) error {
+	err := os.Rename(source, target)
+	if err == nil || !isCrossDeviceError(err) {
+		return err
+	}
+	return h.moveAcrossDevices(ctx, source, target, true, progress)
+}
+
+// movePathNoReplace is movePath that fails with an error matching fs.ErrExist
+// instead of replacing an existing target.
+func (h *Handler) movePathNoReplace(ctx context.Context, source, target string) error {
+	err := renameNoReplace(source, target)
+	if err == nil || !isCrossDeviceError(err) {
+		return err
+	}
+	err = h.moveAcrossDevices(ctx, source, target, false, nil)
+	if errors.Is(err, errTargetExists) {
+		return &os.LinkError{Op: "move", Old: source, New: target, Err: fs.ErrExist}
+	}
+	return err
+}
+
+// moveAcrossDevices never removes anything from the source until the complete
+// copy has been verified and published under the target name. The copy is
+// staged in a hidden sibling of the target, so a failed verification leaves
+// any existing target untouched. The source is then renamed to a hidden
+// sibling before deletion, so an interruption leaves either the untouched
+// source or an unlisted leftover, never a partial tree under the original
+// name. With replace set an existing regular file is replaced like os.Rename
+// would.
+func (h *Handler) moveAcrossDevices(ctx context.Context, source, target string, replace bool, onProgress copyProgress) error {
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	// os.Rename replaces an existing file, so the fallback does the same.
+	existing, err := os.Lstat(target)
+	replace = replace && err == nil && existing.Mode().IsRegular() && info.Mode().IsRegular()
+	totalFiles, totalBytes, err := summarizeTree(ctx, source)
+	if err != nil {
+		return err
+	}
+	id, err := newUploadID()
+	if err != nil {
+		return err
+	}
+	progress := &moveProgress{
+		MoveID: id, Source: h.convertToVirtualPath(source), Target: h.convertToVirtualPath(target),
+		TotalFiles: totalFiles, TotalBytes: totalBytes,
+	}
+	h.publishMoveProgress(progress, true)
+
+	moveErr := h.copyVerified(ctx, source, target, replace, func(files int, bytes int64) {
+		progress.Files, progress.Bytes = files, bytes
+		h.publishMoveProgress(progress, false)
+		if onProgress != nil {
+			onProgress(files, bytes)
+		}
+	})
+	if moveErr == nil {
+		moveErr = h.removeMovedSource(source)
+	}
+	progress.Done = true
+	if moveErr != nil {
+		progress.Error = "Cannot move file"
+	}
+	h.publishMoveProgress(progress, true)
+	return moveErr
+}
+
+// copyVerified copies source into a staging directory beside target, verifies
+// the copy, and only then publishes it. Without replace an existing target
+// fails with errTargetExists.
+func (h *Handler) copyVerified(ctx context.Context, source, target string, replace bool, progress copyProgress) error {
+	staging, cleanup, err := h.createStagingDir(filepath.Dir(target), copyStagingPrefix)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cleanup() }()
+	staged := filepath.Join(staging, filepath.Base(target))
+	if err := h.copyTree(ctx, source, staged, false, progress); err != nil {
+		return err
+	}
+	if err := verifyCopiedTree(ctx, source, staged); err != nil {
+		return err
+	}
+	if replace {
+		return os.Rename(staged, target)
+	}
+	if err := renameNoReplace(staged, target); errors.Is(err, fs.ErrExist) {
+		return errTargetExists
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (h *Handler) publishMoveProgress(progress *moveProgress, force bool) {
+	now := time.Now()
+	if !force && now.Sub(progress.lastPublish) < moveProgressInterval {
+		return
+	}
+	progress.lastPublish = now
+	snapshot := *progress
+	h.events.publish(serverEvent{name: "move", key: "move:" + progress.MoveID, data: snapshot})
+}
+
+func summarizeTree(ctx context.Context, root string) (int, int64, error) {
+	var files int
+	var bytes int64
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, walkErr error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if walkErr != nil {
+			return walkErr
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			// copyTree skips special files, so deleting the source afterwards
+			// would silently lose them.
+			return fmt.Errorf("cannot move special file across filesystems: %s", filepath.Base(path))
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files++
+		bytes += info.Size()
+		return nil
+	})
+	return files, bytes, err
+}
+
+// verifyCopiedTree confirms that every regular file and directory in source
+// exists in target with the same kind and content. Modification times are not
+// compared: filesystems such as FAT store them too coarsely to match.
+func verifyCopiedTree(ctx context.Context, source, target string) error {
+	return filepath.WalkDir(source, func(path string, entry fs.DirEntry, walkErr error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if walkErr != nil {
+			return walkErr
+		}
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			return nil
+		}
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		copied, err := os.Lstat(filepath.Join(target, relative))
+		if err != nil {
+			return fmt.Errorf("copy verification failed for %s: %w", relative, err)
+		}
+		if entry.IsDir() {
+			if !copied.IsDir() {
+				return fmt.Errorf("copy verification failed for %s: not a directory", relative)
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !copied.Mode().IsRegular() || copied.Size() != info.Size() {
+			return fmt.Errorf("copy verification failed for %s: size mismatch", relative)
+		}
+		same, err := sameFileContent(ctx, path, filepath.Join(target, relative))
+		if err != nil {
+			return fmt.Errorf("copy verification failed for %s: %w", relative, err)
+		}
+		if !same {
+			return fmt.Errorf("copy verification failed for %s: content mismatch", relative)
+		}
+		return nil
+	})
+}
+
+// sameFileContent compares two files of equal size byte by byte.
+func sameFileContent(ctx context.Context, a, b string) (bool, error) {
+	first, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = first.Close() }()
+	second, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = second.Close() }()
+	bufA, bufB := make([]byte, 1<<20), make([]byte, 1<<20)
+	for {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		n, errA := io.ReadFull(first, bufA)
+		m, errB := io.ReadFull(second, bufB)
+		if n != m || !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == errA, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+// removeMovedSource hides the source in a journaled tombstone beside it
+// before deleting it, so a delete interrupted by the process dying is
+// finished by sweepStagingLeftovers at the next start.
+func (h *Handler) removeMovedSource(source string) error {
+	tombstone, cleanup, err := h.createStagingDir(filepath.Dir(source), movedPrefix)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(source, filepath.Join(tombstone, filepath.Base(source))); err != nil {
+		_ = cleanup()
+		return err
+	}
+	return cleanup()
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMoveAcrossDevicesCopiesThenRemovesSource(t *testing.T) {
+	h := newContentTestHandler(t)
+	source := filepath.Join(h.config.StorageDir, "photos")
+	if err := os.MkdirAll(filepath.Join(source, "2024"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(source, "2024", "a.jpg"), "image")
+	events, unsubscribe := h.events.subscribe()
+	defer unsubscribe()
+
+	target := filepath.Join(h.config.StorageDir, "archive")
+	if err := h.moveAcrossDevices(context.Background(), source, target, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Fatalf("source still exists, stat error=%v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(target, "2024", "a.jpg"))
+	if err != nil || string(content) != "image" {
+		t.Fatalf("moved content = %q, err = %v", content, err)
+	}
+	entries, err := os.ReadDir(h.config.StorageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".puremania-") && entry.Name() != stagingJournalDir {
+			t.Fatalf("temporary move entry was left behind: %s", entry.Name())
+		}
+	}
+	if journal, _ := os.ReadDir(filepath.Join(h.config.StorageDir, stagingJournalDir)); len(journal) != 0 {
+		t.Fatalf("staging journal was not cleared: %d entries", len(journal))
+	}
+
+	<-events.ready
+	pending := events.drain()
+	progress, ok := pending[0].data.(moveProgress)
+	if len(pending) != 1 || !ok || !progress.Done || progress.Files != 1 || progress.TotalBytes != 5 {
+		t.Fatalf("unexpected move progress: %#v", pending)
+	}
+}
+
+func TestMoveAcrossDevicesKeepsSourceWhenCopyFails(t *testing.T) {
+	h := newContentTestHandler(t)
+	source := filepath.Join(h.config.StorageDir, "dir")
+	if err := os.Mkdir(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(source, "keep.txt"), "keep")
+	target := filepath.Join(h.config.StorageDir, "existing")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.moveAcrossDevices(context.Background(), source, target, true, nil); err == nil {
+		t.Fatal("expected moving onto an existing directory to fail")
+	}
+	if _, err := os.Stat(filepath.Join(source, "keep.txt")); err != nil {
+		t.Fatalf("source was modified after a failed move: %v", err)
+	}
+}
+
+func TestVerifyCopiedTreeDetectsSizeMismatch(t *testing.T) {
+	source, target := t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(source, "file.bin"), "1234")
+	writeTestFile(t, filepath.Join(target, "file.bin"), "12")
+	if err := verifyCopiedTree(context.Background(), source, target); err == nil {
+		t.Fatal("expected a truncated copy to fail verification")
+	}
+}
+
+func TestVerifyCopiedTreeDetectsContentMismatch(t *testing.T) {
+	source, target := t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(source, "file.bin"), "1234")
+	writeTestFile(t, filepath.Join(target, "file.bin"), "1204")
+	if err := verifyCopiedTree(context.Background(), source, target); err == nil {
+		t.Fatal("expected a corrupted copy of the same size to fail verification")
+	}
+	writeTestFile(t, filepath.Join(target, "file.bin"), "1234")
+	if err := verifyCopiedTree(context.Background(), source, target); err != nil {
+		t.Fatalf("identical copy failed verification: %v", err)
+	}
+}
+
+func TestSweepStagingLeftoversRemovesJournaledPaths(t *testing.T) {
+	h := newContentTestHandler(t)
+	tombstone := filepath.Join(h.config.StorageDir, "mnt", movedPrefix+"123")
+	if err := os.MkdirAll(filepath.Join(tombstone, "photos"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(tombstone, "photos", "a.jpg"), "image")
+	merged := filepath.Join(h.config.StorageDir, "merged")
+	if err := os.MkdirAll(filepath.Join(merged, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(merged, "sub", copyingPrefix+"abc"), "partial")
+	writeTestFile(t, filepath.Join(merged, "sub", "keep.txt"), "keep")
+	for _, path := range []string{tombstone, merged} {
+		if _, err := h.journalStaging(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h.sweepStagingLeftovers()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries, _ := os.ReadDir(filepath.Join(h.config.StorageDir, stagingJournalDir))
+		if len(entries) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("journal entries were kept: %d", len(entries))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(tombstone); !os.IsNotExist(err) {
+		t.Fatalf("tombstone was kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(merged, "sub", copyingPrefix+"abc")); !os.IsNotExist(err) {
+		t.Fatalf("unpublished copy was kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(merged, "sub", "keep.txt")); err != nil {
+		t.Fatalf("merged file was removed: %v", err)
+	}
+}
+
+func TestMoveAcrossDevicesKeepsTargetUntilVerified(t *testing.T) {
+	h := newContentTestHandler(t)
+	source := filepath.Join(h.config.StorageDir, "new.txt")
+	target := filepath.Join(h.config.StorageDir, "old.txt")
+	writeTestFile(t, source, "new")
+	writeTestFile(t, target, "old")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := h.moveAcrossDevices(ctx, source, target, true, nil); err == nil {
+		t.Fatal("expected a canceled move to fail")
+	}
+	if content, err := os.ReadFile(target); err != nil || string(content) != "old" {
+		t.Fatalf("target = %q, err = %v; want the original", content, err)
+	}
+	if err := h.moveAcrossDevices(context.Background(), source, target, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(target); err != nil || string(content) != "new" {
+		t.Fatalf("target = %q, err = %v; want the moved file", content, err)
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Fatalf("source still exists: %v", err)
+	}
+}
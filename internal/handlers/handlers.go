@@ -17,22 +17,31 @@ const (
 
 // Handler はAPIハンドラーの依存関係を保持
 type Handler struct {
-	config               *types.Config
-	cache                *types.TTLCache
-	workerPool           *types.WorkerPool
-	logger               *slog.Logger
-	uploadLocks          [256]sync.Mutex // fixed striped locks; serializes writes to one session without unbounded state
-	uploadGate           chan struct{}   // bounds concurrent disk writes across sessions
-	zipGate              chan struct{}   // bounds concurrent archive preparation
-	extractGate          chan struct{}   // bounds concurrent archive extraction
-	thumbnailGate        chan struct{}   // bounds concurrent ffmpeg work
-	searchGate           chan struct{}   // bounds concurrent recursive searches
-	zipDownloads         sync.Map        // token -> preparedZip; entries expire after download preparation
-	zipDownloadsMu       sync.Mutex
-	preparedZipCount     int
-	thumbnailCleanupMu   sync.Mutex
-	lastThumbnailCleanup time.Time
-	events               *eventBroker
+	config           *types.Config
+	cache            *types.TTLCache
+	workerPool       *types.WorkerPool
+	logger           *slog.Logger
+	uploadLocks      [256]sync.Mutex // fixed striped locks; serializes writes to one session without unbounded state
+	uploadGate       chan struct{}   // bounds concurrent disk writes across sessions
+	uploadRanges     sync.Map        // session id -> []uploadExtent being written to a parallel session
+	zipGate          chan struct{}   // bounds concurrent archive preparation
+	extractGate      chan struct{}   // bounds concurrent archive extraction
+	thumbnailGate    chan struct{}   // bounds concurrent ffmpeg work
+	probeGate        chan struct{}   // bounds concurrent ffprobe runs
+	searchGate       chan struct{}   // bounds concurrent recursive searches
+	copyGate         chan struct{}   // bounds concurrent server-side copies
+	zipDownloads     sync.Map        // token -> preparedZip; entries expire after download preparation
+	zipDownloadsMu   sync.Mutex
+	preparedZipCount int
+	thumbnails       *thumbnailCache
+	thumbnailWarmer  *thumbnailWarmer
+	trashCleanupMu   sync.Mutex
+	lastTrashCleanup time.Time
+	events           *eventBroker
+	jobs             *jobManager
+	searchIndex      *searchIndex // nil when SEARCH_INDEX_DIR is empty
+	watcherOnce      sync.Once
+	watcher          *directoryWatcher // nil until first listing, or where unsupported
 }
 
 type preparedZip struct {
@@ -66,9 +75,21 @@ func NewHandler(config *types.Config, logger *slog.Logger) *Handler {
 		zipGate:       make(chan struct{}, 2),
 		extractGate:   make(chan struct{}, 2),
 		thumbnailGate: make(chan struct{}, 2),
+		probeGate:     make(chan struct{}, 4),
 		searchGate:    make(chan struct{}, 4),
+		copyGate:      make(chan struct{}, 2),
 		events:        newEventBroker(),
+
+		thumbnails:      newThumbnailCache(config),
+		thumbnailWarmer: newThumbnailWarmer(),
+	}
+	h.jobs = newJobManager(h.events)
+	if config.SearchIndexDir != "" {
+		h.searchIndex = newSearchIndex(config.SearchIndexDir, h.allowedRoots(), logger)
+		go h.searchIndex.start()
 	}
 	h.cleanupExpiredUploadSessions()
+	h.sweepStagingLeftovers()
+	h.cleanupTrashIfDue()
 	return h
 }
@@ -0,0 +1,276 @@
+package handlers
+
+// Background jobs. Long-running file operations can be started with
+// "async": true; they then run detached from the HTTP request, so closing the
+// browser tab neither cancels nor loses them. They have no deadline; only
+// CancelJob stops them early. Progress is pushed as "job" invalidation events
+// over /api/events and the authoritative state, including the result, is read
+// from /api/jobs/{id}.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	jobRetention        = time.Hour
+	maxTrackedJobs      = 256
+	jobProgressInterval = 250 * time.Millisecond
+)
+
+type jobStatus string
+
+const (
+	jobQueued    jobStatus = "queued"
+	jobRunning   jobStatus = "running"
+	jobSucceeded jobStatus = "succeeded"
+	jobFailed    jobStatus = "failed"
+	jobCanceled  jobStatus = "canceled"
+)
+
+var errTooManyJobs = errors.New("too many jobs")
+
+type jobSnapshot struct {
+	ID         string      `json:"id"`
+	Kind       string      `json:"kind"`
+	Status     jobStatus   `json:"status"`
+	Done       int64       `json:"done"`
+	Total      int64       `json:"total"`
+	Error      string      `json:"error,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	StartedAt  *time.Time  `json:"startedAt,omitempty"`
+	FinishedAt *time.Time  `json:"finishedAt,omitempty"`
+}
+
+type job struct {
+	mu          sync.Mutex
+	state       jobSnapshot
+	cancel      context.CancelFunc
+	lastPublish time.Time
+	events      *eventBroker
+}
+
+// jobFunc performs the work of a job. It must return promptly once ctx is
+// canceled; the returned value becomes the job's result.
+type jobFunc func(ctx context.Context, j *job) (interface{}, error)
+
+func (j *job) snapshot() jobSnapshot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+func (j *job) finished() bool {
+	switch j.snapshot().Status {
+	case jobSucceeded, jobFailed, jobCanceled:
+		return true
+	}
+	return false
+}
+
+// setRunning marks the end of any wait for a concurrency gate.
+func (j *job) setRunning() {
+	j.mu.Lock()
+	now := time.Now().UTC()
+	j.state.Status = jobRunning
+	j.state.StartedAt = &now
+	j.mu.Unlock()
+	j.publish(true)
+}
+
+// setProgress records completed and total work units. A zero total means the
+// amount of work is not known in advance.
+func (j *job) setProgress(done, total int64) {
+	j.mu.Lock()
+	j.state.Done, j.state.Total = done, total
+	j.mu.Unlock()
+	j.publish(false)
+}
+
+func (j *job) finish(result interface{}, err error) {
+	j.mu.Lock()
+	now := time.Now().UTC()
+	j.state.FinishedAt = &now
+	switch {
+	case err == nil:
+		j.state.Status = jobSucceeded
+		j.state.Result = result
+	case errors.Is(err, context.Canceled):
+		j.state.Status = jobCanceled
+	default:
+		j.state.Status = jobFailed
+		j.state.Error = err.Error()
+	}
+	j.mu.Unlock()
+	j.publish(true)
+}
+
+func (j *job) publish(force bool) {
+	j.mu.Lock()
+	now := time.Now()
+	if !force && now.Sub(j.lastPublish) < jobProgressInterval {
+		j.mu.Unlock()
+		return
+	}
+	j.lastPublish = now
+	// Like upload events, job events are invalidation hints. Results such as
+	// one-time download URLs are only returned by the jobs endpoint.
+	data := map[string]interface{}{"jobId": j.state.ID, "kind": j.state.Kind, "status": j.state.Status, "done": j.state.Done, "total": j.state.Total}
+	j.mu.Unlock()
+	j.events.publish(serverEvent{name: "job", key: "job:" + j.state.ID, data: data})
+}
+
+type jobManager struct {
+	mu     sync.Mutex
+	jobs   map[string]*job
+	events *eventBroker
+}
+
+func newJobManager(events *eventBroker) *jobManager {
+	return &jobManager{jobs: make(map[string]*job), events: events}
+}
+
+// pruneLocked forgets finished jobs after their retention period.
+func (m *jobManager) pruneLocked(now time.Time) {
+	for id, j := range m.jobs {
+		state := j.snapshot()
+		if state.FinishedAt != nil && now.Sub(*state.FinishedAt) > jobRetention {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func (m *jobManager) start(kind string, run jobFunc) (*job, error) {
+	id, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		state:  jobSnapshot{ID: id, Kind: kind, Status: jobQueued, CreatedAt: time.Now().UTC()},
+		cancel: cancel,
+		events: m.events,
+	}
+	m.mu.Lock()
+	m.pruneLocked(time.Now())
+	if len(m.jobs) >= maxTrackedJobs {
+		m.mu.Unlock()
+		cancel()
+		return nil, errTooManyJobs
+	}
+	m.jobs[id] = j
+	m.mu.Unlock()
+	j.publish(true)
+
+	go func() {
+		defer cancel()
+		var result interface{}
+		var runErr error
+		func() {
+			// A panicking job must not take the server down with it.
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					runErr = errors.New("job failed unexpectedly")
+				}
+			}()
+			result, runErr = run(ctx, j)
+		}()
+		if runErr != nil && ctx.Err() == context.Canceled {
+			runErr = context.Canceled
+		}
+		j.finish(result, runErr)
+	}()
+	return j, nil
+}
+
+func (m *jobManager) get(id string) (*job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pruneLocked(time.Now())
+	j, ok := m.jobs[id]
+	return j, ok
+}
+
+func (m *jobManager) list() []jobSnapshot {
+	m.mu.Lock()
+	m.pruneLocked(time.Now())
+	snapshots := make([]jobSnapshot, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		snapshots = append(snapshots, j.snapshot())
+	}
+	m.mu.Unlock()
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+	return snapshots
+}
+
+// runGated waits for a slot in gate instead of rejecting the work, because a
+// queued job has no client waiting on the response.
+func runGated(ctx context.Context, j *job, gate chan struct{}) error {
+	select {
+	case gate <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	j.setRunning()
+	return nil
+}
+
+// startJob launches run in the background and answers 202 with the job URL.
+func (h *Handler) startJob(w http.ResponseWriter, kind string, run jobFunc) {
+	j, err := h.jobs.start(kind, run)
+	if err != nil {
+		if errors.Is(err, errTooManyJobs) {
+			h.respondError(w, "Too many jobs", http.StatusTooManyRequests)
+			return
+		}
+		h.respondError(w, "Cannot start job", http.StatusInternalServerError)
+		return
+	}
+	location := "/api/jobs/" + j.state.ID
+	w.Header().Set("Location", location)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]string{"jobId": j.state.ID, "jobUrl": location})
+}
+
+// ListJobs returns queued, running, and recently finished jobs.
+func (h *Handler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "private, no-cache")
+	h.respondSuccess(w, h.jobs.list())
+}
+
+// GetJob returns one job including its result once it has finished.
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "private, no-cache")
+	j, ok := h.jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		h.respondError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	h.respondSuccess(w, j.snapshot())
+}
+
+// CancelJob requests cancellation. Work that already completed is kept; for
+// example files extracted or deleted before the request are not rolled back
+// unless the operation itself is staged.
+func (h *Handler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := h.jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		h.respondError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if j.finished() {
+		h.respondError(w, "Job has already finished", http.StatusConflict)
+		return
+	}
+	j.cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func waitForJob(t *testing.T, h *Handler, id string) jobSnapshot {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		j, ok := h.jobs.get(id)
+		if !ok {
+			t.Fatalf("job %s disappeared", id)
+		}
+		if j.finished() {
+			return j.snapshot()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return jobSnapshot{}
+}
+
+func decodeJobID(t *testing.T, res *httptest.ResponseRecorder) string {
+	t.Helper()
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, body = %s", res.Code, res.Body.String())
+	}
+	var body struct {
+		JobID  string `json:"jobId"`
+		JobURL string `json:"jobUrl"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.JobURL != "/api/jobs/"+body.JobID || res.Header().Get("Location") != body.JobURL {
+		t.Fatalf("job URL = %q, Location = %q", body.JobURL, res.Header().Get("Location"))
+	}
+	return body.JobID
+}
+
+func TestAsyncCopyRunsAsJob(t *testing.T) {
+	h := newContentTestHandler(t)
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "a.txt"), "payload")
+
+	res := httptest.NewRecorder()
+	h.CopyFile(res, httptest.NewRequest(http.MethodPost, "/api/files/copy", strings.NewReader(`{"sourcePath":"/a.txt","targetPath":"/b.txt","async":true}`)))
+	state := waitForJob(t, h, decodeJobID(t, res))
+	if state.Status != jobSucceeded || state.Kind != "copy" {
+		t.Fatalf("job = %+v", state)
+	}
+	if content, err := os.ReadFile(filepath.Join(h.config.StorageDir, "b.txt")); err != nil || string(content) != "payload" {
+		t.Fatalf("copied content = %q, err = %v", content, err)
+	}
+
+	get := httptest.NewRecorder()
+	h.GetJob(get, mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/jobs/"+state.ID, nil), map[string]string{"id": state.ID}))
+	if get.Code != http.StatusOK || !strings.Contains(get.Body.String(), `"/b.txt"`) {
+		t.Fatalf("get status = %d, body = %s", get.Code, get.Body.String())
+	}
+}
+
+func TestAsyncJobFailureIsReported(t *testing.T) {
+	h := newContentTestHandler(t)
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "a.txt"), "new")
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "b.txt"), "old")
+
+	res := httptest.NewRecorder()
+	h.CopyFile(res, httptest.NewRequest(http.MethodPost, "/api/files/copy", strings.NewReader(`{"sourcePath":"/a.txt","targetPath":"/b.txt","async":true}`)))
+	state := waitForJob(t, h, decodeJobID(t, res))
+	if state.Status != jobFailed || state.Error == "" {
+		t.Fatalf("job = %+v", state)
+	}
+}
+
+func TestCancelJobStopsQueuedWork(t *testing.T) {
+	h := newContentTestHandler(t)
+	// Occupy every copy slot so the job stays queued until canceled.
+	for i := 0; i < cap(h.copyGate); i++ {
+		h.copyGate <- struct{}{}
+	}
+	j, err := h.jobs.start("copy", func(ctx context.Context, j *job) (interface{}, error) {
+		if err := runGated(ctx, j, h.copyGate); err != nil {
+			return nil, err
+		}
+		defer release(h.copyGate)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := j.snapshot().ID
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/api/jobs/"+id, nil), map[string]string{"id": id})
+
+	res := httptest.NewRecorder()
+	h.CancelJob(res, req)
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("cancel status = %d", res.Code)
+	}
+	if state := waitForJob(t, h, id); state.Status != jobCanceled {
+		t.Fatalf("job = %+v", state)
+	}
+	res = httptest.NewRecorder()
+	h.CancelJob(res, req)
+	if res.Code != http.StatusConflict {
+		t.Fatalf("second cancel status = %d", res.Code)
+	}
+}
+
+func TestJobEventsOmitResults(t *testing.T) {
+	h := newContentTestHandler(t)
+	sub, unsubscribe := h.events.subscribe()
+	defer unsubscribe()
+
+	j, err := h.jobs.start("zip", func(ctx context.Context, j *job) (interface{}, error) {
+		j.setRunning()
+		return map[string]string{"downloadUrl": "/api/files/download-zip/secret"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, h, j.snapshot().ID)
+	for _, event := range sub.drain() {
+		b, _ := json.Marshal(event.data)
+		if strings.Contains(string(b), "secret") {
+			t.Fatalf("job event leaked result: %s", b)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"puremania/internal/cache"
+)
+
+const (
+	mediaInfoTimeout     = 30 * time.Second
+	mediaInfoTTL         = time.Hour
+	maxMediaInfoStreams  = 64
+	maxMediaProbeBytes   = 1 << 20
+	mediaInfoCachePrefix = "media-info:"
+)
+
+type mediaStream struct {
+	Index         int     `json:"index"`
+	Type          string  `json:"type"`
+	Codec         string  `json:"codec"`
+	CodecName     string  `json:"codecName,omitempty"`
+	Profile       string  `json:"profile,omitempty"`
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	FrameRate     float64 `json:"frameRate,omitempty"`
+	PixelFormat   string  `json:"pixelFormat,omitempty"`
+	SampleRate    int     `json:"sampleRate,omitempty"`
+	Channels      int     `json:"channels,omitempty"`
+	ChannelLayout string  `json:"channelLayout,omitempty"`
+	BitRate       int64   `json:"bitRate,omitempty"`
+	Duration      float64 `json:"duration,omitempty"`
+	Language      string  `json:"language,omitempty"`
+	Title         string  `json:"title,omitempty"`
+	Default       bool    `json:"default,omitempty"`
+	AttachedPic   bool    `json:"attachedPicture,omitempty"`
+}
+
+type mediaInfo struct {
+	Path          string        `json:"path"`
+	Container     string        `json:"container"`
+	ContainerName string        `json:"containerName,omitempty"`
+	Duration      float64       `json:"duration"`
+	BitRate       int64         `json:"bitRate,omitempty"`
+	Width         int           `json:"width,omitempty"`
+	Height        int           `json:"height,omitempty"`
+	Streams       []mediaStream `json:"streams"`
+}
+
+// ffprobeOutput is the subset of `ffprobe -print_format json` that is used.
+// ffprobe prints most numbers as strings.
+type ffprobeOutput struct {
+	Format struct {
+		FormatName     string `json:"format_name"`
+		FormatLongName string `json:"format_long_name"`
+		Duration       string `json:"duration"`
+		BitRate        string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []struct {
+		Index         int    `json:"index"`
+		CodecType     string `json:"codec_type"`
+		CodecName     string `json:"codec_name"`
+		CodecLongName string `json:"codec_long_name"`
+		Profile       string `json:"profile"`
+		Width         int    `json:"width"`
+		Height        int    `json:"height"`
+		AvgFrameRate  string `json:"avg_frame_rate"`
+		PixFmt        string `json:"pix_fmt"`
+		SampleRate    string `json:"sample_rate"`
+		Channels      int    `json:"channels"`
+		ChannelLayout string `json:"channel_layout"`
+		BitRate       string `json:"bit_rate"`
+		Duration      string `json:"duration"`
+		Tags          struct {
+			Language string `json:"language"`
+			Title    string `json:"title"`
+		} `json:"tags"`
+		Disposition struct {
+			Default     int `json:"default"`
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+}
+
+// probeMediaInfo runs ffprobe on a media file.
+func probeMediaInfo(ctx context.Context, mediaPath string, extraFiles ...*os.File) (mediaInfo, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", mediaPath)
+	cmd.ExtraFiles = extraFiles
+	output, err := readProbeOutput(cmd, maxMediaProbeBytes)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return mediaInfo{}, fmt.Errorf("ffprobe command timed out")
+		}
+		return mediaInfo{}, err
+	}
+	return parseMediaInfo(output)
+}
+
+// readProbeOutput runs cmd and returns its standard output. Output is read
+// through a limit, so a file with a huge stream or chapter list cannot make
+// the server buffer it whole; the process is killed once it exceeds limit.
+func readProbeOutput(cmd *exec.Cmd, limit int64) ([]byte, error) {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to probe media: %w", err)
+	}
+	output, readErr := io.ReadAll(io.LimitReader(stdout, limit+1))
+	if int64(len(output)) > limit {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("ffprobe output is too large")
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to probe media: %w", err)
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to probe media: %w", readErr)
+	}
+	return output, nil
+}
+
+func parseMediaInfo(output []byte) (mediaInfo, error) {
+	var probe ffprobeOutput
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return mediaInfo{}, fmt.Errorf("invalid ffprobe output: %w", err)
+	}
+	if probe.Format.FormatName == "" {
+		return mediaInfo{}, fmt.Errorf("not a media file")
+	}
+	info := mediaInfo{
+		Container:     probe.Format.FormatName,
+		ContainerName: probe.Format.FormatLongName,
+		Duration:      parseProbeFloat(probe.Format.Duration),
+		BitRate:       parseProbeInt(probe.Format.BitRate),
+		Streams:       make([]mediaStream, 0, min(len(probe.Streams), maxMediaInfoStreams)),
+	}
+	for _, s := range probe.Streams {
+		if len(info.Streams) == maxMediaInfoStreams {
+			break
+		}
+		stream := mediaStream{
+			Index:         s.Index,
+			Type:          s.CodecType,
+			Codec:         s.CodecName,
+			CodecName:     s.CodecLongName,
+			Profile:       s.Profile,
+			Width:         s.Width,
+			Height:        s.Height,
+			FrameRate:     parseProbeRate(s.AvgFrameRate),
+			PixelFormat:   s.PixFmt,
+			SampleRate:    int(parseProbeInt(s.SampleRate)),
+			Channels:      s.Channels,
+			ChannelLayout: s.ChannelLayout,
+			BitRate:       parseProbeInt(s.BitRate),
+			Duration:      parseProbeFloat(s.Duration),
+			Language:      s.Tags.Language,
+			Title:         s.Tags.Title,
+			Default:       s.Disposition.Default == 1,
+			AttachedPic:   s.Disposition.AttachedPic == 1,
+		}
+		// Embedded cover art is a video stream but says nothing about the
+		// dimensions of the media.
+		if stream.Type == "video" && !stream.AttachedPic && info.Width == 0 {
+			info.Width, info.Height = stream.Width, stream.Height
+		}
+		info.Streams = append(info.Streams, stream)
+	}
+	return info, nil
+}
+
+func parseProbeFloat(value string) float64 {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return parsed
+}
+
+func parseProbeInt(value string) int64 {
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return parsed
+}
+
+// parseProbeRate converts a rational such as "30000/1001".
+func parseProbeRate(value string) float64 {
+	numerator, denominator, found := strings.Cut(value, "/")
+	if !found {
+		return parseProbeFloat(value)
+	}
+	d := parseProbeFloat(denominator)
+	if d == 0 {
+		return 0
+	}
+	return parseProbeFloat(numerator) / d
+}
+
+var errProbeBusy = errors.New("too many media probes are running")
+
+// cachedMediaInfo probes one version of an opened file, consulting the cache
+// first. It returns errProbeBusy when every probe slot is taken.
+func (h *Handler) cachedMediaInfo(ctx context.Context, virtualPath, fullPath string, source *os.File, info os.FileInfo) (mediaInfo, error) {
+	cacheKey := mediaInfoCachePrefix + fileVersionHash(virtualPath, info, "media-info")
+	if cached, found := cache.Get(h.cache, cacheKey); found {
+		if result, ok := cached.(mediaInfo); ok {
+			return result, nil
+		}
+	}
+	if !tryAcquire(h.probeGate) {
+		return mediaInfo{}, errProbeBusy
+	}
+	defer release(h.probeGate)
+
+	ctx, cancel := context.WithTimeout(ctx, mediaInfoTimeout)
+	defer cancel()
+	mediaPath, extraFiles := childProcessFilePath(source, fullPath)
+	result, err := probeMediaInfo(ctx, mediaPath, extraFiles...)
+	if err != nil {
+		return mediaInfo{}, err
+	}
+	result.Path = virtualPath
+	cache.Set(h.cache, cacheKey, result, int64(512+256*len(result.Streams)), mediaInfoTTL)
+	return result, nil
+}
+
+// MediaInfo returns the container, duration, bitrate, dimensions, and streams
+// of an audio or video file as reported by ffprobe.
+func (h *Handler) MediaInfo(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		h.respondError(w, "Path required", http.StatusBadRequest)
+		return
+	}
+	if len(path) > maxVirtualPathBytes {
+		h.respondError(w, "Path is too long", http.StatusBadRequest)
+		return
+	}
+	fullPath, err := h.convertToPhysicalPath(path)
+	if err != nil {
+		h.respondError(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	source, err := h.openAllowedPath(fullPath, os.O_RDONLY, 0)
+	if err != nil {
+		h.respondError(w, "Cannot open file", http.StatusNotFound)
+		return
+	}
+	defer func() { _ = source.Close() }()
+	info, err := source.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		h.respondError(w, "Cannot inspect file", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.cachedMediaInfo(r.Context(), path, fullPath, source, info)
+	if errors.Is(err, errProbeBusy) {
+		respondBusy(w)
+		return
+	}
+	if err != nil {
+		h.logger.Warn("Failed to probe media", "path", fullPath, "error", err)
+		h.respondError(w, "Cannot read media information", http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	h.respondSuccess(w, result)
+}
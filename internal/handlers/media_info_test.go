@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sampleProbeOutput = `{
+  "streams": [
+    {"index": 0, "codec_name": "h264", "codec_long_name": "H.264 / AVC", "profile": "High", "codec_type": "video",
+     "width": 1920, "height": 1080, "pix_fmt": "yuv420p", "avg_frame_rate": "30000/1001", "bit_rate": "4000000",
+     "disposition": {"default": 1, "attached_pic": 0}},
+    {"index": 1, "codec_name": "aac", "codec_type": "audio", "sample_rate": "48000", "channels": 2,
+     "channel_layout": "stereo", "bit_rate": "128000", "tags": {"language": "jpn", "title": "Main"},
+     "disposition": {"default": 1}},
+    {"index": 2, "codec_name": "mjpeg", "codec_type": "video", "width": 600, "height": 600,
+     "avg_frame_rate": "0/0", "disposition": {"attached_pic": 1}}
+  ],
+  "format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "format_long_name": "QuickTime / MOV",
+             "duration": "62.500000", "bit_rate": "4130000"}
+}`
+
+func TestParseMediaInfo(t *testing.T) {
+	info, err := parseMediaInfo([]byte(sampleProbeOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Container != "mov,mp4,m4a,3gp,3g2,mj2" || info.Duration != 62.5 || info.BitRate != 4130000 || info.Width != 1920 || info.Height != 1080 {
+		t.Fatalf("info = %+v", info)
+	}
+	want := []mediaStream{
+		{Index: 0, Type: "video", Codec: "h264", CodecName: "H.264 / AVC", Profile: "High", Width: 1920, Height: 1080, FrameRate: 30000.0 / 1001, PixelFormat: "yuv420p", BitRate: 4000000, Default: true},
+		{Index: 1, Type: "audio", Codec: "aac", SampleRate: 48000, Channels: 2, ChannelLayout: "stereo", BitRate: 128000, Language: "jpn", Title: "Main", Default: true},
+		{Index: 2, Type: "video", Codec: "mjpeg", Width: 600, Height: 600, AttachedPic: true},
+	}
+	if !reflect.DeepEqual(info.Streams, want) {
+		t.Fatalf("streams = %+v", info.Streams)
+	}
+	if _, err := parseMediaInfo([]byte(`{"streams": [], "format": {}}`)); err == nil {
+		t.Fatal("output without a container was accepted")
+	}
+}
+
+func TestMediaInfoIsCachedByFileVersion(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "probe.json")
+	writeTestFile(t, output, sampleProbeOutput)
+	calls := filepath.Join(t.TempDir(), "calls")
+	installFakeCommands(t, map[string]string{"ffprobe": "echo run >> '" + calls + "'\ncat '" + output + "'\n"})
+	h := newContentTestHandler(t)
+	video := filepath.Join(h.config.StorageDir, "movie.mp4")
+	writeTestFile(t, video, "video")
+
+	request := func() mediaInfo {
+		t.Helper()
+		res := httptest.NewRecorder()
+		h.MediaInfo(res, httptest.NewRequest(http.MethodGet, "/api/files/media-info?path=/movie.mp4", nil))
+		if res.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", res.Code, res.Body.String())
+		}
+		var response struct {
+			Data mediaInfo `json:"data"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return response.Data
+	}
+	if info := request(); info.Path != "/movie.mp4" || len(info.Streams) != 3 {
+		t.Fatalf("info = %+v", info)
+	}
+	request()
+	writeTestFile(t, video, "replaced video")
+	request()
+
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := strings.Count(string(data), "run"); runs != 2 {
+		t.Fatalf("ffprobe ran %d times, want once per file version", runs)
+	}
+}
+
+func TestMediaInfoRejectsUnreadableMedia(t *testing.T) {
+	installFakeCommands(t, map[string]string{"ffprobe": "echo 'Invalid data found' >&2\nexit 1\n"})
+	h := newContentTestHandler(t)
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "notes.txt"), "text")
+	for query, status := range map[string]int{"path=/notes.txt": http.StatusUnprocessableEntity, "path=/missing.mp4": http.StatusNotFound, "": http.StatusBadRequest} {
+		res := httptest.NewRecorder()
+		h.MediaInfo(res, httptest.NewRequest(http.MethodGet, "/api/files/media-info?"+query, nil))
+		if res.Code != status {
+			t.Fatalf("%q: status = %d, want %d", query, res.Code, status)
+		}
+	}
+}
+
+func TestReadProbeOutputStopsAtLimit(t *testing.T) {
+	if _, err := exec.LookPath("yes"); err != nil {
+		t.Skip("yes is not installed")
+	}
+	// yes never stops writing, so only the limit ends the read.
+	if _, err := readProbeOutput(exec.Command("yes"), 1<<10); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("err = %v, want the output to be rejected as too large", err)
+	}
+	output, err := readProbeOutput(exec.Command("echo", "{}"), 1<<10)
+	if err != nil || string(output) != "{}\n" {
+		t.Fatalf("output = %q, err = %v", output, err)
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"puremania/internal/cache"
+	"puremania/internal/types"
+	"puremania/internal/worker"
+)
+
+const defaultTimelineLimit = 100
+
+// timelineFile is an image with the time used to place it on the timeline.
+// TakenAt is the EXIF capture time, or the modification time in server local
+// time when the image records none.
+type timelineFile struct {
+	types.FileInfo
+	TakenAt    string `json:"taken_at"`
+	Date       string `json:"date"`
+	DateSource string `json:"date_source"`
+}
+
+type timelineGroup struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// timelinePage extends a directory page with the dates on the page and the
+// number of images of each date in the whole directory.
+type timelinePage struct {
+	Data       []timelineFile  `json:"data"`
+	Groups     []timelineGroup `json:"groups"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+	HasMore    bool            `json:"hasMore"`
+	Offset     int             `json:"offset"`
+	Total      int             `json:"total"`
+}
+
+// serveTimeline lists the images of a directory by capture date, newest first
+// unless direction=asc. Paging follows servePaginatedFileList.
+func (h *Handler) serveTimeline(w http.ResponseWriter, r *http.Request, path, stateKey, cacheKey string) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultTimelineLimit
+	}
+	if limit > 500 {
+		limit = 500
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+	if offset < 0 {
+		offset = 0
+	}
+	direction := r.URL.Query().Get("direction")
+	if direction != "asc" {
+		direction = "desc"
+	}
+	pageHash := sha256.Sum256([]byte(fmt.Sprintf("timeline:%s:%d:%d:%s", stateKey, offset, limit, direction)))
+	etag := hex.EncodeToString(pageHash[:])
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+
+	// The key keeps the list: prefix so that directory changes invalidate it.
+	timelineKey := cacheKey + "#timeline"
+	var entries []timelineFile
+	if cached, found := cache.Get(h.cache, timelineKey); found {
+		entries, _ = cached.([]timelineFile)
+	}
+	if entries == nil {
+		files, err := h.getFileList(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				h.respondError(w, "Directory not found", http.StatusNotFound)
+			} else {
+				h.respondError(w, "Cannot read directory", http.StatusInternalServerError)
+			}
+			return
+		}
+		entries = h.timelineEntries(files)
+		cache.Set(h.cache, timelineKey, entries, int64(len(entries)*260), CacheTTL)
+	}
+
+	ordered := append([]timelineFile(nil), entries...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		comparison := strings.Compare(ordered[i].TakenAt, ordered[j].TakenAt)
+		if comparison == 0 {
+			comparison = strings.Compare(ordered[i].Path, ordered[j].Path)
+		}
+		if direction == "desc" {
+			return comparison > 0
+		}
+		return comparison < 0
+	})
+	counts := make(map[string]int)
+	for _, entry := range ordered {
+		counts[entry.Date]++
+	}
+	if offset > len(ordered) {
+		offset = len(ordered)
+	}
+	end := min(offset+limit, len(ordered))
+	groups := []timelineGroup{}
+	for _, entry := range ordered[offset:end] {
+		if len(groups) == 0 || groups[len(groups)-1].Date != entry.Date {
+			groups = append(groups, timelineGroup{Date: entry.Date, Count: counts[entry.Date]})
+		}
+	}
+	hasMore := end < len(ordered)
+	nextCursor := ""
+	if hasMore {
+		nextCursor = strconv.Itoa(end)
+	}
+	h.respondSuccess(w, timelinePage{Data: ordered[offset:end], Groups: groups, NextCursor: nextCursor, HasMore: hasMore, Offset: offset, Total: len(ordered)})
+}
+
+// timelineEntries dates the images among files, reading EXIF in parallel.
+func (h *Handler) timelineEntries(files []types.FileInfo) []timelineFile {
+	entries := make([]timelineFile, 0, len(files))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, file := range files {
+		if file.IsDir || !strings.HasPrefix(file.MimeType, "image/") {
+			continue
+		}
+		wg.Add(1)
+		worker.Submit(h.workerPool, func() {
+			defer wg.Done()
+			entry := h.timelineEntry(file)
+			mu.Lock()
+			entries = append(entries, entry)
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	return entries
+}
+
+func (h *Handler) timelineEntry(file types.FileInfo) timelineFile {
+	entry := timelineFile{FileInfo: file, TakenAt: "0001-01-01T00:00:00", DateSource: "mtime"}
+	if modTime, err := time.Parse(time.RFC3339, file.ModTime); err == nil {
+		entry.TakenAt = modTime.Local().Format(captureTimeLayout)
+	}
+	if meta, ok := h.timelineMetadata(file); ok && meta.CaptureTime != "" {
+		entry.TakenAt, entry.DateSource = meta.CaptureTime, "exif"
+	}
+	entry.Date = entry.TakenAt[:len("2006-01-02")]
+	return entry
+}
+
+func (h *Handler) timelineMetadata(file types.FileInfo) (imageMetadata, bool) {
+	if !exifExtensions[strings.ToLower(filepath.Ext(file.Name))] {
+		return imageMetadata{}, false
+	}
+	fullPath, err := h.convertToPhysicalPath(file.Path)
+	if err != nil {
+		return imageMetadata{}, false
+	}
+	source, err := h.openAllowedPath(fullPath, os.O_RDONLY, 0)
+	if err != nil {
+		return imageMetadata{}, false
+	}
+	defer func() { _ = source.Close() }()
+	info, err := source.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return imageMetadata{}, false
+	}
+	meta, err := h.imageMetadata(file.Path, source, info)
+	return meta, err == nil
+}
@@ -0,0 +1,408 @@
+package handlers
+
+// Playlists in the M3U8 and PLS formats understood by VLC, mpv, and most
+// other players. Exported playlists point at absolute download URLs so that
+// players elsewhere on the network can stream them; imported playlists are
+// resolved to virtual paths within the allowed roots.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxPlaylistEntries = 10000
+	maxPlaylistBytes   = 1 << 20
+)
+
+// playlistFormats maps playlist extensions to their format.
+var playlistFormats = map[string]string{".m3u": "m3u8", ".m3u8": "m3u8", ".pls": "pls"}
+
+var errPlaylistTooLarge = fmt.Errorf("playlist has more than %d entries", maxPlaylistEntries)
+
+type playlistEntry struct {
+	Path     string  `json:"path"`
+	Name     string  `json:"name"`
+	Title    string  `json:"title,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+	MimeType string  `json:"mime_type"`
+}
+
+type playlistExportRequest struct {
+	Paths     []string `json:"paths"`
+	Recursive bool     `json:"recursive,omitempty"`
+	Format    string   `json:"format,omitempty"`
+	Name      string   `json:"name,omitempty"`
+}
+
+func isPlayableMedia(name string) bool {
+	if playlistFormats[strings.ToLower(filepath.Ext(name))] != "" {
+		return false
+	}
+	mediaType := mediaTypeByPath(name)
+	return strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/")
+}
+
+// collectPlaylistEntries returns the audio and video files among paths.
+// Directories contribute their files in name order, and their
+// subdirectories too when recursive is set.
+func (h *Handler) collectPlaylistEntries(virtualPaths []string, recursive bool) ([]playlistEntry, error) {
+	entries := []playlistEntry{}
+	add := func(physicalPath string) error {
+		if len(entries) == maxPlaylistEntries {
+			return errPlaylistTooLarge
+		}
+		name := filepath.Base(physicalPath)
+		entries = append(entries, playlistEntry{Path: h.convertToVirtualPath(physicalPath), Name: name, Title: strings.TrimSuffix(name, filepath.Ext(name)), MimeType: mediaTypeByPath(name)})
+		return nil
+	}
+	for _, virtualPath := range virtualPaths {
+		fullPath, err := h.convertToPhysicalPath(virtualPath)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if info.Mode().IsRegular() && isPlayableMedia(fullPath) {
+				if err := add(fullPath); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		err = filepath.WalkDir(fullPath, func(filePath string, entry os.DirEntry, walkErr error) error {
+			if walkErr != nil {
+				h.logger.Warn("Skipping path in playlist", "path", filePath, "error", walkErr)
+				return nil
+			}
+			if filePath == fullPath {
+				return nil
+			}
+			if entry.IsDir() {
+				if !recursive || isInternalName(entry.Name()) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !entry.Type().IsRegular() || isInternalName(entry.Name()) || !isPlayableMedia(entry.Name()) {
+				return nil
+			}
+			return add(filePath)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// downloadURL is built from PUBLIC_URL. The Host header is chosen by the
+// client, so it is never written into a saved playlist.
+func downloadURL(baseURL, virtualPath string) string {
+	return baseURL + "/api/files/download?" + url.Values{"path": {virtualPath}}.Encode()
+}
+
+// playlistTitle keeps a title on one line.
+func playlistTitle(title string) string {
+	return strings.Join(strings.Fields(title), " ")
+}
+
+func writeM3U8(w io.Writer, baseURL string, entries []playlistEntry) {
+	_, _ = io.WriteString(w, "#EXTM3U\n")
+	for _, entry := range entries {
+		_, _ = fmt.Fprintf(w, "#EXTINF:-1,%s\n%s\n", playlistTitle(entry.Title), downloadURL(baseURL, entry.Path))
+	}
+}
+
+func writePLS(w io.Writer, baseURL string, entries []playlistEntry) {
+	_, _ = io.WriteString(w, "[playlist]\n")
+	for i, entry := range entries {
+		_, _ = fmt.Fprintf(w, "File%d=%s\nTitle%d=%s\nLength%d=-1\n", i+1, downloadURL(baseURL, entry.Path), i+1, playlistTitle(entry.Title), i+1)
+	}
+	_, _ = fmt.Fprintf(w, "NumberOfEntries=%d\nVersion=2\n", len(entries))
+}
+
+// ExportPlaylist writes the audio and video files of a directory, or with
+// POST of a selection of files and directories, as an M3U8 or PLS playlist.
+func (h *Handler) ExportPlaylist(w http.ResponseWriter, r *http.Request) {
+	var req playlistExportRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	} else {
+		query := r.URL.Query()
+		if query.Get("path") != "" {
+			req.Paths = []string{query.Get("path")}
+		}
+		req.Recursive = query.Get("recursive") == "1" || query.Get("recursive") == "true"
+		req.Format = query.Get("format")
+	}
+	if len(req.Paths) == 0 {
+		h.respondError(w, "Path required", http.StatusBadRequest)
+		return
+	}
+	if req.Format == "" {
+		req.Format = "m3u8"
+	}
+	if req.Format != "m3u8" && req.Format != "pls" {
+		h.respondError(w, "format must be m3u8 or pls", http.StatusBadRequest)
+		return
+	}
+	// Players resolve links against wherever the file was saved, so a
+	// playlist is only useful with absolute URLs.
+	if h.config.PublicURL == "" {
+		h.respondError(w, "Set PUBLIC_URL to export playlists", http.StatusServiceUnavailable)
+		return
+	}
+
+	entries, err := h.collectPlaylistEntries(req.Paths, req.Recursive)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPlaylistTooLarge):
+			h.respondError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case os.IsNotExist(err):
+			h.respondError(w, "Path not found", http.StatusNotFound)
+		default:
+			h.respondError(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = path.Base(req.Paths[0])
+		if len(req.Paths) > 1 || name == "/" || name == "." {
+			name = "playlist"
+		}
+	}
+	name = strings.TrimSuffix(name, path.Ext(name)) + "." + req.Format
+	if req.Format == "pls" {
+		w.Header().Set("Content-Type", "audio/x-scpls; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+	}
+	if disposition := mime.FormatMediaType("attachment", map[string]string{"filename": name}); disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if req.Format == "pls" {
+		writePLS(w, h.config.PublicURL, entries)
+	} else {
+		writeM3U8(w, h.config.PublicURL, entries)
+	}
+}
+
+type playlistItem struct {
+	location string
+	title    string
+	duration float64
+}
+
+// parseM3U reads the locations of an M3U or M3U8 playlist with the titles
+// and durations of their #EXTINF lines.
+func parseM3U(text string) []playlistItem {
+	var items []playlistItem
+	var pending playlistItem
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			duration, title, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			// Attributes such as tvg-id="..." may follow the duration.
+			duration, _, _ = strings.Cut(duration, " ")
+			pending.duration, _ = strconv.ParseFloat(duration, 64)
+			pending.title = strings.TrimSpace(title)
+		case strings.HasPrefix(line, "#"):
+		default:
+			pending.location = line
+			items = append(items, pending)
+			pending = playlistItem{}
+		}
+	}
+	return items
+}
+
+// parsePLS reads the FileN, TitleN, and LengthN keys of a PLS playlist.
+func parsePLS(text string) []playlistItem {
+	byNumber := map[int]*playlistItem{}
+	for _, line := range strings.Split(text, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(key)
+		var field string
+		for _, prefix := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, prefix) {
+				field = prefix
+				break
+			}
+		}
+		number, err := strconv.Atoi(strings.TrimPrefix(key, field))
+		if field == "" || err != nil || number < 1 {
+			continue
+		}
+		item := byNumber[number]
+		if item == nil {
+			item = &playlistItem{}
+			byNumber[number] = item
+		}
+		switch field {
+		case "file":
+			item.location = strings.TrimSpace(value)
+		case "title":
+			item.title = strings.TrimSpace(value)
+		case "length":
+			item.duration, _ = strconv.ParseFloat(strings.TrimSpace(value), 64)
+		}
+	}
+	numbers := make([]int, 0, len(byNumber))
+	for number, item := range byNumber {
+		if item.location != "" {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+	items := make([]playlistItem, 0, len(numbers))
+	for _, number := range numbers {
+		items = append(items, *byNumber[number])
+	}
+	return items
+}
+
+// playlistVirtualPath turns a playlist location into a virtual path.
+// Relative locations are relative to the playlist's directory, absolute ones
+// are virtual paths, and download URLs of this server, root-relative or
+// under PUBLIC_URL, name their path. Other URLs cannot be resolved.
+func playlistVirtualPath(location, playlistDir, publicURL string) (string, bool) {
+	if parsed, err := url.Parse(location); err == nil {
+		target := parsed.Query().Get("path")
+		absolute := isPublicDownloadURL(parsed, publicURL)
+		rootRelative := parsed.Scheme == "" && parsed.Host == "" && parsed.Path == "/api/files/download"
+		if target != "" && (absolute || rootRelative) {
+			return path.Clean("/" + target), true
+		}
+		// A single letter is a Windows drive, not a scheme.
+		if len(parsed.Scheme) > 1 {
+			return "", false
+		}
+	}
+	location = strings.ReplaceAll(location, `\`, "/")
+	if strings.HasPrefix(location, "/") {
+		return path.Clean(location), true
+	}
+	return path.Join(playlistDir, location), true
+}
+
+// isPublicDownloadURL reports whether location is a download URL under
+// PUBLIC_URL. A URL of any other origin names a file on another server, even
+// when its path looks like one of ours.
+func isPublicDownloadURL(location *url.URL, publicURL string) bool {
+	if publicURL == "" {
+		return false
+	}
+	public, err := url.Parse(publicURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(location.Scheme, public.Scheme) &&
+		strings.EqualFold(location.Host, public.Host) &&
+		location.User == nil &&
+		location.Path == public.Path+"/api/files/download"
+}
+
+// OpenPlaylist reads an M3U, M3U8, or PLS file and returns its entries as
+// virtual paths. Entries outside the allowed roots, missing files, and
+// remote URLs are reported as unresolved.
+func (h *Handler) OpenPlaylist(w http.ResponseWriter, r *http.Request) {
+	playlistPath := r.URL.Query().Get("path")
+	if playlistPath == "" {
+		h.respondError(w, "Path required", http.StatusBadRequest)
+		return
+	}
+	if len(playlistPath) > maxVirtualPathBytes {
+		h.respondError(w, "Path is too long", http.StatusBadRequest)
+		return
+	}
+	format := playlistFormats[strings.ToLower(path.Ext(playlistPath))]
+	if format == "" {
+		h.respondError(w, "Not a playlist", http.StatusBadRequest)
+		return
+	}
+	fullPath, err := h.convertToPhysicalPath(playlistPath)
+	if err != nil {
+		h.respondError(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	source, err := h.openAllowedPath(fullPath, os.O_RDONLY, 0)
+	if err != nil {
+		h.respondError(w, "Cannot open file", http.StatusNotFound)
+		return
+	}
+	defer func() { _ = source.Close() }()
+	data, err := io.ReadAll(io.LimitReader(source, maxPlaylistBytes+1))
+	if err != nil {
+		h.respondError(w, "Cannot read file", http.StatusInternalServerError)
+		return
+	}
+	if len(data) > maxPlaylistBytes {
+		h.respondError(w, "Playlist is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Legacy .m3u files are often in a local code page; the subtitle
+	// decoder handles the common cases.
+	text := decodeSubtitleText(data)
+	var items []playlistItem
+	if format == "pls" {
+		items = parsePLS(text)
+	} else {
+		items = parseM3U(text)
+	}
+	if len(items) > maxPlaylistEntries {
+		items = items[:maxPlaylistEntries]
+	}
+	entries := []playlistEntry{}
+	unresolved := []string{}
+	playlistDir := path.Dir(path.Clean("/" + playlistPath))
+	for _, item := range items {
+		virtualPath, ok := playlistVirtualPath(item.location, playlistDir, h.config.PublicURL)
+		if ok {
+			ok = false
+			if physicalPath, err := h.convertToPhysicalPath(virtualPath); err == nil {
+				if info, err := os.Stat(physicalPath); err == nil && info.Mode().IsRegular() && isPlayableMedia(physicalPath) {
+					ok = true
+				}
+			}
+		}
+		if !ok {
+			unresolved = append(unresolved, item.location)
+			continue
+		}
+		name := path.Base(virtualPath)
+		title := item.title
+		if title == "" {
+			title = strings.TrimSuffix(name, path.Ext(name))
+		}
+		entries = append(entries, playlistEntry{Path: virtualPath, Name: name, Title: title, Duration: max(item.duration, 0), MimeType: mediaTypeByPath(name)})
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	h.respondSuccess(w, map[string]interface{}{"path": playlistPath, "entries": entries, "unresolved": unresolved})
+}
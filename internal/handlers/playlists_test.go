@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePlaylistLibrary(t *testing.T, h *Handler) {
+	t.Helper()
+	album := filepath.Join(h.config.StorageDir, "music", "album")
+	for _, dir := range []string{filepath.Join(album, "disc2"), filepath.Join(album, ".puremania-trash")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"b.mp3", "a.flac", "cover.jpg", "old.m3u8", "disc2/c.mp3", ".puremania-trash/d.mp3"} {
+		writeTestFile(t, filepath.Join(album, filepath.FromSlash(name)), "x")
+	}
+}
+
+func TestExportPlaylistWritesDownloadURLs(t *testing.T) {
+	h := newContentTestHandler(t)
+	writePlaylistLibrary(t, h)
+
+	res := httptest.NewRecorder()
+	h.ExportPlaylist(res, httptest.NewRequest(http.MethodGet, "http://nas.local:8844/api/playlists/export?path=/music/album", nil))
+	if res.Code != http.StatusServiceUnavailable || strings.Contains(res.Body.String(), "download") {
+		t.Fatalf("export without PUBLIC_URL: status = %d, body =\n%s", res.Code, res.Body.String())
+	}
+
+	h.config.PublicURL = "https://nas.example/files"
+	res = httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "http://nas.local:8844/api/playlists/export?path=/music/album", nil)
+	request.Host = "attacker.example"
+	h.ExportPlaylist(res, request)
+	want := "#EXTM3U\n" +
+		"#EXTINF:-1,a\nhttps://nas.example/files/api/files/download?path=%2Fmusic%2Falbum%2Fa.flac\n" +
+		"#EXTINF:-1,b\nhttps://nas.example/files/api/files/download?path=%2Fmusic%2Falbum%2Fb.mp3\n"
+	if res.Code != http.StatusOK || res.Body.String() != want {
+		t.Fatalf("status = %d, body =\n%s", res.Code, res.Body.String())
+	}
+	if got := res.Header().Get("Content-Disposition"); got != "attachment; filename=album.m3u8" {
+		t.Fatalf("Content-Disposition = %q", got)
+	}
+
+	h.config.PublicURL = "https://nas.example"
+	res = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodGet, "http://nas.local/api/playlists/export?path=/music/album&recursive=1&format=pls", nil)
+	request.Header.Set("X-Forwarded-Proto", "http")
+	h.ExportPlaylist(res, request)
+	body := res.Body.String()
+	if !strings.Contains(body, "File3=https://nas.example/api/files/download?path=%2Fmusic%2Falbum%2Fdisc2%2Fc.mp3\nTitle3=c\n") || !strings.Contains(body, "NumberOfEntries=3\n") {
+		t.Fatalf("recursive PLS =\n%s", body)
+	}
+
+	res = httptest.NewRecorder()
+	selection := `{"paths":["/music/album/disc2","/music/album/b.mp3","/music/album/cover.jpg"],"name":"mix"}`
+	h.ExportPlaylist(res, httptest.NewRequest(http.MethodPost, "http://nas.local/api/playlists/export", strings.NewReader(selection)))
+	if got := strings.Count(res.Body.String(), "#EXTINF"); res.Code != http.StatusOK || got != 2 || res.Header().Get("Content-Disposition") != "attachment; filename=mix.m3u8" {
+		t.Fatalf("selection: status = %d, entries = %d, body =\n%s", res.Code, got, res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	h.ExportPlaylist(res, httptest.NewRequest(http.MethodGet, "/api/playlists/export?path=/missing", nil))
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("missing directory status = %d", res.Code)
+	}
+}
+
+func TestOpenPlaylistResolvesEntries(t *testing.T) {
+	h := newContentTestHandler(t)
+	h.config.PublicURL = "http://nas.local"
+	writePlaylistLibrary(t, h)
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "music", "list.m3u8"), "#EXTM3U\r\n"+
+		"#EXTINF:123,Artist - Song\r\nalbum/a.flac\r\n"+
+		"album\\b.mp3\r\n"+
+		"/music/album/disc2/c.mp3\r\n"+
+		"http://NAS.local/api/files/download?path=%2Fmusic%2Falbum%2Fa.flac\r\n"+
+		"/api/files/download?path=%2Fmusic%2Falbum%2Fb.mp3\r\n"+
+		"http://attacker.example/api/files/download?path=%2Fmusic%2Falbum%2Fa.flac\r\n"+
+		"https://nas.local/api/files/download?path=%2Fmusic%2Falbum%2Fa.flac\r\n"+
+		"../../etc/passwd\r\nhttp://radio.example/stream\r\nalbum/missing.mp3\r\nalbum/cover.jpg\r\n")
+
+	res := httptest.NewRecorder()
+	h.OpenPlaylist(res, httptest.NewRequest(http.MethodGet, "/api/playlists?path=/music/list.m3u8", nil))
+	var body struct {
+		Data struct {
+			Entries    []playlistEntry `json:"entries"`
+			Unresolved []string        `json:"unresolved"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil || res.Code != http.StatusOK {
+		t.Fatalf("status = %d, err = %v", res.Code, err)
+	}
+	var paths []string
+	for _, entry := range body.Data.Entries {
+		paths = append(paths, entry.Path)
+	}
+	want := []string{"/music/album/a.flac", "/music/album/b.mp3", "/music/album/disc2/c.mp3", "/music/album/a.flac", "/music/album/b.mp3"}
+	if strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Fatalf("entries = %v, want %v", paths, want)
+	}
+	if first := body.Data.Entries[0]; first.Title != "Artist - Song" || first.Duration != 123 || first.MimeType != "audio/flac" {
+		t.Fatalf("first entry = %+v", first)
+	}
+	if len(body.Data.Unresolved) != 6 || body.Data.Unresolved[0] != "http://attacker.example/api/files/download?path=%2Fmusic%2Falbum%2Fa.flac" {
+		t.Fatalf("unresolved = %v", body.Data.Unresolved)
+	}
+}
+
+func TestParsePLSOrdersEntriesByNumber(t *testing.T) {
+	items := parsePLS("[playlist]\nFile2=second.mp3\nTitle2=Second\nfile1=first.mp3\nLength1=61\nTitle9=orphan\nNumberOfEntries=2\n")
+	if len(items) != 2 || items[0].location != "first.mp3" || items[0].duration != 61 || items[1].title != "Second" {
+		t.Fatalf("items = %+v", items)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package handlers
+
+import "errors"
+
+func renameNoReplace(oldpath, newpath string) error { return linkNoReplace(oldpath, newpath) }
+
+func exchangePaths(_, _ string) error { return errors.ErrUnsupported }
@@ -5,6 +5,7 @@ package handlers
 // ever materialized in application memory.
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
@@ -33,6 +34,24 @@ type uploadSession struct {
 	CreatedAt     time.Time `json:"createdAt"`
 	UpdatedAt     time.Time `json:"updatedAt"`
 	Fingerprint   string    `json:"fingerprint,omitempty"`
+	// SHA256 is the declared whole-file digest in hex; after completion it is
+	// the digest of the stored file. HashState is the marshaled SHA-256 state
+	// of the first UploadedBytes.
+	SHA256    string `json:"sha256,omitempty"`
+	HashState []byte `json:"hashState,omitempty"`
+	// Parallel sessions accept ranges in any order; Extents lists the received
+	// ranges and UploadedBytes is their total.
+	Parallel bool           `json:"parallel,omitempty"`
+	Extents  []uploadExtent `json:"extents,omitempty"`
+	// OnConflict applies when the target exists at completion; Skipped
+	// records that the existing file was kept. LastModified is the client's
+	// modification time in Unix milliseconds. Placed is set before the part
+	// file is renamed into place, so only then may a missing part file mean
+	// that the target is this upload.
+	OnConflict   conflictPolicy `json:"onConflict,omitempty"`
+	LastModified int64          `json:"lastModified,omitempty"`
+	Skipped      bool           `json:"skipped,omitempty"`
+	Placed       bool           `json:"placed,omitempty"`
 }
 
 type createUploadRequest struct {
@@ -40,6 +59,10 @@ type createUploadRequest struct {
 	RelativePath string `json:"relativePath"`
 	Size         int64  `json:"size"`
 	Fingerprint  string `json:"fingerprint"`
+	SHA256       string `json:"sha256"`
+	Parallel     bool   `json:"parallel"`
+	OnConflict   string `json:"onConflict"`
+	LastModified int64  `json:"lastModified"`
 }
 
 func (h *Handler) uploadSessionDir() string {
@@ -121,55 +144,73 @@ func (h *Handler) writeUploadSession(session *uploadSession) error {
 	return os.Rename(tmp, h.uploadMetadataPath(session.ID))
 }
 
-// CreateUpload creates a durable upload session and returns its dedicated URL.
-func (h *Handler) CreateUpload(w http.ResponseWriter, r *http.Request) {
-	var req createUploadRequest
-	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil {
-		h.respondError(w, "Invalid upload request", http.StatusBadRequest)
+// uploadError carries the HTTP status of a failed upload step shared by the
+// upload-sessions and tus endpoints.
+type uploadError struct {
+	status  int
+	message string
+}
+
+func (e *uploadError) Error() string { return e.message }
+
+var (
+	errUploadCanceled      = &uploadError{status: http.StatusRequestTimeout, message: "Upload interrupted"}
+	errChunkDigestMismatch = &uploadError{status: http.StatusBadRequest, message: "Upload chunk digest mismatch"}
+	errUploadSumMismatch   = &uploadError{status: http.StatusUnprocessableEntity, message: "Upload checksum mismatch"}
+)
+
+func (h *Handler) respondUploadError(w http.ResponseWriter, err error) {
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		h.respondError(w, uploadErr.message, uploadErr.status)
 		return
 	}
+	h.respondError(w, err.Error(), http.StatusInternalServerError)
+}
+
+// createUploadSession validates the destination, reserves the part file, and
+// persists a new session.
+func (h *Handler) createUploadSession(req createUploadRequest) (*uploadSession, error) {
 	if req.Path == "" {
 		req.Path = "/"
 	}
 	if req.Size < 0 || req.Size > h.config.MaxFileSize<<20 || req.RelativePath == "" || len(req.Path) > maxVirtualPathBytes || len(req.RelativePath) > maxRelativePathBytes {
-		h.respondError(w, "Invalid upload size or path", http.StatusBadRequest)
-		return
+		return nil, &uploadError{http.StatusBadRequest, "Invalid upload size or path"}
 	}
 	destination, err := h.convertToPhysicalPath(req.Path)
 	if err != nil {
-		h.respondError(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
-		return
+		return nil, &uploadError{http.StatusBadRequest, "Invalid path: " + err.Error()}
 	}
 	relativePath := filepath.FromSlash(req.RelativePath)
 	if _, err := secureJoin(destination, relativePath); err != nil {
-		h.respondError(w, "Invalid relative path", http.StatusBadRequest)
-		return
+		return nil, &uploadError{http.StatusBadRequest, "Invalid relative path"}
+	}
+	if req.SHA256 != "" {
+		if req.SHA256, err = parseSHA256Hex(req.SHA256); err != nil {
+			return nil, &uploadError{http.StatusBadRequest, "Invalid upload checksum"}
+		}
+	}
+	policy, err := parseUploadConflictPolicy(req.OnConflict)
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, err.Error()}
+	}
+	if req.LastModified < 0 || policy == conflictNewerOnly && req.LastModified == 0 {
+		return nil, &uploadError{http.StatusBadRequest, "Invalid upload lastModified"}
 	}
 	if err := os.MkdirAll(h.uploadSessionDir(), 0700); err != nil {
-		h.respondError(w, "Cannot create upload session", http.StatusInternalServerError)
-		return
+		return nil, &uploadError{http.StatusInternalServerError, "Cannot create upload session"}
 	}
 	id, err := newUploadID()
 	if err != nil {
-		h.respondError(w, "Cannot create upload session", http.StatusInternalServerError)
-		return
+		return nil, &uploadError{http.StatusInternalServerError, "Cannot create upload session"}
 	}
 	now := time.Now().UTC()
-	if req.Fingerprint == "" || len(req.Fingerprint) != sha256.Size*2 {
-		h.respondError(w, "Invalid upload fingerprint", http.StatusBadRequest)
-		return
-	}
-	if _, err := hex.DecodeString(req.Fingerprint); err != nil {
-		h.respondError(w, "Invalid upload fingerprint", http.StatusBadRequest)
-		return
-	}
-	session := &uploadSession{ID: id, Destination: destination, RelativePath: relativePath, TotalBytes: req.Size, CreatedAt: now, UpdatedAt: now, Fingerprint: req.Fingerprint}
+	session := &uploadSession{ID: id, Destination: destination, RelativePath: relativePath, TotalBytes: req.Size, CreatedAt: now, UpdatedAt: now, Fingerprint: req.Fingerprint, SHA256: req.SHA256, Parallel: req.Parallel, OnConflict: policy, LastModified: req.LastModified}
 	// Reserve storage before acknowledging the session. KEEP_SIZE allocation
 	// preserves resumable writes' logical length and catches ENOSPC early.
 	part, createErr := os.OpenFile(h.uploadTempPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
 	if createErr != nil {
-		h.respondError(w, "Cannot create upload data", http.StatusInternalServerError)
-		return
+		return nil, &uploadError{http.StatusInternalServerError, "Cannot create upload data"}
 	}
 	if req.Size > 0 && h.config.PreallocateUploads {
 		createErr = preallocateUpload(part, req.Size)
@@ -179,20 +220,41 @@ func (h *Handler) CreateUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	if createErr != nil {
 		_ = os.Remove(h.uploadTempPath(id))
-		h.respondError(w, "Cannot reserve upload storage", http.StatusInsufficientStorage)
-		return
+		return nil, &uploadError{http.StatusInsufficientStorage, "Cannot reserve upload storage"}
 	}
 	if err := h.writeUploadSession(session); err != nil {
 		_ = os.Remove(h.uploadTempPath(id))
-		h.respondError(w, "Cannot save upload session", http.StatusInternalServerError)
-		return
+		return nil, &uploadError{http.StatusInternalServerError, "Cannot save upload session"}
 	}
 	h.publishUploadState(session, false)
-	location := "/api/files/upload-sessions/" + id
+	return session, nil
+}
+
+// CreateUpload creates a durable upload session and returns its dedicated URL.
+func (h *Handler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	var req createUploadRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil {
+		h.respondError(w, "Invalid upload request", http.StatusBadRequest)
+		return
+	}
+	if req.Fingerprint == "" || len(req.Fingerprint) != sha256.Size*2 {
+		h.respondError(w, "Invalid upload fingerprint", http.StatusBadRequest)
+		return
+	}
+	if _, err := hex.DecodeString(req.Fingerprint); err != nil {
+		h.respondError(w, "Invalid upload fingerprint", http.StatusBadRequest)
+		return
+	}
+	session, err := h.createUploadSession(req)
+	if err != nil {
+		h.respondUploadError(w, err)
+		return
+	}
+	location := "/api/files/upload-sessions/" + session.ID
 	w.Header().Set("Location", location)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(map[string]any{"uploadId": id, "uploadURL": location, "uploadedBytes": int64(0), "fingerprint": req.Fingerprint})
+	_ = json.NewEncoder(w).Encode(map[string]any{"uploadId": session.ID, "uploadURL": location, "uploadedBytes": int64(0), "fingerprint": session.Fingerprint, "sha256": session.SHA256, "parallel": session.Parallel})
 }
 
 func parseContentRange(value string) (int64, int64, int64, error) {
@@ -204,11 +266,12 @@ func parseContentRange(value string) (int64, int64, int64, error) {
 }
 
 func (h *Handler) resumeFingerprint(session *uploadSession) (string, int64) {
-	length := min(session.UploadedBytes, int64(1024*1024))
+	prefix := session.contiguousBytes()
+	length := min(prefix, int64(1024*1024))
 	if length == 0 || session.Completed {
 		return "", 0
 	}
-	offset := session.UploadedBytes - length
+	offset := prefix - length
 	part, err := os.Open(h.uploadTempPath(session.ID))
 	if err != nil {
 		return "", 0
@@ -222,17 +285,21 @@ func (h *Handler) resumeFingerprint(session *uploadSession) (string, int64) {
 }
 
 func (h *Handler) writeUploadPosition(w http.ResponseWriter, session *uploadSession, status int) {
-	if session.UploadedBytes > 0 {
-		w.Header().Set("Range", "bytes=0-"+strconv.FormatInt(session.UploadedBytes-1, 10))
+	extents := session.receivedExtents()
+	if len(extents) > 0 {
+		w.Header().Set("Range", formatExtentRanges(extents))
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	resumeFingerprint, resumeOffset := h.resumeFingerprint(session)
-	_ = json.NewEncoder(w).Encode(map[string]any{"uploadedBytes": session.UploadedBytes, "totalBytes": session.TotalBytes, "completed": session.Completed, "fingerprint": session.Fingerprint, "resumeFingerprint": resumeFingerprint, "resumeOffset": resumeOffset})
+	_ = json.NewEncoder(w).Encode(map[string]any{"uploadedBytes": session.UploadedBytes, "totalBytes": session.TotalBytes, "completed": session.Completed, "fingerprint": session.Fingerprint, "resumeFingerprint": resumeFingerprint, "resumeOffset": resumeOffset, "sha256": session.SHA256, "parallel": session.Parallel, "extents": extents})
 }
 
-// UploadChunk accepts only the next contiguous range. A completely acknowledged
-// range may be resent safely (for example when its response was lost).
+// UploadChunk accepts only the next contiguous range, or any unreceived range of
+// a parallel session. A completely acknowledged range may be resent safely (for
+// example when its response was lost).
+// Content-Digest (or Digest) is checked against the chunk's bytes, and
+// Repr-Digest declares the digest of the whole file.
 func (h *Handler) UploadChunk(w http.ResponseWriter, r *http.Request) {
 	id := filepath.Base(filepath.Dir(r.URL.Path))
 	lock := h.sessionMutex(id)
@@ -248,10 +315,32 @@ func (h *Handler) UploadChunk(w http.ResponseWriter, r *http.Request) {
 		h.respondError(w, "Invalid Content-Range", http.StatusBadRequest)
 		return
 	}
+	wantChunk, err := chunkDigest(r.Header)
+	if err != nil {
+		h.respondError(w, "Invalid Content-Digest", http.StatusBadRequest)
+		return
+	}
+	wantFile, err := parseDigestHeader(r.Header.Get("Repr-Digest"))
+	if err != nil {
+		h.respondError(w, "Invalid Repr-Digest", http.StatusBadRequest)
+		return
+	}
+	if wantFile != nil {
+		declared := hex.EncodeToString(wantFile)
+		if session.SHA256 != "" && session.SHA256 != declared {
+			h.respondError(w, "Repr-Digest does not match the upload checksum", http.StatusConflict)
+			return
+		}
+		session.SHA256 = declared
+	}
 	if session.Completed {
 		h.writeUploadPosition(w, session, http.StatusOK)
 		return
 	}
+	if session.Parallel {
+		h.writeParallelChunk(w, r, session, uploadExtent{Start: start, End: end + 1}, wantChunk)
+		return
+	}
 	if end < session.UploadedBytes {
 		h.writeUploadPosition(w, session, http.StatusPermanentRedirect)
 		return
@@ -260,53 +349,106 @@ func (h *Handler) UploadChunk(w http.ResponseWriter, r *http.Request) {
 		h.writeUploadPosition(w, session, http.StatusConflict)
 		return
 	}
-	queueStart := time.Now()
-	select {
-	case h.uploadGate <- struct{}{}:
-	case <-r.Context().Done():
+	if err := h.appendUploadChunk(w, r, session, end-start+1, true, wantChunk); err != nil {
+		if err != errUploadCanceled {
+			h.respondUploadError(w, err)
+		}
 		return
 	}
-	queueDelay := time.Since(queueStart)
+	status := http.StatusPermanentRedirect
+	if session.UploadedBytes == session.TotalBytes {
+		status = http.StatusOK
+	}
+	h.writeUploadPosition(w, session, status)
+}
+
+// appendUploadChunk writes up to length bytes of the request body at the
+// session's offset and persists the new offset with the running hash. With
+// exact set the body must hold exactly length bytes; otherwise a body that
+// ends early is kept. A failed chunk is truncated away.
+func (h *Handler) appendUploadChunk(w http.ResponseWriter, r *http.Request, session *uploadSession, length int64, exact bool, wantChunk []byte) error {
+	start := session.UploadedBytes
+	queueDelay, err := h.acquireUploadGate(r)
+	if err != nil {
+		return err
+	}
 	defer func() { <-h.uploadGate }()
+	fileHash, err := h.uploadHash(session)
+	if err != nil {
+		h.logger.Error("Cannot restore upload checksum", "id", session.ID, "error", err)
+		return &uploadError{http.StatusInternalServerError, "Cannot restore upload checksum"}
+	}
 	writeStart := time.Now()
-	part, err := os.OpenFile(h.uploadTempPath(id), os.O_CREATE|os.O_WRONLY, 0600)
+	part, err := os.OpenFile(h.uploadTempPath(session.ID), os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
-		h.respondError(w, "Cannot open upload data", http.StatusInternalServerError)
-		return
+		return &uploadError{http.StatusInternalServerError, "Cannot open upload data"}
 	}
 	defer func() { _ = part.Close() }()
 	if err := part.Truncate(start); err != nil {
-		h.respondError(w, "Cannot reset upload data", http.StatusInternalServerError)
-		return
+		return &uploadError{http.StatusInternalServerError, "Cannot reset upload data"}
 	}
 	if _, err := part.Seek(start, io.SeekStart); err != nil {
-		h.respondError(w, "Cannot seek upload data", http.StatusInternalServerError)
-		return
+		return &uploadError{http.StatusInternalServerError, "Cannot seek upload data"}
 	}
-	expected := end - start + 1
 	// Keep large sequential uploads from becoming valuable page-cache residents.
 	// This is advisory and does not alter the stream or the on-disk bytes.
-	prepareUploadRange(part, start, expected)
-	written, copyErr := io.CopyBuffer(part, io.LimitReader(r.Body, expected), make([]byte, 128*1024))
-	if copyErr != nil || written != expected {
-		_ = part.Truncate(start)
-		h.respondError(w, "Incomplete upload chunk", http.StatusBadRequest)
-		return
-	}
-	var extra [1]byte
-	if n, _ := r.Body.Read(extra[:]); n != 0 {
+	prepareUploadRange(part, start, length)
+	written, err := copyUploadChunk(io.MultiWriter(part, fileHash), r, length, exact, wantChunk)
+	if err != nil {
+		// A corrupted chunk is discarded here, so the client resends only it.
 		_ = part.Truncate(start)
-		h.respondError(w, "Upload chunk exceeds Content-Range", http.StatusBadRequest)
-		return
+		return err
 	}
 	if err := part.Sync(); err != nil {
-		h.respondError(w, "Cannot save upload chunk", http.StatusInternalServerError)
-		return
+		return &uploadError{http.StatusInternalServerError, "Cannot save upload chunk"}
 	}
 	// Only evict data that has been durably flushed; a retry can always write a
 	// chunk again, but this avoids retaining multi-gigabyte page cache per upload.
 	releaseUploadRange(part, start, written)
-	writeTime := time.Since(writeStart)
+	h.writeUploadTelemetry(w, queueDelay, time.Since(writeStart))
+	session.UploadedBytes += written
+	if err := saveUploadHash(session, fileHash); err != nil {
+		return &uploadError{http.StatusInternalServerError, "Cannot persist upload progress"}
+	}
+	if err := h.writeUploadSession(session); err != nil {
+		return &uploadError{http.StatusInternalServerError, "Cannot persist upload progress"}
+	}
+	h.publishUploadState(session, false)
+	return nil
+}
+
+// acquireUploadGate waits for a disk write slot and reports how long it took.
+// The caller releases the slot.
+func (h *Handler) acquireUploadGate(r *http.Request) (time.Duration, error) {
+	queueStart := time.Now()
+	select {
+	case h.uploadGate <- struct{}{}:
+		return time.Since(queueStart), nil
+	case <-r.Context().Done():
+		return 0, errUploadCanceled
+	}
+}
+
+// copyUploadChunk copies up to length bytes of the body to dst and checks them
+// against the chunk's declared digest. With exact set the body must hold
+// exactly length bytes.
+func copyUploadChunk(dst io.Writer, r *http.Request, length int64, exact bool, wantChunk []byte) (int64, error) {
+	chunkHash := sha256.New()
+	written, err := io.CopyBuffer(io.MultiWriter(dst, chunkHash), io.LimitReader(r.Body, length), make([]byte, 128*1024))
+	if err != nil || (exact && written != length) {
+		return written, &uploadError{http.StatusBadRequest, "Incomplete upload chunk"}
+	}
+	var extra [1]byte
+	if n, _ := r.Body.Read(extra[:]); n != 0 {
+		return written, &uploadError{http.StatusBadRequest, "Upload chunk exceeds Content-Range"}
+	}
+	if wantChunk != nil && !bytes.Equal(chunkHash.Sum(nil), wantChunk) {
+		return written, errChunkDigestMismatch
+	}
+	return written, nil
+}
+
+func (h *Handler) writeUploadTelemetry(w http.ResponseWriter, queueDelay, writeTime time.Duration) {
 	// Telemetry is advisory: it lets clients distinguish disk contention from
 	// network saturation without coupling correctness to a specific algorithm.
 	w.Header().Set("Upload-Queue-Delay", strconv.FormatInt(queueDelay.Milliseconds(), 10))
@@ -317,17 +459,6 @@ func (h *Handler) UploadChunk(w http.ResponseWriter, r *http.Request) {
 	// server-wide concurrency limit.
 	w.Header().Set("Upload-Concurrency-Capacity", strconv.Itoa(cap(h.uploadGate)))
 	w.Header().Set("Upload-Concurrency-Active", strconv.Itoa(len(h.uploadGate)))
-	session.UploadedBytes += written
-	if err := h.writeUploadSession(session); err != nil {
-		h.respondError(w, "Cannot persist upload progress", http.StatusInternalServerError)
-		return
-	}
-	h.publishUploadState(session, false)
-	status := http.StatusPermanentRedirect
-	if session.UploadedBytes == session.TotalBytes {
-		status = http.StatusOK
-	}
-	h.writeUploadPosition(w, session, status)
 }
 
 func (h *Handler) UploadStatus(w http.ResponseWriter, r *http.Request) {
@@ -353,42 +484,81 @@ func (h *Handler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
 		h.respondError(w, "Upload session not found", http.StatusNotFound)
 		return
 	}
-	if session.Completed {
-		h.respondSuccess(w, map[string]any{"path": h.convertToVirtualPath(filepath.Join(session.Destination, session.RelativePath))})
+	if !session.Completed {
+		declared, err := parseDigestHeader(r.Header.Get("Repr-Digest"))
+		if err != nil {
+			h.respondError(w, "Invalid Repr-Digest", http.StatusBadRequest)
+			return
+		}
+		if declared != nil {
+			if session.SHA256 != "" && session.SHA256 != hex.EncodeToString(declared) {
+				h.respondError(w, "Repr-Digest does not match the upload checksum", http.StatusConflict)
+				return
+			}
+			session.SHA256 = hex.EncodeToString(declared)
+		}
+	}
+	target, err := h.finalizeUpload(session)
+	if err != nil {
+		h.respondUploadError(w, err)
 		return
 	}
+	if digest, err := hex.DecodeString(session.SHA256); err == nil && len(digest) == sha256.Size {
+		w.Header().Set("Repr-Digest", formatDigestHeader(digest))
+	}
+	h.respondSuccess(w, map[string]any{"path": h.convertToVirtualPath(target), "sha256": session.SHA256, "skipped": session.Skipped})
+}
+
+// finalizeUpload verifies a fully received session against its declared
+// checksum and renames the part file into place under the session's conflict
+// policy. It returns the physical target, or the existing file when the upload
+// was skipped; completing a completed session again is a no-op.
+func (h *Handler) finalizeUpload(session *uploadSession) (string, error) {
+	if session.Completed {
+		return filepath.Join(session.Destination, session.RelativePath), nil
+	}
 	if session.UploadedBytes != session.TotalBytes {
-		h.respondError(w, "Upload is incomplete", http.StatusConflict)
-		return
+		return "", &uploadError{http.StatusConflict, "Upload is incomplete"}
 	}
 	target, err := secureJoin(session.Destination, session.RelativePath)
 	if err != nil {
-		h.respondError(w, "Invalid upload destination", http.StatusBadRequest)
-		return
+		return "", &uploadError{http.StatusBadRequest, "Invalid upload destination"}
 	}
 	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
-		h.respondError(w, "Cannot create destination directory", http.StatusInternalServerError)
-		return
+		return "", &uploadError{http.StatusInternalServerError, "Cannot create destination directory"}
 	}
-	partPath := h.uploadTempPath(id)
-	if _, err := os.Stat(partPath); os.IsNotExist(err) {
+	partPath := h.uploadTempPath(session.ID)
+	if _, err := os.Stat(partPath); os.IsNotExist(err) && session.Placed {
+		// The rename below succeeded before the session was updated; it only
+		// happens after the checksum was verified. Without Placed the part
+		// file was lost some other way and a same-size target is not ours.
 		if info, statErr := os.Stat(target); statErr == nil && info.Size() == session.TotalBytes {
+			if digest, digestErr := h.uploadDigest(session); digestErr == nil && session.SHA256 == "" {
+				session.SHA256 = hex.EncodeToString(digest)
+			}
+			h.applyUploadModTime(target, session.LastModified)
 			session.Completed = true
+			session.HashState = nil
 			if err := h.writeUploadSession(session); err != nil {
-				h.respondError(w, "Cannot persist completed upload", http.StatusInternalServerError)
-				return
+				return "", &uploadError{http.StatusInternalServerError, "Cannot persist completed upload"}
 			}
-			cache.InvalidateByPrefix(h.cache, "list:"+filepath.Dir(h.convertToVirtualPath(target)))
-			cache.InvalidateByPrefix(h.cache, "search:")
-			h.publishUploadState(session, false)
-			h.respondSuccess(w, map[string]any{"path": h.convertToVirtualPath(target)})
-			return
+			h.publishUploadPlaced(session, target)
+			return target, nil
 		}
 	}
+	digest, err := h.uploadDigest(session)
+	if err != nil {
+		h.logger.Error("Cannot compute upload checksum", "id", session.ID, "error", err)
+		return "", &uploadError{http.StatusInternalServerError, "Cannot verify upload"}
+	}
+	sum := hex.EncodeToString(digest)
+	if session.SHA256 != "" && session.SHA256 != sum {
+		h.logger.Warn("Upload checksum mismatch", "id", session.ID, "expected", session.SHA256, "actual", sum)
+		return "", errUploadSumMismatch
+	}
 	part, err := os.OpenFile(partPath, os.O_WRONLY, 0600)
 	if err != nil {
-		h.respondError(w, "Cannot finalize upload", http.StatusInternalServerError)
-		return
+		return "", &uploadError{http.StatusInternalServerError, "Cannot finalize upload"}
 	}
 	truncateErr := part.Truncate(session.TotalBytes)
 	var syncErr error
@@ -397,37 +567,87 @@ func (h *Handler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	closeErr := part.Close()
 	if truncateErr != nil || syncErr != nil || closeErr != nil {
-		h.respondError(w, "Cannot finalize upload", http.StatusInternalServerError)
-		return
+		return "", &uploadError{http.StatusInternalServerError, "Cannot finalize upload"}
 	}
-	if err := os.Rename(partPath, target); err != nil {
-		h.respondError(w, "Cannot finalize upload", http.StatusInternalServerError)
-		return
+	session.Placed = true
+	if err := h.writeUploadSession(session); err != nil {
+		h.logger.Error("Cannot persist upload placement", "id", session.ID, "error", err)
+		return "", &uploadError{http.StatusInternalServerError, "Cannot finalize upload"}
+	}
+	target, skipped, err := h.placeUpload(session, partPath, target)
+	if err == errUploadTargetExists {
+		return "", err
+	}
+	if err != nil {
+		h.logger.Error("Cannot finalize upload", "id", session.ID, "error", err)
+		return "", &uploadError{http.StatusInternalServerError, "Cannot finalize upload"}
+	}
+	if !skipped {
+		h.applyUploadModTime(target, session.LastModified)
 	}
 	session.Completed = true
-	if err := h.writeUploadSession(session); err != nil {
-		h.logger.Error("Upload completed but session metadata update failed", "id", id, "error", err)
-		h.respondError(w, "Cannot persist completed upload", http.StatusInternalServerError)
-		return
+	session.SHA256 = sum
+	session.HashState = nil
+	if skipped {
+		// The stored file is the existing one, whose digest is unknown.
+		session.Skipped = true
+		session.SHA256 = ""
 	}
+	if err := h.writeUploadSession(session); err != nil {
+		h.logger.Error("Upload completed but session metadata update failed", "id", session.ID, "error", err)
+		return "", &uploadError{http.StatusInternalServerError, "Cannot persist completed upload"}
+	}
+	if skipped {
+		// Removed only after the session is completed, so the recovery above
+		// cannot mistake the existing file for this upload.
+		_ = os.Remove(partPath)
+		h.publishUploadState(session, false)
+		return target, nil
+	}
+	h.publishUploadPlaced(session, target)
+	return target, nil
+}
+
+// publishUploadPlaced announces an upload stored at target, whether it was
+// placed now or recovered after an interrupted completion.
+func (h *Handler) publishUploadPlaced(session *uploadSession, target string) {
 	cache.InvalidateByPrefix(h.cache, "list:"+filepath.Dir(h.convertToVirtualPath(target)))
 	cache.InvalidateByPrefix(h.cache, "search:")
 	h.publishUploadState(session, false)
-	h.respondSuccess(w, map[string]any{"path": h.convertToVirtualPath(target)})
+	h.publishFileChanges(eventFilesCreated, []string{target}, nil)
+}
+
+// applyUploadModTime sets a stored upload's mtime to the client's file time in
+// Unix milliseconds; zero keeps the server time. A failure only costs the
+// preserved time, so it is logged rather than failing the upload.
+func (h *Handler) applyUploadModTime(path string, lastModified int64) {
+	if lastModified <= 0 {
+		return
+	}
+	if err := os.Chtimes(path, time.Time{}, time.UnixMilli(lastModified)); err != nil {
+		h.logger.Warn("Cannot preserve upload modification time", "path", path, "error", err)
+	}
 }
 
 func (h *Handler) AbortUpload(w http.ResponseWriter, r *http.Request) {
-	id := filepath.Base(r.URL.Path)
+	if err := h.discardUploadSession(filepath.Base(r.URL.Path)); err != nil {
+		h.respondError(w, "Upload session not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// discardUploadSession permanently removes a session and its received data.
+func (h *Handler) discardUploadSession(id string) error {
 	lock := h.sessionMutex(id)
 	lock.Lock()
 	defer lock.Unlock()
 	session, err := h.readUploadSession(id)
 	if err != nil {
-		h.respondError(w, "Upload session not found", http.StatusNotFound)
-		return
+		return err
 	}
 	_ = os.Remove(h.uploadTempPath(id))
 	_ = os.Remove(h.uploadMetadataPath(id))
 	h.publishUploadState(session, true)
-	w.WriteHeader(http.StatusNoContent)
+	return nil
 }
@@ -2,17 +2,23 @@ package handlers
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path"
 	"path/filepath"
 	"puremania/internal/types"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func newUploadTestHandler(t *testing.T) *Handler {
@@ -91,13 +97,21 @@ func TestCompleteUploadRecoversAfterRenameBeforeMetadata(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	// The part file was renamed into place, but the process stopped before
+	// the session was completed.
 	session.UploadedBytes = 3
+	session.Placed = true
 	if err := h.writeUploadSession(session); err != nil {
 		t.Fatal(err)
 	}
+	if err := os.Rename(h.uploadTempPath(id), filepath.Join(h.config.StorageDir, "recovered.bin")); err != nil {
+		t.Fatal(err)
+	}
 	if err := os.WriteFile(filepath.Join(h.config.StorageDir, "recovered.bin"), []byte("abc"), 0600); err != nil {
 		t.Fatal(err)
 	}
+	events, unsubscribe := h.events.subscribe()
+	defer unsubscribe()
 
 	res := httptest.NewRecorder()
 	h.CompleteUpload(res, httptest.NewRequest(http.MethodPost, url+"/complete", nil))
@@ -111,6 +125,134 @@ func TestCompleteUploadRecoversAfterRenameBeforeMetadata(t *testing.T) {
 	if !recovered.Completed {
 		t.Fatal("session was not repaired as completed")
 	}
+	if content, err := os.ReadFile(filepath.Join(h.config.StorageDir, "recovered.bin")); err != nil || string(content) != "abc" {
+		t.Fatalf("recovered content = %q, %v", content, err)
+	}
+	<-events.ready
+	created := false
+	for _, event := range events.drain() {
+		created = created || event.name == eventFilesCreated
+	}
+	if !created {
+		t.Fatal("recovered upload was not announced")
+	}
+}
+
+func TestCompleteUploadDoesNotAdoptUnrelatedFileAfterLosingData(t *testing.T) {
+	h := newUploadTestHandler(t)
+	body := `{"path":"/","relativePath":"photo.jpg","size":3,"fingerprint":"` + strings.Repeat("a", 64) + `","lastModified":1000}`
+	res := httptest.NewRecorder()
+	h.CreateUpload(res, httptest.NewRequest(http.MethodPost, "/api/files/upload-sessions", strings.NewReader(body)))
+	var created struct{ UploadID, UploadURL string }
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil || res.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body = %s", res.Code, res.Body.String())
+	}
+	if res := putTestChunk(t, h, created.UploadURL, "bytes 0-2/3", "abc", http.Header{}); res.Code != http.StatusOK {
+		t.Fatalf("chunk status = %d, body = %s", res.Code, res.Body.String())
+	}
+	// The part file disappears before completion ever placed it, while an
+	// unrelated file of the same size sits at the target.
+	if err := os.Remove(h.uploadTempPath(created.UploadID)); err != nil {
+		t.Fatal(err)
+	}
+	unrelated := filepath.Join(h.config.StorageDir, "photo.jpg")
+	writeTestFile(t, unrelated, "xyz")
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(unrelated, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	res = httptest.NewRecorder()
+	h.CompleteUpload(res, httptest.NewRequest(http.MethodPost, created.UploadURL+"/complete", nil))
+	if res.Code == http.StatusOK {
+		t.Fatalf("completion adopted an unrelated file: %s", res.Body.String())
+	}
+	session, err := h.readUploadSession(created.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Completed {
+		t.Fatal("session was marked completed")
+	}
+	info, err := os.Stat(unrelated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Fatalf("unrelated file mtime = %v, want %v", info.ModTime(), modTime)
+	}
+}
+
+func putTestChunk(t *testing.T, h *Handler, url, contentRange, body string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPut, url+"/chunks", strings.NewReader(body))
+	req.Header = header
+	req.Header.Set("Content-Range", contentRange)
+	res := httptest.NewRecorder()
+	h.UploadChunk(res, req)
+	return res
+}
+
+func TestUploadChecksumsSurviveRestartAndRejectCorruption(t *testing.T) {
+	h := newUploadTestHandler(t)
+	whole := sha256.Sum256([]byte("abcdef"))
+	body := `{"path":"/","relativePath":"checked.bin","size":6,"fingerprint":"` + strings.Repeat("a", 64) + `","sha256":"` + hex.EncodeToString(whole[:]) + `"}`
+	res := httptest.NewRecorder()
+	h.CreateUpload(res, httptest.NewRequest(http.MethodPost, "/api/files/upload-sessions", strings.NewReader(body)))
+	var created struct{ UploadURL string }
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil || res.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, err = %v", res.Code, err)
+	}
+	url := created.UploadURL
+	digest := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		return formatDigestHeader(sum[:])
+	}
+
+	if res := putTestChunk(t, h, url, "bytes 0-2/6", "abc", http.Header{"Content-Digest": {digest("abc")}}); res.Code != http.StatusPermanentRedirect {
+		t.Fatalf("first chunk status = %d, body = %s", res.Code, res.Body.String())
+	}
+	// A restarted server resumes hashing from the state in the session JSON.
+	if session, err := h.readUploadSession(path.Base(url)); err != nil || len(session.HashState) == 0 {
+		t.Fatalf("hash state was not persisted: %v", err)
+	}
+	h.config.UploadSessionTTLHours = 1
+	h = NewHandler(h.config, h.logger)
+	if res := putTestChunk(t, h, url, "bytes 3-5/6", "dXf", http.Header{"Digest": {"SHA-256=" + strings.Trim(strings.TrimPrefix(digest("def"), "sha-256="), ":")}}); res.Code != http.StatusBadRequest {
+		t.Fatalf("corrupted chunk status = %d", res.Code)
+	}
+	if res := putTestChunk(t, h, url, "bytes 3-5/6", "def", http.Header{"Content-Digest": {"md5=:AAAA:, " + digest("def")}}); res.Code != http.StatusOK {
+		t.Fatalf("resent chunk status = %d, body = %s", res.Code, res.Body.String())
+	}
+	res = httptest.NewRecorder()
+	h.CompleteUpload(res, httptest.NewRequest(http.MethodPost, url+"/complete", nil))
+	if res.Code != http.StatusOK || res.Header().Get("Repr-Digest") != digest("abcdef") || !strings.Contains(res.Body.String(), hex.EncodeToString(whole[:])) {
+		t.Fatalf("complete status = %d, Repr-Digest = %q, body = %s", res.Code, res.Header().Get("Repr-Digest"), res.Body.String())
+	}
+	if content, err := os.ReadFile(filepath.Join(h.config.StorageDir, "checked.bin")); err != nil || string(content) != "abcdef" {
+		t.Fatalf("content = %q, err = %v", content, err)
+	}
+}
+
+func TestCompleteUploadRefusesChecksumMismatch(t *testing.T) {
+	h := newUploadTestHandler(t)
+	_, url := createTestUpload(t, h, "mismatch.bin", 3)
+	if res := putTestChunk(t, h, url, "bytes 0-2/3", "abc", http.Header{"Repr-Digest": {"sha-256=:not base64:"}}); res.Code != http.StatusBadRequest {
+		t.Fatalf("malformed Repr-Digest status = %d", res.Code)
+	}
+	wrong := sha256.Sum256([]byte("abd"))
+	if res := putTestChunk(t, h, url, "bytes 0-2/3", "abc", http.Header{"Repr-Digest": {formatDigestHeader(wrong[:])}}); res.Code != http.StatusOK {
+		t.Fatalf("chunk status = %d, body = %s", res.Code, res.Body.String())
+	}
+
+	res := httptest.NewRecorder()
+	h.CompleteUpload(res, httptest.NewRequest(http.MethodPost, url+"/complete", nil))
+	if res.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("complete status = %d, body = %s", res.Code, res.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(h.config.StorageDir, "mismatch.bin")); !os.IsNotExist(err) {
+		t.Fatalf("mismatched upload was finalized: %v", err)
+	}
 }
 
 func TestProtectedRootCannotBeDeletedAndSymlinkEscapesAreRejected(t *testing.T) {
@@ -157,3 +299,260 @@ func TestInputLimitsRejectOversizedPathAndBatch(t *testing.T) {
 		t.Fatal("oversized path in batch was accepted")
 	}
 }
+
+func createParallelTestUpload(t *testing.T, h *Handler, relativePath, content string) string {
+	t.Helper()
+	sum := sha256.Sum256([]byte(content))
+	body := `{"path":"/","relativePath":"` + relativePath + `","size":` + strconv.Itoa(len(content)) + `,"fingerprint":"` + strings.Repeat("a", 64) + `","sha256":"` + hex.EncodeToString(sum[:]) + `","parallel":true}`
+	res := httptest.NewRecorder()
+	h.CreateUpload(res, httptest.NewRequest(http.MethodPost, "/api/files/upload-sessions", strings.NewReader(body)))
+	var created struct {
+		UploadURL string
+		Parallel  bool
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil || res.Code != http.StatusCreated || !created.Parallel {
+		t.Fatalf("create status = %d, body = %s", res.Code, res.Body.String())
+	}
+	return created.UploadURL
+}
+
+func TestParallelUploadAcceptsRangesOutOfOrder(t *testing.T) {
+	h := newUploadTestHandler(t)
+	url := createParallelTestUpload(t, h, "parallel.bin", "abcdefghi")
+	complete := func() int {
+		res := httptest.NewRecorder()
+		h.CompleteUpload(res, httptest.NewRequest(http.MethodPost, url+"/complete", nil))
+		return res.Code
+	}
+
+	if res := putTestChunk(t, h, url, "bytes 6-8/9", "ghi", http.Header{}); res.Code != http.StatusPermanentRedirect || res.Header().Get("Range") != "bytes=6-8" {
+		t.Fatalf("last chunk status = %d, Range = %q", res.Code, res.Header().Get("Range"))
+	}
+	if code := complete(); code != http.StatusConflict {
+		t.Fatalf("incomplete complete status = %d", code)
+	}
+	if res := putTestChunk(t, h, url, "bytes 0-2/9", "abc", http.Header{}); res.Code != http.StatusPermanentRedirect || res.Header().Get("Range") != "bytes=0-2,6-8" {
+		t.Fatalf("first chunk status = %d, Range = %q", res.Code, res.Header().Get("Range"))
+	}
+	if res := putTestChunk(t, h, url, "bytes 2-4/9", "cde", http.Header{}); res.Code != http.StatusConflict {
+		t.Fatalf("overlapping chunk status = %d", res.Code)
+	}
+	if res := putTestChunk(t, h, url, "bytes 6-8/9", "ghi", http.Header{}); res.Code != http.StatusPermanentRedirect {
+		t.Fatalf("resent chunk status = %d", res.Code)
+	}
+
+	res := httptest.NewRecorder()
+	h.UploadStatus(res, httptest.NewRequest(http.MethodGet, url, nil))
+	var status struct {
+		UploadedBytes int64
+		ResumeOffset  int64
+		Extents       []uploadExtent
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &status); err != nil || status.UploadedBytes != 6 || status.ResumeOffset != 0 || len(status.Extents) != 2 || status.Extents[1] != (uploadExtent{Start: 6, End: 9}) {
+		t.Fatalf("status = %s, err = %v", res.Body.String(), err)
+	}
+
+	if res := putTestChunk(t, h, url, "bytes 3-5/9", "def", http.Header{}); res.Code != http.StatusOK || res.Header().Get("Range") != "bytes=0-8" {
+		t.Fatalf("middle chunk status = %d, Range = %q", res.Code, res.Header().Get("Range"))
+	}
+	if code := complete(); code != http.StatusOK {
+		t.Fatalf("complete status = %d", code)
+	}
+	if content, err := os.ReadFile(filepath.Join(h.config.StorageDir, "parallel.bin")); err != nil || string(content) != "abcdefghi" {
+		t.Fatalf("content = %q, err = %v", content, err)
+	}
+}
+
+func TestParallelUploadWritesChunksConcurrently(t *testing.T) {
+	h := newUploadTestHandler(t)
+	url := createParallelTestUpload(t, h, "concurrent.bin", "abcdef")
+
+	body, writer := io.Pipe()
+	slow := make(chan *httptest.ResponseRecorder)
+	go func() {
+		req := httptest.NewRequest(http.MethodPut, url+"/chunks", body)
+		req.Header.Set("Content-Range", "bytes 0-2/6")
+		res := httptest.NewRecorder()
+		h.UploadChunk(res, req)
+		slow <- res
+	}()
+	// The write returns once the handler is copying the body, with the range
+	// reserved and the session lock released.
+	if _, err := writer.Write([]byte("ab")); err != nil {
+		t.Fatal(err)
+	}
+	if res := putTestChunk(t, h, url, "bytes 2-3/6", "cd", http.Header{}); res.Code != http.StatusConflict {
+		t.Fatalf("chunk overlapping an in-flight range: status = %d", res.Code)
+	}
+	if res := putTestChunk(t, h, url, "bytes 3-5/6", "def", http.Header{}); res.Code != http.StatusPermanentRedirect {
+		t.Fatalf("concurrent chunk status = %d, body = %s", res.Code, res.Body.String())
+	}
+	if _, err := writer.Write([]byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	_ = writer.Close()
+	if res := <-slow; res.Code != http.StatusOK {
+		t.Fatalf("slow chunk status = %d, body = %s", res.Code, res.Body.String())
+	}
+	if _, inflight := h.uploadRanges.Load(path.Base(url)); inflight {
+		t.Fatal("in-flight range was not released")
+	}
+
+	res := httptest.NewRecorder()
+	h.CompleteUpload(res, httptest.NewRequest(http.MethodPost, url+"/complete", nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("complete status = %d, body = %s", res.Code, res.Body.String())
+	}
+	if content, err := os.ReadFile(filepath.Join(h.config.StorageDir, "concurrent.bin")); err != nil || string(content) != "abcdef" {
+		t.Fatalf("content = %q, err = %v", content, err)
+	}
+}
+
+func TestCompleteUploadAppliesConflictPolicy(t *testing.T) {
+	h := newUploadTestHandler(t)
+	existingTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name, policy string
+		lastModified time.Time
+		status       int
+		stored       string // file that receives "new"; the existing one keeps "old" otherwise
+		skipped      bool
+	}{
+		{name: "default.txt", status: http.StatusOK, stored: "default.txt"},
+		{name: "skip.txt", policy: "skip", status: http.StatusOK, skipped: true},
+		{name: "rename.txt", policy: "rename", status: http.StatusOK, stored: "rename (1).txt"},
+		{name: "fail.txt", policy: "fail", status: http.StatusConflict},
+		{name: "older.txt", policy: "newer-only", lastModified: existingTime.Add(-time.Hour), status: http.StatusOK, skipped: true},
+		{name: "newer.txt", policy: "newer-only", lastModified: existingTime.Add(time.Hour), status: http.StatusOK, stored: "newer.txt"},
+	}
+	for _, tc := range cases {
+		name := tc.name
+		existing := filepath.Join(h.config.StorageDir, name)
+		writeTestFile(t, existing, "old")
+		if err := os.Chtimes(existing, existingTime, existingTime); err != nil {
+			t.Fatal(err)
+		}
+		request := map[string]any{"path": "/", "relativePath": name, "size": 3, "fingerprint": strings.Repeat("a", 64), "onConflict": tc.policy}
+		if !tc.lastModified.IsZero() {
+			request["lastModified"] = tc.lastModified.UnixMilli()
+		}
+		body, _ := json.Marshal(request)
+		res := httptest.NewRecorder()
+		h.CreateUpload(res, httptest.NewRequest(http.MethodPost, "/api/files/upload-sessions", bytes.NewReader(body)))
+		var created struct{ UploadURL string }
+		if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil || res.Code != http.StatusCreated {
+			t.Fatalf("%s: create status = %d, body = %s", tc.name, res.Code, res.Body.String())
+		}
+		if res := putTestChunk(t, h, created.UploadURL, "bytes 0-2/3", "new", http.Header{}); res.Code != http.StatusOK {
+			t.Fatalf("%s: chunk status = %d", tc.name, res.Code)
+		}
+		res = httptest.NewRecorder()
+		h.CompleteUpload(res, httptest.NewRequest(http.MethodPost, created.UploadURL+"/complete", nil))
+		if res.Code != tc.status || strings.Contains(res.Body.String(), `"skipped":true`) != tc.skipped {
+			t.Fatalf("%s: complete status = %d, body = %s", tc.name, res.Code, res.Body.String())
+		}
+		if tc.stored != "" && !strings.Contains(res.Body.String(), `"path":"/`+tc.stored+`"`) {
+			t.Fatalf("%s: complete body = %s", tc.name, res.Body.String())
+		}
+		if content, err := os.ReadFile(existing); tc.stored != name && (err != nil || string(content) != "old") {
+			t.Fatalf("%s: existing file = %q, err = %v", tc.name, content, err)
+		}
+		if tc.stored != "" {
+			if content, err := os.ReadFile(filepath.Join(h.config.StorageDir, tc.stored)); err != nil || string(content) != "new" {
+				t.Fatalf("%s: stored file = %q, err = %v", tc.name, content, err)
+			}
+		}
+		if _, err := os.Stat(h.uploadTempPath(path.Base(created.UploadURL))); os.IsNotExist(err) == (tc.status == http.StatusConflict) {
+			t.Fatalf("%s: part file state: %v", tc.name, err)
+		}
+	}
+
+	body := `{"path":"/","relativePath":"a.txt","size":3,"fingerprint":"` + strings.Repeat("a", 64) + `","onConflict":"newer-only"}`
+	res := httptest.NewRecorder()
+	h.CreateUpload(res, httptest.NewRequest(http.MethodPost, "/api/files/upload-sessions", strings.NewReader(body)))
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("newer-only without lastModified: status = %d", res.Code)
+	}
+}
+
+func TestUploadsPreserveClientModificationTime(t *testing.T) {
+	h := newUploadTestHandler(t)
+	taken := time.Date(2019, 8, 3, 9, 30, 0, 0, time.UTC)
+
+	body := `{"path":"/","relativePath":"photo.jpg","size":3,"fingerprint":"` + strings.Repeat("a", 64) + `","lastModified":` + strconv.FormatInt(taken.UnixMilli(), 10) + `}`
+	res := httptest.NewRecorder()
+	h.CreateUpload(res, httptest.NewRequest(http.MethodPost, "/api/files/upload-sessions", strings.NewReader(body)))
+	var created struct{ UploadURL string }
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil || res.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body = %s", res.Code, res.Body.String())
+	}
+	putTestChunk(t, h, created.UploadURL, "bytes 0-2/3", "jpg", http.Header{})
+	res = httptest.NewRecorder()
+	h.CompleteUpload(res, httptest.NewRequest(http.MethodPost, created.UploadURL+"/complete", nil))
+	if info, err := os.Stat(filepath.Join(h.config.StorageDir, "photo.jpg")); res.Code != http.StatusOK || err != nil || !info.ModTime().Equal(taken) {
+		t.Fatalf("session upload: status = %d, err = %v, info = %v", res.Code, err, info)
+	}
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	for _, name := range []string{"kept.txt", "now.txt"} {
+		part, _ := writer.CreateFormFile("file", name)
+		_, _ = part.Write([]byte(name))
+		_ = writer.WriteField("relativePath[]", name)
+	}
+	_ = writer.WriteField("lastModified[]", strconv.FormatInt(taken.UnixMilli(), 10))
+	_ = writer.WriteField("lastModified[]", "0")
+	_ = writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/files/upload", &form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	res = httptest.NewRecorder()
+	h.UploadFile(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("multipart status = %d, body = %s", res.Code, res.Body.String())
+	}
+	if info, err := os.Stat(filepath.Join(h.config.StorageDir, "kept.txt")); err != nil || !info.ModTime().Equal(taken) {
+		t.Fatalf("multipart upload: err = %v, info = %v", err, info)
+	}
+	if info, err := os.Stat(filepath.Join(h.config.StorageDir, "now.txt")); err != nil || time.Since(info.ModTime()) > time.Minute {
+		t.Fatalf("upload without lastModified: err = %v, info = %v", err, info)
+	}
+}
+
+func TestReplaceOlderUploadKeepsFileWrittenAfterComparison(t *testing.T) {
+	dir := t.TempDir()
+	part, target := filepath.Join(dir, "upload.part"), filepath.Join(dir, "photo.jpg")
+	client := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	writeTestFile(t, part, "upload")
+	// The target was older when compared, then replaced by a newer file.
+	writeTestFile(t, target, "newer")
+	if err := os.Chtimes(target, client.Add(time.Hour), client.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := exchangePaths(part, part); errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("RENAME_EXCHANGE is not supported here")
+	}
+
+	skipped, err := replaceOlderUpload(part, target, client)
+	if err != nil || !skipped {
+		t.Fatalf("skipped = %v, err = %v", skipped, err)
+	}
+	if content, err := os.ReadFile(target); err != nil || string(content) != "newer" {
+		t.Fatalf("target = %q, err = %v", content, err)
+	}
+	if content, err := os.ReadFile(part); err != nil || string(content) != "upload" {
+		t.Fatalf("part = %q, err = %v", content, err)
+	}
+
+	if err := os.Chtimes(target, client.Add(-time.Hour), client.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if skipped, err := replaceOlderUpload(part, target, client); err != nil || skipped {
+		t.Fatalf("older target: skipped = %v, err = %v", skipped, err)
+	}
+	if content, err := os.ReadFile(target); err != nil || string(content) != "upload" {
+		t.Fatalf("replaced target = %q, err = %v", content, err)
+	}
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Fatalf("displaced file was kept: %v", err)
+	}
+}
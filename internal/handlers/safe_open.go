@@ -50,3 +50,24 @@ func (h *Handler) allowedRootForPath(path string) (string, string, error) {
 
 	return "", "", fmt.Errorf("path is not in an allowed directory: %s", path)
 }
+
+// allowedRoots returns each resolved allowed root once. A specific directory
+// inside STORAGE_DIR belongs to the storage root.
+func (h *Handler) allowedRoots() []string {
+	candidates := append([]string{h.config.StorageDir}, h.config.MountDirs...)
+	candidates = append(candidates, h.config.SpecificDirs...)
+	seen := make(map[string]struct{}, len(candidates))
+	roots := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		root, _, err := h.allowedRootForPath(candidate)
+		if err != nil {
+			continue
+		}
+		if _, ok := seen[root]; ok {
+			continue
+		}
+		seen[root] = struct{}{}
+		roots = append(roots, root)
+	}
+	return roots
+}
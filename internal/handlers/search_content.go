@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"puremania/internal/types"
+	"puremania/internal/utils"
+)
+
+const (
+	// maxContentSearchFileBytes matches the editor limit; larger files are
+	// skipped rather than read by a request-scoped grep.
+	maxContentSearchFileBytes = 10 * 1024 * 1024
+	// maxContentSearchScanBytes bounds what one page reads. A page that
+	// reaches it ends early, and the cursor resumes after the last file read.
+	maxContentSearchScanBytes = 256 * 1024 * 1024
+	maxContentMatchesPerFile  = 20
+	maxContentContextLines    = 5
+	maxContentSnippetBytes    = 512
+	// maxContentLineBytes ends the scan of a file at a longer line, which in
+	// practice is minified or generated data.
+	maxContentLineBytes = 1024 * 1024
+	// contentSniffBytes is how much of a file is checked for NUL bytes before
+	// it is treated as text, as git does.
+	contentSniffBytes = 8000
+)
+
+var errContentBudgetExhausted = errors.New("content search budget exhausted")
+
+type contentMatch struct {
+	Line   int      `json:"line"`
+	Text   string   `json:"text"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+type contentSearchResult struct {
+	types.FileInfo
+	Matches []contentMatch `json:"matches"`
+	// Truncated reports that the file has more than maxContentMatchesPerFile
+	// matching lines.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type contentSearchPage struct {
+	Data       []contentSearchResult `json:"data"`
+	NextCursor string                `json:"nextCursor,omitempty"`
+	HasMore    bool                  `json:"hasMore"`
+}
+
+// afterSearchCursor reports whether virtualPath comes after cursor in the
+// order filepath.WalkDir visits paths, which sorts each directory by name.
+func afterSearchCursor(virtualPath, cursor string) bool {
+	if cursor == "" {
+		return true
+	}
+	for i := 0; i < len(virtualPath) && i < len(cursor); i++ {
+		a, b := virtualPath[i], cursor[i]
+		if a == b {
+			continue
+		}
+		// A separator ends a name, so it sorts before any name byte.
+		if a == '/' {
+			return false
+		}
+		if b == '/' {
+			return true
+		}
+		return a > b
+	}
+	return len(virtualPath) > len(cursor)
+}
+
+// isContentSearchCandidate selects regular files the editor would open.
+func isContentSearchCandidate(entry fs.DirEntry) bool {
+	if !entry.Type().IsRegular() {
+		return false
+	}
+	return utils.IsTextFile(mediaTypeByPath(entry.Name())) || utils.IsEditableByExtension(entry.Name())
+}
+
+// searchContentPage greps text files under basePath for lines matching the
+// search term. Files are visited in walk order so the last file of a page is
+// the cursor of the next one.
+func (h *Handler) searchContentPage(ctx context.Context, req searchRequest, basePath string, observe *searchObserver) (contentSearchPage, error) {
+	match, err := buildSearchMatcher(req)
+	if err != nil {
+		return contentSearchPage{}, err
+	}
+	filter, err := buildSearchFilter(req)
+	if err != nil {
+		return contentSearchPage{}, err
+	}
+	results := make([]contentSearchResult, 0, req.Limit+1)
+	var scanned int64
+	lastScanned := ""
+	err = filepath.WalkDir(basePath, func(filePath string, entry os.DirEntry, walkErr error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if walkErr != nil {
+			h.logger.Warn("Skipping path in content search", "path", filePath, "error", walkErr)
+			return nil
+		}
+		if filePath == basePath {
+			observe.scannedDirectory()
+			return nil
+		}
+		if isInternalName(entry.Name()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		virtualPath := h.convertToVirtualPath(filePath)
+		if entry.IsDir() {
+			if req.Scope != "recursive" {
+				return filepath.SkipDir
+			}
+			if !afterSearchCursor(virtualPath, req.Cursor) && !strings.HasPrefix(req.Cursor, virtualPath+"/") {
+				return filepath.SkipDir
+			}
+			observe.scannedDirectory()
+			return nil
+		}
+		if !afterSearchCursor(virtualPath, req.Cursor) || !isContentSearchCandidate(entry) || !filter.matchEntry(entry) {
+			return nil
+		}
+		if scanned >= maxContentSearchScanBytes {
+			return errContentBudgetExhausted
+		}
+		matches, truncated, read, err := h.grepFile(ctx, filePath, match, req.Context)
+		if err != nil {
+			return err
+		}
+		scanned += read
+		lastScanned = virtualPath
+		if len(matches) == 0 {
+			return nil
+		}
+		results = append(results, contentSearchResult{FileInfo: h.searchFileInfo(filePath, entry), Matches: matches, Truncated: truncated})
+		if len(results) > req.Limit {
+			return filepath.SkipAll
+		}
+		observe.foundResult(results[len(results)-1])
+		return nil
+	})
+	if errors.Is(err, errContentBudgetExhausted) {
+		return contentSearchPage{Data: results, NextCursor: lastScanned, HasMore: true}, nil
+	}
+	if err != nil {
+		return contentSearchPage{}, err
+	}
+	page := contentSearchPage{Data: results}
+	if len(results) > req.Limit {
+		page.Data = results[:req.Limit]
+		page.NextCursor = page.Data[len(page.Data)-1].Path
+		page.HasMore = true
+	}
+	return page, nil
+}
+
+// grepFile returns the matching lines of one file and how many bytes the
+// file holds. Binary, oversized, and unreadable files yield no matches.
+func (h *Handler) grepFile(ctx context.Context, path string, match func(string) bool, contextLines int) ([]contentMatch, bool, int64, error) {
+	file, err := h.openAllowedPath(path, os.O_RDONLY, 0)
+	if err != nil {
+		h.logger.Debug("Skipping unreadable file in content search", "path", path, "error", err)
+		return nil, false, 0, nil
+	}
+	defer func() { _ = file.Close() }()
+	stat, err := file.Stat()
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() > maxContentSearchFileBytes {
+		return nil, false, 0, nil
+	}
+	reader := bufio.NewReaderSize(file, 64*1024)
+	if head, _ := reader.Peek(contentSniffBytes); bytes.IndexByte(head, 0) >= 0 {
+		return nil, false, int64(len(head)), nil
+	}
+	matches, truncated, err := scanContentLines(ctx, reader, match, contextLines)
+	return matches, truncated, stat.Size(), err
+}
+
+// scanContentLines collects up to maxContentMatchesPerFile matching lines
+// with contextLines of surrounding text on each side.
+func scanContentLines(ctx context.Context, r io.Reader, match func(string) bool, contextLines int) ([]contentMatch, bool, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxContentLineBytes)
+	var matches []contentMatch
+	var before []string
+	open := 0 // trailing matches still collecting After lines
+	truncated := false
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if lineNumber%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, false, err
+			}
+		}
+		line := scanner.Text()
+		snippet := ""
+		if open > 0 || contextLines > 0 {
+			snippet = contentSnippet(line)
+		}
+		for i := len(matches) - open; i < len(matches); i++ {
+			matches[i].After = append(matches[i].After, snippet)
+			if len(matches[i].After) == contextLines {
+				open--
+			}
+		}
+		if match(line) {
+			if len(matches) == maxContentMatchesPerFile {
+				truncated = true
+			} else {
+				matches = append(matches, contentMatch{Line: lineNumber, Text: contentSnippet(line), Before: append([]string(nil), before...)})
+				if contextLines > 0 {
+					open++
+				}
+			}
+		}
+		if truncated && open == 0 {
+			break
+		}
+		if contextLines > 0 {
+			if len(before) == contextLines {
+				before = before[1:]
+			}
+			before = append(before, snippet)
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, bufio.ErrTooLong) {
+		return nil, false, err
+	}
+	return matches, truncated, nil
+}
+
+// contentSnippet shortens a line for the response without splitting a
+// UTF-8 sequence.
+func contentSnippet(line string) string {
+	if len(line) <= maxContentSnippetBytes {
+		return line
+	}
+	return strings.ToValidUTF8(line[:maxContentSnippetBytes], "")
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func searchContent(t *testing.T, h *Handler, req searchRequest) contentSearchPage {
+	t.Helper()
+	req.Mode = "content"
+	body, _ := json.Marshal(req)
+	res := httptest.NewRecorder()
+	h.SearchFiles(res, httptest.NewRequest(http.MethodPost, "/api/search", strings.NewReader(string(body))))
+	if res.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", res.Code, res.Body.String())
+	}
+	var response struct {
+		Data contentSearchPage `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Data
+}
+
+func TestScanContentLinesReportsLineNumbersAndContext(t *testing.T) {
+	input := "alpha\nneedle one\nbeta\ngamma\nNEEDLE two\n"
+	match, _ := buildSearchMatcher(searchRequest{Term: "needle"})
+	matches, truncated, err := scanContentLines(context.Background(), strings.NewReader(input), match, 1)
+	if err != nil || truncated {
+		t.Fatalf("err = %v, truncated = %v", err, truncated)
+	}
+	want := []contentMatch{
+		{Line: 2, Text: "needle one", Before: []string{"alpha"}, After: []string{"beta"}},
+		{Line: 5, Text: "NEEDLE two", Before: []string{"gamma"}},
+	}
+	if !reflect.DeepEqual(matches, want) {
+		t.Fatalf("matches = %#v", matches)
+	}
+}
+
+func TestScanContentLinesCapsMatchesPerFile(t *testing.T) {
+	input := strings.Repeat("hit\n", maxContentMatchesPerFile+5)
+	match, _ := buildSearchMatcher(searchRequest{Term: "hit"})
+	matches, truncated, err := scanContentLines(context.Background(), strings.NewReader(input), match, 0)
+	if err != nil || !truncated || len(matches) != maxContentMatchesPerFile {
+		t.Fatalf("err = %v, truncated = %v, matches = %d", err, truncated, len(matches))
+	}
+}
+
+func TestContentSnippetKeepsValidUTF8(t *testing.T) {
+	snippet := contentSnippet(strings.Repeat("あ", maxContentSnippetBytes))
+	if len(snippet) > maxContentSnippetBytes || !strings.HasSuffix(snippet, "あ") {
+		t.Fatalf("snippet length %d ends with %q", len(snippet), snippet[len(snippet)-3:])
+	}
+}
+
+func TestAfterSearchCursorFollowsWalkOrder(t *testing.T) {
+	// WalkDir visits /a/b/z.txt before /a/b-c.txt although "-" sorts before "/".
+	if !afterSearchCursor("/a/b-c.txt", "/a/b/z.txt") || afterSearchCursor("/a/b/z.txt", "/a/b-c.txt") {
+		t.Fatal("cursor comparison does not follow walk order")
+	}
+	if !afterSearchCursor("/a/b", "/a") || afterSearchCursor("/a", "/a") {
+		t.Fatal("descendants must follow their directory")
+	}
+}
+
+func TestContentSearchSkipsBinaryAndNonTextFiles(t *testing.T) {
+	h := newContentTestHandler(t)
+	root := h.config.StorageDir
+	if err := os.MkdirAll(filepath.Join(root, "conf"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(root, "conf", "app.yaml"), "port: 80\ntoken: secret\n")
+	writeTestFile(t, filepath.Join(root, "notes.txt"), "secret plans\n")
+	writeTestFile(t, filepath.Join(root, "dump.txt"), "secret\x00binary")
+	writeTestFile(t, filepath.Join(root, "image.bin"), "secret")
+
+	page := searchContent(t, h, searchRequest{Term: "SECRET", Scope: "recursive", Context: 1})
+	if len(page.Data) != 2 || page.HasMore {
+		t.Fatalf("unexpected page: %#v", page)
+	}
+	if got := page.Data[0]; got.Path != "/conf/app.yaml" || !reflect.DeepEqual(got.Matches, []contentMatch{{Line: 2, Text: "token: secret", Before: []string{"port: 80"}}}) {
+		t.Fatalf("first result = %#v", got)
+	}
+	if page.Data[1].Path != "/notes.txt" {
+		t.Fatalf("second result = %#v", page.Data[1])
+	}
+
+	current := searchContent(t, h, searchRequest{Term: "secret"})
+	if len(current.Data) != 1 || current.Data[0].Path != "/notes.txt" {
+		t.Fatalf("current-folder search descended: %#v", current)
+	}
+}
+
+func TestContentSearchPaginatesByCursor(t *testing.T) {
+	h := newContentTestHandler(t)
+	for _, name := range []string{"a.log", "b.log", "c.log"} {
+		writeTestFile(t, filepath.Join(h.config.StorageDir, name), "error: "+name+"\n")
+	}
+	var paths []string
+	req := searchRequest{Term: "error", Limit: 2}
+	for {
+		page := searchContent(t, h, req)
+		for _, result := range page.Data {
+			paths = append(paths, result.Path)
+		}
+		if !page.HasMore {
+			break
+		}
+		req.Cursor = page.NextCursor
+	}
+	if strings.Join(paths, ",") != "/a.log,/b.log,/c.log" {
+		t.Fatalf("paged results = %v", paths)
+	}
+}
+
+func TestContentSearchStopsWhenCancelled(t *testing.T) {
+	h := newContentTestHandler(t)
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "a.txt"), "match\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := h.searchContentPage(ctx, searchRequest{Term: "match", Limit: 10}, h.config.StorageDir, nil); err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
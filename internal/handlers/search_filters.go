@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"puremania/internal/utils"
+)
+
+var searchKinds = map[string]bool{"image": true, "video": true, "audio": true, "text": true, "archive": true, "dir": true}
+
+var archiveExtensions = map[string]bool{
+	".zip": true, ".tar": true, ".gz": true, ".tgz": true, ".bz2": true, ".tbz2": true,
+	".xz": true, ".txz": true, ".zst": true, ".7z": true, ".rar": true, ".lz4": true,
+}
+
+// searchFilter narrows search results by metadata. The zero value accepts
+// everything.
+type searchFilter struct {
+	minSize, maxSize int64 // maxSize < 0 means unbounded
+	after, before    time.Time
+	kind             string
+	extensions       map[string]bool // lower case, with the leading dot
+	dirsOnly         bool
+	filesOnly        bool
+}
+
+func (f searchFilter) active() bool {
+	return f.minSize > 0 || f.maxSize >= 0 || !f.after.IsZero() || !f.before.IsZero() ||
+		f.kind != "" || len(f.extensions) > 0 || f.dirsOnly || f.filesOnly
+}
+
+// needsInfo reports whether matching needs more than the name and type, which
+// costs a stat per entry.
+func (f searchFilter) needsInfo() bool {
+	return f.minSize > 0 || f.maxSize >= 0 || !f.after.IsZero() || !f.before.IsZero()
+}
+
+// matchName applies the filters that need only the name and type.
+func (f searchFilter) matchName(name string, isDir bool) bool {
+	if (f.dirsOnly || f.kind == "dir") && !isDir {
+		return false
+	}
+	if f.filesOnly && isDir {
+		return false
+	}
+	if len(f.extensions) > 0 && (isDir || !f.extensions[strings.ToLower(filepath.Ext(name))]) {
+		return false
+	}
+	switch f.kind {
+	case "", "dir":
+		return true
+	case "text":
+		return !isDir && (utils.IsTextFile(mediaTypeByPath(name)) || utils.IsEditableByExtension(name))
+	case "archive":
+		return !isDir && archiveExtensions[strings.ToLower(filepath.Ext(name))]
+	default:
+		return !isDir && strings.HasPrefix(mediaTypeByPath(name), f.kind+"/")
+	}
+}
+
+func (f searchFilter) matchInfo(info fs.FileInfo) bool {
+	if info.Size() < f.minSize || (f.maxSize >= 0 && info.Size() > f.maxSize) {
+		return false
+	}
+	if !f.after.IsZero() && info.ModTime().Before(f.after) {
+		return false
+	}
+	return f.before.IsZero() || info.ModTime().Before(f.before)
+}
+
+func (f searchFilter) matchEntry(entry fs.DirEntry) bool {
+	if !f.matchName(entry.Name(), entry.IsDir()) {
+		return false
+	}
+	if !f.needsInfo() {
+		return true
+	}
+	info, err := entry.Info()
+	return err == nil && f.matchInfo(info)
+}
+
+// buildSearchFilter validates the filter fields of a search request.
+func buildSearchFilter(req searchRequest) (searchFilter, error) {
+	filter := searchFilter{maxSize: -1, kind: strings.ToLower(req.Kind), dirsOnly: req.DirsOnly, filesOnly: req.FilesOnly}
+	if req.MinSize != nil {
+		filter.minSize = *req.MinSize
+	}
+	if req.MaxSize != nil {
+		filter.maxSize = *req.MaxSize
+	}
+	if filter.minSize < 0 || (req.MaxSize != nil && filter.maxSize < filter.minSize) {
+		return searchFilter{}, fmt.Errorf("invalid size range")
+	}
+	var err error
+	if filter.after, err = parseSearchTime(req.ModifiedAfter); err != nil {
+		return searchFilter{}, fmt.Errorf("invalid modifiedAfter: %w", err)
+	}
+	if filter.before, err = parseSearchTime(req.ModifiedBefore); err != nil {
+		return searchFilter{}, fmt.Errorf("invalid modifiedBefore: %w", err)
+	}
+	if filter.kind != "" && !searchKinds[filter.kind] {
+		return searchFilter{}, fmt.Errorf("unknown kind %q", req.Kind)
+	}
+	if filter.dirsOnly && filter.filesOnly {
+		return searchFilter{}, fmt.Errorf("dirsOnly and filesOnly exclude each other")
+	}
+	for _, ext := range req.Extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if filter.extensions == nil {
+			filter.extensions = make(map[string]bool)
+		}
+		filter.extensions[ext] = true
+	}
+	return filter, nil
+}
+
+// parseSearchTime accepts RFC 3339 timestamps and dates, which are read as
+// midnight in the server's time zone.
+func parseSearchTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// parseSearchSize reads a byte count with an optional binary unit suffix,
+// such as 512, 10K, 1.5G, or 2TiB.
+func parseSearchSize(value string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(value))
+	number = strings.TrimSuffix(strings.TrimSuffix(number, "B"), "I")
+	multiplier := 1.0
+	if n := len(number); n > 0 {
+		if exponent := strings.IndexByte("KMGTP", number[n-1]); exponent >= 0 {
+			multiplier = float64(int64(1) << (10 * (exponent + 1)))
+			number = number[:n-1]
+		}
+	}
+	// ParseFloat alone would also accept NaN, Inf, and hex floats such as
+	// 0x1p40, which are not sizes.
+	if strings.Trim(number, "0123456789.") != "" {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size < 0 || size*multiplier >= 1<<63 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(size * multiplier), nil
+}
+
+// applyQuery moves filter tokens such as size:>1G, type:video, ext:mkv,mp4,
+// modified:>2024-01-01, and is:dir out of the search term into the request.
+// Other tokens, including unknown key:value pairs and quoted text, stay in the
+// term. A term without filter tokens is left exactly as written.
+func (req *searchRequest) applyQuery() error {
+	tokens := splitSearchQuery(req.Term)
+	rest := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		key, value, found := strings.Cut(token.text, ":")
+		if token.quoted || !found || value == "" {
+			rest = append(rest, token.term(req.UseRegex))
+			continue
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "size":
+			err = req.applySizeToken(value)
+		case "type", "kind":
+			req.Kind = value
+		case "ext":
+			req.Extensions = append(req.Extensions, strings.Split(value, ",")...)
+		case "modified", "mtime":
+			err = req.applyTimeToken(value)
+		case "after":
+			req.ModifiedAfter = value
+		case "before":
+			req.ModifiedBefore = value
+		case "is":
+			switch strings.ToLower(value) {
+			case "dir":
+				req.DirsOnly = true
+			case "file":
+				req.FilesOnly = true
+			default:
+				err = fmt.Errorf("unknown is:%s", value)
+			}
+		default:
+			rest = append(rest, token.term(req.UseRegex))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(rest) < len(tokens) {
+		req.Term = strings.Join(rest, " ")
+	}
+	return nil
+}
+
+func (req *searchRequest) applySizeToken(value string) error {
+	if low, high, isRange := strings.Cut(value, ".."); isRange {
+		minSize, err := parseSearchSize(low)
+		if err != nil {
+			return err
+		}
+		maxSize, err := parseSearchSize(high)
+		if err != nil {
+			return err
+		}
+		req.MinSize, req.MaxSize = &minSize, &maxSize
+		return nil
+	}
+	operator := value[:len(value)-len(strings.TrimLeft(value, "<>="))]
+	size, err := parseSearchSize(value[len(operator):])
+	if err != nil {
+		return err
+	}
+	switch operator {
+	case ">":
+		size++
+		req.MinSize = &size
+	case ">=":
+		req.MinSize = &size
+	case "<":
+		if size == 0 {
+			return fmt.Errorf("invalid size %q", value)
+		}
+		size--
+		req.MaxSize = &size
+	case "<=":
+		req.MaxSize = &size
+	case "=", "":
+		req.MinSize, req.MaxSize = &size, &size
+	default:
+		return fmt.Errorf("invalid size %q", value)
+	}
+	return nil
+}
+
+// applyTimeToken reads modified:<op><date>. The filter's after bound is
+// inclusive and its before bound exclusive, and a date covers the whole day:
+// > starts at the next day and <= ends at the next day's midnight.
+func (req *searchRequest) applyTimeToken(value string) error {
+	operator := value[:len(value)-len(strings.TrimLeft(value, "<>="))]
+	date := value[len(operator):]
+	start, err := parseSearchTime(date)
+	if err != nil || date == "" {
+		return fmt.Errorf("invalid date %q", value)
+	}
+	end := start.AddDate(0, 0, 1)
+	if _, err := time.Parse(time.RFC3339, date); err == nil {
+		// A timestamp is a single instant rather than a day.
+		end = start.Add(time.Nanosecond)
+	}
+	switch operator {
+	case ">":
+		req.ModifiedAfter = end.Format(time.RFC3339Nano)
+	case ">=":
+		req.ModifiedAfter = start.Format(time.RFC3339Nano)
+	case "<":
+		req.ModifiedBefore = start.Format(time.RFC3339Nano)
+	case "<=":
+		req.ModifiedBefore = end.Format(time.RFC3339Nano)
+	case "=", "":
+		req.ModifiedAfter = start.Format(time.RFC3339Nano)
+		req.ModifiedBefore = end.Format(time.RFC3339Nano)
+	default:
+		return fmt.Errorf("invalid date %q", value)
+	}
+	return nil
+}
+
+type queryToken struct {
+	text   string // without quotes
+	raw    string // as written
+	quoted bool
+}
+
+// term returns the token as search text. Regular expressions keep their
+// quotes, which may be part of the pattern.
+func (t queryToken) term(regex bool) string {
+	if regex {
+		return t.raw
+	}
+	return t.text
+}
+
+// splitSearchQuery splits on whitespace outside double quotes.
+func splitSearchQuery(query string) []queryToken {
+	var tokens []queryToken
+	var text strings.Builder
+	start := -1
+	quoted, inQuotes := false, false
+	for i, r := range query {
+		if start < 0 {
+			if r == ' ' || r == '\t' || r == '\n' {
+				continue
+			}
+			start = i
+		}
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			quoted = true
+		case !inQuotes && (r == ' ' || r == '\t' || r == '\n'):
+			tokens = append(tokens, queryToken{text: text.String(), raw: query[start:i], quoted: quoted})
+			text.Reset()
+			start, quoted = -1, false
+		default:
+			text.WriteRune(r)
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, queryToken{text: text.String(), raw: query[start:], quoted: quoted})
+	}
+	return tokens
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyQueryExtractsFilterTokens(t *testing.T) {
+	req := searchRequest{Term: `size:>1G type:video ext:mkv,MP4 "size:big" holiday is:file`}
+	if err := req.applyQuery(); err != nil {
+		t.Fatal(err)
+	}
+	if req.Term != "size:big holiday" {
+		t.Fatalf("remaining term = %q", req.Term)
+	}
+	if req.MinSize == nil || *req.MinSize != 1<<30+1 || req.MaxSize != nil {
+		t.Fatalf("size bounds = %v, %v", req.MinSize, req.MaxSize)
+	}
+	if req.Kind != "video" || strings.Join(req.Extensions, ",") != "mkv,MP4" || !req.FilesOnly {
+		t.Fatalf("unexpected filters: %+v", req)
+	}
+}
+
+func TestApplyQueryLeavesPlainTermsUntouched(t *testing.T) {
+	for _, term := range []string{`a  "b"`, `^\d{2}:x$`, "http://example.com"} {
+		req := searchRequest{Term: term, UseRegex: true}
+		if err := req.applyQuery(); err != nil || req.Term != term {
+			t.Fatalf("term %q became %q (err %v)", term, req.Term, err)
+		}
+	}
+}
+
+func TestApplyQueryRejectsMalformedTokens(t *testing.T) {
+	for _, term := range []string{"size:>lots", "size:<0", "modified:yesterday", "is:link"} {
+		req := searchRequest{Term: term}
+		if err := req.applyQuery(); err == nil {
+			t.Errorf("%q was accepted", term)
+		}
+	}
+}
+
+type modTimeInfo struct {
+	fs.FileInfo
+	modTime time.Time
+}
+
+func (i modTimeInfo) Size() int64        { return 0 }
+func (i modTimeInfo) ModTime() time.Time { return i.modTime }
+
+func TestApplyQueryDateOperatorsCoverWholeDays(t *testing.T) {
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local) }
+	times := []time.Time{at(0, 23, 59), at(1, 0, 0), at(1, 12, 0), at(2, 0, 0)}
+	cases := []struct {
+		token string
+		want  string // which of times match
+	}{
+		{"modified:>2024-01-01", "0001"},
+		{"modified:>=2024-01-01", "0111"},
+		{"modified:<2024-01-01", "1000"},
+		{"modified:<=2024-01-01", "1110"},
+		{"modified:2024-01-01", "0110"},
+		{"modified:=2024-01-01", "0110"},
+		{"modified:>" + at(1, 12, 0).Format(time.RFC3339), "0001"},
+		{"modified:<=" + at(1, 12, 0).Format(time.RFC3339), "1110"},
+	}
+	for _, tc := range cases {
+		req := searchRequest{Term: tc.token}
+		if err := req.applyQuery(); err != nil {
+			t.Fatalf("%s: %v", tc.token, err)
+		}
+		filter, err := buildSearchFilter(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.token, err)
+		}
+		var got strings.Builder
+		for _, modified := range times {
+			if filter.matchInfo(modTimeInfo{modTime: modified}) {
+				got.WriteByte('1')
+			} else {
+				got.WriteByte('0')
+			}
+		}
+		if got.String() != tc.want {
+			t.Errorf("%s matched %s, want %s", tc.token, got.String(), tc.want)
+		}
+	}
+}
+
+func TestParseSearchSizeUnits(t *testing.T) {
+	cases := map[string]int64{"512": 512, "10K": 10 << 10, "1.5G": 3 << 29, "2TiB": 2 << 40, "3mb": 3 << 20}
+	for input, want := range cases {
+		if got, err := parseSearchSize(input); err != nil || got != want {
+			t.Errorf("parseSearchSize(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"NaN", "nanK", "Inf", "+InfG", "0x1p4", "0x10", "1e3", "-1", "1.2.3", ".", "", "9000P"} {
+		if got, err := parseSearchSize(input); err == nil {
+			t.Errorf("parseSearchSize(%q) = %d, want an error", input, got)
+		}
+	}
+}
+
+func TestBuildSearchFilterValidatesFields(t *testing.T) {
+	small, large := int64(10), int64(5)
+	for _, req := range []searchRequest{
+		{Kind: "spreadsheet"},
+		{MinSize: &small, MaxSize: &large},
+		{ModifiedAfter: "last week"},
+		{DirsOnly: true, FilesOnly: true},
+	} {
+		if _, err := buildSearchFilter(req); err == nil {
+			t.Errorf("%+v was accepted", req)
+		}
+	}
+}
+
+func TestSearchFiltersBySizeKindAndDate(t *testing.T) {
+	h := newContentTestHandler(t)
+	root := h.config.StorageDir
+	if err := os.MkdirAll(filepath.Join(root, "clips"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(root, "clips", "big.mkv"), strings.Repeat("x", 4096))
+	writeTestFile(t, filepath.Join(root, "clips", "small.mkv"), "x")
+	writeTestFile(t, filepath.Join(root, "clips", "notes.txt"), strings.Repeat("x", 4096))
+	writeTestFile(t, filepath.Join(root, "old.mp4"), strings.Repeat("x", 4096))
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	if err := os.Chtimes(filepath.Join(root, "old.mp4"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(req searchRequest) string {
+		t.Helper()
+		body, _ := json.Marshal(req)
+		res := httptest.NewRecorder()
+		h.SearchFiles(res, httptest.NewRequest(http.MethodPost, "/api/search", strings.NewReader(string(body))))
+		if res.Code != http.StatusOK {
+			t.Fatalf("%+v: status = %d: %s", req, res.Code, res.Body.String())
+		}
+		var response struct {
+			Data searchPage `json:"data"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return resultPaths(response.Data)
+	}
+
+	cases := []struct {
+		req  searchRequest
+		want string
+	}{
+		{searchRequest{Term: "type:video size:>1K", Scope: "recursive"}, "/clips/big.mkv,/old.mp4"},
+		{searchRequest{Term: "type:video modified:>2021-01-01", Scope: "recursive"}, "/clips/big.mkv,/clips/small.mkv"},
+		{searchRequest{Term: "before:2021-01-01", Scope: "recursive"}, "/old.mp4"},
+		{searchRequest{Term: "big", Scope: "recursive", Extensions: []string{".mkv"}}, "/clips/big.mkv"},
+		{searchRequest{Term: "is:dir"}, "/clips"},
+		{searchRequest{Term: "type:text", Scope: "recursive"}, "/clips/notes.txt"},
+	}
+	for _, tc := range cases {
+		if got := search(tc.req); got != tc.want {
+			t.Errorf("%+v: got %q, want %q", tc.req, got, tc.want)
+		}
+	}
+}
+
+func TestSearchIndexAppliesFilters(t *testing.T) {
+	h := newIndexedSearchHandler(t)
+	req := searchRequest{Term: "type:audio ext:flac", Path: "/"}
+	if err := req.applyQuery(); err != nil {
+		t.Fatal(err)
+	}
+	if got := resultPaths(indexedSearch(t, h, req)); got != "/music/jazz/track02.flac" {
+		t.Fatalf("results = %q", got)
+	}
+}
+
+func TestSearchRequiresTermOrFilter(t *testing.T) {
+	h := newContentTestHandler(t)
+	for _, body := range []string{`{"term":""}`, `{"term":"is:file","mode":"content"}`} {
+		res := httptest.NewRecorder()
+		h.SearchFiles(res, httptest.NewRequest(http.MethodPost, "/api/search", strings.NewReader(body)))
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", body, res.Code)
+		}
+	}
+}
@@ -3,7 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -19,9 +19,22 @@ type searchRequest struct {
 	Path          string `json:"path"`
 	Scope         string `json:"scope"`
 	UseRegex      bool   `json:"useRegex"`
+	Match         string `json:"match"`   // "substring" (default) or "prefix"
+	Mode          string `json:"mode"`    // "name" (default) or "content"
+	Context       int    `json:"context"` // lines around each content match
 	CaseSensitive bool   `json:"caseSensitive"`
 	Cursor        string `json:"cursor"`
 	Limit         int    `json:"limit"`
+
+	// Filters; applyQuery also fills them from tokens in Term.
+	MinSize        *int64   `json:"minSize"`
+	MaxSize        *int64   `json:"maxSize"`
+	ModifiedAfter  string   `json:"modifiedAfter"`
+	ModifiedBefore string   `json:"modifiedBefore"`
+	Kind           string   `json:"kind"` // image, video, audio, text, archive, or dir
+	Extensions     []string `json:"extensions"`
+	DirsOnly       bool     `json:"dirsOnly"`
+	FilesOnly      bool     `json:"filesOnly"`
 }
 
 type searchPage struct {
@@ -32,25 +45,86 @@ type searchPage struct {
 
 // SearchFiles - 並列処理と細かいキャッシュキー使用
 func (h *Handler) SearchFiles(w http.ResponseWriter, r *http.Request) {
+	req, basePath, ok := h.decodeSearchRequest(w, r)
+	if !ok {
+		return
+	}
+	// Indexed searches take milliseconds and do not compete for the gate
+	// that bounds filesystem walks.
+	if req.Scope == "recursive" && req.Mode != "content" {
+		page, indexed, err := h.searchIndexPage(r.Context(), req, basePath)
+		if indexed {
+			if err == nil {
+				h.respondSuccess(w, page)
+			} else if err != context.Canceled && err != context.DeadlineExceeded {
+				h.logger.Error("Search failed", "error", err)
+				h.respondError(w, "Search failed", http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+	if !tryAcquire(h.searchGate) {
+		respondBusy(w)
+		return
+	}
+	defer release(h.searchGate)
+
+	results, searchErr := h.runSearch(r.Context(), req, basePath, nil)
+	if searchErr == nil {
+		h.respondSuccess(w, results)
+	} else {
+		if searchErr == context.Canceled || searchErr == context.DeadlineExceeded {
+			return
+		}
+		h.logger.Error("Search failed", "error", searchErr)
+		h.respondError(w, "Search failed", http.StatusInternalServerError)
+	}
+}
+
+// decodeSearchRequest reads and validates a search request, answering the
+// client itself when it is invalid.
+func (h *Handler) decodeSearchRequest(w http.ResponseWriter, r *http.Request) (searchRequest, string, bool) {
 	var req searchRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.logger.Error("Failed to decode search request", "error", err)
 		h.respondError(w, "Invalid JSON", http.StatusBadRequest)
-		return
+		return req, "", false
 	}
 
-	if req.Term == "" {
-		h.respondError(w, "Search term required", http.StatusBadRequest)
-		return
-	}
-	if len(req.Term) > maxSearchTermBytes || len(req.Cursor) > maxVirtualPathBytes {
+	if len(req.Term) > maxSearchTermBytes || len(req.Cursor) > maxVirtualPathBytes || len(req.Extensions) > maxBatchPaths {
 		h.respondError(w, "Search term or cursor is too long", http.StatusBadRequest)
-		return
+		return req, "", false
+	}
+	if err := req.applyQuery(); err != nil {
+		h.respondError(w, "Invalid search query: "+err.Error(), http.StatusBadRequest)
+		return req, "", false
+	}
+	filter, err := buildSearchFilter(req)
+	if err != nil {
+		h.respondError(w, "Invalid search filter: "+err.Error(), http.StatusBadRequest)
+		return req, "", false
+	}
+	// A filter alone lists everything it selects; content search needs text.
+	if req.Term == "" && (req.Mode == "content" || !filter.active()) {
+		h.respondError(w, "Search term required", http.StatusBadRequest)
+		return req, "", false
 	}
 	if req.Scope != "" && req.Scope != "current" && req.Scope != "recursive" {
 		h.respondError(w, "Invalid search scope", http.StatusBadRequest)
-		return
+		return req, "", false
+	}
+	if req.Match != "" && req.Match != "substring" && req.Match != "prefix" {
+		h.respondError(w, "Invalid match mode", http.StatusBadRequest)
+		return req, "", false
+	}
+	if req.Mode != "" && req.Mode != "name" && req.Mode != "content" {
+		h.respondError(w, "Invalid search mode", http.StatusBadRequest)
+		return req, "", false
+	}
+	if req.Context < 0 || req.Context > maxContentContextLines {
+		h.respondError(w, "Invalid context line count", http.StatusBadRequest)
+		return req, "", false
 	}
 
 	if req.Limit <= 0 || req.Limit > 500 {
@@ -63,7 +137,7 @@ func (h *Handler) SearchFiles(w http.ResponseWriter, r *http.Request) {
 		}
 		if _, err := regexp.Compile(pattern); err != nil {
 			h.respondError(w, "Invalid regular expression", http.StatusBadRequest)
-			return
+			return req, "", false
 		}
 	}
 
@@ -71,41 +145,52 @@ func (h *Handler) SearchFiles(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		h.logger.Error("Invalid path for search", "path", req.Path, "error", err)
 		h.respondError(w, "Invalid path", http.StatusBadRequest)
-		return
+		return req, "", false
 	}
 	if !contextStillActive(r.Context()) {
-		return
+		return req, "", false
 	}
-	if !tryAcquire(h.searchGate) {
-		respondBusy(w)
-		return
-	}
-	defer release(h.searchGate)
+	return req, basePath, true
+}
 
-	results, searchErr := h.performSearchPage(r.Context(), req, basePath)
-	if searchErr == nil {
-		h.respondSuccess(w, results)
-	} else {
-		if searchErr == context.Canceled || searchErr == context.DeadlineExceeded {
-			return
-		}
-		h.logger.Error("Search failed", "error", searchErr)
-		h.respondError(w, "Search failed", http.StatusInternalServerError)
+// runSearch produces one page of name or content results.
+func (h *Handler) runSearch(ctx context.Context, req searchRequest, basePath string, observe *searchObserver) (interface{}, error) {
+	if req.Mode == "content" {
+		return h.searchContentPage(ctx, req, basePath, observe)
 	}
+	return h.performSearchPage(ctx, req, basePath, observe)
 }
 
-func (h *Handler) performSearchPage(ctx context.Context, req searchRequest, basePath string) (searchPage, error) {
-	match, err := buildSearchMatcher(req)
+func (h *Handler) performSearchPage(ctx context.Context, req searchRequest, basePath string, observe *searchObserver) (searchPage, error) {
+	matchName, err := buildSearchMatcher(req)
 	if err != nil {
 		return searchPage{}, err
 	}
+	filter, err := buildSearchFilter(req)
+	if err != nil {
+		return searchPage{}, err
+	}
+	match := func(entry os.DirEntry) bool { return matchName(entry.Name()) && filter.matchEntry(entry) }
 	if req.Scope == "recursive" {
-		return h.searchRecursivePage(ctx, basePath, req.Cursor, req.Limit, match)
+		return h.searchRecursivePage(ctx, basePath, req.Cursor, req.Limit, match, observe)
 	}
-	return h.searchCurrentPage(ctx, basePath, req.Cursor, req.Limit, match)
+	return h.searchCurrentPage(ctx, basePath, req.Cursor, req.Limit, match, observe)
 }
 
 func buildSearchMatcher(req searchRequest) (func(string) bool, error) {
+	match, err := buildNameMatcher(req)
+	if err != nil {
+		return nil, err
+	}
+	if req.UseRegex || req.CaseSensitive {
+		return func(name string) bool { return match(name, name) }, nil
+	}
+	return func(name string) bool { return match(name, strings.ToLower(name)) }, nil
+}
+
+// buildNameMatcher returns a matcher that takes a name and its lower-case
+// form, which the search index keeps precomputed.
+func buildNameMatcher(req searchRequest) (func(name, lower string) bool, error) {
 	if req.UseRegex {
 		pattern := req.Term
 		if !req.CaseSensitive {
@@ -115,18 +200,17 @@ func buildSearchMatcher(req searchRequest) (func(string) bool, error) {
 		if err != nil {
 			return nil, err
 		}
-		return re.MatchString, nil
+		return func(name, _ string) bool { return re.MatchString(name) }, nil
 	}
-	term := req.Term
-	if !req.CaseSensitive {
-		term = strings.ToLower(term)
+	test := strings.Contains
+	if req.Match == "prefix" {
+		test = strings.HasPrefix
 	}
-	return func(name string) bool {
-		if req.CaseSensitive {
-			return strings.Contains(name, term)
-		}
-		return strings.Contains(strings.ToLower(name), term)
-	}, nil
+	if req.CaseSensitive {
+		return func(name, _ string) bool { return test(name, req.Term) }, nil
+	}
+	term := strings.ToLower(req.Term)
+	return func(_, lower string) bool { return test(lower, term) }, nil
 }
 
 func (h *Handler) searchFileInfo(path string, entry os.DirEntry) types.FileInfo {
@@ -156,30 +240,35 @@ func finishSearchPage(results []types.FileInfo, limit int) searchPage {
 	return searchPage{Data: results, NextCursor: nextCursor, HasMore: hasMore}
 }
 
-func (h *Handler) searchCurrentPage(ctx context.Context, path, cursor string, limit int, match func(string) bool) (searchPage, error) {
+func (h *Handler) searchCurrentPage(ctx context.Context, path, cursor string, limit int, match func(os.DirEntry) bool, observe *searchObserver) (searchPage, error) {
 	entries, err := os.ReadDir(path)
 	if err != nil {
 		return searchPage{}, err
 	}
+	observe.scannedDirectory()
 	results := make([]types.FileInfo, 0, limit+1)
 	for _, entry := range entries {
 		if err := ctx.Err(); err != nil {
 			return searchPage{}, err
 		}
+		if isInternalName(entry.Name()) {
+			continue
+		}
 		fullPath := filepath.Join(path, entry.Name())
 		virtualPath := h.convertToVirtualPath(fullPath)
-		if virtualPath <= cursor || !match(entry.Name()) {
+		if virtualPath <= cursor || !match(entry) {
 			continue
 		}
 		results = append(results, h.searchFileInfo(fullPath, entry))
 		if len(results) > limit {
 			break
 		}
+		observe.foundResult(results[len(results)-1])
 	}
 	return finishSearchPage(results, limit), nil
 }
 
-func (h *Handler) searchRecursivePage(ctx context.Context, path, cursor string, limit int, match func(string) bool) (searchPage, error) {
+func (h *Handler) searchRecursivePage(ctx context.Context, path, cursor string, limit int, match func(os.DirEntry) bool, observe *searchObserver) (searchPage, error) {
 	results := make([]types.FileInfo, 0, limit+1)
 	err := filepath.WalkDir(path, func(filePath string, entry os.DirEntry, walkErr error) error {
 		if err := ctx.Err(); err != nil {
@@ -189,14 +278,24 @@ func (h *Handler) searchRecursivePage(ctx context.Context, path, cursor string,
 			h.logger.Warn("Skipping path in recursive search", "path", filePath, "error", walkErr)
 			return nil
 		}
+		if isInternalName(entry.Name()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			observe.scannedDirectory()
+		}
 		virtualPath := h.convertToVirtualPath(filePath)
-		if virtualPath <= cursor || !match(entry.Name()) {
+		if !afterSearchCursor(virtualPath, cursor) || !match(entry) {
 			return nil
 		}
 		results = append(results, h.searchFileInfo(filePath, entry))
 		if len(results) > limit {
 			return filepath.SkipAll
 		}
+		observe.foundResult(results[len(results)-1])
 		return nil
 	})
 	if err != nil {
@@ -204,3 +303,65 @@ func (h *Handler) searchRecursivePage(ctx context.Context, path, cursor string,
 	}
 	return finishSearchPage(results, limit), nil
 }
+
+// searchIndexPage answers a recursive search from the filename index. It
+// reports false when the index does not cover basePath yet, so the caller
+// walks the filesystem instead.
+func (h *Handler) searchIndexPage(ctx context.Context, req searchRequest, basePath string) (searchPage, bool, error) {
+	if h.searchIndex == nil {
+		return searchPage{}, false, nil
+	}
+	match, err := buildNameMatcher(req)
+	if err != nil {
+		return searchPage{}, false, err
+	}
+	filter, err := buildSearchFilter(req)
+	if err != nil {
+		return searchPage{}, false, err
+	}
+	var after []string
+	baseVirtual := h.cleanVirtualPath(basePath)
+	if relative := strings.TrimPrefix(req.Cursor, strings.TrimSuffix(baseVirtual, "/")+"/"); req.Cursor != "" && relative != req.Cursor {
+		after = strings.Split(relative, "/")
+	}
+	// Matching names are collected under the index lock and only stat'ed
+	// after it is released, so a slow disk never blocks index updates. Each
+	// batch resumes after the last candidate of the previous one.
+	results := make([]types.FileInfo, 0, req.Limit+1)
+	for {
+		candidates := make([]string, 0, req.Limit+1)
+		indexed, err := h.searchIndex.search(ctx, basePath, after, func(path string, node *indexNode) bool {
+			if !match(node.name, node.lower) || !filter.matchName(node.name, node.isDir) {
+				return true
+			}
+			candidates = append(candidates, path)
+			return len(candidates) < cap(candidates)
+		})
+		if !indexed || err != nil {
+			return searchPage{}, indexed, err
+		}
+		for _, path := range candidates {
+			info, err := os.Lstat(path)
+			if err != nil {
+				// Removed since it was indexed; the watcher will catch up.
+				continue
+			}
+			if filter.needsInfo() && !filter.matchInfo(info) {
+				continue
+			}
+			results = append(results, h.searchFileInfo(path, fs.FileInfoToDirEntry(info)))
+			if len(results) > req.Limit {
+				return finishSearchPage(results, req.Limit), true, nil
+			}
+		}
+		if len(candidates) < cap(candidates) {
+			break
+		}
+		relative, err := filepath.Rel(basePath, candidates[len(candidates)-1])
+		if err != nil {
+			return searchPage{}, true, err
+		}
+		after = strings.Split(relative, string(filepath.Separator))
+	}
+	return finishSearchPage(results, req.Limit), true, nil
+}
@@ -0,0 +1,604 @@
+package handlers
+
+// Filename index for recursive search. Each allowed root has an in-memory
+// tree of entry names that is saved to SEARCH_INDEX_DIR, loaded at startup so
+// searches work immediately, and rebuilt in the background to pick up changes
+// made while the server was down. Afterwards it is kept current from inotify
+// and from the server's own mutations; where watches are unavailable the root
+// is rescanned periodically instead.
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	searchIndexHeader         = "puremania-search-index 1\n"
+	searchIndexFlushInterval  = time.Second
+	searchIndexSaveInterval   = 5 * time.Minute
+	searchIndexRescanInterval = 30 * time.Minute
+	maxSearchIndexWatches     = 1 << 18
+	maxSearchIndexNameBytes   = 4096
+)
+
+// Record types of the on-disk format: a depth-first sequence of entries in
+// which every directory's children are terminated by indexRecordEnd.
+const (
+	indexRecordFile byte = iota
+	indexRecordDir
+	indexRecordEnd
+)
+
+type indexNode struct {
+	name     string
+	lower    string
+	isDir    bool
+	children []*indexNode // sorted by name, as os.ReadDir returns them
+}
+
+func newIndexNode(name string, isDir bool) *indexNode {
+	return &indexNode{name: name, lower: strings.ToLower(name), isDir: isDir}
+}
+
+func (n *indexNode) find(name string) (int, bool) {
+	i := sort.Search(len(n.children), func(i int) bool { return n.children[i].name >= name })
+	return i, i < len(n.children) && n.children[i].name == name
+}
+
+func (n *indexNode) put(child *indexNode) {
+	i, found := n.find(child.name)
+	if found {
+		n.children[i] = child
+		return
+	}
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = child
+}
+
+func (n *indexNode) remove(name string) {
+	if i, found := n.find(name); found {
+		n.children = append(n.children[:i], n.children[i+1:]...)
+	}
+}
+
+// isInternalName reports names Pure Mania uses for its own staging, trash,
+// and upload state, which are never listed or returned as search results.
+func isInternalName(name string) bool {
+	return strings.HasPrefix(name, ".puremania-")
+}
+
+type rootIndex struct {
+	root     string
+	mu       sync.RWMutex
+	tree     *indexNode // nil until loaded or built
+	modified bool       // changed since the last save
+	complete bool       // every directory has a watch, so no rescans are needed
+}
+
+// lookup returns the node for a path relative to the root.
+func (r *rootIndex) lookupLocked(relative string) *indexNode {
+	node := r.tree
+	if node == nil || relative == "." {
+		return node
+	}
+	for _, name := range strings.Split(filepath.ToSlash(relative), "/") {
+		i, found := node.find(name)
+		if !found || !node.children[i].isDir {
+			return nil
+		}
+		node = node.children[i]
+	}
+	return node
+}
+
+type searchIndex struct {
+	dir     string
+	logger  *slog.Logger
+	roots   []*rootIndex
+	backend watchBackend // nil where watching is unsupported
+
+	mu      sync.Mutex
+	watches map[int]string // watch descriptor -> directory
+	watched map[string]int // directory -> watch descriptor
+	pending map[string]struct{}
+}
+
+func newSearchIndex(dir string, roots []string, logger *slog.Logger) *searchIndex {
+	ix := &searchIndex{
+		dir:     dir,
+		logger:  logger,
+		watches: make(map[int]string),
+		watched: make(map[string]int),
+		pending: make(map[string]struct{}),
+	}
+	for _, root := range roots {
+		ix.roots = append(ix.roots, &rootIndex{root: root})
+	}
+	backend, err := newWatchBackend(watchNames)
+	if err != nil && !errors.Is(err, errWatchUnsupported) {
+		logger.Warn("Search index cannot watch for changes; rescanning periodically", "error", err)
+	}
+	if err == nil {
+		ix.backend = backend
+		go backend.run(ix.changed)
+	}
+	return ix
+}
+
+// start loads saved indexes, then rebuilds each root and keeps it current.
+func (ix *searchIndex) start() {
+	for _, root := range ix.roots {
+		if tree, err := ix.load(root.root); err == nil {
+			root.mu.Lock()
+			root.tree = tree
+			root.mu.Unlock()
+		} else if !os.IsNotExist(err) {
+			ix.logger.Warn("Ignoring unreadable search index", "root", root.root, "error", err)
+		}
+	}
+	for _, root := range ix.roots {
+		ix.rebuild(root)
+	}
+	ix.maintain()
+}
+
+func (ix *searchIndex) rebuild(root *rootIndex) {
+	started := time.Now()
+	complete := true
+	tree, err := ix.scan(root.root, filepath.Base(root.root), &complete)
+	if err != nil {
+		ix.logger.Warn("Search index rebuild failed", "root", root.root, "error", err)
+		return
+	}
+	root.mu.Lock()
+	root.tree = tree
+	root.complete = complete
+	root.modified = true
+	root.mu.Unlock()
+	ix.save(root)
+	ix.logger.Info("Search index rebuilt", "root", root.root, "duration", time.Since(started))
+}
+
+// scan reads a directory tree into nodes and watches every directory in it.
+// It skips internal names and other roots, which have their own index.
+func (ix *searchIndex) scan(path, name string, complete *bool) (*indexNode, error) {
+	node := newIndexNode(name, true)
+	if !ix.watch(path) {
+		*complete = false
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if isInternalName(entry.Name()) {
+			continue
+		}
+		childPath := filepath.Join(path, entry.Name())
+		if !entry.IsDir() {
+			node.children = append(node.children, newIndexNode(entry.Name(), false))
+			continue
+		}
+		if ix.isOtherRoot(childPath) {
+			continue
+		}
+		child, err := ix.scan(childPath, entry.Name(), complete)
+		if err != nil {
+			// Unreadable directories are listed but not descended into.
+			child = newIndexNode(entry.Name(), true)
+		}
+		node.children = append(node.children, child)
+	}
+	return node, nil
+}
+
+func (ix *searchIndex) isOtherRoot(path string) bool {
+	for _, root := range ix.roots {
+		if root.root == path {
+			return true
+		}
+	}
+	return false
+}
+
+func (ix *searchIndex) watch(dir string) bool {
+	if ix.backend == nil {
+		return false
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if _, ok := ix.watched[dir]; ok {
+		return true
+	}
+	if len(ix.watched) >= maxSearchIndexWatches {
+		return false
+	}
+	wd, err := ix.backend.add(dir)
+	if err != nil {
+		return false
+	}
+	// A moved directory keeps its watch descriptor under the new name.
+	if previous, ok := ix.watches[wd]; ok {
+		delete(ix.watched, previous)
+	}
+	ix.watches[wd] = dir
+	ix.watched[dir] = wd
+	return true
+}
+
+// unwatch releases the watches of dir and everything below it.
+func (ix *searchIndex) unwatch(dir string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for path, wd := range ix.watched {
+		if isPathWithin(dir, path) {
+			ix.backend.remove(wd)
+			delete(ix.watched, path)
+			delete(ix.watches, wd)
+		}
+	}
+}
+
+func (ix *searchIndex) changed(wd int, gone bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if wd < 0 {
+		// Events were lost; reconcile every watched directory.
+		for dir := range ix.watched {
+			ix.pending[dir] = struct{}{}
+		}
+		return
+	}
+	dir, ok := ix.watches[wd]
+	if !ok {
+		return
+	}
+	if gone {
+		// The parent's own event reconciles a removal or rename. Reconciling
+		// dir as well catches a directory recreated under the same name.
+		ix.backend.remove(wd)
+		delete(ix.watches, wd)
+		delete(ix.watched, dir)
+	}
+	ix.pending[dir] = struct{}{}
+}
+
+// refresh queues a path changed by the server itself. Directories are
+// reconciled with their parent, so creations, deletions, and moves look the
+// same.
+func (ix *searchIndex) refresh(path string) {
+	ix.mu.Lock()
+	ix.pending[filepath.Dir(path)] = struct{}{}
+	if info, err := os.Lstat(path); err == nil && info.IsDir() {
+		ix.pending[path] = struct{}{}
+	}
+	ix.mu.Unlock()
+}
+
+func (ix *searchIndex) maintain() {
+	ticker := time.NewTicker(searchIndexFlushInterval)
+	defer ticker.Stop()
+	lastSave, lastRescan := time.Now(), time.Now()
+	for now := range ticker.C {
+		ix.mu.Lock()
+		pending := make([]string, 0, len(ix.pending))
+		for dir := range ix.pending {
+			pending = append(pending, dir)
+			delete(ix.pending, dir)
+		}
+		ix.mu.Unlock()
+		sort.Strings(pending)
+		for _, dir := range pending {
+			ix.reconcile(dir)
+		}
+		if now.Sub(lastRescan) >= searchIndexRescanInterval {
+			lastRescan = now
+			for _, root := range ix.roots {
+				root.mu.RLock()
+				complete := root.complete
+				root.mu.RUnlock()
+				if !complete {
+					ix.rebuild(root)
+				}
+			}
+		}
+		if now.Sub(lastSave) >= searchIndexSaveInterval {
+			lastSave = now
+			for _, root := range ix.roots {
+				ix.save(root)
+			}
+		}
+	}
+}
+
+func (ix *searchIndex) rootFor(path string) (*rootIndex, string) {
+	var best *rootIndex
+	for _, root := range ix.roots {
+		if isPathWithin(root.root, path) && (best == nil || len(root.root) > len(best.root)) {
+			best = root
+		}
+	}
+	if best == nil {
+		return nil, ""
+	}
+	relative, err := filepath.Rel(best.root, path)
+	if err != nil {
+		return nil, ""
+	}
+	return best, relative
+}
+
+// reconcile brings the children of dir in line with the filesystem. New
+// subdirectories are scanned without holding the lock, then swapped in.
+func (ix *searchIndex) reconcile(dir string) {
+	root, relative := ix.rootFor(dir)
+	if root == nil {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, fs.ErrPermission) {
+			// The parent's reconciliation removes it.
+			return
+		}
+		ix.logger.Warn("Search index cannot read directory", "path", dir, "error", err)
+		return
+	}
+	root.mu.RLock()
+	node := root.lookupLocked(relative)
+	known := make(map[string]bool)
+	if node != nil {
+		for _, child := range node.children {
+			known[child.name] = child.isDir
+		}
+	}
+	root.mu.RUnlock()
+	if node == nil {
+		// The directory itself is new to the index; its parent adds it.
+		if relative != "." {
+			ix.reconcile(filepath.Dir(dir))
+		}
+		return
+	}
+
+	complete := ix.backend == nil || ix.watch(dir)
+	var added []*indexNode
+	present := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if isInternalName(name) {
+			continue
+		}
+		present[name] = struct{}{}
+		isDir, ok := known[name]
+		if ok && isDir == entry.IsDir() {
+			continue
+		}
+		childPath := filepath.Join(dir, name)
+		if !entry.IsDir() {
+			added = append(added, newIndexNode(name, false))
+			continue
+		}
+		if ix.isOtherRoot(childPath) {
+			continue
+		}
+		child, err := ix.scan(childPath, name, &complete)
+		if err != nil {
+			child = newIndexNode(name, true)
+		}
+		added = append(added, child)
+	}
+
+	var removed, removedDirs []string
+	for name, isDir := range known {
+		if _, ok := present[name]; !ok {
+			removed = append(removed, name)
+			if isDir {
+				removedDirs = append(removedDirs, filepath.Join(dir, name))
+			}
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 && complete {
+		return
+	}
+	root.mu.Lock()
+	if node = root.lookupLocked(relative); node != nil {
+		for _, name := range removed {
+			node.remove(name)
+		}
+		for _, child := range added {
+			node.put(child)
+		}
+		root.modified = true
+		root.complete = root.complete && complete
+	}
+	root.mu.Unlock()
+	if ix.backend != nil {
+		for _, path := range removedDirs {
+			ix.unwatch(path)
+		}
+	}
+}
+
+// search visits entries below dir in filepath.WalkDir order, starting after
+// the relative path after. It reports false when dir is not indexed yet.
+func (ix *searchIndex) search(ctx context.Context, dir string, after []string, visit func(path string, node *indexNode) bool) (bool, error) {
+	root, relative := ix.rootFor(dir)
+	if root == nil {
+		return false, nil
+	}
+	root.mu.RLock()
+	defer root.mu.RUnlock()
+	node := root.lookupLocked(relative)
+	if node == nil {
+		return false, nil
+	}
+	var walkErr error
+	visited := 0
+	var walk func(node *indexNode, path string, after []string) bool
+	walk = func(node *indexNode, path string, after []string) bool {
+		start := 0
+		if len(after) > 0 {
+			i, found := node.find(after[0])
+			start = i
+			if found {
+				child := node.children[i]
+				// Everything below the cursor entry still follows it.
+				if child.isDir && !walk(child, filepath.Join(path, child.name), after[1:]) {
+					return false
+				}
+				start = i + 1
+			}
+		}
+		for _, child := range node.children[start:] {
+			visited++
+			if visited%4096 == 0 {
+				if walkErr = ctx.Err(); walkErr != nil {
+					return false
+				}
+			}
+			childPath := filepath.Join(path, child.name)
+			if !visit(childPath, child) {
+				return false
+			}
+			if child.isDir && !walk(child, childPath, nil) {
+				return false
+			}
+		}
+		return true
+	}
+	walk(node, dir, after)
+	return true, walkErr
+}
+
+func (ix *searchIndex) indexFile(root string) string {
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Join(ix.dir, hex.EncodeToString(sum[:16])+".idx")
+}
+
+func (ix *searchIndex) save(root *rootIndex) {
+	root.mu.Lock()
+	if !root.modified || root.tree == nil {
+		root.mu.Unlock()
+		return
+	}
+	root.modified = false
+	root.mu.Unlock()
+
+	if err := ix.write(root); err != nil {
+		ix.logger.Warn("Failed to save search index", "root", root.root, "error", err)
+		root.mu.Lock()
+		root.modified = true
+		root.mu.Unlock()
+	}
+}
+
+func (ix *searchIndex) write(root *rootIndex) error {
+	if err := os.MkdirAll(ix.dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(ix.dir, ".index-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	w := bufio.NewWriterSize(tmp, 1<<20)
+	_, _ = w.WriteString(searchIndexHeader)
+	_, _ = w.WriteString(root.root + "\n")
+	var record [binary.MaxVarintLen64 + 1]byte
+	var encode func(node *indexNode)
+	encode = func(node *indexNode) {
+		for _, child := range node.children {
+			record[0] = indexRecordFile
+			if child.isDir {
+				record[0] = indexRecordDir
+			}
+			n := binary.PutUvarint(record[1:], uint64(len(child.name)))
+			_, _ = w.Write(record[:n+1])
+			_, _ = w.WriteString(child.name)
+			if child.isDir {
+				encode(child)
+			}
+		}
+		_ = w.WriteByte(indexRecordEnd)
+	}
+	root.mu.RLock()
+	encode(root.tree)
+	root.mu.RUnlock()
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ix.indexFile(root.root))
+}
+
+func (ix *searchIndex) load(root string) (*indexNode, error) {
+	file, err := os.Open(ix.indexFile(root))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	r := bufio.NewReaderSize(file, 1<<20)
+	header, err := r.ReadString('\n')
+	if err != nil || header != searchIndexHeader {
+		return nil, errors.New("unknown search index format")
+	}
+	savedRoot, err := r.ReadString('\n')
+	if err != nil || savedRoot != root+"\n" {
+		return nil, errors.New("search index belongs to another root")
+	}
+	tree := newIndexNode(filepath.Base(root), true)
+	stack := []*indexNode{tree}
+	for len(stack) > 0 {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("truncated search index: %w", err)
+		}
+		if kind == indexRecordEnd {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if kind != indexRecordFile && kind != indexRecordDir {
+			return nil, errors.New("corrupt search index")
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil || length == 0 || length > maxSearchIndexNameBytes {
+			return nil, errors.New("corrupt search index")
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, fmt.Errorf("truncated search index: %w", err)
+		}
+		if strings.ContainsRune(string(name), '/') || string(name) == "." || string(name) == ".." {
+			return nil, errors.New("corrupt search index")
+		}
+		parent := stack[len(stack)-1]
+		child := newIndexNode(string(name), kind == indexRecordDir)
+		if n := len(parent.children); n > 0 && parent.children[n-1].name >= child.name {
+			return nil, errors.New("corrupt search index")
+		}
+		parent.children = append(parent.children, child)
+		if child.isDir {
+			stack = append(stack, child)
+		}
+	}
+	return tree, nil
+}
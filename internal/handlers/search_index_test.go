@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"puremania/internal/types"
+)
+
+func newIndexedSearchHandler(t *testing.T) *Handler {
+	t.Helper()
+	storage := t.TempDir()
+	for _, dir := range []string{"music/rock", "music/jazz", "video", ".puremania-trash"} {
+		if err := os.MkdirAll(filepath.Join(storage, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"music/rock/Track01.mp3", "music/jazz/track02.flac", "video/trailer.mkv", ".puremania-trash/track.old"} {
+		writeTestFile(t, filepath.Join(storage, file), "x")
+	}
+	config := &types.Config{StorageDir: storage, SearchIndexDir: t.TempDir()}
+	h := NewHandler(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	waitForIndexedSearch(t, h, "/")
+	return h
+}
+
+func waitForIndexedSearch(t *testing.T, h *Handler, path string) {
+	t.Helper()
+	basePath, err := h.convertToPhysicalPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, indexed, _ := h.searchIndexPage(context.Background(), searchRequest{Term: "x", Limit: 1}, basePath); indexed {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("search index was not built")
+}
+
+func indexedSearch(t *testing.T, h *Handler, req searchRequest) searchPage {
+	t.Helper()
+	basePath, err := h.convertToPhysicalPath(req.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Limit == 0 {
+		req.Limit = 100
+	}
+	page, indexed, err := h.searchIndexPage(context.Background(), req, basePath)
+	if err != nil || !indexed {
+		t.Fatalf("indexed = %v, err = %v", indexed, err)
+	}
+	return page
+}
+
+func resultPaths(page searchPage) string {
+	paths := make([]string, 0, len(page.Data))
+	for _, file := range page.Data {
+		paths = append(paths, file.Path)
+	}
+	return strings.Join(paths, ",")
+}
+
+func TestSearchIndexMatchModes(t *testing.T) {
+	h := newIndexedSearchHandler(t)
+	cases := []struct {
+		req  searchRequest
+		want string
+	}{
+		{searchRequest{Term: "track", Path: "/"}, "/music/jazz/track02.flac,/music/rock/Track01.mp3"},
+		{searchRequest{Term: "track", Path: "/", CaseSensitive: true}, "/music/jazz/track02.flac"},
+		{searchRequest{Term: "rack", Path: "/", Match: "prefix"}, ""},
+		{searchRequest{Term: "TR", Path: "/", Match: "prefix"}, "/music/jazz/track02.flac,/music/rock/Track01.mp3,/video/trailer.mkv"},
+		{searchRequest{Term: `\.(mp3|mkv)$`, Path: "/", UseRegex: true}, "/music/rock/Track01.mp3,/video/trailer.mkv"},
+		{searchRequest{Term: "track", Path: "/music/rock"}, "/music/rock/Track01.mp3"},
+	}
+	for _, tc := range cases {
+		if got := resultPaths(indexedSearch(t, h, tc.req)); got != tc.want {
+			t.Errorf("%+v: got %q, want %q", tc.req, got, tc.want)
+		}
+	}
+}
+
+func TestSearchIndexCursorResumesInWalkOrder(t *testing.T) {
+	h := newIndexedSearchHandler(t)
+	var all []string
+	cursor := ""
+	for {
+		page := indexedSearch(t, h, searchRequest{Term: "", Path: "/", Cursor: cursor, Limit: 2})
+		for _, file := range page.Data {
+			all = append(all, file.Path)
+		}
+		if !page.HasMore {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	want := "/music,/music/jazz,/music/jazz/track02.flac,/music/rock,/music/rock/Track01.mp3,/video,/video/trailer.mkv"
+	if got := strings.Join(all, ","); got != want {
+		t.Fatalf("paged results = %q, want %q", got, want)
+	}
+}
+
+func TestSearchIndexContinuesPastCandidatesRejectedByStat(t *testing.T) {
+	h := newIndexedSearchHandler(t)
+	for _, name := range []string{"a1.txt", "a2.txt", "a3.txt", "a4.txt"} {
+		writeTestFile(t, filepath.Join(h.config.StorageDir, "video", name), "x")
+	}
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "video", "a5.txt"), "larger")
+	h.searchIndex.reconcile(filepath.Join(h.config.StorageDir, "video"))
+
+	minSize := int64(2)
+	page := indexedSearch(t, h, searchRequest{Term: "a", Path: "/video", Limit: 1, MinSize: &minSize})
+	if got := resultPaths(page); got != "/video/a5.txt" || page.HasMore {
+		t.Fatalf("results = %q, hasMore = %v", got, page.HasMore)
+	}
+}
+
+func TestSearchIndexPersistsAcrossRestarts(t *testing.T) {
+	h := newIndexedSearchHandler(t)
+	root := h.searchIndex.roots[0]
+	h.searchIndex.save(root)
+	loaded, err := h.searchIndex.load(root.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &rootIndex{root: root.root, tree: loaded}
+	if node := restored.lookupLocked(filepath.Join("music", "rock")); node == nil || len(node.children) != 1 || node.children[0].name != "Track01.mp3" {
+		t.Fatalf("restored index lost entries: %#v", node)
+	}
+
+	if err := os.WriteFile(h.searchIndex.indexFile(root.root), []byte(searchIndexHeader+root.root+"\n\x01"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.searchIndex.load(root.root); err == nil {
+		t.Fatal("expected a truncated index to be rejected")
+	}
+}
+
+func TestSearchIndexFollowsServerMutations(t *testing.T) {
+	h := newIndexedSearchHandler(t)
+	res := httptest.NewRecorder()
+	h.CreateDirectory(res, httptest.NewRequest(http.MethodPost, "/api/files/mkdir", strings.NewReader(`{"path":"/video","name":"new-track-dir"}`)))
+	if res.Code != http.StatusOK {
+		t.Fatalf("mkdir status = %d", res.Code)
+	}
+	h.DeleteMultipleFiles(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/files/delete", strings.NewReader(`{"paths":["/music/rock"],"permanent":true}`)))
+
+	want := "/music/jazz/track02.flac,/video/new-track-dir"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := resultPaths(indexedSearch(t, h, searchRequest{Term: "track", Path: "/"}))
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("results = %q, want %q", got, want)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestSearchFilesRejectsUnknownMatchMode(t *testing.T) {
+	h := newContentTestHandler(t)
+	body, _ := json.Marshal(searchRequest{Term: "a", Scope: "recursive", Match: "fuzzy"})
+	res := httptest.NewRecorder()
+	h.SearchFiles(res, httptest.NewRequest(http.MethodPost, "/api/search", strings.NewReader(string(body))))
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d", res.Code)
+	}
+}
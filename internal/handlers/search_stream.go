@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// searchProgressInterval spaces the progress frames of a streamed search.
+const searchProgressInterval = 500 * time.Millisecond
+
+// searchObserver sees a search while it runs, so a streamed response can send
+// results before the page is complete. A nil observer ignores everything.
+type searchObserver struct {
+	found     func(result interface{})
+	directory func()
+}
+
+func (o *searchObserver) foundResult(result interface{}) {
+	if o != nil {
+		o.found(result)
+	}
+}
+
+func (o *searchObserver) scannedDirectory() {
+	if o != nil {
+		o.directory()
+	}
+}
+
+type searchProgress struct {
+	Directories int `json:"directories"`
+	Matches     int `json:"matches"`
+}
+
+type searchDone struct {
+	searchProgress
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasMore    bool   `json:"hasMore"`
+}
+
+// searchStream writes frames as SSE events or as NDJSON lines of
+// {"event": name, "data": payload}. Headers are sent with the first frame so
+// that errors found before it still get a normal status code.
+type searchStream struct {
+	w        http.ResponseWriter
+	flusher  http.Flusher
+	sse      bool
+	started  bool
+	failed   bool
+	progress searchProgress
+	lastSent time.Time
+}
+
+func (s *searchStream) send(name string, data interface{}) {
+	if s.failed {
+		return
+	}
+	if !s.started {
+		s.started = true
+		if s.sse {
+			s.w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			s.w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		s.w.Header().Set("Cache-Control", "no-cache, no-transform")
+		s.w.Header().Set("X-Accel-Buffering", "no")
+		s.w.WriteHeader(http.StatusOK)
+	}
+	var err error
+	if s.sse {
+		err = writeServerEvent(s.w, serverEvent{name: name, data: data})
+	} else {
+		err = json.NewEncoder(s.w).Encode(struct {
+			Event string      `json:"event"`
+			Data  interface{} `json:"data"`
+		}{name, data})
+	}
+	if err != nil {
+		// The client is gone; the request context stops the search.
+		s.failed = true
+		return
+	}
+	s.flusher.Flush()
+}
+
+func (s *searchStream) observer() *searchObserver {
+	return &searchObserver{
+		found: func(result interface{}) {
+			s.progress.Matches++
+			s.send("match", result)
+		},
+		directory: func() {
+			s.progress.Directories++
+			if time.Since(s.lastSent) >= searchProgressInterval {
+				s.lastSent = time.Now()
+				s.send("progress", s.progress)
+			}
+		},
+	}
+}
+
+// SearchFilesStream runs the same search as SearchFiles but sends each result
+// as soon as it is found, with "progress" frames while directories are being
+// scanned and a final "done" frame holding the cursor of the next page.
+// Clients receive SSE when they accept text/event-stream and NDJSON
+// otherwise; aborting the request cancels the search.
+func (h *Handler) SearchFilesStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.respondError(w, "Streaming is unavailable", http.StatusInternalServerError)
+		return
+	}
+	req, basePath, ok := h.decodeSearchRequest(w, r)
+	if !ok {
+		return
+	}
+	stream := &searchStream{w: w, flusher: flusher, sse: strings.Contains(r.Header.Get("Accept"), "text/event-stream"), lastSent: time.Now()}
+
+	var page searchPage
+	var results interface{}
+	var err error
+	indexed := false
+	if req.Scope == "recursive" && req.Mode != "content" {
+		// The index answers at once and holds a lock while it is walked, so
+		// its page is sent after the walk rather than during it.
+		page, indexed, err = h.searchIndexPage(r.Context(), req, basePath)
+		if indexed && err == nil {
+			observe := stream.observer()
+			for _, result := range page.Data {
+				observe.foundResult(result)
+			}
+			results = page
+		}
+	}
+	if !indexed {
+		if !tryAcquire(h.searchGate) {
+			respondBusy(w)
+			return
+		}
+		defer release(h.searchGate)
+		results, err = h.runSearch(r.Context(), req, basePath, stream.observer())
+	}
+	if err != nil {
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			return
+		}
+		h.logger.Error("Search failed", "error", err)
+		if !stream.started {
+			h.respondError(w, "Search failed", http.StatusInternalServerError)
+			return
+		}
+		stream.send("error", map[string]string{"message": "Search failed"})
+		return
+	}
+
+	done := searchDone{searchProgress: stream.progress}
+	switch results := results.(type) {
+	case searchPage:
+		done.NextCursor, done.HasMore = results.NextCursor, results.HasMore
+	case contentSearchPage:
+		done.NextCursor, done.HasMore = results.NextCursor, results.HasMore
+	}
+	stream.send("done", done)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type streamFrame struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+func TestSearchStreamSendsMatchesThenDone(t *testing.T) {
+	h := newContentTestHandler(t)
+	for _, name := range []string{"a-report.txt", "b-report.txt", "other.txt"} {
+		writeTestFile(t, filepath.Join(h.config.StorageDir, name), "x")
+	}
+	res := httptest.NewRecorder()
+	h.SearchFilesStream(res, httptest.NewRequest(http.MethodPost, "/api/search/stream", strings.NewReader(`{"term":"report"}`)))
+
+	if res.Code != http.StatusOK || res.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("status = %d, Content-Type = %q", res.Code, res.Header().Get("Content-Type"))
+	}
+	var frames []streamFrame
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		var frame streamFrame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		frames = append(frames, frame)
+	}
+	if len(frames) != 3 || frames[0].Event != "match" || frames[1].Event != "match" || frames[2].Event != "done" {
+		t.Fatalf("unexpected frames: %+v", frames)
+	}
+	var done searchDone
+	if err := json.Unmarshal(frames[2].Data, &done); err != nil || done.Matches != 2 || done.HasMore {
+		t.Fatalf("done = %+v, err = %v", done, err)
+	}
+}
+
+func TestSearchStreamUsesSSEWhenAccepted(t *testing.T) {
+	h := newContentTestHandler(t)
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "notes.txt"), "needle\n")
+	req := httptest.NewRequest(http.MethodPost, "/api/search/stream", strings.NewReader(`{"term":"needle","mode":"content"}`))
+	req.Header.Set("Accept", "text/event-stream")
+	res := httptest.NewRecorder()
+	h.SearchFilesStream(res, req)
+
+	body := res.Body.String()
+	if res.Header().Get("Content-Type") != "text/event-stream" || !strings.Contains(body, "event: match\ndata: {") || !strings.Contains(body, `"line":1`) {
+		t.Fatalf("unexpected SSE stream: %q", body)
+	}
+	if !strings.HasSuffix(body, "event: done\ndata: {\"directories\":1,\"matches\":1,\"hasMore\":false}\n\n") {
+		t.Fatalf("missing done frame: %q", body)
+	}
+}
+
+func TestSearchStreamReportsProgress(t *testing.T) {
+	res := httptest.NewRecorder()
+	stream := &searchStream{w: res, flusher: res}
+	observe := stream.observer()
+	observe.scannedDirectory()
+	observe.scannedDirectory()
+	if got := res.Body.String(); got != "{\"event\":\"progress\",\"data\":{\"directories\":1,\"matches\":0}}\n" {
+		t.Fatalf("progress frames = %q", got)
+	}
+}
+
+func TestSearchStreamStopsWhenClientAborts(t *testing.T) {
+	h := newContentTestHandler(t)
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "report.txt"), "x")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res := httptest.NewRecorder()
+	h.SearchFilesStream(res, httptest.NewRequest(http.MethodPost, "/api/search/stream", strings.NewReader(`{"term":"report"}`)).WithContext(ctx))
+	if res.Body.Len() != 0 {
+		t.Fatalf("aborted search wrote %q", res.Body.String())
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+// Staging paths of moves and copies are journaled in the storage directory
+// before they are created, so the ones an interrupted process leaves behind
+// are found and swept at startup wherever they were created, including on
+// filesystems mounted below a root.
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	stagingJournalDir = ".puremania-staging"
+	copyingPrefix     = ".puremania-copying-"
+)
+
+func (h *Handler) stagingJournalPath(id string) string {
+	return filepath.Join(h.config.StorageDir, stagingJournalDir, id)
+}
+
+// journalStaging records path until the returned function is called. An
+// internal name is removed as a whole by the sweep; any other path is a
+// merge target that is searched for unpublished copies.
+func (h *Handler) journalStaging(path string) (func(), error) {
+	id, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(h.config.StorageDir, stagingJournalDir), 0700); err != nil {
+		return nil, err
+	}
+	entry := h.stagingJournalPath(id)
+	if err := atomicWriteFile(entry, []byte(path), 0600); err != nil {
+		return nil, err
+	}
+	return func() { _ = os.Remove(entry) }, nil
+}
+
+// createStagingDir creates a journaled hidden directory in parent. cleanup
+// removes it with everything still inside and forgets it; a directory that
+// cannot be removed stays journaled for the next sweep.
+func (h *Handler) createStagingDir(parent, prefix string) (string, func() error, error) {
+	suffix, err := newUploadID()
+	if err != nil {
+		return "", nil, err
+	}
+	dir := filepath.Join(parent, prefix+suffix)
+	forget, err := h.journalStaging(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		forget()
+		return "", nil, err
+	}
+	return dir, func() error {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		forget()
+		return nil
+	}, nil
+}
+
+// sweepStagingLeftovers reads the journal before any request can add to it
+// and deletes the leftovers in the background, as they may be whole trees.
+func (h *Handler) sweepStagingLeftovers() {
+	entries, err := os.ReadDir(filepath.Join(h.config.StorageDir, stagingJournalDir))
+	if err != nil {
+		return
+	}
+	go func() {
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			if err := h.sweepStagingEntry(h.stagingJournalPath(entry.Name())); err != nil {
+				h.logger.Warn("Failed to remove staging leftover", "entry", entry.Name(), "error", err)
+			}
+		}
+	}()
+}
+
+func (h *Handler) sweepStagingEntry(entry string) error {
+	data, err := os.ReadFile(entry)
+	if err != nil {
+		return err
+	}
+	path := filepath.Clean(string(data))
+	// The journal is trusted no further than the allowed roots.
+	if _, _, err := h.allowedRootForPath(path); err != nil || h.isProtectedRoot(path) {
+		return os.Remove(entry)
+	}
+	if isInternalName(filepath.Base(path)) {
+		err = os.RemoveAll(path)
+	} else {
+		err = removeCopyingFiles(path)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.Remove(entry)
+}
+
+// removeCopyingFiles deletes the unpublished files that an interrupted merge
+// left in the tree at dir.
+func removeCopyingFiles(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if entry.Type().IsRegular() && strings.HasPrefix(entry.Name(), copyingPrefix) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,651 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"puremania/internal/types"
+)
+
+// Subsonic API の一部をディレクトリツリーに対応付ける。
+// タグは読まず、Artist/Album/Track のフォルダ構成を前提にする。
+const (
+	subsonicAPIVersion      = "1.16.1"
+	subsonicMusicFolderID   = 1
+	subsonicDefaultCount    = 20
+	subsonicMaxCount        = 500
+	subsonicIgnoredArticles = "The El La Los Las Le Les"
+
+	subsonicErrorGeneric          = 0
+	subsonicErrorMissingParameter = 10
+	subsonicErrorNotFound         = 70
+)
+
+// albumArtNames is the lookup order of the web player's getAlbumArt.
+var albumArtNames = []string{"cover.jpg", "cover.jpeg", "cover.png", "folder.jpg", "album.jpg"}
+
+// trackNumberPrefix matches the "01 - " of "01 - Title.flac".
+var trackNumberPrefix = regexp.MustCompile(`^(\d{1,3})[\s._-]+`)
+
+type subsonicResponse struct {
+	XMLName       xml.Name               `xml:"http://subsonic.org/restapi subsonic-response" json:"-"`
+	Status        string                 `xml:"status,attr" json:"status"`
+	Version       string                 `xml:"version,attr" json:"version"`
+	Type          string                 `xml:"type,attr" json:"type"`
+	Error         *subsonicError         `xml:"error,omitempty" json:"error,omitempty"`
+	License       *subsonicLicense       `xml:"license,omitempty" json:"license,omitempty"`
+	MusicFolders  *subsonicMusicFolders  `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Indexes       *subsonicIndexes       `xml:"indexes,omitempty" json:"indexes,omitempty"`
+	Directory     *subsonicDirectory     `xml:"directory,omitempty" json:"directory,omitempty"`
+	SearchResult3 *subsonicSearchResult3 `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+}
+
+type subsonicError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+type subsonicLicense struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+type subsonicMusicFolders struct {
+	MusicFolder []subsonicMusicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type subsonicMusicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type subsonicIndexes struct {
+	LastModified    int64           `xml:"lastModified,attr" json:"lastModified"`
+	IgnoredArticles string          `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []subsonicIndex `xml:"index" json:"index,omitempty"`
+	Child           []subsonicChild `xml:"child" json:"child,omitempty"`
+}
+
+type subsonicIndex struct {
+	Name   string           `xml:"name,attr" json:"name"`
+	Artist []subsonicArtist `xml:"artist" json:"artist"`
+}
+
+type subsonicArtist struct {
+	ID       string `xml:"id,attr" json:"id"`
+	Name     string `xml:"name,attr" json:"name"`
+	CoverArt string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+}
+
+type subsonicDirectory struct {
+	ID     string          `xml:"id,attr" json:"id"`
+	Parent string          `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	Name   string          `xml:"name,attr" json:"name"`
+	Child  []subsonicChild `xml:"child" json:"child"`
+}
+
+// subsonicChild is a directory or song entry. Album and artist are the
+// names of the parent and grandparent directories.
+type subsonicChild struct {
+	ID          string `xml:"id,attr" json:"id"`
+	Parent      string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir       bool   `xml:"isDir,attr" json:"isDir"`
+	Title       string `xml:"title,attr" json:"title"`
+	Album       string `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist      string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Track       int    `xml:"track,attr,omitempty" json:"track,omitempty"`
+	CoverArt    string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Size        int64  `xml:"size,attr,omitempty" json:"size,omitempty"`
+	ContentType string `xml:"contentType,attr,omitempty" json:"contentType,omitempty"`
+	Suffix      string `xml:"suffix,attr,omitempty" json:"suffix,omitempty"`
+	Path        string `xml:"path,attr,omitempty" json:"path,omitempty"`
+	Type        string `xml:"type,attr,omitempty" json:"type,omitempty"`
+	Created     string `xml:"created,attr,omitempty" json:"created,omitempty"`
+}
+
+type subsonicAlbum struct {
+	ID       string `xml:"id,attr" json:"id"`
+	Parent   string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	Name     string `xml:"name,attr" json:"name"`
+	Artist   string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	CoverArt string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Created  string `xml:"created,attr,omitempty" json:"created,omitempty"`
+}
+
+type subsonicSearchResult3 struct {
+	Artist []subsonicArtist `xml:"artist" json:"artist"`
+	Album  []subsonicAlbum  `xml:"album" json:"album"`
+	Song   []subsonicChild  `xml:"song" json:"song"`
+}
+
+// Subsonic - /rest/{method} を処理する。
+// 認証は行わず、u/p/t/s などの資格情報はすべて受け入れる。
+func (h *Handler) Subsonic(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimSuffix(mux.Vars(r)["method"], ".view")
+	switch method {
+	case "ping":
+		h.writeSubsonic(w, r, &subsonicResponse{})
+	case "getLicense":
+		h.writeSubsonic(w, r, &subsonicResponse{License: &subsonicLicense{Valid: true}})
+	case "getMusicFolders":
+		folder := subsonicMusicFolder{ID: subsonicMusicFolderID, Name: h.subsonicRootName()}
+		h.writeSubsonic(w, r, &subsonicResponse{MusicFolders: &subsonicMusicFolders{MusicFolder: []subsonicMusicFolder{folder}}})
+	case "getIndexes":
+		h.subsonicIndexes(w, r)
+	case "getMusicDirectory":
+		h.subsonicMusicDirectory(w, r)
+	case "stream", "download":
+		h.subsonicStream(w, r, method == "stream")
+	case "getCoverArt":
+		h.subsonicCoverArt(w, r)
+	case "search3":
+		h.subsonicSearch(w, r)
+	default:
+		h.subsonicFail(w, r, subsonicErrorGeneric, "Unsupported method: "+method)
+	}
+}
+
+func (h *Handler) writeSubsonic(w http.ResponseWriter, r *http.Request, response *subsonicResponse) {
+	if response.Status == "" {
+		response.Status = "ok"
+	}
+	response.Version = subsonicAPIVersion
+	response.Type = "puremania"
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.FormValue("f") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]*subsonicResponse{"subsonic-response": response}); err != nil {
+			h.logger.Warn("Failed to write Subsonic response", "error", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return
+	}
+	if err := xml.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Warn("Failed to write Subsonic response", "error", err)
+	}
+}
+
+// subsonicFail answers with a Subsonic error. Clients expect these with
+// status 200.
+func (h *Handler) subsonicFail(w http.ResponseWriter, r *http.Request, code int, message string) {
+	h.writeSubsonic(w, r, &subsonicResponse{Status: "failed", Error: &subsonicError{Code: code, Message: message}})
+}
+
+func (h *Handler) subsonicRoot() string {
+	if h.config.SubsonicMusicDir == "" {
+		return "/"
+	}
+	return h.config.SubsonicMusicDir
+}
+
+func (h *Handler) subsonicRootName() string {
+	if root := h.subsonicRoot(); root != "/" {
+		return path.Base(root)
+	}
+	return "Music"
+}
+
+// subsonicID encodes a virtual path as an opaque, URL-safe id.
+func subsonicID(virtualPath string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(virtualPath))
+}
+
+// subsonicPath decodes the id parameter into a virtual path below the music
+// directory.
+func (h *Handler) subsonicPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.FormValue("id")
+	if id == "" {
+		h.subsonicFail(w, r, subsonicErrorMissingParameter, "Required parameter is missing: id")
+		return "", false
+	}
+	if decoded, err := base64.RawURLEncoding.DecodeString(id); err == nil && len(decoded) <= maxVirtualPathBytes {
+		virtualPath := string(decoded)
+		if strings.HasPrefix(virtualPath, "/") && path.Clean(virtualPath) == virtualPath && h.withinSubsonicRoot(virtualPath) {
+			return virtualPath, true
+		}
+	}
+	h.subsonicFail(w, r, subsonicErrorNotFound, "Not found")
+	return "", false
+}
+
+func (h *Handler) withinSubsonicRoot(virtualPath string) bool {
+	root := h.subsonicRoot()
+	relative, ok := strings.CutPrefix(virtualPath, root)
+	if !ok || (root != "/" && relative != "" && !strings.HasPrefix(relative, "/")) {
+		return false
+	}
+	for _, name := range strings.Split(relative, "/") {
+		if isInternalName(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func isSubsonicSong(name string) bool {
+	return isPlayableMedia(name) && strings.HasPrefix(mediaTypeByPath(name), "audio/")
+}
+
+// subsonicStat stats the virtual path for the handlers that take an id.
+func (h *Handler) subsonicStat(w http.ResponseWriter, r *http.Request, virtualPath string) (os.FileInfo, bool) {
+	fullPath, err := h.convertToPhysicalPath(virtualPath)
+	if err == nil {
+		var info os.FileInfo
+		if info, err = os.Stat(fullPath); err == nil {
+			return info, true
+		}
+	}
+	h.subsonicFail(w, r, subsonicErrorNotFound, "Not found")
+	return nil, false
+}
+
+func (h *Handler) subsonicIndexes(w http.ResponseWriter, r *http.Request) {
+	root := h.subsonicRoot()
+	info, ok := h.subsonicStat(w, r, root)
+	if !ok {
+		return
+	}
+	indexes := &subsonicIndexes{LastModified: info.ModTime().UnixMilli(), IgnoredArticles: subsonicIgnoredArticles}
+	// 変更がなければ一覧を省略し、クライアントのキャッシュを使わせる。
+	if since, err := strconv.ParseInt(r.FormValue("ifModifiedSince"), 10, 64); err == nil && since >= indexes.LastModified {
+		h.writeSubsonic(w, r, &subsonicResponse{Indexes: indexes})
+		return
+	}
+
+	files, err := h.getFileList(root)
+	if err != nil {
+		h.logger.Warn("Cannot list Subsonic music directory", "path", root, "error", err)
+		h.subsonicFail(w, r, subsonicErrorNotFound, "Not found")
+		return
+	}
+	groups := map[string][]subsonicArtist{}
+	for _, file := range files {
+		if isInternalName(file.Name) {
+			continue
+		}
+		if file.IsDir {
+			key := subsonicIndexKey(file.Name)
+			groups[key] = append(groups[key], subsonicArtist{ID: subsonicID(file.Path), Name: file.Name})
+		} else if isSubsonicSong(file.Name) {
+			indexes.Child = append(indexes.Child, h.subsonicSong(file))
+		}
+	}
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	// "#" は英字の後に並べる。
+	sort.Slice(keys, func(i, j int) bool {
+		if (keys[i] == "#") != (keys[j] == "#") {
+			return keys[j] == "#"
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys {
+		artists := groups[key]
+		sort.Slice(artists, func(i, j int) bool {
+			return strings.ToLower(subsonicSortName(artists[i].Name)) < strings.ToLower(subsonicSortName(artists[j].Name))
+		})
+		indexes.Index = append(indexes.Index, subsonicIndex{Name: key, Artist: artists})
+	}
+	sortSubsonicSongs(indexes.Child)
+	h.writeSubsonic(w, r, &subsonicResponse{Indexes: indexes})
+}
+
+// subsonicSortName drops a leading ignored article: "The Beatles" sorts as "Beatles".
+func subsonicSortName(name string) string {
+	for _, article := range strings.Fields(subsonicIgnoredArticles) {
+		if len(name) > len(article)+1 && strings.EqualFold(name[:len(article)], article) && name[len(article)] == ' ' {
+			return strings.TrimSpace(name[len(article)+1:])
+		}
+	}
+	return name
+}
+
+// subsonicIndexKey is the upper-case first letter of the sort name, or "#".
+func subsonicIndexKey(name string) string {
+	first, _ := utf8.DecodeRuneInString(subsonicSortName(name))
+	if !unicode.IsLetter(first) {
+		return "#"
+	}
+	return string(unicode.ToUpper(first))
+}
+
+func (h *Handler) subsonicMusicDirectory(w http.ResponseWriter, r *http.Request) {
+	virtualPath, ok := h.subsonicPath(w, r)
+	if !ok {
+		return
+	}
+	info, ok := h.subsonicStat(w, r, virtualPath)
+	if !ok {
+		return
+	}
+	if !info.IsDir() {
+		h.subsonicFail(w, r, subsonicErrorNotFound, "Not a directory")
+		return
+	}
+	files, err := h.getFileList(virtualPath)
+	if err != nil {
+		h.logger.Warn("Cannot list Subsonic directory", "path", virtualPath, "error", err)
+		h.subsonicFail(w, r, subsonicErrorGeneric, "Cannot read directory")
+		return
+	}
+
+	directory := &subsonicDirectory{ID: subsonicID(virtualPath), Name: path.Base(virtualPath), Child: []subsonicChild{}}
+	if virtualPath == h.subsonicRoot() {
+		directory.Name = h.subsonicRootName()
+	} else {
+		directory.Parent = subsonicID(path.Dir(virtualPath))
+	}
+	var dirs, songs []subsonicChild
+	for _, file := range files {
+		if isInternalName(file.Name) {
+			continue
+		}
+		if file.IsDir {
+			dirs = append(dirs, h.subsonicDir(file))
+		} else if isSubsonicSong(file.Name) {
+			songs = append(songs, h.subsonicSong(file))
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool { return strings.ToLower(dirs[i].Title) < strings.ToLower(dirs[j].Title) })
+	sortSubsonicSongs(songs)
+	directory.Child = append(append(directory.Child, dirs...), songs...)
+	h.writeSubsonic(w, r, &subsonicResponse{Directory: directory})
+}
+
+// sortSubsonicSongs orders songs by track number, then by file name.
+func sortSubsonicSongs(songs []subsonicChild) {
+	sort.SliceStable(songs, func(i, j int) bool {
+		if songs[i].Track != songs[j].Track {
+			return songs[i].Track < songs[j].Track
+		}
+		return songs[i].Path < songs[j].Path
+	})
+}
+
+func (h *Handler) subsonicDir(file types.FileInfo) subsonicChild {
+	id := subsonicID(file.Path)
+	child := subsonicChild{ID: id, IsDir: true, Title: file.Name, CoverArt: id, Created: file.ModTime}
+	if dir := path.Dir(file.Path); h.withinSubsonicRoot(dir) {
+		child.Parent = subsonicID(dir)
+		if dir != h.subsonicRoot() {
+			child.Artist = path.Base(dir)
+		}
+	}
+	return child
+}
+
+func (h *Handler) subsonicSong(file types.FileInfo) subsonicChild {
+	root := h.subsonicRoot()
+	dir := path.Dir(file.Path)
+	ext := path.Ext(file.Name)
+	title := strings.TrimSuffix(file.Name, ext)
+	child := subsonicChild{
+		ID: subsonicID(file.Path), Parent: subsonicID(dir), Title: title, CoverArt: subsonicID(dir),
+		Size: file.Size, ContentType: mediaTypeByPath(file.Name), Suffix: strings.ToLower(strings.TrimPrefix(ext, ".")),
+		Path: strings.TrimPrefix(strings.TrimPrefix(file.Path, root), "/"), Type: "music", Created: file.ModTime,
+	}
+	if match := trackNumberPrefix.FindStringSubmatch(title); match != nil && len(match[0]) < len(title) {
+		child.Track, _ = strconv.Atoi(match[1])
+		child.Title = title[len(match[0]):]
+	}
+	if dir != root {
+		child.Album = path.Base(dir)
+		if parent := path.Dir(dir); parent != root && h.withinSubsonicRoot(parent) {
+			child.Artist = path.Base(parent)
+		}
+	}
+	return child
+}
+
+// subsonicStream delegates to DownloadFile, which serves Range requests.
+// maxBitRate and format are ignored: files are sent as stored.
+func (h *Handler) subsonicStream(w http.ResponseWriter, r *http.Request, songsOnly bool) {
+	virtualPath, ok := h.subsonicPath(w, r)
+	if !ok {
+		return
+	}
+	info, ok := h.subsonicStat(w, r, virtualPath)
+	if !ok {
+		return
+	}
+	if !info.Mode().IsRegular() || (songsOnly && !isSubsonicSong(virtualPath)) {
+		h.subsonicFail(w, r, subsonicErrorNotFound, "Not a song")
+		return
+	}
+	h.DownloadFile(w, withQuery(r, url.Values{"path": {virtualPath}}))
+}
+
+// withQuery clones r with its query replaced, to reuse the /api handlers.
+func withQuery(r *http.Request, query url.Values) *http.Request {
+	clone := r.Clone(r.Context())
+	clone.URL.RawQuery = query.Encode()
+	clone.Form = nil
+	return clone
+}
+
+// subsonicCoverArt serves the cover image of a directory, or of a song's
+// directory. A size up to 640 is answered from the thumbnail cache.
+func (h *Handler) subsonicCoverArt(w http.ResponseWriter, r *http.Request) {
+	virtualPath, ok := h.subsonicPath(w, r)
+	if !ok {
+		return
+	}
+	info, ok := h.subsonicStat(w, r, virtualPath)
+	if !ok {
+		return
+	}
+	dir := virtualPath
+	if !info.IsDir() {
+		dir = path.Dir(virtualPath)
+	}
+	cover, found := h.findAlbumArt(dir)
+	if !found {
+		h.subsonicFail(w, r, subsonicErrorNotFound, "Cover art not found")
+		return
+	}
+	if size, err := strconv.Atoi(r.FormValue("size")); err == nil && size > 0 {
+		for _, thumbnailSize := range []int{160, 320, 640} {
+			if size <= thumbnailSize {
+				h.Thumbnail(w, withQuery(r, url.Values{"path": {cover}, "size": {strconv.Itoa(thumbnailSize)}}))
+				return
+			}
+		}
+	}
+	h.DownloadFile(w, withQuery(r, url.Values{"path": {cover}}))
+}
+
+// findAlbumArt returns the first of albumArtNames in dir, compared without case.
+func (h *Handler) findAlbumArt(dir string) (string, bool) {
+	fullPath, err := h.convertToPhysicalPath(dir)
+	if err != nil {
+		return "", false
+	}
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return "", false
+	}
+	names := map[string]string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names[strings.ToLower(entry.Name())] = entry.Name()
+		}
+	}
+	for _, name := range albumArtNames {
+		if actual, ok := names[name]; ok {
+			return path.Join(dir, actual), true
+		}
+	}
+	return "", false
+}
+
+// subsonicSearchBucket pages one kind of search3 result.
+type subsonicSearchBucket struct {
+	count, offset, seen int
+}
+
+func newSubsonicSearchBucket(r *http.Request, kind string) *subsonicSearchBucket {
+	bucket := &subsonicSearchBucket{count: subsonicDefaultCount}
+	if count, err := strconv.Atoi(r.FormValue(kind + "Count")); err == nil && count >= 0 {
+		bucket.count = min(count, subsonicMaxCount)
+	}
+	if offset, err := strconv.Atoi(r.FormValue(kind + "Offset")); err == nil && offset > 0 {
+		bucket.offset = offset
+	}
+	return bucket
+}
+
+func (b *subsonicSearchBucket) take() bool {
+	if b.full() {
+		return false
+	}
+	b.seen++
+	return b.seen > b.offset
+}
+
+func (b *subsonicSearchBucket) full() bool {
+	return b.seen >= b.offset+b.count
+}
+
+// subsonicSearch matches file and directory names: first-level directories
+// are artists, deeper directories albums, and audio files songs. An empty
+// query ("" included) matches everything, which clients use to sync.
+func (h *Handler) subsonicSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.ToLower(strings.Trim(strings.TrimSpace(r.FormValue("query")), `"*`))
+	artists := newSubsonicSearchBucket(r, "artist")
+	albums := newSubsonicSearchBucket(r, "album")
+	songs := newSubsonicSearchBucket(r, "song")
+
+	if !tryAcquire(h.searchGate) {
+		h.subsonicFail(w, r, subsonicErrorGeneric, "Server busy, try again")
+		return
+	}
+	defer release(h.searchGate)
+
+	root := h.subsonicRoot()
+	result := &subsonicSearchResult3{Artist: []subsonicArtist{}, Album: []subsonicAlbum{}, Song: []subsonicChild{}}
+	visit := func(fullPath, name, lower string, isDir bool) bool {
+		if query != "" && !strings.Contains(lower, query) {
+			return true
+		}
+		var bucket *subsonicSearchBucket
+		virtualPath := h.convertToVirtualPath(fullPath)
+		switch {
+		case isDir && path.Dir(virtualPath) == root:
+			bucket = artists
+		case isDir:
+			bucket = albums
+		case isSubsonicSong(name):
+			bucket = songs
+		default:
+			return true
+		}
+		if !bucket.take() {
+			return !(artists.full() && albums.full() && songs.full())
+		}
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			return true
+		}
+		file := h.searchFileInfo(fullPath, fs.FileInfoToDirEntry(info))
+		switch bucket {
+		case artists:
+			result.Artist = append(result.Artist, subsonicArtist{ID: subsonicID(file.Path), Name: file.Name, CoverArt: subsonicID(file.Path)})
+		case albums:
+			dir := h.subsonicDir(file)
+			result.Album = append(result.Album, subsonicAlbum{ID: dir.ID, Parent: dir.Parent, Name: dir.Title, Artist: dir.Artist, CoverArt: dir.CoverArt, Created: dir.Created})
+		default:
+			result.Song = append(result.Song, h.subsonicSong(file))
+		}
+		return !(artists.full() && albums.full() && songs.full())
+	}
+
+	ctx := r.Context()
+	for _, base := range h.subsonicSearchBases(root) {
+		more, err := h.walkMusicLibrary(ctx, base, visit)
+		if err != nil {
+			if ctx.Err() == nil {
+				h.logger.Warn("Subsonic search failed", "path", base, "error", err)
+			}
+			h.subsonicFail(w, r, subsonicErrorGeneric, "Search failed")
+			return
+		}
+		if !more {
+			break
+		}
+	}
+	h.writeSubsonic(w, r, &subsonicResponse{SearchResult3: result})
+}
+
+// subsonicSearchBases returns the physical directories to search: the music
+// directory, plus the mount directories when it is the virtual root.
+func (h *Handler) subsonicSearchBases(root string) []string {
+	fullPath, err := h.convertToPhysicalPath(root)
+	if err != nil {
+		return nil
+	}
+	bases := []string{fullPath}
+	if root == "/" {
+		bases = append(bases, h.config.MountDirs...)
+	}
+	return bases
+}
+
+// walkMusicLibrary visits the entries below base, from the filename index
+// when it covers base. It reports false once visit has stopped the walk.
+func (h *Handler) walkMusicLibrary(ctx context.Context, base string, visit func(fullPath, name, lower string, isDir bool) bool) (bool, error) {
+	more := true
+	if h.searchIndex != nil {
+		indexed, err := h.searchIndex.search(ctx, base, nil, func(fullPath string, node *indexNode) bool {
+			more = visit(fullPath, node.name, node.lower, node.isDir)
+			return more
+		})
+		if indexed {
+			return more, err
+		}
+	}
+	err := filepath.WalkDir(base, func(fullPath string, entry os.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories are skipped like in the index.
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if fullPath == base {
+			return nil
+		}
+		if isInternalName(entry.Name()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !visit(fullPath, entry.Name(), strings.ToLower(entry.Name()), entry.IsDir()) {
+			more = false
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return more, err
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func writeSubsonicLibrary(t *testing.T, h *Handler) {
+	t.Helper()
+	music := filepath.Join(h.config.StorageDir, "Music")
+	for _, dir := range []string{"Artist A/Album X", "The Beatles/Abbey Road", "zed", "12 Inches", ".puremania-trash"} {
+		if err := os.MkdirAll(filepath.Join(music, filepath.FromSlash(dir)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"Artist A/Album X/02 Second Song.flac", "Artist A/Album X/01 - Intro.mp3", "Artist A/Album X/notes.txt", "loose.mp3", "list.m3u8", ".puremania-trash/gone.mp3"} {
+		writeTestFile(t, filepath.Join(music, filepath.FromSlash(name)), "0123456789")
+	}
+	writeTestImage(t, filepath.Join(music, "Artist A", "Album X", "Cover.JPG"), image.NewGray(image.Rect(0, 0, 800, 600)), func(b *bytes.Buffer, img image.Image) error {
+		return jpeg.Encode(b, img, nil)
+	}, nil)
+	h.config.SubsonicMusicDir = "/Music"
+}
+
+func subsonicRequest(h *Handler, method, query string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/rest/"+method+".view?u=any&p=any&v=1.16.1&c=test&"+query, nil)
+	h.Subsonic(res, mux.SetURLVars(request, map[string]string{"method": method + ".view"}))
+	return res
+}
+
+func decodeSubsonic(t *testing.T, res *httptest.ResponseRecorder) subsonicResponse {
+	t.Helper()
+	var body struct {
+		Response subsonicResponse `json:"subsonic-response"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil || res.Code != http.StatusOK {
+		t.Fatalf("status = %d, err = %v", res.Code, err)
+	}
+	return body.Response
+}
+
+func TestSubsonicPingAndErrors(t *testing.T) {
+	h := newContentTestHandler(t)
+	writeSubsonicLibrary(t, h)
+
+	res := subsonicRequest(h, "ping", "")
+	want := `<subsonic-response xmlns="http://subsonic.org/restapi" status="ok" version="1.16.1" type="puremania"></subsonic-response>`
+	if !strings.Contains(res.Body.String(), want) || res.Header().Get("Content-Type") != "text/xml; charset=utf-8" {
+		t.Fatalf("ping =\n%s", res.Body.String())
+	}
+
+	for query, code := range map[string]int{
+		"f=json":                                             subsonicErrorMissingParameter,
+		"f=json&id=" + subsonicID("/"):                       subsonicErrorNotFound,
+		"f=json&id=" + subsonicID("/Musical"):                subsonicErrorNotFound,
+		"f=json&id=" + subsonicID("/Music/.puremania-trash"): subsonicErrorNotFound,
+		"f=json&id=!!!":                                      subsonicErrorNotFound,
+	} {
+		response := decodeSubsonic(t, subsonicRequest(h, "getMusicDirectory", query))
+		if response.Status != "failed" || response.Error == nil || response.Error.Code != code {
+			t.Fatalf("%s: response = %+v", query, response)
+		}
+	}
+}
+
+func TestSubsonicBrowsesDirectoryTree(t *testing.T) {
+	h := newContentTestHandler(t)
+	writeSubsonicLibrary(t, h)
+
+	indexes := decodeSubsonic(t, subsonicRequest(h, "getIndexes", "f=json")).Indexes
+	var names []string
+	for _, index := range indexes.Index {
+		for _, artist := range index.Artist {
+			names = append(names, index.Name+":"+artist.Name)
+		}
+	}
+	if got := strings.Join(names, ","); got != "A:Artist A,B:The Beatles,Z:zed,#:12 Inches" {
+		t.Fatalf("indexes = %s", got)
+	}
+	if len(indexes.Child) != 1 || indexes.Child[0].Title != "loose" || indexes.Child[0].Album != "" {
+		t.Fatalf("root songs = %+v", indexes.Child)
+	}
+	unchanged := decodeSubsonic(t, subsonicRequest(h, "getIndexes", "f=json&ifModifiedSince=99999999999999")).Indexes
+	if len(unchanged.Index) != 0 || unchanged.LastModified != indexes.LastModified {
+		t.Fatalf("unchanged indexes = %+v", unchanged)
+	}
+
+	album := subsonicID("/Music/Artist A/Album X")
+	directory := decodeSubsonic(t, subsonicRequest(h, "getMusicDirectory", "f=json&id="+album)).Directory
+	if directory.Name != "Album X" || directory.Parent != subsonicID("/Music/Artist A") || len(directory.Child) != 2 {
+		t.Fatalf("directory = %+v", directory)
+	}
+	intro := directory.Child[0]
+	if intro.Title != "Intro" || intro.Track != 1 || intro.Album != "Album X" || intro.Artist != "Artist A" ||
+		intro.CoverArt != album || intro.Suffix != "mp3" || intro.Path != "Artist A/Album X/01 - Intro.mp3" || intro.Type != "music" {
+		t.Fatalf("first song = %+v", intro)
+	}
+	if second := directory.Child[1]; second.Title != "Second Song" || second.Track != 2 || second.ContentType != "audio/flac" {
+		t.Fatalf("second song = %+v", second)
+	}
+	artist := decodeSubsonic(t, subsonicRequest(h, "getMusicDirectory", "f=json&id="+subsonicID("/Music/Artist A"))).Directory
+	if len(artist.Child) != 1 || !artist.Child[0].IsDir || artist.Child[0].ID != album || artist.Child[0].Artist != "Artist A" {
+		t.Fatalf("artist directory = %+v", artist)
+	}
+}
+
+func TestSubsonicStreamsRangesAndCoverArt(t *testing.T) {
+	h := newContentTestHandler(t)
+	h.thumbnails.dir = t.TempDir()
+	writeSubsonicLibrary(t, h)
+
+	res := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/rest/stream?id="+subsonicID("/Music/Artist A/Album X/01 - Intro.mp3")+"&maxBitRate=128", nil)
+	request.Header.Set("Range", "bytes=2-5")
+	h.Subsonic(res, mux.SetURLVars(request, map[string]string{"method": "stream"}))
+	if res.Code != http.StatusPartialContent || res.Body.String() != "2345" || res.Header().Get("Content-Type") != "audio/mpeg" {
+		t.Fatalf("stream: status = %d, body = %q", res.Code, res.Body.String())
+	}
+	if response := decodeSubsonic(t, subsonicRequest(h, "stream", "f=json&id="+subsonicID("/Music/Artist A/Album X/notes.txt"))); response.Error == nil {
+		t.Fatal("streaming a text file should fail")
+	}
+
+	res = subsonicRequest(h, "getCoverArt", "id="+subsonicID("/Music/Artist A/Album X/02 Second Song.flac"))
+	if res.Code != http.StatusOK || res.Header().Get("Content-Type") != "image/jpeg" || res.Body.Len() == 0 {
+		t.Fatalf("cover: status = %d, type = %q", res.Code, res.Header().Get("Content-Type"))
+	}
+	res = subsonicRequest(h, "getCoverArt", "size=300&id="+subsonicID("/Music/Artist A/Album X"))
+	if config, err := jpeg.DecodeConfig(res.Body); err != nil || config.Width != 320 {
+		t.Fatalf("scaled cover: status = %d, config = %+v, err = %v", res.Code, config, err)
+	}
+	if response := decodeSubsonic(t, subsonicRequest(h, "getCoverArt", "f=json&id="+subsonicID("/Music/zed"))); response.Error == nil || response.Error.Code != subsonicErrorNotFound {
+		t.Fatalf("missing cover = %+v", response)
+	}
+}
+
+func TestSubsonicSearch3ClassifiesDirectories(t *testing.T) {
+	h := newContentTestHandler(t)
+	writeSubsonicLibrary(t, h)
+
+	result := decodeSubsonic(t, subsonicRequest(h, "search3", "f=json&query=a")).SearchResult3
+	var got []string
+	for _, artist := range result.Artist {
+		got = append(got, "artist:"+artist.Name)
+	}
+	for _, album := range result.Album {
+		got = append(got, "album:"+album.Name+"/"+album.Artist)
+	}
+	for _, song := range result.Song {
+		got = append(got, "song:"+song.Title)
+	}
+	for _, want := range []string{"artist:Artist A", "artist:The Beatles", "album:Album X/Artist A", "album:Abbey Road/The Beatles", "song:Second Song"} {
+		if !strings.Contains(","+strings.Join(got, ",")+",", ","+want+",") {
+			t.Fatalf("results %v lack %s", got, want)
+		}
+	}
+	if strings.Contains(strings.Join(got, ","), "gone") {
+		t.Fatalf("results include trashed items: %v", got)
+	}
+
+	all := decodeSubsonic(t, subsonicRequest(h, "search3", `f=json&query=""&artistCount=0&albumCount=0&songCount=2&songOffset=1`)).SearchResult3
+	if len(all.Artist) != 0 || len(all.Album) != 0 || len(all.Song) != 2 {
+		t.Fatalf("paged results = %+v", all)
+	}
+}
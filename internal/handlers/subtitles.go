@@ -0,0 +1,520 @@
+package handlers
+
+// Subtitle tracks for the media player. Browsers only accept WebVTT, so
+// SubRip and Advanced SubStation files next to a video are converted when
+// requested, and text streams embedded in the video are extracted to WebVTT
+// with ffmpeg and kept in the thumbnail cache.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+
+	"puremania/internal/worker"
+)
+
+const (
+	maxSubtitleBytes       = 10 << 20
+	subtitleExtractTimeout = 5 * time.Minute
+)
+
+// subtitleFormats maps sidecar extensions to their format.
+var subtitleFormats = map[string]string{".srt": "srt", ".ass": "ass", ".ssa": "ass", ".vtt": "vtt"}
+
+// textSubtitleCodecs are the embedded formats ffmpeg converts to WebVTT.
+// Bitmap subtitles such as PGS and DVD subpictures would need OCR.
+var textSubtitleCodecs = map[string]bool{"subrip": true, "srt": true, "ass": true, "ssa": true, "mov_text": true, "webvtt": true, "text": true}
+
+// subtitleLanguage matches a language suffix such as "ja", "eng", or "pt-BR".
+var subtitleLanguage = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})?$`)
+
+type subtitleTrack struct {
+	Label    string `json:"label"`
+	Language string `json:"language,omitempty"`
+	Format   string `json:"format"`
+	Source   string `json:"source"`
+	Path     string `json:"path"`
+	Stream   *int   `json:"stream,omitempty"`
+	Default  bool   `json:"default,omitempty"`
+	URL      string `json:"url"`
+}
+
+// sidecarSubtitles lists the subtitle files named after a video, such as
+// movie.srt, movie.ja.srt, or movie.en.forced.ass for movie.mp4.
+func (h *Handler) sidecarSubtitles(videoPath, fullPath string) ([]subtitleTrack, error) {
+	entries, err := os.ReadDir(filepath.Dir(fullPath))
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(filepath.Base(fullPath), filepath.Ext(fullPath))
+	virtualDir := filepath.ToSlash(filepath.Dir(videoPath))
+	tracks := []subtitleTrack{}
+	for _, entry := range entries {
+		name := entry.Name()
+		format := subtitleFormats[strings.ToLower(filepath.Ext(name))]
+		if format == "" || !entry.Type().IsRegular() || isInternalName(name) {
+			continue
+		}
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		suffix, found := strings.CutPrefix(stem, base+".")
+		if !found {
+			if stem != base {
+				continue
+			}
+			suffix = ""
+		}
+		track := subtitleTrack{Label: suffix, Format: format, Source: "sidecar", Path: filepath.ToSlash(filepath.Join(virtualDir, name))}
+		if language, _, _ := strings.Cut(suffix, "."); subtitleLanguage.MatchString(language) {
+			track.Language = language
+		}
+		if track.Label == "" {
+			track.Label = name
+		}
+		track.URL = "/api/files/subtitles/vtt?" + url.Values{"path": {track.Path}}.Encode()
+		tracks = append(tracks, track)
+	}
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].Path < tracks[j].Path })
+	return tracks, nil
+}
+
+// embeddedSubtitles lists the text subtitle streams of a probed video.
+func embeddedSubtitles(videoPath string, info mediaInfo) []subtitleTrack {
+	tracks := []subtitleTrack{}
+	for _, stream := range info.Streams {
+		if stream.Type != "subtitle" || !textSubtitleCodecs[stream.Codec] {
+			continue
+		}
+		index := stream.Index
+		track := subtitleTrack{Label: stream.Title, Language: stream.Language, Format: stream.Codec, Source: "embedded", Path: videoPath, Stream: &index, Default: stream.Default}
+		if track.Language == "und" {
+			track.Language = ""
+		}
+		if track.Label == "" {
+			track.Label = fmt.Sprintf("Track %d", index)
+			if track.Language != "" {
+				track.Label += " (" + track.Language + ")"
+			}
+		}
+		track.URL = "/api/files/subtitles/vtt?" + url.Values{"path": {videoPath}, "stream": {strconv.Itoa(index)}}.Encode()
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+// decodeSubtitleText returns subtitle text as UTF-8 with LF line endings.
+// Files without a byte order mark that are not UTF-8 are read as Shift_JIS,
+// the usual encoding of older Japanese subtitles.
+func decodeSubtitleText(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		data = data[3:]
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}), bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		if decoded, err := unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder().Bytes(data); err == nil {
+			data = decoded
+		}
+	case !utf8.Valid(data):
+		if decoded, err := japanese.ShiftJIS.NewDecoder().Bytes(data); err == nil {
+			data = decoded
+		}
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\r", "\n")
+}
+
+var (
+	srtTiming = regexp.MustCompile(`^\s*(\d+):(\d{1,2}):(\d{1,2})[,.](\d{1,3})\s*-->\s*(\d+):(\d{1,2}):(\d{1,2})[,.](\d{1,3})`)
+	cueMarkup = regexp.MustCompile(`</?[A-Za-z][^<>]*>|[<>]`)
+	cueTag    = regexp.MustCompile(`^</?([bBiIuU])(\s[^>]*)?>$`)
+)
+
+// subtitleSeconds converts hours, minutes, seconds, and a decimal fraction.
+func subtitleSeconds(hours, minutes, seconds, fraction string) float64 {
+	h, _ := strconv.Atoi(hours)
+	m, _ := strconv.Atoi(minutes)
+	s, _ := strconv.Atoi(seconds)
+	f, _ := strconv.Atoi(fraction)
+	scale := 1.0
+	for range fraction {
+		scale *= 10
+	}
+	return float64(h*3600+m*60+s) + float64(f)/scale
+}
+
+// escapeCueText escapes text for a WebVTT cue. With keepTags, the bold,
+// italic, and underline tags that SubRip shares with WebVTT are kept and
+// other tags such as <font> are dropped.
+func escapeCueText(text string, keepTags bool) string {
+	text = strings.ReplaceAll(text, "&", "&amp;")
+	text = cueMarkup.ReplaceAllStringFunc(text, func(markup string) string {
+		switch markup {
+		case "<":
+			return "&lt;"
+		case ">":
+			return "&gt;"
+		}
+		if !keepTags {
+			return strings.NewReplacer("<", "&lt;", ">", "&gt;").Replace(markup)
+		}
+		if match := cueTag.FindStringSubmatch(markup); match != nil {
+			if strings.HasPrefix(markup, "</") {
+				return "</" + strings.ToLower(match[1]) + ">"
+			}
+			return "<" + strings.ToLower(match[1]) + ">"
+		}
+		return ""
+	})
+	// A blank line would end the cue early.
+	lines := strings.Split(text, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// srtToVTT converts SubRip to WebVTT. Cue numbers are dropped and each cue
+// ends at the first blank line after its timing.
+func srtToVTT(text string) string {
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n")
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		match := srtTiming.FindStringSubmatch(lines[i])
+		if match == nil {
+			continue
+		}
+		var cue []string
+		for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+			i++
+			cue = append(cue, lines[i])
+		}
+		body := escapeCueText(strings.Join(cue, "\n"), true)
+		if body == "" {
+			continue
+		}
+		start := subtitleSeconds(match[1], match[2], match[3], match[4])
+		end := subtitleSeconds(match[5], match[6], match[7], match[8])
+		fmt.Fprintf(&vtt, "\n%s --> %s\n%s\n", vttTimestamp(start), vttTimestamp(end), body)
+	}
+	return vtt.String()
+}
+
+var (
+	assTime     = regexp.MustCompile(`^(\d+):(\d{1,2}):(\d{1,2})(?:\.(\d{1,3}))?$`)
+	assOverride = regexp.MustCompile(`\{[^}]*\}`)
+	assDrawing  = regexp.MustCompile(`\\p[1-9]`)
+)
+
+type subtitleCue struct {
+	start, end float64
+	text       string
+}
+
+// assToVTT converts the dialogue of an Advanced SubStation or SubStation
+// Alpha script to WebVTT. Styling and positioning are dropped, and vector
+// drawings, which have no text, are skipped.
+func assToVTT(text string) string {
+	section := ""
+	fields := []string{"layer", "start", "end", "style", "name", "marginl", "marginr", "marginv", "effect", "text"}
+	var cues []subtitleCue
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			section = strings.ToLower(line)
+			continue
+		}
+		if section != "[events]" {
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "format":
+			fields = nil
+			for _, field := range strings.Split(value, ",") {
+				fields = append(fields, strings.ToLower(strings.TrimSpace(field)))
+			}
+		case "dialogue":
+			// The text is the last field and may contain commas.
+			values := strings.SplitN(value, ",", len(fields))
+			if len(values) != len(fields) {
+				continue
+			}
+			var start, end, body string
+			for i, field := range fields {
+				switch field {
+				case "start":
+					start = strings.TrimSpace(values[i])
+				case "end":
+					end = strings.TrimSpace(values[i])
+				case "text":
+					body = values[i]
+				}
+			}
+			startMatch, endMatch := assTime.FindStringSubmatch(start), assTime.FindStringSubmatch(end)
+			if startMatch == nil || endMatch == nil || assDrawing.MatchString(body) {
+				continue
+			}
+			body = assOverride.ReplaceAllString(body, "")
+			body = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(body)
+			body = escapeCueText(strings.TrimSpace(body), false)
+			if body == "" {
+				continue
+			}
+			cues = append(cues, subtitleCue{
+				start: subtitleSeconds(startMatch[1], startMatch[2], startMatch[3], startMatch[4]),
+				end:   subtitleSeconds(endMatch[1], endMatch[2], endMatch[3], endMatch[4]),
+				text:  body,
+			})
+		}
+	}
+	// Scripts are not required to list events in time order; WebVTT is.
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].start < cues[j].start })
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		fmt.Fprintf(&vtt, "\n%s --> %s\n%s\n", vttTimestamp(cue.start), vttTimestamp(cue.end), cue.text)
+	}
+	return vtt.String()
+}
+
+// convertSubtitle returns a sidecar subtitle file as WebVTT.
+func convertSubtitle(data []byte, format string) (string, error) {
+	text := decodeSubtitleText(data)
+	switch format {
+	case "srt":
+		return srtToVTT(text), nil
+	case "ass":
+		return assToVTT(text), nil
+	case "vtt":
+		if !strings.HasPrefix(text, "WEBVTT") {
+			return "", fmt.Errorf("not a WebVTT file")
+		}
+		return text, nil
+	}
+	return "", fmt.Errorf("unsupported subtitle format %q", format)
+}
+
+// extractSubtitle converts one embedded subtitle stream to WebVTT.
+func extractSubtitle(ctx context.Context, videoPath, outputPath string, stream int, extraFiles ...*os.File) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-i", videoPath, "-map", "0:"+strconv.Itoa(stream), "-c:s", "webvtt", "-f", "webvtt", "-y", outputPath)
+	cmd.ExtraFiles = extraFiles
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("ffmpeg command timed out")
+		}
+		return fmt.Errorf("failed to extract subtitles: %w. Output: %s", err, string(output))
+	}
+	return nil
+}
+
+// subtitlePath names the extracted track of one stream of one file version.
+func (c *thumbnailCache) subtitlePath(virtualPath string, info os.FileInfo, stream int) string {
+	return filepath.Join(c.dir, fileVersionHash(virtualPath, info, "subtitle-"+strconv.Itoa(stream))+".vtt")
+}
+
+// ensureEmbeddedSubtitle returns the cached WebVTT track of a stream,
+// extracting it first when needed.
+func (h *Handler) ensureEmbeddedSubtitle(ctx context.Context, fullPath, trackPath string, source *os.File, stream int) error {
+	ctx, cancel := context.WithTimeout(ctx, subtitleExtractTimeout)
+	defer cancel()
+	tmp, err := os.CreateTemp(h.thumbnails.dir, ".thumbnail-*.vtt")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+	videoPath, extraFiles := childProcessFilePath(source, fullPath)
+	if err := extractSubtitle(ctx, videoPath, tmpPath, stream, extraFiles...); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, trackPath); err != nil {
+		return err
+	}
+	if err := h.thumbnails.cleanupIfDue(true); err != nil {
+		h.logger.Warn("Failed to enforce thumbnail cache limit", "path", h.thumbnails.dir, "error", err)
+	}
+	return nil
+}
+
+// Subtitles lists the sidecar subtitle files and embedded text subtitle
+// streams of a video. Embedded streams are omitted when ffprobe cannot read
+// the video.
+func (h *Handler) Subtitles(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		h.respondError(w, "Path required", http.StatusBadRequest)
+		return
+	}
+	if len(path) > maxVirtualPathBytes {
+		h.respondError(w, "Path is too long", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(mediaTypeByPath(path), "video/") {
+		h.respondError(w, "Subtitles are only available for videos", http.StatusBadRequest)
+		return
+	}
+	fullPath, err := h.convertToPhysicalPath(path)
+	if err != nil {
+		h.respondError(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	source, err := h.openAllowedPath(fullPath, os.O_RDONLY, 0)
+	if err != nil {
+		h.respondError(w, "Cannot open file", http.StatusNotFound)
+		return
+	}
+	defer func() { _ = source.Close() }()
+	info, err := source.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		h.respondError(w, "Cannot inspect file", http.StatusBadRequest)
+		return
+	}
+
+	tracks, err := h.sidecarSubtitles(path, fullPath)
+	if err != nil {
+		h.logger.Error("Failed to list subtitles", "path", fullPath, "error", err)
+		h.respondError(w, "Cannot read directory", http.StatusInternalServerError)
+		return
+	}
+	if probed, err := h.cachedMediaInfo(r.Context(), path, fullPath, source, info); err == nil {
+		tracks = append(tracks, embeddedSubtitles(path, probed)...)
+	} else {
+		h.logger.Debug("Skipping embedded subtitles", "path", fullPath, "error", err)
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	h.respondSuccess(w, tracks)
+}
+
+// SubtitleTrack serves a subtitle as WebVTT: a sidecar file given by path,
+// or embedded stream number stream of the video given by path.
+func (h *Handler) SubtitleTrack(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		h.respondError(w, "Path required", http.StatusBadRequest)
+		return
+	}
+	if len(path) > maxVirtualPathBytes {
+		h.respondError(w, "Path is too long", http.StatusBadRequest)
+		return
+	}
+	stream := -1
+	if value := r.URL.Query().Get("stream"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			h.respondError(w, "Invalid stream", http.StatusBadRequest)
+			return
+		}
+		stream = parsed
+	}
+	format := subtitleFormats[strings.ToLower(filepath.Ext(path))]
+	if stream < 0 && format == "" {
+		h.respondError(w, "Not a subtitle file", http.StatusBadRequest)
+		return
+	}
+	if stream >= 0 && !strings.HasPrefix(mediaTypeByPath(path), "video/") {
+		h.respondError(w, "Subtitle streams are only available for videos", http.StatusBadRequest)
+		return
+	}
+	fullPath, err := h.convertToPhysicalPath(path)
+	if err != nil {
+		h.respondError(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	source, err := h.openAllowedPath(fullPath, os.O_RDONLY, 0)
+	if err != nil {
+		h.respondError(w, "Cannot open file", http.StatusNotFound)
+		return
+	}
+	defer func() { _ = source.Close() }()
+	info, err := source.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		h.respondError(w, "Cannot inspect file", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if stream < 0 {
+		if info.Size() > maxSubtitleBytes {
+			h.respondError(w, "Subtitle file is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(source, maxSubtitleBytes))
+		if err != nil {
+			h.respondError(w, "Cannot read file", http.StatusInternalServerError)
+			return
+		}
+		track, err := convertSubtitle(data, format)
+		if err != nil {
+			h.respondError(w, "Cannot convert subtitles: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+		_, _ = io.WriteString(w, track)
+		return
+	}
+
+	if err := h.thumbnails.ensureDir(); err != nil {
+		h.logger.Error("Failed to create thumbnail directory", "path", h.thumbnails.dir, "error", err)
+		h.respondError(w, "Cannot create thumbnail directory", http.StatusInternalServerError)
+		return
+	}
+	trackPath := h.thumbnails.subtitlePath(path, info, stream)
+	if !h.thumbnails.lookup(trackPath) {
+		probed, err := h.cachedMediaInfo(r.Context(), path, fullPath, source, info)
+		if errors.Is(err, errProbeBusy) {
+			respondBusy(w)
+			return
+		}
+		if err != nil {
+			h.respondError(w, "Cannot read media information", http.StatusUnprocessableEntity)
+			return
+		}
+		found := false
+		for _, track := range embeddedSubtitles(path, probed) {
+			found = found || *track.Stream == stream
+		}
+		if !found {
+			h.respondError(w, "Stream is not a text subtitle", http.StatusBadRequest)
+			return
+		}
+		if !tryAcquire(h.thumbnailGate) {
+			respondBusy(w)
+			return
+		}
+		result := <-worker.SubmitWithResult(h.workerPool, func() interface{} {
+			return h.ensureEmbeddedSubtitle(r.Context(), fullPath, trackPath, source, stream)
+		})
+		release(h.thumbnailGate)
+		if err, _ := result.(error); err != nil {
+			h.logger.Error("Failed to extract subtitles", "path", fullPath, "stream", stream, "error", err)
+			h.respondError(w, "Cannot extract subtitles", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	http.ServeFile(w, r, trackPath)
+}
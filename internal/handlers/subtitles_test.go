@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestSRTToVTT(t *testing.T) {
+	srt := "\uFEFF1\r\n0:00:01,5 --> 00:00:02,250 X1:10 X2:20\r\n<font color=\"red\"><i>Tom & Jerry</i></font>\r\nsecond line\r\n\r\n2\r\n00:01:00,000 --> 00:01:01,000\r\n\r\n3\r\n01:00:00.000 --> 01:00:02.000\r\na < b -->\r\n"
+	want := "WEBVTT\n\n00:00:01.500 --> 00:00:02.250\n<i>Tom &amp; Jerry</i>\nsecond line\n\n01:00:00.000 --> 01:00:02.000\na &lt; b --&gt;\n"
+	if got := srtToVTT(decodeSubtitleText([]byte(srt))); got != want {
+		t.Fatalf("srtToVTT =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestASSToVTT(t *testing.T) {
+	ass := `[Script Info]
+Title: sample
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: 0,0:00:05.00,0:00:06.50,Default,,0,0,0,,{\i1}Later{\i0}, line\Nbreak
+Comment: 0,0:00:00.00,0:00:09.00,Default,,0,0,0,,not shown
+Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,{\pos(10,10)}First\hword
+Dialogue: 0,0:00:03.00,0:00:04.00,Default,,0,0,0,,{\p1}m 0 0 l 100 0 100 100{\p0}
+`
+	want := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nFirst word\n\n00:00:05.000 --> 00:00:06.500\nLater, line\nbreak\n"
+	if got := assToVTT(ass); got != want {
+		t.Fatalf("assToVTT =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestDecodeSubtitleTextEncodings(t *testing.T) {
+	shiftJIS, err := japanese.ShiftJIS.NewEncoder().String("こんにちは\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeSubtitleText([]byte(shiftJIS)); got != "こんにちは\n" {
+		t.Fatalf("Shift_JIS = %q", got)
+	}
+	utf16 := []byte{0xFF, 0xFE, 'h', 0, 'i', 0, '\r', 0, '\n', 0}
+	if got := decodeSubtitleText(utf16); got != "hi\n" {
+		t.Fatalf("UTF-16 = %q", got)
+	}
+}
+
+const subtitleProbeOutput = `{"format":{"format_name":"matroska,webm","duration":"60.0"},"streams":[
+{"index":0,"codec_type":"video","codec_name":"h264","width":640,"height":360},
+{"index":2,"codec_type":"subtitle","codec_name":"subrip","tags":{"language":"eng","title":"English"},"disposition":{"default":1}},
+{"index":3,"codec_type":"subtitle","codec_name":"hdmv_pgs_subtitle","tags":{"language":"jpn"}}]}`
+
+func TestSubtitlesListsSidecarsAndEmbeddedStreams(t *testing.T) {
+	probe := filepath.Join(t.TempDir(), "probe.json")
+	writeTestFile(t, probe, subtitleProbeOutput)
+	installFakeCommands(t, map[string]string{"ffprobe": "cat '" + probe + "'\n"})
+	h := newContentTestHandler(t)
+	dir := filepath.Join(h.config.StorageDir, "movies")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"movie.mkv", "movie.srt", "movie.ja.srt", "movie.en.forced.ass", "movie2.srt", "other.srt", "movie.txt"} {
+		writeTestFile(t, filepath.Join(dir, name), "x")
+	}
+
+	res := httptest.NewRecorder()
+	h.Subtitles(res, httptest.NewRequest(http.MethodGet, "/api/files/subtitles?path=/movies/movie.mkv", nil))
+	var body struct {
+		Data []subtitleTrack `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil || res.Code != http.StatusOK {
+		t.Fatalf("status = %d, err = %v", res.Code, err)
+	}
+	var got []string
+	for _, track := range body.Data {
+		got = append(got, track.Source+":"+track.Label+":"+track.Language)
+	}
+	want := []string{"sidecar:en.forced:en", "sidecar:ja:ja", "sidecar:movie.srt:", "embedded:English:eng"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("tracks = %v, want %v", got, want)
+	}
+	if embedded := body.Data[3]; embedded.Stream == nil || *embedded.Stream != 2 || !embedded.Default || embedded.URL != "/api/files/subtitles/vtt?path=%2Fmovies%2Fmovie.mkv&stream=2" {
+		t.Fatalf("embedded track = %+v", embedded)
+	}
+}
+
+func TestSubtitleTrackConvertsSidecarsAndCachesEmbeddedStreams(t *testing.T) {
+	probe := filepath.Join(t.TempDir(), "probe.json")
+	writeTestFile(t, probe, subtitleProbeOutput)
+	calls := filepath.Join(t.TempDir(), "calls")
+	// The output path is the last argument.
+	installFakeCommands(t, map[string]string{
+		"ffprobe": "cat '" + probe + "'\n",
+		"ffmpeg":  "echo run >> '" + calls + "'\nfor last; do :; done\nprintf 'WEBVTT\\n\\n00:00:01.000 --> 00:00:02.000\\nembedded\\n' > \"$last\"\n",
+	})
+	h := newContentTestHandler(t)
+	h.thumbnails.dir = t.TempDir()
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "movie.mkv"), "video")
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "movie.ja.srt"), "1\n00:00:01,000 --> 00:00:02,000\nこんにちは\n")
+
+	request := func(query string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		h.SubtitleTrack(res, httptest.NewRequest(http.MethodGet, "/api/files/subtitles/vtt?"+query, nil))
+		return res
+	}
+	res := request("path=/movie.ja.srt")
+	if res.Code != http.StatusOK || res.Header().Get("Content-Type") != "text/vtt; charset=utf-8" || !strings.Contains(res.Body.String(), "00:00:01.000 --> 00:00:02.000\nこんにちは") {
+		t.Fatalf("sidecar: status = %d, body = %q", res.Code, res.Body.String())
+	}
+	for i := 0; i < 2; i++ {
+		res = request("path=/movie.mkv&stream=2")
+		if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), "embedded") {
+			t.Fatalf("embedded: status = %d, body = %q", res.Code, res.Body.String())
+		}
+	}
+	if data, err := os.ReadFile(calls); err != nil || strings.Count(string(data), "run") != 1 {
+		t.Fatalf("ffmpeg ran %q times (err %v), want once", data, err)
+	}
+	for query, status := range map[string]int{"path=/movie.mkv&stream=3": http.StatusBadRequest, "path=/movie.mkv": http.StatusBadRequest, "path=/movie.ja.srt&stream=2": http.StatusBadRequest} {
+		if res := request(query); res.Code != status {
+			t.Fatalf("%s: status = %d, want %d", query, res.Code, status)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"puremania/internal/types"
+)
+
+const (
+	defaultThumbnailDir            = ".cache/thumbnails"
+	defaultThumbnailMaxBytes int64 = 256 << 20
+	defaultThumbnailMaxFiles       = 4096
+	thumbnailCleanupInterval       = 5 * time.Minute
+	thumbnailTempTTL               = 10 * time.Minute
+	// thumbnailTouchInterval limits how often a hit rewrites the access time
+	// of a cached thumbnail.
+	thumbnailTouchInterval = time.Minute
+)
+
+// thumbnailCache is the on-disk store of generated thumbnails. The
+// modification time of each entry records its last access, because atime is
+// commonly disabled or coarsened by noatime and relatime mounts; cleanup then
+// evicts the least recently used entries first.
+type thumbnailCache struct {
+	dir         string
+	maxBytes    int64
+	maxFiles    int
+	hits        atomic.Int64
+	misses      atomic.Int64
+	cleanupMu   sync.Mutex
+	lastCleanup time.Time
+}
+
+// newThumbnailCache applies the configured location and limits, falling back
+// to the defaults for settings left at zero.
+func newThumbnailCache(config *types.Config) *thumbnailCache {
+	c := &thumbnailCache{dir: config.ThumbnailDir, maxBytes: config.ThumbnailMaxSizeMB << 20, maxFiles: config.ThumbnailMaxFiles}
+	if c.dir == "" {
+		c.dir = defaultThumbnailDir
+	}
+	if c.maxBytes <= 0 {
+		c.maxBytes = defaultThumbnailMaxBytes
+	}
+	if c.maxFiles <= 0 {
+		c.maxFiles = defaultThumbnailMaxFiles
+	}
+	return c
+}
+
+// fileVersionHash identifies one version of a file together with a variant
+// such as a thumbnail size. Including the size and modification time means
+// replacing a file never reuses data derived from its previous content.
+func fileVersionHash(virtualPath string, info os.FileInfo, variant string) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d:%s", virtualPath, info.Size(), info.ModTime().UnixNano(), variant)))
+	return hex.EncodeToString(hash[:])
+}
+
+// path names the cached thumbnail of one version of a file.
+func (c *thumbnailCache) path(virtualPath string, info os.FileInfo, size int) string {
+	return filepath.Join(c.dir, fileVersionHash(virtualPath, info, strconv.Itoa(size))+".jpg")
+}
+
+// lookup reports whether a thumbnail is cached, counting the hit or miss and
+// marking a hit as recently used.
+func (c *thumbnailCache) lookup(thumbnailPath string) bool {
+	if !c.touch(thumbnailPath) {
+		c.misses.Add(1)
+		return false
+	}
+	c.hits.Add(1)
+	return true
+}
+
+// touch reports whether an entry is cached and marks it as recently used,
+// without counting toward the hit rate. Every path that reuses an entry goes
+// through it, so eviction never removes entries that are still in use.
+func (c *thumbnailCache) touch(cachedPath string) bool {
+	info, err := os.Stat(cachedPath)
+	if err != nil {
+		return false
+	}
+	if now := time.Now(); now.Sub(info.ModTime()) >= thumbnailTouchInterval {
+		_ = os.Chtimes(cachedPath, now, now)
+	}
+	return true
+}
+
+func (c *thumbnailCache) ensureDir() error {
+	return os.MkdirAll(c.dir, 0755)
+}
+
+func (c *thumbnailCache) cleanupIfDue(force bool) error {
+	c.cleanupMu.Lock()
+	defer c.cleanupMu.Unlock()
+
+	now := time.Now()
+	if !force && now.Sub(c.lastCleanup) < thumbnailCleanupInterval {
+		return nil
+	}
+	c.lastCleanup = now
+	return cleanupThumbnailCache(c.dir, c.maxBytes, c.maxFiles)
+}
+
+// full reports whether one more thumbnail would take the cache past a limit.
+func (c *thumbnailCache) full() (bool, error) {
+	files, bytes, err := c.usage()
+	if err != nil {
+		return false, err
+	}
+	return files >= c.maxFiles || bytes >= c.maxBytes, nil
+}
+
+// usage counts the finished thumbnails in the cache.
+func (c *thumbnailCache) usage() (int, int64, error) {
+	entries, err := readThumbnailCache(c.dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var bytes int64
+	for _, entry := range entries {
+		bytes += entry.size
+	}
+	return len(entries), bytes, nil
+}
+
+// purge removes every finished thumbnail. Thumbnails being generated are
+// left to finish.
+func (c *thumbnailCache) purge() (int, int64, error) {
+	entries, err := readThumbnailCache(c.dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	removed, bytes := 0, int64(0)
+	for _, entry := range entries {
+		if err := os.Remove(entry.path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, bytes, err
+		}
+		removed++
+		bytes += entry.size
+	}
+	return removed, bytes, nil
+}
+
+type thumbnailCacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func isThumbnailTempName(name string) bool {
+	return strings.HasPrefix(name, ".thumbnail-")
+}
+
+// readThumbnailCache lists the finished thumbnails and storyboards in dir. A
+// missing directory is an empty cache.
+func readThumbnailCache(dir string) ([]thumbnailCacheEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cacheEntries := make([]thumbnailCacheEntry, 0, len(entries))
+	for _, entry := range entries {
+		// Storyboards add a .vtt track next to their .jpg sprite sheet.
+		if isThumbnailTempName(entry.Name()) || !(strings.HasSuffix(entry.Name(), ".jpg") || strings.HasSuffix(entry.Name(), ".vtt")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		cacheEntries = append(cacheEntries, thumbnailCacheEntry{
+			path:    filepath.Join(dir, entry.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	return cacheEntries, nil
+}
+
+// cleanupThumbnailCache removes stale temporary files, then evicts the least
+// recently used thumbnails until the cache is within both limits.
+func cleanupThumbnailCache(dir string, maxBytes int64, maxFiles int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if !isThumbnailTempName(entry.Name()) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && now.Sub(info.ModTime()) >= thumbnailTempTTL {
+			_ = os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	cacheEntries, err := readThumbnailCache(dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(cacheEntries, func(i, j int) bool {
+		return cacheEntries[i].modTime.Before(cacheEntries[j].modTime)
+	})
+
+	var totalBytes int64
+	for _, entry := range cacheEntries {
+		totalBytes += entry.size
+	}
+	for len(cacheEntries) > 0 && (totalBytes > maxBytes || len(cacheEntries) > maxFiles) {
+		oldest := cacheEntries[0]
+		cacheEntries = cacheEntries[1:]
+		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		totalBytes -= oldest.size
+	}
+	return nil
+}
+
+type thumbnailStats struct {
+	Entries    int   `json:"entries"`
+	Bytes      int64 `json:"bytes"`
+	MaxEntries int   `json:"maxEntries"`
+	MaxBytes   int64 `json:"maxBytes"`
+	Hits       int64 `json:"hits"`
+	Misses     int64 `json:"misses"`
+}
+
+// ThumbnailStats reports the size of the thumbnail cache and the hit and miss
+// counts of thumbnail requests since the server started.
+func (h *Handler) ThumbnailStats(w http.ResponseWriter, r *http.Request) {
+	entries, bytes, err := h.thumbnails.usage()
+	if err != nil {
+		h.logger.Error("Failed to read thumbnail cache", "path", h.thumbnails.dir, "error", err)
+		h.respondError(w, "Cannot read thumbnail cache", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	h.respondSuccess(w, thumbnailStats{
+		Entries:    entries,
+		Bytes:      bytes,
+		MaxEntries: h.thumbnails.maxFiles,
+		MaxBytes:   h.thumbnails.maxBytes,
+		Hits:       h.thumbnails.hits.Load(),
+		Misses:     h.thumbnails.misses.Load(),
+	})
+}
+
+// PurgeThumbnails empties the thumbnail cache.
+func (h *Handler) PurgeThumbnails(w http.ResponseWriter, r *http.Request) {
+	removed, bytes, err := h.thumbnails.purge()
+	if err != nil {
+		h.logger.Error("Failed to purge thumbnail cache", "path", h.thumbnails.dir, "error", err)
+		h.respondError(w, "Cannot purge thumbnail cache", http.StatusInternalServerError)
+		return
+	}
+	h.logger.Info("Thumbnail cache purged", "entries", removed, "bytes", bytes)
+	h.respondSuccess(w, map[string]interface{}{"removed": removed, "bytes": bytes})
+}
@@ -1,10 +1,17 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"puremania/internal/types"
 )
 
 func TestCleanupThumbnailCacheRemovesOldestEntries(t *testing.T) {
@@ -59,3 +66,90 @@ func TestCleanupThumbnailCacheRemovesStaleTemporaryFiles(t *testing.T) {
 		t.Fatalf("stale temporary thumbnail still exists, stat error=%v", err)
 	}
 }
+
+func TestThumbnailCacheLookupKeepsRecentlyUsedEntries(t *testing.T) {
+	cache := newThumbnailCache(&types.Config{ThumbnailDir: t.TempDir(), ThumbnailMaxFiles: 1})
+	older := filepath.Join(cache.dir, "older.jpg")
+	newer := filepath.Join(cache.dir, "newer.jpg")
+	for i, file := range []string{older, newer} {
+		writeTestFile(t, file, "jpeg")
+		created := time.Now().Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(file, created, created); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !cache.lookup(older) || cache.lookup(filepath.Join(cache.dir, "missing.jpg")) {
+		t.Fatal("lookup did not report cached entries")
+	}
+	if err := cache.cleanupIfDue(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(older); err != nil {
+		t.Fatalf("recently used thumbnail was evicted: %v", err)
+	}
+	if _, err := os.Stat(newer); !os.IsNotExist(err) {
+		t.Fatalf("least recently used thumbnail still exists, stat error=%v", err)
+	}
+	if cache.hits.Load() != 1 || cache.misses.Load() != 1 {
+		t.Fatalf("hits=%d misses=%d", cache.hits.Load(), cache.misses.Load())
+	}
+}
+
+func TestThumbnailStatsAndPurge(t *testing.T) {
+	h := newContentTestHandler(t)
+	h.thumbnails.dir = t.TempDir()
+	writeTestFile(t, filepath.Join(h.thumbnails.dir, "a.jpg"), "12345")
+	writeTestFile(t, filepath.Join(h.thumbnails.dir, "b.jpg"), "123")
+	writeTestFile(t, filepath.Join(h.thumbnails.dir, ".thumbnail-123.jpg"), "in progress")
+	h.thumbnails.hits.Add(4)
+
+	var stats struct {
+		Data thumbnailStats `json:"data"`
+	}
+	res := httptest.NewRecorder()
+	h.ThumbnailStats(res, httptest.NewRequest(http.MethodGet, "/api/thumbnails/stats", nil))
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if want := (thumbnailStats{Entries: 2, Bytes: 8, MaxEntries: defaultThumbnailMaxFiles, MaxBytes: defaultThumbnailMaxBytes, Hits: 4}); stats.Data != want {
+		t.Fatalf("stats = %+v, want %+v", stats.Data, want)
+	}
+
+	res = httptest.NewRecorder()
+	h.PurgeThumbnails(res, httptest.NewRequest(http.MethodPost, "/api/thumbnails/purge", nil))
+	if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), `"removed":2`) {
+		t.Fatalf("purge: status = %d, body = %s", res.Code, res.Body.String())
+	}
+	entries, err := os.ReadDir(h.thumbnails.dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("purge left %d entries (err %v), want only the thumbnail in progress", len(entries), err)
+	}
+}
+
+func TestEnsureThumbnailMarksCachedEntriesUsed(t *testing.T) {
+	h := newContentTestHandler(t)
+	h.thumbnails.dir = t.TempDir()
+	source := filepath.Join(h.config.StorageDir, "a.jpg")
+	writeTestFile(t, source, "jpeg")
+	info, err := os.Stat(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := h.thumbnails.path("/a.jpg", info, 160)
+	writeTestFile(t, cached, "thumbnail")
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(cached, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := h.ensureThumbnail(context.Background(), "/a.jpg", source, 160); err != nil || got != cached {
+		t.Fatalf("ensureThumbnail = %q, %v", got, err)
+	}
+	if touched, err := os.Stat(cached); err != nil || !touched.ModTime().After(old) {
+		t.Fatalf("cached thumbnail was not marked as used: %v", err)
+	}
+	if h.thumbnails.hits.Load() != 0 {
+		t.Fatal("reuse inside ensureThumbnail was counted as a request hit")
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// Decoders registered for image.Decode.
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	defaultThumbnailSize = 320
+	// maxThumbnailSourcePixels bounds the decoded image, which is held in
+	// memory at up to four bytes per pixel.
+	maxThumbnailSourcePixels = 64 << 20
+	thumbnailJPEGQuality     = 80
+)
+
+// thumbnailSizes are the longest-side lengths clients may request.
+var thumbnailSizes = map[int]bool{160: true, 320: true, 640: true}
+
+// nativeThumbnailExtensions are decoded in-process instead of through ffmpeg.
+var nativeThumbnailExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true}
+
+func isNativeThumbnail(path string) bool {
+	return nativeThumbnailExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// generateImageThumbnail decodes an image, scales it to fit within size x size
+// without enlarging it, applies its EXIF orientation, and writes a JPEG.
+func generateImageThumbnail(source *os.File, thumbnailPath string, size int) error {
+	orientation := jpegOrientation(source)
+	if _, err := source.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	config, _, err := image.DecodeConfig(bufio.NewReader(source))
+	if err != nil {
+		return err
+	}
+	if int64(config.Width)*int64(config.Height) > maxThumbnailSourcePixels {
+		return fmt.Errorf("image is too large for a thumbnail: %dx%d", config.Width, config.Height)
+	}
+	if _, err := source.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// GIF decoding returns the first frame.
+	src, _, err := image.Decode(bufio.NewReader(source))
+	if err != nil {
+		return err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if longest := max(width, height); longest > size {
+		width = max(1, width*size/longest)
+		height = max(1, height*size/longest)
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	// JPEG has no alpha channel; transparent areas become white.
+	draw.Draw(scaled, scaled.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.BiLinear.Scale(scaled, scaled.Bounds(), src, bounds, draw.Over, nil)
+
+	output, err := os.Create(thumbnailPath)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(output, orientImage(scaled, orientation), &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+		_ = output.Close()
+		return err
+	}
+	return output.Close()
+}
+
+// orientImage returns img transformed as EXIF orientation 1-8 describes.
+func orientImage(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	out := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(out.Pix[out.PixOffset(x, y):out.PixOffset(x, y)+4], img.Pix[img.PixOffset(sx, sy):img.PixOffset(sx, sy)+4])
+		}
+	}
+	return out
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, or 1 when the file
+// is not a JPEG or carries no orientation.
+func jpegOrientation(r io.ReaderAt) int {
+	section := jpegExifSection(r)
+	if section == nil {
+		return 1
+	}
+	tiff := make([]byte, section.Size())
+	if _, err := section.ReadAt(tiff, 0); err != nil {
+		return 1
+	}
+	return tiffOrientation(tiff)
+}
+
+// tiffOrientation reads tag 0x0112 from the first IFD of a TIFF structure.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		// An orientation is a single SHORT stored inline.
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			if value := int(order.Uint16(tiff[entry+8:])); value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestImage(t *testing.T, path string, img image.Image, encode func(*bytes.Buffer, image.Image) error, prefix []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if prefix != nil {
+		// Insert the segment right after the JPEG SOI marker.
+		data = append(append(append([]byte(nil), data[:2]...), prefix...), data[2:]...)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func halfAndHalf(width, height int, left, right color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, left)
+			} else {
+				img.Set(x, y, right)
+			}
+		}
+	}
+	return img
+}
+
+// exifOrientationSegment builds a big-endian APP1 segment with one IFD entry.
+func exifOrientationSegment(orientation byte) []byte {
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0, 0, 0, 0, 0, 0, 0}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(payload) + 2
+	return append([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)}, payload...)
+}
+
+func decodeThumbnail(t *testing.T, path string) image.Image {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+	img, err := jpeg.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestImageThumbnailFitsRequestedSize(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "wide.png")
+	writeTestImage(t, source, halfAndHalf(800, 400, color.Black, color.White), func(b *bytes.Buffer, img image.Image) error { return png.Encode(b, img) }, nil)
+	file, err := os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	output := filepath.Join(dir, "thumb.jpg")
+	if err := generateImageThumbnail(file, output, 160); err != nil {
+		t.Fatal(err)
+	}
+	if bounds := decodeThumbnail(t, output).Bounds(); bounds.Dx() != 160 || bounds.Dy() != 80 {
+		t.Fatalf("thumbnail is %dx%d, want 160x80", bounds.Dx(), bounds.Dy())
+	}
+}
+
+func TestImageThumbnailAppliesEXIFOrientation(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "rotated.jpg")
+	red, blue := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}
+	writeTestImage(t, source, halfAndHalf(40, 20, red, blue), func(b *bytes.Buffer, img image.Image) error {
+		return jpeg.Encode(b, img, &jpeg.Options{Quality: 95})
+	}, exifOrientationSegment(6))
+	file, err := os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	output := filepath.Join(dir, "thumb.jpg")
+	if err := generateImageThumbnail(file, output, 320); err != nil {
+		t.Fatal(err)
+	}
+	thumb := decodeThumbnail(t, output)
+	if bounds := thumb.Bounds(); bounds.Dx() != 20 || bounds.Dy() != 40 {
+		t.Fatalf("thumbnail is %dx%d, want the rotated 20x40 without enlargement", bounds.Dx(), bounds.Dy())
+	}
+	// Rotating 90 degrees clockwise moves the left half to the top.
+	if r, _, b, _ := thumb.At(10, 5).RGBA(); r < b {
+		t.Fatalf("top of the rotated thumbnail is not red")
+	}
+	if r, _, b, _ := thumb.At(10, 35).RGBA(); b < r {
+		t.Fatalf("bottom of the rotated thumbnail is not blue")
+	}
+}
+
+func TestJPEGOrientationIgnoresFilesWithoutEXIF(t *testing.T) {
+	if got := jpegOrientation(bytes.NewReader([]byte("\x89PNG\r\n"))); got != 1 {
+		t.Fatalf("orientation = %d", got)
+	}
+	if got := tiffOrientation([]byte("II*\x00\xff\xff\xff\xff")); got != 1 {
+		t.Fatalf("orientation with an out-of-range IFD = %d", got)
+	}
+}
+
+func TestThumbnailServesSelectedSizes(t *testing.T) {
+	h := newContentTestHandler(t)
+	h.thumbnails.dir = t.TempDir()
+	writeTestImage(t, filepath.Join(h.config.StorageDir, "photo.png"), halfAndHalf(1000, 500, color.Black, color.White), func(b *bytes.Buffer, img image.Image) error { return png.Encode(b, img) }, nil)
+
+	for size, width := range map[string]int{"": 320, "160": 160, "640": 640} {
+		res := httptest.NewRecorder()
+		h.Thumbnail(res, httptest.NewRequest(http.MethodGet, "/api/files/thumbnail?path=/photo.png&size="+size, nil))
+		if res.Code != http.StatusOK || res.Header().Get("Content-Type") != "image/jpeg" {
+			t.Fatalf("size %q: status = %d, Content-Type = %q", size, res.Code, res.Header().Get("Content-Type"))
+		}
+		img, err := jpeg.Decode(res.Body)
+		if err != nil || img.Bounds().Dx() != width {
+			t.Fatalf("size %q: err = %v, width = %d", size, err, img.Bounds().Dx())
+		}
+	}
+	entries, err := os.ReadDir(h.thumbnails.dir)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("cache holds %d entries (err %v), want one per size", len(entries), err)
+	}
+
+	res := httptest.NewRecorder()
+	h.Thumbnail(res, httptest.NewRequest(http.MethodGet, "/api/files/thumbnail?path=/photo.png&size=100", nil))
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("unsupported size status = %d", res.Code)
+	}
+}
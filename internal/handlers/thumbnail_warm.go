@@ -0,0 +1,444 @@
+package handlers
+
+// Thumbnail warming. A single background crawler walks a directory tree and
+// generates thumbnails of every image and video in it ahead of time, so that
+// opening a large media folder is served from the cache instead of queueing
+// on thumbnailGate. The crawler only takes a gate slot while another one is
+// free for interactive requests, and it stops once the cache limits are
+// reached instead of evicting the thumbnails it has just generated.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"puremania/internal/types"
+)
+
+const thumbnailWarmBusyWait = 250 * time.Millisecond
+
+var (
+	errThumbnailWarmRunning = errors.New("thumbnail warming is already running")
+	errThumbnailCacheFull   = errors.New("thumbnail cache is full")
+)
+
+type thumbnailWarmState string
+
+const (
+	thumbnailWarmIdle      thumbnailWarmState = "idle"
+	thumbnailWarmRunning   thumbnailWarmState = "running"
+	thumbnailWarmPaused    thumbnailWarmState = "paused"
+	thumbnailWarmCompleted thumbnailWarmState = "completed"
+	thumbnailWarmFull      thumbnailWarmState = "full"
+	thumbnailWarmStopped   thumbnailWarmState = "stopped"
+	thumbnailWarmFailed    thumbnailWarmState = "failed"
+)
+
+type thumbnailWarmStatus struct {
+	State      thumbnailWarmState `json:"state"`
+	Paths      []string           `json:"paths,omitempty"`
+	Sizes      []int              `json:"sizes,omitempty"`
+	Current    string             `json:"current,omitempty"`
+	Scanned    int                `json:"scanned"`
+	Generated  int                `json:"generated"`
+	Cached     int                `json:"cached"`
+	Failed     int                `json:"failed"`
+	Error      string             `json:"error,omitempty"`
+	StartedAt  *time.Time         `json:"startedAt,omitempty"`
+	FinishedAt *time.Time         `json:"finishedAt,omitempty"`
+}
+
+func (s thumbnailWarmStatus) active() bool {
+	return s.State == thumbnailWarmRunning || s.State == thumbnailWarmPaused
+}
+
+type thumbnailWarmer struct {
+	mu     sync.Mutex
+	status thumbnailWarmStatus
+	resume chan struct{} // non-nil while paused; closed to resume
+	cancel context.CancelFunc
+	report func(path string, size int, err error) // optional, called after each generation
+}
+
+func newThumbnailWarmer() *thumbnailWarmer {
+	return &thumbnailWarmer{status: thumbnailWarmStatus{State: thumbnailWarmIdle}}
+}
+
+func (w *thumbnailWarmer) snapshot() thumbnailWarmStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+func (w *thumbnailWarmer) update(change func(*thumbnailWarmStatus)) {
+	w.mu.Lock()
+	change(&w.status)
+	w.mu.Unlock()
+}
+
+func (w *thumbnailWarmer) pause() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status.State != thumbnailWarmRunning {
+		return false
+	}
+	w.status.State = thumbnailWarmPaused
+	w.resume = make(chan struct{})
+	return true
+}
+
+func (w *thumbnailWarmer) unpause() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status.State != thumbnailWarmPaused {
+		return false
+	}
+	w.status.State = thumbnailWarmRunning
+	close(w.resume)
+	w.resume = nil
+	return true
+}
+
+func (w *thumbnailWarmer) stop() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.status.active() || w.cancel == nil {
+		return false
+	}
+	w.cancel()
+	return true
+}
+
+// waitWhilePaused blocks until the crawler is resumed or canceled.
+func (w *thumbnailWarmer) waitWhilePaused(ctx context.Context) error {
+	w.mu.Lock()
+	resume := w.resume
+	w.mu.Unlock()
+	if resume == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-resume:
+		return ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *thumbnailWarmer) finish(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now().UTC()
+	w.status.FinishedAt = &now
+	w.status.Current = ""
+	w.cancel = nil
+	if w.resume != nil {
+		close(w.resume)
+		w.resume = nil
+	}
+	switch {
+	case err == nil:
+		w.status.State = thumbnailWarmCompleted
+	case errors.Is(err, errThumbnailCacheFull):
+		w.status.State = thumbnailWarmFull
+	case errors.Is(err, context.Canceled):
+		w.status.State = thumbnailWarmStopped
+	default:
+		w.status.State = thumbnailWarmFailed
+		w.status.Error = err.Error()
+	}
+}
+
+func isThumbnailCandidate(name string) bool {
+	return isNativeThumbnail(name) || strings.HasPrefix(mediaTypeByPath(name), "video/")
+}
+
+// warmThumbnailRoots returns the virtual path of every configured root.
+func (h *Handler) warmThumbnailRoots() []string {
+	roots := []string{"/"}
+	for _, dir := range append(append([]string{}, h.config.MountDirs...), h.config.SpecificDirs...) {
+		roots = append(roots, "/"+filepath.Base(dir))
+	}
+	return roots
+}
+
+// acquireThumbnailSlot waits until a thumbnail slot can be taken while
+// another one stays free for interactive requests.
+func (h *Handler) acquireThumbnailSlot(ctx context.Context, w *thumbnailWarmer) error {
+	for {
+		if err := w.waitWhilePaused(ctx); err != nil {
+			return err
+		}
+		if len(h.thumbnailGate) < cap(h.thumbnailGate)-1 && tryAcquire(h.thumbnailGate) {
+			return nil
+		}
+		timer := time.NewTimer(thumbnailWarmBusyWait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// warmThumbnails generates the missing thumbnails below each virtual path.
+func (h *Handler) warmThumbnails(ctx context.Context, w *thumbnailWarmer, virtualPaths []string, sizes []int) error {
+	if err := h.thumbnails.ensureDir(); err != nil {
+		return err
+	}
+	roots := h.allowedRoots()
+	for _, virtualPath := range virtualPaths {
+		base, err := h.convertToPhysicalPath(virtualPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", virtualPath, err)
+		}
+		virtualBase := path.Clean("/" + virtualPath)
+		err = filepath.WalkDir(base, func(filePath string, entry os.DirEntry, walkErr error) error {
+			if err := w.waitWhilePaused(ctx); err != nil {
+				return err
+			}
+			if walkErr != nil {
+				h.logger.Warn("Skipping path while warming thumbnails", "path", filePath, "error", walkErr)
+				return nil
+			}
+			if entry.IsDir() {
+				if filePath != base && (isInternalName(entry.Name()) || isAllowedRoot(roots, filePath)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !entry.Type().IsRegular() || !isThumbnailCandidate(entry.Name()) {
+				return nil
+			}
+			relative, err := filepath.Rel(base, filePath)
+			if err != nil {
+				return nil
+			}
+			return h.warmThumbnail(ctx, w, path.Join(virtualBase, filepath.ToSlash(relative)), filePath, entry, sizes)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isAllowedRoot(roots []string, dir string) bool {
+	for _, root := range roots {
+		if root == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) warmThumbnail(ctx context.Context, w *thumbnailWarmer, virtualPath, fullPath string, entry os.DirEntry, sizes []int) error {
+	info, err := entry.Info()
+	if err != nil {
+		return nil
+	}
+	w.update(func(s *thumbnailWarmStatus) {
+		s.Scanned++
+		s.Current = virtualPath
+	})
+	for _, size := range sizes {
+		if h.thumbnails.touch(h.thumbnails.path(virtualPath, info, size)) {
+			w.update(func(s *thumbnailWarmStatus) { s.Cached++ })
+			continue
+		}
+		full, err := h.thumbnails.full()
+		if err != nil {
+			return err
+		}
+		if full {
+			return errThumbnailCacheFull
+		}
+		if err := h.acquireThumbnailSlot(ctx, w); err != nil {
+			return err
+		}
+		_, err = h.ensureThumbnail(ctx, virtualPath, fullPath, size)
+		release(h.thumbnailGate)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			h.logger.Debug("Failed to warm thumbnail", "path", fullPath, "error", err)
+			w.update(func(s *thumbnailWarmStatus) { s.Failed++ })
+		} else {
+			w.update(func(s *thumbnailWarmStatus) { s.Generated++ })
+		}
+		if w.report != nil {
+			w.report(virtualPath, size, err)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) startThumbnailWarm(virtualPaths []string, sizes []int) error {
+	w := h.thumbnailWarmer
+	w.mu.Lock()
+	if w.status.active() {
+		w.mu.Unlock()
+		return errThumbnailWarmRunning
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now().UTC()
+	w.status = thumbnailWarmStatus{State: thumbnailWarmRunning, Paths: virtualPaths, Sizes: sizes, StartedAt: &now}
+	w.cancel = cancel
+	w.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		err := h.warmThumbnails(ctx, w, virtualPaths, sizes)
+		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, errThumbnailCacheFull) {
+			h.logger.Warn("Thumbnail warming failed", "paths", virtualPaths, "error", err)
+		}
+		w.finish(err)
+	}()
+	return nil
+}
+
+type thumbnailWarmRequest struct {
+	Path  string `json:"path"`
+	Sizes []int  `json:"sizes"`
+}
+
+// parseThumbnailSizes validates requested sizes, defaulting to the size the
+// file browser asks for.
+func parseThumbnailSizes(sizes []int) ([]int, error) {
+	if len(sizes) == 0 {
+		return []int{defaultThumbnailSize}, nil
+	}
+	seen := make(map[int]bool, len(sizes))
+	result := make([]int, 0, len(sizes))
+	for _, size := range sizes {
+		if !thumbnailSizes[size] {
+			return nil, fmt.Errorf("invalid thumbnail size: %d", size)
+		}
+		if !seen[size] {
+			seen[size] = true
+			result = append(result, size)
+		}
+	}
+	return result, nil
+}
+
+// ThumbnailWarmStatus reports the state and counters of the crawler.
+func (h *Handler) ThumbnailWarmStatus(w http.ResponseWriter, r *http.Request) {
+	h.respondSuccess(w, h.thumbnailWarmer.snapshot())
+}
+
+// StartThumbnailWarm starts generating thumbnails below path, or below every
+// configured root when path is empty.
+func (h *Handler) StartThumbnailWarm(w http.ResponseWriter, r *http.Request) {
+	var req thumbnailWarmRequest
+	// An empty body warms every root at the default size.
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		h.respondError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	sizes, err := parseThumbnailSizes(req.Sizes)
+	if err != nil {
+		h.respondError(w, "Invalid thumbnail size", http.StatusBadRequest)
+		return
+	}
+	paths := h.warmThumbnailRoots()
+	if req.Path != "" {
+		fullPath, err := h.convertToPhysicalPath(req.Path)
+		if err != nil {
+			h.respondError(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if info, err := os.Stat(fullPath); err != nil || !info.IsDir() {
+			h.respondError(w, "Path is not a directory", http.StatusBadRequest)
+			return
+		}
+		paths = []string{req.Path}
+	}
+	if err := h.startThumbnailWarm(paths, sizes); err != nil {
+		h.respondError(w, "Thumbnail warming is already running", http.StatusConflict)
+		return
+	}
+	h.respondSuccess(w, h.thumbnailWarmer.snapshot())
+}
+
+// PauseThumbnailWarm suspends the crawler between files.
+func (h *Handler) PauseThumbnailWarm(w http.ResponseWriter, r *http.Request) {
+	if !h.thumbnailWarmer.pause() {
+		h.respondError(w, "Thumbnail warming is not running", http.StatusConflict)
+		return
+	}
+	h.respondSuccess(w, h.thumbnailWarmer.snapshot())
+}
+
+// ResumeThumbnailWarm continues a paused crawler.
+func (h *Handler) ResumeThumbnailWarm(w http.ResponseWriter, r *http.Request) {
+	if !h.thumbnailWarmer.unpause() {
+		h.respondError(w, "Thumbnail warming is not paused", http.StatusConflict)
+		return
+	}
+	h.respondSuccess(w, h.thumbnailWarmer.snapshot())
+}
+
+// StopThumbnailWarm cancels the crawler. Thumbnails generated so far stay
+// cached.
+func (h *Handler) StopThumbnailWarm(w http.ResponseWriter, r *http.Request) {
+	if !h.thumbnailWarmer.stop() {
+		h.respondError(w, "Thumbnail warming is not running", http.StatusConflict)
+		return
+	}
+	h.respondSuccess(w, h.thumbnailWarmer.snapshot())
+}
+
+// WarmThumbnails fills the thumbnail cache for the tree at target without
+// starting a server. target is a virtual path as shown in the file browser or
+// a physical path inside an allowed root. Each processed file and a final
+// summary are written to out.
+func WarmThumbnails(ctx context.Context, config *types.Config, logger *slog.Logger, target string, sizes []int, out io.Writer) error {
+	h := &Handler{config: config, logger: logger, thumbnailGate: make(chan struct{}, 2), thumbnails: newThumbnailCache(config)}
+	sizes, err := parseThumbnailSizes(sizes)
+	if err != nil {
+		return err
+	}
+	virtualPath := target
+	if absolute, err := filepath.Abs(target); err == nil {
+		if resolved, err := resolveExistingPath(absolute); err == nil {
+			for _, root := range h.allowedRoots() {
+				if isPathWithin(root, resolved) {
+					virtualPath = h.cleanVirtualPath(resolved)
+					break
+				}
+			}
+		}
+	}
+	w := newThumbnailWarmer()
+	w.status.State = thumbnailWarmRunning
+	w.report = func(path string, size int, err error) {
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "failed     %s (%d): %v\n", path, size, err)
+			return
+		}
+		_, _ = fmt.Fprintf(out, "generated  %s (%d)\n", path, size)
+	}
+	err = h.warmThumbnails(ctx, w, []string{virtualPath}, sizes)
+	w.finish(err)
+	status := w.snapshot()
+	_, _ = fmt.Fprintf(out, "%s: %d files scanned, %d generated, %d already cached, %d failed\n",
+		status.State, status.Scanned, status.Generated, status.Cached, status.Failed)
+	if errors.Is(err, errThumbnailCacheFull) {
+		_, _ = fmt.Fprintf(out, "stopped at the cache limit of %d files or %d bytes\n", h.thumbnails.maxFiles, h.thumbnails.maxBytes)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitForThumbnailWarm(t *testing.T, h *Handler) thumbnailWarmStatus {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := h.thumbnailWarmer.snapshot(); !status.active() {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("thumbnail warming did not finish")
+	return thumbnailWarmStatus{}
+}
+
+func TestThumbnailWarmFillsCacheBelowPath(t *testing.T) {
+	h := newContentTestHandler(t)
+	h.thumbnails.dir = t.TempDir()
+	root := h.config.StorageDir
+	for _, dir := range []string{"photos/2024", trashDirName} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	encode := func(b *bytes.Buffer, img image.Image) error { return png.Encode(b, img) }
+	img := halfAndHalf(400, 200, color.Black, color.White)
+	writeTestImage(t, filepath.Join(root, "photos", "a.png"), img, encode, nil)
+	writeTestImage(t, filepath.Join(root, "photos", "2024", "b.png"), img, encode, nil)
+	writeTestImage(t, filepath.Join(root, trashDirName, "deleted.png"), img, encode, nil)
+	writeTestFile(t, filepath.Join(root, "photos", "notes.txt"), "not an image")
+
+	res := httptest.NewRecorder()
+	h.StartThumbnailWarm(res, httptest.NewRequest(http.MethodPost, "/api/thumbnails/warm", strings.NewReader(`{"path":"/photos","sizes":[160,320]}`)))
+	if res.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", res.Code, res.Body.String())
+	}
+	status := waitForThumbnailWarm(t, h)
+	if status.State != thumbnailWarmCompleted || status.Scanned != 2 || status.Generated != 4 || status.Failed != 0 {
+		t.Fatalf("status = %+v", status)
+	}
+	info, err := os.Stat(filepath.Join(root, "photos", "2024", "b.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(h.thumbnails.path("/photos/2024/b.png", info, 160)); err != nil {
+		t.Fatalf("warmed thumbnail is not where Thumbnail looks for it: %v", err)
+	}
+
+	res = httptest.NewRecorder()
+	h.StartThumbnailWarm(res, httptest.NewRequest(http.MethodPost, "/api/thumbnails/warm", strings.NewReader(`{"path":"/photos","sizes":[160]}`)))
+	if status := waitForThumbnailWarm(t, h); status.Generated != 0 || status.Cached != 2 {
+		t.Fatalf("second run regenerated thumbnails: %+v", status)
+	}
+}
+
+func TestThumbnailWarmRejectsInvalidRequests(t *testing.T) {
+	h := newContentTestHandler(t)
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "file.txt"), "x")
+	for _, body := range []string{`{"sizes":[100]}`, `{"path":"/file.txt"}`, `{"path":"/../outside"}`} {
+		res := httptest.NewRecorder()
+		h.StartThumbnailWarm(res, httptest.NewRequest(http.MethodPost, "/api/thumbnails/warm", strings.NewReader(body)))
+		if res.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d", body, res.Code)
+		}
+	}
+	res := httptest.NewRecorder()
+	h.PauseThumbnailWarm(res, httptest.NewRequest(http.MethodPost, "/api/thumbnails/warm/pause", nil))
+	if res.Code != http.StatusConflict {
+		t.Fatalf("pausing an idle crawler: status = %d", res.Code)
+	}
+}
+
+func TestThumbnailWarmerPausesUntilResumed(t *testing.T) {
+	w := newThumbnailWarmer()
+	w.status.State = thumbnailWarmRunning
+	if !w.pause() || w.pause() {
+		t.Fatal("pause must only succeed while running")
+	}
+	resumed := make(chan error, 1)
+	go func() { resumed <- w.waitWhilePaused(context.Background()) }()
+	select {
+	case <-resumed:
+		t.Fatal("paused crawler kept going")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if !w.unpause() {
+		t.Fatal("unpause failed")
+	}
+	if err := <-resumed; err != nil {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestThumbnailWarmLeavesASlotForRequests(t *testing.T) {
+	h := newContentTestHandler(t)
+	h.thumbnailGate <- struct{}{}
+	defer release(h.thumbnailGate)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := h.acquireThumbnailSlot(ctx, newThumbnailWarmer()); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want the crawler to wait for an idle gate", err)
+	}
+}
@@ -0,0 +1,421 @@
+package handlers
+
+// Recycle bin. Deleted items are renamed into a hidden directory at the root
+// of the allowed directory that contains them, so trashing a large tree costs
+// one rename instead of a recursive delete. Each item has a JSON record next
+// to it describing where it came from and when it was deleted.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"puremania/internal/cache"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	trashDirName         = ".puremania-trash"
+	trashCleanupInterval = time.Hour
+)
+
+type trashItem struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	OriginalPath string    `json:"originalPath"`
+	DeletedAt    time.Time `json:"deletedAt"`
+	Size         int64     `json:"size"`
+	IsDir        bool      `json:"isDir"`
+	// SizePending marks an item whose size has not been measured yet.
+	SizePending bool `json:"sizePending,omitempty"`
+}
+
+type trashRequest struct {
+	IDs        []string `json:"ids"`
+	OnConflict string   `json:"onConflict"`
+}
+
+var errTrashItemNotFound = errors.New("trash item not found")
+
+func trashDataPath(dir, id string) string     { return filepath.Join(dir, id) }
+func trashMetadataPath(dir, id string) string { return filepath.Join(dir, id+".json") }
+
+func readTrashItem(dir, id string) (*trashItem, error) {
+	b, err := os.ReadFile(trashMetadataPath(dir, id))
+	if err != nil {
+		return nil, err
+	}
+	var item trashItem
+	if err := json.Unmarshal(b, &item); err != nil {
+		return nil, err
+	}
+	if item.ID != id {
+		return nil, errors.New("invalid trash item")
+	}
+	return &item, nil
+}
+
+// diskUsage reports the apparent size of a file or tree. Unreadable entries
+// are ignored; the value only drives the trash size limit.
+func diskUsage(ctx context.Context, root string) int64 {
+	var total int64
+	_ = filepath.WalkDir(root, func(_ string, entry fs.DirEntry, walkErr error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if walkErr != nil || !entry.Type().IsRegular() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// moveToTrash records the item before moving it so a crash can only leave a
+// record without data, which cleanupTrash discards. The size is measured
+// later by measureTrashItem, so deleting a large tree costs only the rename.
+func (h *Handler) moveToTrash(ctx context.Context, fullPath string) (*trashItem, error) {
+	root, _, err := h.allowedRootForPath(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(root, trashDirName)
+	if isPathWithin(dir, fullPath) {
+		return nil, errors.New("item is already in the trash")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	id, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+	item := &trashItem{
+		ID: id, Name: filepath.Base(fullPath), OriginalPath: h.convertToVirtualPath(fullPath),
+		DeletedAt: time.Now().UTC(), IsDir: info.IsDir(), SizePending: true,
+	}
+	b, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	if err := atomicWriteFile(trashMetadataPath(dir, id), b, 0600); err != nil {
+		return nil, err
+	}
+	if err := h.movePath(ctx, fullPath, trashDataPath(dir, id), nil); err != nil {
+		_ = os.Remove(trashMetadataPath(dir, id))
+		return nil, err
+	}
+	return item, nil
+}
+
+// measureTrashItem records the size of a trashed item.
+func (h *Handler) measureTrashItem(dir string, item *trashItem) {
+	item.Size = diskUsage(context.Background(), trashDataPath(dir, item.ID))
+	item.SizePending = false
+	// Nothing is left to update after a restore or purge; a record rewritten
+	// just after one has no data and is discarded by cleanupTrash.
+	if _, err := os.Lstat(trashDataPath(dir, item.ID)); err != nil {
+		return
+	}
+	b, err := json.Marshal(item)
+	if err == nil {
+		err = atomicWriteFile(trashMetadataPath(dir, item.ID), b, 0600)
+	}
+	if err != nil {
+		h.logger.Warn("Failed to record trash item size", "id", item.ID, "error", err)
+	}
+}
+
+// measureTrashedItems measures items trashed by a request and then runs any
+// due cleanup, off the request path.
+func (h *Handler) measureTrashedItems(ids []string) {
+	for _, id := range ids {
+		if dir, item, err := h.findTrashItem(id); err == nil && item.SizePending {
+			h.measureTrashItem(dir, item)
+		}
+	}
+	h.cleanupTrashIfDue()
+}
+
+func (h *Handler) listTrash() []trashItem {
+	items := make([]trashItem, 0)
+	for _, root := range h.allowedRoots() {
+		dir := filepath.Join(root, trashDirName)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+				continue
+			}
+			id := strings.TrimSuffix(entry.Name(), ".json")
+			item, err := readTrashItem(dir, id)
+			if err != nil {
+				continue
+			}
+			if _, err := os.Lstat(trashDataPath(dir, id)); err != nil {
+				continue
+			}
+			items = append(items, *item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+	return items
+}
+
+func (h *Handler) findTrashItem(id string) (string, *trashItem, error) {
+	if !validUploadID(id) {
+		return "", nil, errTrashItemNotFound
+	}
+	for _, root := range h.allowedRoots() {
+		dir := filepath.Join(root, trashDirName)
+		item, err := readTrashItem(dir, id)
+		if err == nil {
+			return dir, item, nil
+		}
+	}
+	return "", nil, errTrashItemNotFound
+}
+
+func (h *Handler) purgeTrashItem(dir, id string) error {
+	if err := os.RemoveAll(trashDataPath(dir, id)); err != nil {
+		return err
+	}
+	if err := os.Remove(trashMetadataPath(dir, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// restoreTrashItem moves an item back to its recorded virtual path. The path
+// is converted again, so it is still confined to the current allowed roots.
+func (h *Handler) restoreTrashItem(ctx context.Context, id string, policy conflictPolicy) (string, error) {
+	dir, item, err := h.findTrashItem(id)
+	if err != nil {
+		return "", err
+	}
+	target, err := h.convertToPhysicalPath(item.OriginalPath)
+	if err != nil {
+		return "", err
+	}
+	if h.isProtectedRoot(target) {
+		return "", errors.New("cannot restore over a protected root")
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+	// The move itself refuses an existing target, so a file created meanwhile
+	// is never replaced.
+	original := target
+	for attempt := 0; ; attempt++ {
+		err = h.movePathNoReplace(ctx, trashDataPath(dir, id), target)
+		if !errors.Is(err, fs.ErrExist) {
+			break
+		}
+		if policy != conflictRename || attempt == maxRenameAttempts {
+			return "", errTargetExists
+		}
+		if target, err = nextAvailableName(original); err != nil {
+			return "", err
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Remove(trashMetadataPath(dir, id)); err != nil && !os.IsNotExist(err) {
+		h.logger.Warn("Failed to remove restored trash record", "id", id, "error", err)
+	}
+	return target, nil
+}
+
+// cleanupTrash purges items older than TRASH_TTL_HOURS and then the oldest
+// items until the trash fits TRASH_MAX_SIZE_MB. Records without data and data
+// without records are leftovers of interrupted operations and are removed.
+func (h *Handler) cleanupTrash() {
+	var cutoff time.Time
+	if h.config.TrashTTLHours > 0 {
+		cutoff = time.Now().Add(-time.Duration(h.config.TrashTTLHours) * time.Hour)
+	}
+	type located struct {
+		dir  string
+		item trashItem
+	}
+	var kept []located
+	var totalBytes int64
+	for _, root := range h.allowedRoots() {
+		dir := filepath.Join(root, trashDirName)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		records := make(map[string]struct{}, len(entries))
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+				continue
+			}
+			id := strings.TrimSuffix(entry.Name(), ".json")
+			records[id] = struct{}{}
+			item, readErr := readTrashItem(dir, id)
+			_, dataErr := os.Lstat(trashDataPath(dir, id))
+			if readErr != nil || dataErr != nil || (!cutoff.IsZero() && item.DeletedAt.Before(cutoff)) {
+				if err := h.purgeTrashItem(dir, id); err != nil {
+					h.logger.Warn("Failed to purge trash item", "id", id, "error", err)
+				}
+				continue
+			}
+			if item.SizePending && h.config.TrashMaxSizeMB > 0 {
+				// Left unmeasured by an interrupted process.
+				h.measureTrashItem(dir, item)
+			}
+			kept = append(kept, located{dir: dir, item: *item})
+			totalBytes += item.Size
+		}
+		for _, entry := range entries {
+			if filepath.Ext(entry.Name()) == ".json" {
+				continue
+			}
+			if _, ok := records[entry.Name()]; !ok && validUploadID(entry.Name()) {
+				_ = os.RemoveAll(trashDataPath(dir, entry.Name()))
+			}
+		}
+	}
+
+	maxBytes := h.config.TrashMaxSizeMB << 20
+	if maxBytes <= 0 {
+		return
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].item.DeletedAt.Before(kept[j].item.DeletedAt) })
+	for len(kept) > 0 && totalBytes > maxBytes {
+		oldest := kept[0]
+		kept = kept[1:]
+		if err := h.purgeTrashItem(oldest.dir, oldest.item.ID); err != nil {
+			h.logger.Warn("Failed to purge trash item", "id", oldest.item.ID, "error", err)
+			continue
+		}
+		totalBytes -= oldest.item.Size
+	}
+}
+
+func (h *Handler) cleanupTrashIfDue() {
+	h.trashCleanupMu.Lock()
+	defer h.trashCleanupMu.Unlock()
+	if time.Since(h.lastTrashCleanup) < trashCleanupInterval {
+		return
+	}
+	h.lastTrashCleanup = time.Now()
+	h.cleanupTrash()
+}
+
+func (h *Handler) invalidateRestoredPath(path string) {
+	h.invalidateFileCache(path)
+	cache.InvalidateByPrefix(h.cache, "list:"+filepath.Dir(h.convertToVirtualPath(path)))
+	cache.InvalidateByPrefix(h.cache, "search:")
+	h.publishFileChanges(eventFilesCreated, []string{path}, nil)
+}
+
+func (h *Handler) decodeTrashRequest(w http.ResponseWriter, r *http.Request) (trashRequest, bool) {
+	var req trashRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, "Invalid JSON", http.StatusBadRequest)
+		return req, false
+	}
+	if len(req.IDs) == 0 || len(req.IDs) > maxBatchPaths {
+		h.respondError(w, "Between 1 and 1000 trash ids are required", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// ListTrash returns trashed items across all roots, newest first.
+func (h *Handler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "private, no-cache")
+	h.respondSuccess(w, h.listTrash())
+}
+
+// RestoreTrash moves items back to where they were deleted from.
+func (h *Handler) RestoreTrash(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.decodeTrashRequest(w, r)
+	if !ok {
+		return
+	}
+	policy, err := parseConflictPolicy(req.OnConflict, conflictFail)
+	if err != nil || (policy != conflictFail && policy != conflictRename) {
+		h.respondError(w, "Restore conflict policy must be fail or rename", http.StatusBadRequest)
+		return
+	}
+	restored := make([]string, 0, len(req.IDs))
+	var failures []string
+	for _, id := range req.IDs {
+		target, err := h.restoreTrashItem(r.Context(), id, policy)
+		if err != nil {
+			h.logger.Error("Failed to restore trash item", "id", id, "error", err)
+			failures = append(failures, "Cannot restore "+id+": "+err.Error())
+			continue
+		}
+		h.invalidateRestoredPath(target)
+		restored = append(restored, h.convertToVirtualPath(target))
+	}
+	if len(failures) > 0 {
+		h.respondError(w, strings.Join(failures, "\n"), http.StatusConflict)
+		return
+	}
+	h.respondSuccess(w, map[string]interface{}{"message": "Items restored successfully", "restored": restored})
+}
+
+// PurgeTrash permanently deletes the selected trashed items.
+func (h *Handler) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.decodeTrashRequest(w, r)
+	if !ok {
+		return
+	}
+	var failures []string
+	for _, id := range req.IDs {
+		dir, _, err := h.findTrashItem(id)
+		if err == nil {
+			err = h.purgeTrashItem(dir, id)
+		}
+		if err != nil {
+			failures = append(failures, "Cannot purge "+id+": "+err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		h.respondError(w, strings.Join(failures, "\n"), http.StatusInternalServerError)
+		return
+	}
+	h.respondSuccess(w, map[string]string{"message": "Items purged successfully"})
+}
+
+// EmptyTrash permanently deletes every trashed item in every root.
+func (h *Handler) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	var failures []string
+	for _, root := range h.allowedRoots() {
+		dir := filepath.Join(root, trashDirName)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				failures = append(failures, err.Error())
+			}
+		}
+	}
+	if len(failures) > 0 {
+		h.respondError(w, "Cannot empty trash", http.StatusInternalServerError)
+		return
+	}
+	h.respondSuccess(w, map[string]string{"message": "Trash emptied successfully"})
+}
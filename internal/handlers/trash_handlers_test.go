@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeleteMovesItemsToTrashAndRestoreReturnsThem(t *testing.T) {
+	h := newContentTestHandler(t)
+	if err := os.Mkdir(filepath.Join(h.config.StorageDir, "photos"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "photos", "a.jpg"), "image")
+
+	res := httptest.NewRecorder()
+	h.DeleteMultipleFiles(res, httptest.NewRequest(http.MethodPost, "/api/files/delete", strings.NewReader(`{"paths":["/photos"]}`)))
+	if res.Code != http.StatusOK {
+		t.Fatalf("delete status = %d, body = %s", res.Code, res.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(h.config.StorageDir, "photos")); !os.IsNotExist(err) {
+		t.Fatalf("deleted directory still exists, stat error=%v", err)
+	}
+	files, err := h.getFileList("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if file.Name == trashDirName {
+			t.Fatal("trash directory is visible in the listing")
+		}
+	}
+
+	// The size is measured after the delete responds.
+	items := h.listTrash()
+	for deadline := time.Now().Add(5 * time.Second); len(items) == 1 && items[0].SizePending && time.Now().Before(deadline); items = h.listTrash() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(items) != 1 || items[0].OriginalPath != "/photos" || !items[0].IsDir || items[0].Size != 5 {
+		t.Fatalf("unexpected trash items: %#v", items)
+	}
+
+	restore := httptest.NewRecorder()
+	h.RestoreTrash(restore, httptest.NewRequest(http.MethodPost, "/api/trash/restore", strings.NewReader(`{"ids":["`+items[0].ID+`"]}`)))
+	if restore.Code != http.StatusOK {
+		t.Fatalf("restore status = %d, body = %s", restore.Code, restore.Body.String())
+	}
+	if content, err := os.ReadFile(filepath.Join(h.config.StorageDir, "photos", "a.jpg")); err != nil || string(content) != "image" {
+		t.Fatalf("restored content = %q, err = %v", content, err)
+	}
+	if len(h.listTrash()) != 0 {
+		t.Fatal("restored item is still listed in the trash")
+	}
+}
+
+func TestPermanentDeleteBypassesTrash(t *testing.T) {
+	h := newContentTestHandler(t)
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "gone.txt"), "x")
+	res := httptest.NewRecorder()
+	h.DeleteMultipleFiles(res, httptest.NewRequest(http.MethodPost, "/api/files/delete", strings.NewReader(`{"paths":["/gone.txt"],"permanent":true}`)))
+	if res.Code != http.StatusOK {
+		t.Fatalf("delete status = %d, body = %s", res.Code, res.Body.String())
+	}
+	if len(h.listTrash()) != 0 {
+		t.Fatal("permanent delete was recorded in the trash")
+	}
+}
+
+func TestRestoreTrashConflictPolicy(t *testing.T) {
+	h := newContentTestHandler(t)
+	path := filepath.Join(h.config.StorageDir, "note.txt")
+	writeTestFile(t, path, "old")
+	item, err := h.moveToTrash(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, "new")
+
+	body := `{"ids":["` + item.ID + `"]}`
+	conflict := httptest.NewRecorder()
+	h.RestoreTrash(conflict, httptest.NewRequest(http.MethodPost, "/api/trash/restore", strings.NewReader(body)))
+	if conflict.Code != http.StatusConflict {
+		t.Fatalf("conflicting restore status = %d", conflict.Code)
+	}
+
+	renamed := httptest.NewRecorder()
+	body = `{"ids":["` + item.ID + `"],"onConflict":"rename"}`
+	h.RestoreTrash(renamed, httptest.NewRequest(http.MethodPost, "/api/trash/restore", strings.NewReader(body)))
+	var response struct {
+		Data struct {
+			Restored []string `json:"restored"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(renamed.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Code != http.StatusOK || len(response.Data.Restored) != 1 || response.Data.Restored[0] != "/note (1).txt" {
+		t.Fatalf("rename restore status = %d, body = %s", renamed.Code, renamed.Body.String())
+	}
+
+	// A directory is never merged into or swapped with an existing one.
+	album := filepath.Join(h.config.StorageDir, "album")
+	if err := os.Mkdir(album, 0755); err != nil {
+		t.Fatal(err)
+	}
+	item, err = h.moveToTrash(context.Background(), album)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(album, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.restoreTrashItem(context.Background(), item.ID, conflictFail); err != errTargetExists {
+		t.Fatalf("restoring onto an existing directory: err = %v", err)
+	}
+	if target, err := h.restoreTrashItem(context.Background(), item.ID, conflictRename); err != nil || target != album+" (1)" {
+		t.Fatalf("renamed directory restore = %q, err = %v", target, err)
+	}
+}
+
+func TestCleanupTrashPurgesExpiredAndOversizedItems(t *testing.T) {
+	h := newContentTestHandler(t)
+	h.config.TrashTTLHours = 24
+	h.config.TrashMaxSizeMB = 1
+	trash := func(name string, size int, deletedAt time.Time) *trashItem {
+		t.Helper()
+		path := filepath.Join(h.config.StorageDir, name)
+		writeTestFile(t, path, strings.Repeat("x", size))
+		item, err := h.moveToTrash(context.Background(), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		item.DeletedAt = deletedAt
+		b, _ := json.Marshal(item)
+		dir := filepath.Join(h.config.StorageDir, trashDirName)
+		if err := os.WriteFile(trashMetadataPath(dir, item.ID), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return item
+	}
+	now := time.Now()
+	trash("expired.txt", 1, now.Add(-48*time.Hour))
+	trash("oldest.bin", 1<<20, now.Add(-2*time.Hour))
+	newest := trash("newest.bin", 1<<19, now.Add(-time.Hour))
+
+	h.cleanupTrash()
+
+	items := h.listTrash()
+	if len(items) != 1 || items[0].ID != newest.ID {
+		t.Fatalf("unexpected items after cleanup: %#v", items)
+	}
+}
@@ -0,0 +1,262 @@
+package handlers
+
+// tus 1.0 resumable uploads (https://tus.io/protocols/resumable-upload) with
+// the creation, termination, and checksum extensions. Sessions are the same
+// durable uploadSession files as /files/upload-sessions, so both APIs share
+// preallocation, the upload gate, checksums, and expiry.
+
+import (
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,checksum"
+	// statusChecksumMismatch is the status tus reserves for a chunk whose
+	// Upload-Checksum does not match.
+	statusChecksumMismatch = 460
+)
+
+// tusRequest rejects requests for another protocol version. Every tus request
+// but OPTIONS carries Tus-Resumable, which also keeps them from being simple
+// cross-origin requests.
+func (h *Handler) tusRequest(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		h.respondError(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// TusOptions advertises the protocol version, extensions, and size limit.
+func (h *Handler) TusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.config.MaxFileSize<<20, 10))
+	w.Header().Set("Tus-Checksum-Algorithm", "sha256")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTusMetadata decodes Upload-Metadata: comma-separated keys, each
+// followed by a space and a base64 value, or alone.
+func parseTusMetadata(value string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(value) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		if _, exists := metadata[key]; exists {
+			return nil, errors.New("duplicate metadata key " + key)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+// parseTusChecksum decodes Upload-Checksum ("sha256 <base64>"); nil means the
+// header is absent.
+func parseTusChecksum(value string) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+	algorithm, encoded, _ := strings.Cut(strings.TrimSpace(value), " ")
+	if algorithm != "sha256" {
+		return nil, errors.New("unsupported checksum algorithm")
+	}
+	return parseDigestHeader("sha-256=" + strings.TrimSpace(encoded))
+}
+
+// TusCreate creates an upload. Upload-Metadata names the file with
+// relativePath (or filename) below the directory path, and may declare the
+// file's sha256 in hex, an onConflict policy, and lastModified in Unix
+// milliseconds.
+func (h *Handler) TusCreate(w http.ResponseWriter, r *http.Request) {
+	if !h.tusRequest(w, r) {
+		return
+	}
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		h.respondError(w, "Upload-Defer-Length is not supported", http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength > 0 {
+		h.respondError(w, "Upload data must be sent with PATCH", http.StatusBadRequest)
+		return
+	}
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		h.respondError(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if size > h.config.MaxFileSize<<20 {
+		h.respondError(w, "Upload exceeds Tus-Max-Size", http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		h.respondError(w, "Invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	relativePath := metadata["relativePath"]
+	if relativePath == "" {
+		relativePath = metadata["filename"]
+	}
+	var lastModified int64
+	if value := metadata["lastModified"]; value != "" {
+		if lastModified, err = strconv.ParseInt(value, 10, 64); err != nil {
+			h.respondError(w, "Invalid Upload-Metadata", http.StatusBadRequest)
+			return
+		}
+	}
+	session, err := h.createUploadSession(createUploadRequest{
+		Path:         metadata["path"],
+		RelativePath: relativePath,
+		Size:         size,
+		SHA256:       metadata["sha256"],
+		OnConflict:   metadata["onConflict"],
+		LastModified: lastModified,
+	})
+	if err != nil {
+		h.respondUploadError(w, err)
+		return
+	}
+	// An empty upload is complete as soon as it exists.
+	if size == 0 {
+		if _, err := h.finalizeUpload(session); err != nil {
+			h.respondUploadError(w, err)
+			return
+		}
+	}
+	w.Header().Set("Location", "/api/files/tus/"+session.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// TusHead reports the offset to resume from.
+func (h *Handler) TusHead(w http.ResponseWriter, r *http.Request) {
+	if !h.tusRequest(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	lock := h.sessionMutex(id)
+	lock.Lock()
+	defer lock.Unlock()
+	session, err := h.readUploadSession(id)
+	if err != nil {
+		h.respondError(w, "Upload session not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.contiguousBytes(), 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.TotalBytes, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// TusPatch appends the body at Upload-Offset and finalizes the file once all
+// bytes are received. A body that ends early is kept; an interrupted one is
+// discarded and resent from the offset HEAD reports.
+func (h *Handler) TusPatch(w http.ResponseWriter, r *http.Request) {
+	if !h.tusRequest(w, r) {
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/offset+octet-stream" {
+		h.respondError(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		h.respondError(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	wantChunk, err := parseTusChecksum(r.Header.Get("Upload-Checksum"))
+	if err != nil {
+		h.respondError(w, "Invalid Upload-Checksum", http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	lock := h.sessionMutex(id)
+	lock.Lock()
+	defer lock.Unlock()
+	session, err := h.readUploadSession(id)
+	if err != nil {
+		h.respondError(w, "Upload session not found", http.StatusNotFound)
+		return
+	}
+	if session.Parallel {
+		h.respondError(w, "Parallel uploads take chunks through upload-sessions", http.StatusConflict)
+		return
+	}
+	if offset != session.UploadedBytes {
+		h.respondError(w, "Upload-Offset does not match the upload", http.StatusConflict)
+		return
+	}
+	length, exact := session.TotalBytes-offset, false
+	if r.ContentLength >= 0 {
+		if r.ContentLength > length {
+			h.respondError(w, "Upload chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+			return
+		}
+		length, exact = r.ContentLength, true
+	}
+	if length > 0 {
+		if err := h.appendUploadChunk(w, r, session, length, exact, wantChunk); err != nil {
+			switch err {
+			case errUploadCanceled:
+			case errChunkDigestMismatch:
+				h.respondError(w, "Checksum Mismatch", statusChecksumMismatch)
+			default:
+				h.respondUploadError(w, err)
+			}
+			return
+		}
+	}
+	if session.UploadedBytes == session.TotalBytes && !session.Completed {
+		if _, err := h.finalizeUpload(session); err != nil {
+			h.respondUploadError(w, err)
+			return
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.UploadedBytes, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TusTerminate discards an upload and its received data.
+func (h *Handler) TusTerminate(w http.ResponseWriter, r *http.Request) {
+	if !h.tusRequest(w, r) {
+		return
+	}
+	if err := h.discardUploadSession(mux.Vars(r)["id"]); err != nil {
+		h.respondError(w, "Upload session not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TusMethodOverride serves clients that tunnel PATCH and DELETE through POST
+// with X-HTTP-Method-Override.
+func (h *Handler) TusMethodOverride(w http.ResponseWriter, r *http.Request) {
+	switch strings.ToUpper(r.Header.Get("X-HTTP-Method-Override")) {
+	case http.MethodPatch:
+		h.TusPatch(w, r)
+	case http.MethodDelete:
+		h.TusTerminate(w, r)
+	default:
+		h.respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
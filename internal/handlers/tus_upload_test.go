@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func tusTestRequest(method, target, body string, header map[string]string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	return mux.SetURLVars(req, map[string]string{"id": path.Base(target)})
+}
+
+func tusPatch(h *Handler, location, offset, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := tusTestRequest(http.MethodPatch, location, body, header)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", offset)
+	res := httptest.NewRecorder()
+	h.TusPatch(res, req)
+	return res
+}
+
+func tusMetadata(pairs ...string) string {
+	var members []string
+	for i := 0; i < len(pairs); i += 2 {
+		members = append(members, pairs[i]+" "+base64.StdEncoding.EncodeToString([]byte(pairs[i+1])))
+	}
+	return strings.Join(members, ",")
+}
+
+func TestTusCreatePatchAndHeadShareUploadSessions(t *testing.T) {
+	h := newUploadTestHandler(t)
+	if err := os.Mkdir(filepath.Join(h.config.StorageDir, "in"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	h.TusOptions(res, httptest.NewRequest(http.MethodOptions, "/api/files/tus", nil))
+	if res.Code != http.StatusNoContent || res.Header().Get("Tus-Extension") != "creation,termination,checksum" || res.Header().Get("Tus-Max-Size") != "1048576" {
+		t.Fatalf("OPTIONS status = %d, headers = %v", res.Code, res.Header())
+	}
+
+	whole := sha256.Sum256([]byte("hello world"))
+	res = httptest.NewRecorder()
+	h.TusCreate(res, tusTestRequest(http.MethodPost, "/api/files/tus", "", map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": tusMetadata("filename", "hello.txt", "path", "/in", "sha256", hex.EncodeToString(whole[:])) + ",empty",
+	}))
+	location := res.Header().Get("Location")
+	if res.Code != http.StatusCreated || !strings.HasPrefix(location, "/api/files/tus/") || res.Header().Get("Tus-Resumable") != tusVersion {
+		t.Fatalf("create status = %d, Location = %q, body = %s", res.Code, location, res.Body.String())
+	}
+	session, err := h.readUploadSession(path.Base(location))
+	if err != nil || session.TotalBytes != 11 || session.RelativePath != "hello.txt" {
+		t.Fatalf("session = %+v, err = %v", session, err)
+	}
+
+	if res := tusPatch(h, location, "0", "hello", nil); res.Code != http.StatusNoContent || res.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("first PATCH status = %d, offset = %q, body = %s", res.Code, res.Header().Get("Upload-Offset"), res.Body.String())
+	}
+	if res := tusPatch(h, location, "0", "hello", nil); res.Code != http.StatusConflict {
+		t.Fatalf("stale offset status = %d", res.Code)
+	}
+	good := sha256.Sum256([]byte(" world"))
+	if res := tusPatch(h, location, "5", " wOrld", map[string]string{"Upload-Checksum": "sha256 " + base64.StdEncoding.EncodeToString(good[:])}); res.Code != statusChecksumMismatch {
+		t.Fatalf("corrupted PATCH status = %d", res.Code)
+	}
+	if res := tusPatch(h, location, "5", " world", map[string]string{"Upload-Checksum": "md5 AAAA"}); res.Code != http.StatusBadRequest {
+		t.Fatalf("unsupported checksum status = %d", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	h.TusHead(res, tusTestRequest(http.MethodHead, location, "", nil))
+	if res.Code != http.StatusOK || res.Header().Get("Upload-Offset") != "5" || res.Header().Get("Upload-Length") != "11" || res.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("HEAD status = %d, headers = %v", res.Code, res.Header())
+	}
+
+	if res := tusPatch(h, location, "5", " world", map[string]string{"Upload-Checksum": "sha256 " + base64.StdEncoding.EncodeToString(good[:])}); res.Code != http.StatusNoContent || res.Header().Get("Upload-Offset") != "11" {
+		t.Fatalf("last PATCH status = %d, body = %s", res.Code, res.Body.String())
+	}
+	if content, err := os.ReadFile(filepath.Join(h.config.StorageDir, "in", "hello.txt")); err != nil || string(content) != "hello world" {
+		t.Fatalf("content = %q, err = %v", content, err)
+	}
+	// The upload-sessions API sees the same, completed session.
+	res = httptest.NewRecorder()
+	h.UploadStatus(res, httptest.NewRequest(http.MethodGet, "/api/files/upload-sessions/"+path.Base(location), nil))
+	if !strings.Contains(res.Body.String(), `"completed":true`) {
+		t.Fatalf("upload-sessions status = %s", res.Body.String())
+	}
+}
+
+func TestTusRejectsOtherVersionsAndTerminates(t *testing.T) {
+	h := newUploadTestHandler(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files/tus", nil)
+	req.Header.Set("Upload-Length", "3")
+	res := httptest.NewRecorder()
+	h.TusCreate(res, req)
+	if res.Code != http.StatusPreconditionFailed || res.Header().Get("Tus-Version") != tusVersion {
+		t.Fatalf("missing Tus-Resumable status = %d", res.Code)
+	}
+	for header, status := range map[string]int{"4": http.StatusBadRequest, "1048577": http.StatusRequestEntityTooLarge} {
+		res = httptest.NewRecorder()
+		metadata := tusMetadata("filename", "a.bin")
+		if header == "4" {
+			metadata = tusMetadata("filename", "../escape.bin")
+		}
+		h.TusCreate(res, tusTestRequest(http.MethodPost, "/api/files/tus", "", map[string]string{"Upload-Length": header, "Upload-Metadata": metadata}))
+		if res.Code != status {
+			t.Fatalf("Upload-Length %s: status = %d, want %d", header, res.Code, status)
+		}
+	}
+
+	res = httptest.NewRecorder()
+	h.TusCreate(res, tusTestRequest(http.MethodPost, "/api/files/tus", "", map[string]string{"Upload-Length": "0", "Upload-Metadata": tusMetadata("relativePath", "dir/empty.txt")}))
+	if info, err := os.Stat(filepath.Join(h.config.StorageDir, "dir", "empty.txt")); res.Code != http.StatusCreated || err != nil || info.Size() != 0 {
+		t.Fatalf("empty upload status = %d, err = %v", res.Code, err)
+	}
+
+	res = httptest.NewRecorder()
+	h.TusCreate(res, tusTestRequest(http.MethodPost, "/api/files/tus", "", map[string]string{"Upload-Length": "3", "Upload-Metadata": tusMetadata("filename", "gone.bin")}))
+	location := res.Header().Get("Location")
+	override := tusTestRequest(http.MethodPost, location, "", map[string]string{"X-HTTP-Method-Override": "DELETE"})
+	res = httptest.NewRecorder()
+	h.TusMethodOverride(res, override)
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("terminate status = %d", res.Code)
+	}
+	res = httptest.NewRecorder()
+	h.TusHead(res, tusTestRequest(http.MethodHead, location, "", nil))
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("HEAD after terminate status = %d", res.Code)
+	}
+	if _, err := os.Stat(h.uploadTempPath(path.Base(location))); !os.IsNotExist(err) {
+		t.Fatalf("upload data was kept: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+// Content checksums for resumable uploads. The server hashes every chunk as it
+// is written and keeps the running SHA-256 state in the session JSON, so a
+// declared whole-file digest is checked at completion without rereading the
+// file, even after a restart. Parallel sessions are read back once at
+// completion instead.
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var errInvalidDigest = errors.New("invalid sha-256 digest")
+
+// parseSHA256Hex decodes the sha256 field of createUploadRequest.
+func parseSHA256Hex(value string) (string, error) {
+	if len(value) != sha256.Size*2 {
+		return "", errInvalidDigest
+	}
+	if _, err := hex.DecodeString(value); err != nil {
+		return "", errInvalidDigest
+	}
+	return strings.ToLower(value), nil
+}
+
+// parseDigestHeader returns the sha-256 member of a Content-Digest or
+// Repr-Digest (RFC 9530, "sha-256=:base64:") or legacy Digest (RFC 3230,
+// "SHA-256=base64") header. Other algorithms are ignored; nil means the
+// header has no sha-256 member.
+func parseDigestHeader(value string) ([]byte, error) {
+	for _, member := range strings.Split(value, ",") {
+		algorithm, encoded, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(algorithm), "sha-256") {
+			continue
+		}
+		encoded = strings.TrimSpace(encoded)
+		if len(encoded) >= 2 && encoded[0] == ':' && encoded[len(encoded)-1] == ':' {
+			encoded = encoded[1 : len(encoded)-1]
+		}
+		digest, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(digest) != sha256.Size {
+			return nil, errInvalidDigest
+		}
+		return digest, nil
+	}
+	return nil, nil
+}
+
+// chunkDigest returns the digest a chunk request declares for its own bytes.
+func chunkDigest(header http.Header) ([]byte, error) {
+	if value := header.Get("Content-Digest"); value != "" {
+		return parseDigestHeader(value)
+	}
+	return parseDigestHeader(header.Get("Digest"))
+}
+
+// formatDigestHeader formats digest as an RFC 9530 dictionary member.
+func formatDigestHeader(digest []byte) string {
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(digest) + ":"
+}
+
+// uploadHash restores the hash of the first UploadedBytes of the session.
+// Sessions saved before hashing existed, and parallel sessions, whose chunks
+// arrive out of order, are hashed from the part file.
+func (h *Handler) uploadHash(session *uploadSession) (hash.Hash, error) {
+	digest := sha256.New()
+	if len(session.HashState) > 0 {
+		if err := digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(session.HashState); err != nil {
+			return nil, err
+		}
+		return digest, nil
+	}
+	if session.UploadedBytes == 0 {
+		return digest, nil
+	}
+	part, err := os.Open(h.uploadTempPath(session.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = part.Close() }()
+	if _, err := io.Copy(digest, io.NewSectionReader(part, 0, session.UploadedBytes)); err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+// uploadDigest returns the SHA-256 of a fully received session.
+func (h *Handler) uploadDigest(session *uploadSession) ([]byte, error) {
+	digest, err := h.uploadHash(session)
+	if err != nil {
+		return nil, err
+	}
+	return digest.Sum(nil), nil
+}
+
+// saveUploadHash stores the running hash in the session; the caller persists it.
+func saveUploadHash(session *uploadSession, digest hash.Hash) error {
+	state, err := digest.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	session.HashState = state
+	return nil
+}
@@ -0,0 +1,118 @@
+package handlers
+
+// Conflict handling for finalized uploads. Except for the default overwrite,
+// whether the target exists is decided by the rename that publishes the file,
+// so a file created concurrently is never clobbered by skip, rename, or fail.
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errUploadTargetExists = &uploadError{status: http.StatusConflict, message: "Upload target already exists"}
+
+// parseUploadConflictPolicy accepts the copy policies plus newer-only, which
+// compares the client's modification time with the existing file's.
+func parseUploadConflictPolicy(value string) (conflictPolicy, error) {
+	if conflictPolicy(strings.ToLower(value)) == conflictNewerOnly {
+		return conflictNewerOnly, nil
+	}
+	return parseConflictPolicy(value, conflictOverwrite)
+}
+
+// linkNoReplace publishes oldpath as newpath with a hard link, which fails if
+// newpath exists, and then removes oldpath. Filesystems without hard links
+// (FAT, exFAT) fall back to a plain rename after an existence check.
+func linkNoReplace(oldpath, newpath string) error {
+	err := os.Link(oldpath, newpath)
+	if err == nil {
+		return os.Remove(oldpath)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	if _, statErr := os.Lstat(newpath); !os.IsNotExist(statErr) {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrExist}
+	}
+	return os.Rename(oldpath, newpath)
+}
+
+// replaceOlderUpload replaces a target found to be older than lastModified.
+// The files are exchanged atomically and the displaced one is checked again,
+// so a newer file written after the comparison is swapped back and kept.
+// Without RENAME_EXCHANGE the replacement is a plain rename, which replaces
+// such a file too.
+func replaceOlderUpload(partPath, target string, lastModified time.Time) (bool, error) {
+	err := exchangePaths(partPath, target)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return false, os.Rename(partPath, target)
+	}
+	if err != nil {
+		return false, err
+	}
+	displaced, err := os.Lstat(partPath)
+	if err == nil && displaced.Mode().IsRegular() && lastModified.After(displaced.ModTime()) {
+		return false, os.Remove(partPath)
+	}
+	if err := exchangePaths(partPath, target); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// placeUpload moves a verified part file to target according to the session's
+// conflict policy. It returns the path written, which differs from target for
+// rename; skipped reports that the existing file was kept instead.
+func (h *Handler) placeUpload(session *uploadSession, partPath, target string) (string, bool, error) {
+	if session.OnConflict == "" || session.OnConflict == conflictOverwrite {
+		return target, false, os.Rename(partPath, target)
+	}
+	original := target
+	for attempt := 0; attempt < maxRenameAttempts; attempt++ {
+		err := renameNoReplace(partPath, target)
+		if !errors.Is(err, fs.ErrExist) {
+			return target, false, err
+		}
+		switch session.OnConflict {
+		case conflictSkip:
+			return target, true, nil
+		case conflictNewerOnly:
+			existing, err := os.Lstat(target)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return "", false, err
+			}
+			if !existing.Mode().IsRegular() {
+				return "", false, errUploadTargetExists
+			}
+			lastModified := time.UnixMilli(session.LastModified)
+			if !lastModified.After(existing.ModTime()) {
+				return target, true, nil
+			}
+			skipped, err := replaceOlderUpload(partPath, target, lastModified)
+			return target, skipped, err
+		case conflictRename:
+			next, err := nextAvailableName(original)
+			if err != nil {
+				return "", false, err
+			}
+			// Record the chosen name first, so a completion interrupted after
+			// the rename still finds the file.
+			session.RelativePath = filepath.Join(filepath.Dir(session.RelativePath), filepath.Base(next))
+			if err := h.writeUploadSession(session); err != nil {
+				return "", false, err
+			}
+			target = next
+		default:
+			return "", false, errUploadTargetExists
+		}
+	}
+	return "", false, errUploadTargetExists
+}
@@ -0,0 +1,188 @@
+package handlers
+
+// Parallel upload sessions accept non-overlapping ranges in any order, so one
+// large file can be sent over several connections. Each chunk is written at
+// its own offset without holding the session lock; the lock only guards the
+// received extents, which are persisted with the session.
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uploadExtent is a received byte range; End is exclusive.
+type uploadExtent struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// addUploadExtent inserts extent into a sorted list, merging it with the
+// ranges it touches.
+func addUploadExtent(extents []uploadExtent, extent uploadExtent) []uploadExtent {
+	merged := make([]uploadExtent, 0, len(extents)+1)
+	for _, current := range extents {
+		switch {
+		case current.End < extent.Start:
+			merged = append(merged, current)
+		case extent.End < current.Start:
+			merged = append(merged, extent)
+			extent = current
+		default:
+			extent = uploadExtent{Start: min(current.Start, extent.Start), End: max(current.End, extent.End)}
+		}
+	}
+	return append(merged, extent)
+}
+
+func overlapsUploadExtent(extents []uploadExtent, extent uploadExtent) bool {
+	for _, current := range extents {
+		if current.Start < extent.End && extent.Start < current.End {
+			return true
+		}
+	}
+	return false
+}
+
+func coversUploadExtent(extents []uploadExtent, extent uploadExtent) bool {
+	for _, current := range extents {
+		if current.Start <= extent.Start && extent.End <= current.End {
+			return true
+		}
+	}
+	return false
+}
+
+func uploadExtentBytes(extents []uploadExtent) int64 {
+	var total int64
+	for _, extent := range extents {
+		total += extent.End - extent.Start
+	}
+	return total
+}
+
+// formatExtentRanges formats received ranges as a Range header value.
+func formatExtentRanges(extents []uploadExtent) string {
+	ranges := make([]string, len(extents))
+	for i, extent := range extents {
+		ranges[i] = strconv.FormatInt(extent.Start, 10) + "-" + strconv.FormatInt(extent.End-1, 10)
+	}
+	return "bytes=" + strings.Join(ranges, ",")
+}
+
+// receivedExtents returns the received ranges of any session; a sequential
+// session has received one range from the start.
+func (s *uploadSession) receivedExtents() []uploadExtent {
+	if s.Parallel {
+		return s.Extents
+	}
+	if s.UploadedBytes == 0 {
+		return nil
+	}
+	return []uploadExtent{{Start: 0, End: s.UploadedBytes}}
+}
+
+// contiguousBytes is the length of the received prefix, the offset a
+// sequential client resumes from.
+func (s *uploadSession) contiguousBytes() int64 {
+	if extents := s.receivedExtents(); len(extents) > 0 && extents[0].Start == 0 {
+		return extents[0].End
+	}
+	return 0
+}
+
+// writeParallelChunk stores one range of a parallel session. It is called with
+// the session lock held and returns with it held, but releases it while the
+// body is written. Ranges being written are reserved in memory, so a range
+// overlapping received or in-flight bytes is rejected instead of racing.
+func (h *Handler) writeParallelChunk(w http.ResponseWriter, r *http.Request, session *uploadSession, extent uploadExtent, wantChunk []byte) {
+	if coversUploadExtent(session.Extents, extent) {
+		h.writeUploadPosition(w, session, http.StatusPermanentRedirect)
+		return
+	}
+	pending, _ := h.uploadRanges.Load(session.ID)
+	inflight, _ := pending.([]uploadExtent)
+	if overlapsUploadExtent(session.Extents, extent) || overlapsUploadExtent(inflight, extent) {
+		h.writeUploadPosition(w, session, http.StatusConflict)
+		return
+	}
+	h.uploadRanges.Store(session.ID, append(slices.Clone(inflight), extent))
+
+	lock := h.sessionMutex(session.ID)
+	lock.Unlock()
+	queueDelay, writeTime, writeErr := h.writeUploadRange(r, session.ID, extent, wantChunk)
+	lock.Lock()
+
+	pending, _ = h.uploadRanges.Load(session.ID)
+	inflight = slices.DeleteFunc(slices.Clone(pending.([]uploadExtent)), func(e uploadExtent) bool { return e == extent })
+	if len(inflight) == 0 {
+		h.uploadRanges.Delete(session.ID)
+	} else {
+		h.uploadRanges.Store(session.ID, inflight)
+	}
+	if writeErr != nil {
+		if writeErr != errUploadCanceled {
+			h.respondUploadError(w, writeErr)
+		}
+		return
+	}
+	// Other chunks may have been recorded meanwhile, and the session may have
+	// been aborted.
+	current, err := h.readUploadSession(session.ID)
+	if err != nil {
+		h.respondError(w, "Upload session not found", http.StatusNotFound)
+		return
+	}
+	if session.SHA256 != "" {
+		if current.SHA256 != "" && current.SHA256 != session.SHA256 {
+			h.respondError(w, "Repr-Digest does not match the upload checksum", http.StatusConflict)
+			return
+		}
+		current.SHA256 = session.SHA256
+	}
+	current.Extents = addUploadExtent(current.Extents, extent)
+	current.UploadedBytes = uploadExtentBytes(current.Extents)
+	if err := h.writeUploadSession(current); err != nil {
+		h.respondError(w, "Cannot persist upload progress", http.StatusInternalServerError)
+		return
+	}
+	h.publishUploadState(current, false)
+	h.writeUploadTelemetry(w, queueDelay, writeTime)
+	status := http.StatusPermanentRedirect
+	if current.UploadedBytes == current.TotalBytes {
+		status = http.StatusOK
+	}
+	h.writeUploadPosition(w, current, status)
+}
+
+// writeUploadRange writes a chunk at its offset with positioned writes, so
+// concurrent chunks never share a file offset. The bytes of a failed chunk are
+// not recorded and are overwritten when it is resent.
+func (h *Handler) writeUploadRange(r *http.Request, id string, extent uploadExtent, wantChunk []byte) (time.Duration, time.Duration, error) {
+	queueDelay, err := h.acquireUploadGate(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() { <-h.uploadGate }()
+	writeStart := time.Now()
+	// Without O_CREATE a chunk racing an abort cannot recreate the part file.
+	part, err := os.OpenFile(h.uploadTempPath(id), os.O_WRONLY, 0)
+	if err != nil {
+		return queueDelay, 0, &uploadError{http.StatusInternalServerError, "Cannot open upload data"}
+	}
+	defer func() { _ = part.Close() }()
+	length := extent.End - extent.Start
+	prepareUploadRange(part, extent.Start, length)
+	if _, err := copyUploadChunk(io.NewOffsetWriter(part, extent.Start), r, length, true, wantChunk); err != nil {
+		return queueDelay, 0, err
+	}
+	if err := part.Sync(); err != nil {
+		return queueDelay, 0, &uploadError{http.StatusInternalServerError, "Cannot save upload chunk"}
+	}
+	releaseUploadRange(part, extent.Start, length)
+	return queueDelay, time.Since(writeStart), nil
+}
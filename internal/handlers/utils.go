@@ -21,6 +21,8 @@ var fallbackMediaTypes = map[string]string{
 	".avi": "video/x-msvideo", ".mpeg": "video/mpeg", ".mpg": "video/mpeg", ".ts": "video/mp2t",
 	".mp3": "audio/mpeg", ".m4a": "audio/mp4", ".flac": "audio/flac",
 	".ogg": "audio/ogg", ".opus": "audio/ogg", ".wav": "audio/wav",
+	".heic": "image/heic", ".heif": "image/heif", ".tif": "image/tiff", ".tiff": "image/tiff",
+	".m3u": "audio/x-mpegurl", ".m3u8": "audio/x-mpegurl", ".pls": "audio/x-scpls",
 }
 
 func mediaTypeByPath(path string) string {
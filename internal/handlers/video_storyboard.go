@@ -0,0 +1,230 @@
+package handlers
+
+// Video storyboards for seek-bar previews. A storyboard is a JPEG sprite
+// sheet of evenly spaced frames plus a WebVTT track whose cues point into the
+// sheet with #xywh= media fragments, the format video players use for
+// thumbnail tracks. Both files live in the thumbnail cache.
+
+import (
+	"context"
+	"fmt"
+	"image/jpeg"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"puremania/internal/worker"
+)
+
+const (
+	defaultStoryboardFrames = 100
+	minStoryboardFrames     = 10
+	maxStoryboardFrames     = 400
+	storyboardColumns       = 10
+	storyboardTileWidth     = 160
+	storyboardTimeout       = 2 * time.Minute
+)
+
+// storyboardPaths names the sprite sheet and track of one version of a file.
+func (c *thumbnailCache) storyboardPaths(virtualPath string, info os.FileInfo, frames int) (string, string) {
+	base := filepath.Join(c.dir, fileVersionHash(virtualPath, info, "storyboard-"+strconv.Itoa(frames)))
+	return base + ".jpg", base + ".vtt"
+}
+
+// probeDuration returns the duration of a media file in seconds.
+func probeDuration(ctx context.Context, mediaPath string, extraFiles ...*os.File) (float64, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", mediaPath)
+	cmd.ExtraFiles = extraFiles
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to probe duration: %w", err)
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("media has no duration")
+	}
+	return duration, nil
+}
+
+// generateStoryboardSprite tiles frames taken every interval seconds into
+// one sheet. Only keyframes are decoded, which keeps long recordings fast at
+// the cost of some precision.
+func (h *Handler) generateStoryboardSprite(ctx context.Context, videoPath, spritePath string, interval float64, frames int, extraFiles ...*os.File) error {
+	rows := (frames + storyboardColumns - 1) / storyboardColumns
+	filter := fmt.Sprintf("fps=1/%.3f,scale=%d:-2,tile=%dx%d", interval, storyboardTileWidth, storyboardColumns, rows)
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-skip_frame", "nokey", "-i", videoPath, "-an", "-sn", "-vf", filter, "-frames:v", "1", "-q:v", "5", "-y", spritePath)
+	cmd.ExtraFiles = extraFiles
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("ffmpeg command timed out")
+		}
+		return fmt.Errorf("failed to generate storyboard: %w. Output: %s", err, string(output))
+	}
+	return nil
+}
+
+// storyboardTrack writes the WebVTT cues of a sprite sheet.
+func storyboardTrack(spriteURL string, duration, interval float64, frames, tileWidth, tileHeight int) string {
+	var track strings.Builder
+	track.WriteString("WEBVTT\n")
+	for i := 0; i < frames; i++ {
+		start := float64(i) * interval
+		if start >= duration {
+			break
+		}
+		end := min(start+interval, duration)
+		x, y := (i%storyboardColumns)*tileWidth, (i/storyboardColumns)*tileHeight
+		fmt.Fprintf(&track, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n", vttTimestamp(start), vttTimestamp(end), spriteURL, x, y, tileWidth, tileHeight)
+	}
+	return track.String()
+}
+
+func vttTimestamp(seconds float64) string {
+	millis := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", millis/3600000, millis/60000%60, millis/1000%60, millis%1000)
+}
+
+// ensureStoryboard returns the cached sprite sheet and track of fullPath,
+// generating them first when needed.
+func (h *Handler) ensureStoryboard(ctx context.Context, virtualPath, fullPath string, frames int) (string, string, error) {
+	source, err := h.openAllowedPath(fullPath, os.O_RDONLY, 0)
+	if err != nil {
+		return "", "", err
+	}
+	defer func() { _ = source.Close() }()
+	info, err := source.Stat()
+	if err != nil {
+		return "", "", err
+	}
+	spritePath, trackPath := h.thumbnails.storyboardPaths(virtualPath, info, frames)
+	if h.thumbnails.touch(spritePath) && h.thumbnails.touch(trackPath) {
+		return spritePath, trackPath, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, storyboardTimeout)
+	defer cancel()
+	videoPath, extraFiles := childProcessFilePath(source, fullPath)
+	duration, err := probeDuration(ctx, videoPath, extraFiles...)
+	if err != nil {
+		return "", "", err
+	}
+	interval := duration / float64(frames)
+
+	tmp, err := os.CreateTemp(h.thumbnails.dir, ".thumbnail-*.jpg")
+	if err != nil {
+		return "", "", err
+	}
+	tmpPath := tmp.Name()
+	if err := tmp.Close(); err != nil {
+		return "", "", err
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+	if err := h.generateStoryboardSprite(ctx, videoPath, tmpPath, interval, frames, extraFiles...); err != nil {
+		return "", "", err
+	}
+	sprite, err := os.Open(tmpPath)
+	if err != nil {
+		return "", "", err
+	}
+	config, err := jpeg.DecodeConfig(sprite)
+	_ = sprite.Close()
+	if err != nil {
+		return "", "", err
+	}
+	rows := (frames + storyboardColumns - 1) / storyboardColumns
+	spriteURL := "/api/files/storyboard/sprite?" + url.Values{"path": {virtualPath}, "frames": {strconv.Itoa(frames)}}.Encode()
+	track := storyboardTrack(spriteURL, duration, interval, frames, config.Width/storyboardColumns, config.Height/rows)
+
+	if err := atomicWriteFile(trackPath, []byte(track), 0644); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(tmpPath, spritePath); err != nil {
+		return "", "", err
+	}
+	if err := h.thumbnails.cleanupIfDue(true); err != nil {
+		h.logger.Warn("Failed to enforce thumbnail cache limit", "path", h.thumbnails.dir, "error", err)
+	}
+	return spritePath, trackPath, nil
+}
+
+// serveStoryboard answers with the track, or with the sprite sheet when
+// sprite is set. The optional frames parameter sets the number of previews.
+func (h *Handler) serveStoryboard(w http.ResponseWriter, r *http.Request, sprite bool) {
+	if err := h.thumbnails.ensureDir(); err != nil {
+		h.logger.Error("Failed to create thumbnail directory", "path", h.thumbnails.dir, "error", err)
+		h.respondError(w, "Cannot create thumbnail directory", http.StatusInternalServerError)
+		return
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		h.respondError(w, "Path required", http.StatusBadRequest)
+		return
+	}
+	if len(path) > maxVirtualPathBytes {
+		h.respondError(w, "Path is too long", http.StatusBadRequest)
+		return
+	}
+	frames := defaultStoryboardFrames
+	if value := r.URL.Query().Get("frames"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < minStoryboardFrames || parsed > maxStoryboardFrames {
+			h.respondError(w, fmt.Sprintf("frames must be between %d and %d", minStoryboardFrames, maxStoryboardFrames), http.StatusBadRequest)
+			return
+		}
+		frames = parsed
+	}
+	if !strings.HasPrefix(mediaTypeByPath(path), "video/") {
+		h.respondError(w, "Storyboards are only available for videos", http.StatusBadRequest)
+		return
+	}
+	fullPath, err := h.convertToPhysicalPath(path)
+	if err != nil {
+		h.respondError(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		h.respondError(w, "Cannot inspect file", http.StatusBadRequest)
+		return
+	}
+
+	spritePath, trackPath := h.thumbnails.storyboardPaths(path, info, frames)
+	if !h.thumbnails.lookup(spritePath) || !h.thumbnails.lookup(trackPath) {
+		if !tryAcquire(h.thumbnailGate) {
+			respondBusy(w)
+			return
+		}
+		result := <-worker.SubmitWithResult(h.workerPool, func() interface{} {
+			_, _, err := h.ensureStoryboard(r.Context(), path, fullPath, frames)
+			return err
+		})
+		release(h.thumbnailGate)
+		if err, _ := result.(error); err != nil {
+			h.logger.Error("Failed to generate storyboard", "path", fullPath, "error", err)
+			h.respondError(w, "Cannot generate storyboard", http.StatusInternalServerError)
+			return
+		}
+	}
+	if sprite {
+		http.ServeFile(w, r, spritePath)
+		return
+	}
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	http.ServeFile(w, r, trackPath)
+}
+
+// Storyboard serves the WebVTT thumbnail track of a video.
+func (h *Handler) Storyboard(w http.ResponseWriter, r *http.Request) {
+	h.serveStoryboard(w, r, false)
+}
+
+// StoryboardSprite serves the sprite sheet that the track refers to.
+func (h *Handler) StoryboardSprite(w http.ResponseWriter, r *http.Request) {
+	h.serveStoryboard(w, r, true)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestStoryboardTrackCoversDuration(t *testing.T) {
+	track := storyboardTrack("/sprite", 25, 10, 3, 160, 90)
+	want := "WEBVTT\n" +
+		"\n00:00:00.000 --> 00:00:10.000\n/sprite#xywh=0,0,160,90\n" +
+		"\n00:00:10.000 --> 00:00:20.000\n/sprite#xywh=160,0,160,90\n" +
+		"\n00:00:20.000 --> 00:00:25.000\n/sprite#xywh=320,0,160,90\n"
+	if track != want {
+		t.Fatalf("track = %q", track)
+	}
+	if got := vttTimestamp(3725.0625); got != "01:02:05.063" {
+		t.Fatalf("timestamp = %q", got)
+	}
+}
+
+// installFakeCommands puts shell scripts named after the commands first on
+// PATH, standing in for ffmpeg and ffprobe.
+func installFakeCommands(t *testing.T, scripts map[string]string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts stand in for ffmpeg")
+	}
+	bin := t.TempDir()
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return bin
+}
+
+// installFakeFFmpeg makes ffprobe report the given duration and ffmpeg copy a
+// prepared sprite sheet to its output.
+func installFakeFFmpeg(t *testing.T, duration string, sprite image.Image) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, sprite, nil); err != nil {
+		t.Fatal(err)
+	}
+	spritePath := filepath.Join(t.TempDir(), "sprite.jpg")
+	if err := os.WriteFile(spritePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	installFakeCommands(t, map[string]string{
+		"ffprobe": "echo " + duration + "\n",
+		"ffmpeg":  "for last; do :; done\ncp '" + spritePath + "' \"$last\"\n",
+	})
+}
+
+func TestStoryboardServesTrackAndSprite(t *testing.T) {
+	h := newContentTestHandler(t)
+	h.thumbnails.dir = t.TempDir()
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "talk.mp4"), "video")
+	installFakeFFmpeg(t, "95.0", image.NewRGBA(image.Rect(0, 0, 1600, 180)))
+
+	res := httptest.NewRecorder()
+	h.Storyboard(res, httptest.NewRequest(http.MethodGet, "/api/files/storyboard?path=/talk.mp4&frames=20", nil))
+	if res.Code != http.StatusOK || !strings.HasPrefix(res.Header().Get("Content-Type"), "text/vtt") {
+		t.Fatalf("status = %d, Content-Type = %q: %s", res.Code, res.Header().Get("Content-Type"), res.Body.String())
+	}
+	track := res.Body.String()
+	if strings.Count(track, "-->") != 20 || !strings.Contains(track, "\n00:01:30.250 --> 00:01:35.000\n/api/files/storyboard/sprite?frames=20&path=%2Ftalk.mp4#xywh=1440,90,160,90\n") {
+		t.Fatalf("unexpected track: %s", track)
+	}
+
+	// The sprite comes from the cache even once ffmpeg is gone.
+	t.Setenv("PATH", "")
+	res = httptest.NewRecorder()
+	h.StoryboardSprite(res, httptest.NewRequest(http.MethodGet, "/api/files/storyboard/sprite?path=/talk.mp4&frames=20", nil))
+	if res.Code != http.StatusOK || res.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("sprite status = %d, Content-Type = %q", res.Code, res.Header().Get("Content-Type"))
+	}
+	if h.thumbnails.hits.Load() != 2 {
+		t.Fatalf("hits = %d, want the sprite and track served from the cache", h.thumbnails.hits.Load())
+	}
+}
+
+func TestStoryboardRejectsInvalidRequests(t *testing.T) {
+	h := newContentTestHandler(t)
+	h.thumbnails.dir = t.TempDir()
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "notes.txt"), "text")
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "clip.mp4"), "video")
+	for _, query := range []string{"path=/notes.txt", "path=/clip.mp4&frames=5", "path=/clip.mp4&frames=x", "path=/missing.mp4"} {
+		res := httptest.NewRecorder()
+		h.Storyboard(res, httptest.NewRequest(http.MethodGet, "/api/files/storyboard?"+query, nil))
+		if res.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d", query, res.Code)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package handlers
+
+// Directory watching. Directories are watched once a client lists them, so
+// changes made outside Pure Mania (scp, a shell, another program) invalidate
+// cached listings and reach open tabs as "directory-changed" events. A watched
+// directory's ETag is a version counter, which replaces hashing the whole
+// directory on every request.
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"puremania/internal/cache"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxWatchedDirectories = 1024
+	watchIdleTimeout      = 10 * time.Minute
+	watchFlushInterval    = 200 * time.Millisecond
+	watchPruneInterval    = time.Minute
+)
+
+var errWatchUnsupported = errors.New("directory watching is not supported on this platform")
+
+// watchMask selects which changes a watch backend reports.
+type watchMask int
+
+const (
+	watchNames           watchMask = iota // entries created, deleted, or renamed
+	watchNamesAndContent                  // also completed writes and attribute changes
+)
+
+// watchBackend is the platform notification API. run reports the watch
+// descriptor of every directory whose entries changed; gone is set once the
+// watch has been removed by the kernel. A negative descriptor means events
+// were lost and every directory must be treated as changed.
+type watchBackend interface {
+	add(path string) (int, error)
+	remove(wd int)
+	run(changed func(wd int, gone bool))
+}
+
+type watchedDirectory struct {
+	wd         int
+	version    uint64
+	lastViewed time.Time
+}
+
+type directoryWatcher struct {
+	mu        sync.Mutex
+	backend   watchBackend
+	epoch     uint32
+	sequence  uint64
+	dirs      map[string]*watchedDirectory
+	byWD      map[int]string
+	dirty     map[string]struct{}
+	lastPrune time.Time
+	onChange  func(paths []string)
+}
+
+func newDirectoryWatcher(onChange func(paths []string)) (*directoryWatcher, error) {
+	backend, err := newWatchBackend(watchNamesAndContent)
+	if err != nil {
+		return nil, err
+	}
+	w := &directoryWatcher{
+		backend: backend,
+		// ETags must not repeat after a restart resets the counters.
+		epoch:    uint32(time.Now().UnixNano()),
+		dirs:     make(map[string]*watchedDirectory),
+		byWD:     make(map[int]string),
+		dirty:    make(map[string]struct{}),
+		onChange: onChange,
+	}
+	go backend.run(w.changed)
+	go w.flushLoop()
+	return w, nil
+}
+
+// state watches dir if necessary and returns its current state key. The watch
+// is installed before the caller reads the directory, so no change can fall
+// between the read and the watch.
+func (w *directoryWatcher) state(dir string) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	entry, ok := w.dirs[dir]
+	if !ok {
+		if len(w.dirs) >= maxWatchedDirectories {
+			w.evictOldestLocked()
+		}
+		wd, err := w.backend.add(dir)
+		if err != nil {
+			return "", err
+		}
+		if existing, ok := w.byWD[wd]; ok && existing != dir {
+			// Another path reached the same inode; keep one owner per watch.
+			return "", fmt.Errorf("directory is already watched as %s", existing)
+		}
+		w.sequence++
+		entry = &watchedDirectory{wd: wd, version: w.sequence}
+		w.dirs[dir] = entry
+		w.byWD[wd] = dir
+	}
+	entry.lastViewed = time.Now()
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(dir))
+	return fmt.Sprintf("w%x-%x-%x", w.epoch, hash.Sum64(), entry.version), nil
+}
+
+func (w *directoryWatcher) evictOldestLocked() {
+	var oldest string
+	var oldestTime time.Time
+	for dir, entry := range w.dirs {
+		if oldest == "" || entry.lastViewed.Before(oldestTime) {
+			oldest, oldestTime = dir, entry.lastViewed
+		}
+	}
+	if oldest != "" {
+		w.forgetLocked(oldest, true)
+	}
+}
+
+func (w *directoryWatcher) forgetLocked(dir string, removeWatch bool) {
+	entry, ok := w.dirs[dir]
+	if !ok {
+		return
+	}
+	if removeWatch {
+		w.backend.remove(entry.wd)
+	}
+	delete(w.byWD, entry.wd)
+	delete(w.dirs, dir)
+}
+
+// changed bumps versions immediately so the next request sees a new ETag, and
+// leaves cache invalidation and events to the coalescing flush loop.
+func (w *directoryWatcher) changed(wd int, gone bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if wd < 0 {
+		for dir, entry := range w.dirs {
+			w.sequence++
+			entry.version = w.sequence
+			w.dirty[dir] = struct{}{}
+		}
+		return
+	}
+	dir, ok := w.byWD[wd]
+	if !ok {
+		return
+	}
+	w.sequence++
+	w.dirs[dir].version = w.sequence
+	w.dirty[dir] = struct{}{}
+	if gone {
+		// Removing an already dropped watch fails harmlessly; a directory that
+		// was renamed away is still watched and must be released.
+		w.forgetLocked(dir, true)
+	}
+}
+
+func (w *directoryWatcher) flushLoop() {
+	ticker := time.NewTicker(watchFlushInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		w.mu.Lock()
+		if now.Sub(w.lastPrune) >= watchPruneInterval {
+			w.lastPrune = now
+			for dir, entry := range w.dirs {
+				if now.Sub(entry.lastViewed) > watchIdleTimeout {
+					w.forgetLocked(dir, true)
+				}
+			}
+		}
+		if len(w.dirty) == 0 {
+			w.mu.Unlock()
+			continue
+		}
+		paths := make([]string, 0, len(w.dirty))
+		for dir := range w.dirty {
+			paths = append(paths, dir)
+			delete(w.dirty, dir)
+		}
+		w.mu.Unlock()
+		sort.Strings(paths)
+		w.onChange(paths)
+	}
+}
+
+// directoryStateKey identifies the current contents of a listed directory. It
+// uses the watcher when possible and falls back to hashing the entries.
+func (h *Handler) directoryStateKey(virtualPath, physicalPath string) (string, error) {
+	watcher := h.directoryWatcher()
+	if watcher == nil {
+		return h.generateDirectoryStateKey(virtualPath)
+	}
+	key, err := watcher.state(physicalPath)
+	if err != nil {
+		return h.generateDirectoryStateKey(virtualPath)
+	}
+	if virtualPath == "/" {
+		// The root listing also shows every mount directory.
+		for _, mountDir := range h.config.MountDirs {
+			mountKey, err := watcher.state(mountDir)
+			if err != nil {
+				return h.generateDirectoryStateKey(virtualPath)
+			}
+			key += "." + mountKey
+		}
+	}
+	return key, nil
+}
+
+// directoryWatcher starts the watcher on first use. Platforms without a
+// notification API keep using per-request hashing.
+func (h *Handler) directoryWatcher() *directoryWatcher {
+	h.watcherOnce.Do(func() {
+		watcher, err := newDirectoryWatcher(h.directoriesChanged)
+		if err != nil {
+			if !errors.Is(err, errWatchUnsupported) {
+				h.logger.Warn("Directory watching is unavailable", "error", err)
+			}
+			return
+		}
+		h.watcher = watcher
+	})
+	return h.watcher
+}
+
+func (h *Handler) cleanVirtualPath(physicalPath string) string {
+	return path.Clean(h.convertToVirtualPath(physicalPath))
+}
+
+func listCacheKey(virtualDir, stateKey string) string {
+	return "list:" + virtualDir + "#" + stateKey
+}
+
+func (h *Handler) directoriesChanged(paths []string) {
+	for _, dir := range paths {
+		virtualDir := h.cleanVirtualPath(dir)
+		cache.InvalidateByPrefix(h.cache, "list:"+virtualDir+"#")
+		h.events.publish(serverEvent{name: "directory-changed", key: "directory:" + virtualDir, data: map[string]string{"path": virtualDir}})
+		for _, mountDir := range h.config.MountDirs {
+			if dir == mountDir {
+				cache.InvalidateByPrefix(h.cache, "list:/#")
+				h.events.publish(serverEvent{name: "directory-changed", key: "directory:/", data: map[string]string{"path": "/"}})
+			}
+		}
+	}
+	cache.InvalidateByPrefix(h.cache, "search:")
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+package handlers
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	inotifyNameMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+		unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ONLYDIR
+	// IN_MODIFY is left out: every write(2) would reload the listing in all
+	// open tabs while a file is being written. Listings change when the writer
+	// closes the file.
+	inotifyContentMask = unix.IN_ATTRIB | unix.IN_CLOSE_WRITE
+)
+
+type inotifyBackend struct {
+	fd   int
+	file *os.File
+	mask uint32
+}
+
+func newWatchBackend(mask watchMask) (watchBackend, error) {
+	// A non-blocking descriptor lets os.File use the runtime poller instead of
+	// parking a thread in read(2).
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	backend := &inotifyBackend{fd: fd, file: os.NewFile(uintptr(fd), "inotify"), mask: inotifyNameMask}
+	if mask == watchNamesAndContent {
+		backend.mask |= inotifyContentMask
+	}
+	return backend, nil
+}
+
+func (b *inotifyBackend) add(path string) (int, error) {
+	return unix.InotifyAddWatch(b.fd, path, b.mask)
+}
+
+func (b *inotifyBackend) remove(wd int) {
+	_, _ = unix.InotifyRmWatch(b.fd, uint32(wd))
+}
+
+func (b *inotifyBackend) run(changed func(wd int, gone bool)) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := b.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+			if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				changed(-1, false)
+				continue
+			}
+			changed(int(event.Wd), event.Mask&(unix.IN_IGNORED|unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+//go:build !linux
+
+package handlers
+
+func newWatchBackend(watchMask) (watchBackend, error) { return nil, errWatchUnsupported }
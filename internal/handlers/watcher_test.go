@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExternalChangeInvalidatesWatchedListing(t *testing.T) {
+	h := newContentTestHandler(t)
+	if h.directoryWatcher() == nil {
+		t.Skip("directory watching is not supported on this platform")
+	}
+	events, unsubscribe := h.events.subscribe()
+	defer unsubscribe()
+
+	first := httptest.NewRecorder()
+	h.ListFiles(first, httptest.NewRequest(http.MethodGet, "/api/files?path=/", nil))
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status = %d, ETag = %q", first.Code, etag)
+	}
+	// Written directly, as scp or a shell would, bypassing the API.
+	writeTestFile(t, filepath.Join(h.config.StorageDir, "external.txt"), "data")
+
+	deadline := time.After(5 * time.Second)
+	for changed := false; !changed; {
+		select {
+		case <-events.ready:
+			for _, event := range events.drain() {
+				data, _ := event.data.(map[string]string)
+				changed = changed || (event.name == "directory-changed" && data["path"] == "/")
+			}
+		case <-deadline:
+			t.Fatal("no directory-changed event for an external write")
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files?path=/", nil)
+	req.Header.Set("If-None-Match", etag)
+	second := httptest.NewRecorder()
+	h.ListFiles(second, req)
+	if second.Code != http.StatusOK || second.Header().Get("ETag") == etag {
+		t.Fatalf("status = %d, ETag = %q after external change", second.Code, second.Header().Get("ETag"))
+	}
+}
+
+func TestUnchangedWatchedListingIsNotModified(t *testing.T) {
+	h := newContentTestHandler(t)
+	first := httptest.NewRecorder()
+	h.ListFiles(first, httptest.NewRequest(http.MethodGet, "/api/files?path=/", nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files?path=/", nil)
+	req.Header.Set("If-None-Match", first.Header().Get("ETag"))
+	second := httptest.NewRecorder()
+	h.ListFiles(second, req)
+	if second.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want %d", second.Code, http.StatusNotModified)
+	}
+}
+
+func TestWatchedDirectoryIgnoresWritesUntilClose(t *testing.T) {
+	h := newContentTestHandler(t)
+	if h.directoryWatcher() == nil {
+		t.Skip("directory watching is not supported on this platform")
+	}
+	file, err := os.Create(filepath.Join(h.config.StorageDir, "growing.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+	h.ListFiles(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/files?path=/", nil))
+	events, unsubscribe := h.events.subscribe()
+	defer unsubscribe()
+
+	changed := func(wait time.Duration) bool {
+		deadline := time.After(wait)
+		for {
+			select {
+			case <-events.ready:
+				for _, event := range events.drain() {
+					if event.name == "directory-changed" {
+						return true
+					}
+				}
+			case <-deadline:
+				return false
+			}
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := file.WriteString("line\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if changed(4 * watchFlushInterval) {
+		t.Fatal("writes to an open file published directory-changed")
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !changed(5 * time.Second) {
+		t.Fatal("no directory-changed event after the writer closed the file")
+	}
+}
@@ -39,7 +39,9 @@ func CSRF(next http.Handler) http.Handler {
 				next.ServeHTTP(w, r)
 				return
 			}
-			if r.URL.Path == "/api/files/upload" || strings.HasSuffix(r.URL.Path, "/chunks") {
+			// tus requests must carry Tus-Resumable, a header that browsers only
+			// send cross-origin after a CORS preflight, which is never granted.
+			if r.URL.Path == "/api/files/upload" || strings.HasSuffix(r.URL.Path, "/chunks") || isTusPath(r.URL.Path) {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -58,7 +60,11 @@ func CSRF(next http.Handler) http.Handler {
 }
 
 func isStateChangingMethod(method string) bool {
-	return method == http.MethodPost || method == http.MethodPut || method == http.MethodDelete
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch || method == http.MethodDelete
+}
+
+func isTusPath(path string) bool {
+	return path == "/api/files/tus" || strings.HasPrefix(path, "/api/files/tus/")
 }
 
 func hasJSONContentType(r *http.Request) bool {
@@ -62,6 +62,8 @@ func TestCSRFAllowsStreamingEndpointsWithoutJSON(t *testing.T) {
 	}{
 		{http.MethodPut, "/api/files/upload-sessions/abc/chunks", "application/octet-stream"},
 		{http.MethodPost, "/api/files/upload", "multipart/form-data; boundary=x"},
+		{http.MethodPost, "/api/files/tus", ""},
+		{http.MethodPatch, "/api/files/tus/abc", "application/offset+octet-stream"},
 	} {
 		res := httptest.NewRecorder()
 		req := httptest.NewRequest(tc.method, tc.path, nil)
@@ -73,6 +75,16 @@ func TestCSRFAllowsStreamingEndpointsWithoutJSON(t *testing.T) {
 	}
 }
 
+func TestCSRFRejectsCrossSitePatch(t *testing.T) {
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPatch, "/api/files/tus/abc", nil)
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	newCSRFTestHandler().ServeHTTP(res, req)
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", res.Code, http.StatusForbidden)
+	}
+}
+
 func TestCSRFAllowsBodylessDelete(t *testing.T) {
 	res := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodDelete, "/api/files/upload-sessions/abcdef", nil)
@@ -16,7 +16,7 @@ func RequestBodyLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Body != nil && strings.HasPrefix(r.URL.Path, "/api/") &&
 			r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions &&
-			r.URL.Path != "/api/files/upload" && !strings.HasSuffix(r.URL.Path, "/chunks") {
+			r.URL.Path != "/api/files/upload" && !strings.HasSuffix(r.URL.Path, "/chunks") && !isTusPath(r.URL.Path) {
 			limit := int64(maxJSONBodyBytes)
 			if r.URL.Path == "/api/files/save" {
 				limit = maxSaveBodyBytes
@@ -35,7 +35,7 @@ func TestRequestBodyLimitMiddlewarePreservesStreamingEndpoints(t *testing.T) {
 	}))
 
 	body := bytes.Repeat([]byte("x"), maxJSONBodyBytes+1)
-	for _, path := range []string{"/api/files/upload", "/api/files/upload-sessions/id/chunks"} {
+	for _, path := range []string{"/api/files/upload", "/api/files/upload-sessions/id/chunks", "/api/files/tus/id"} {
 		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
 		res := httptest.NewRecorder()
 		handler.ServeHTTP(res, req)
@@ -14,4 +14,12 @@ type Config struct {
 	Aria2cEnabled         bool
 	UploadSessionTTLHours int
 	PreallocateUploads    bool
+	TrashTTLHours         int
+	TrashMaxSizeMB        int64
+	SearchIndexDir        string // empty disables the filename index
+	ThumbnailDir          string
+	ThumbnailMaxSizeMB    int64
+	ThumbnailMaxFiles     int
+	SubsonicMusicDir      string // virtual path served by the Subsonic API
+	PublicURL             string // base URL of exported playlists; empty disables playlist export
 }
@@ -23,6 +23,14 @@ type SaveFileRequest struct {
 
 type BatchPathsRequest struct {
 	Paths []string `json:"paths"`
+	Async bool     `json:"async,omitempty"`
+}
+
+// DeleteRequest は削除リクエスト。Permanent が false の場合はゴミ箱へ移動する
+type DeleteRequest struct {
+	Paths     []string `json:"paths"`
+	Permanent bool     `json:"permanent,omitempty"`
+	Async     bool     `json:"async,omitempty"`
 }
 
 // UploadResult はファイルアップロードの結果
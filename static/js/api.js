@@ -11,6 +11,8 @@ const MAX_CACHED_DIRECTORIES = 20;
 const MAX_CACHED_ENTRIES = 100000;
 const SEARCH_REQUEST_TIMEOUT_MS = 2 * 60_000;
 const LONG_OPERATION_TIMEOUT_MS = 15 * 60_000;
+const JOB_POLL_INTERVAL_MS = 1000;
+const MAX_JOB_POLL_FAILURES = 5;
 
 export class ApiClient {
     constructor(app) {
@@ -152,15 +154,20 @@ export class ApiClient {
         showLoading = false,
         onSuccess = null,
         timeoutMs = DEFAULT_REQUEST_TIMEOUT_MS,
+        jobLabel = '',
         logContext = endpoint
     }) {
         try {
             if (showLoading) this.app.ui.showLoading();
-            const result = await this.postJson(endpoint, payload, {
-                validateSuccess: true,
-                fallbackMessage: errorMessage,
-                timeoutMs
-            });
+            // Operations with a job label run as server jobs, which no request
+            // timeout or closed tab can interrupt.
+            const result = jobLabel
+                ? await this.runLabeledJob(endpoint, payload, jobLabel, errorMessage)
+                : await this.postJson(endpoint, payload, {
+                    validateSuccess: true,
+                    fallbackMessage: errorMessage,
+                    timeoutMs
+                });
 
             if (successMessage) {
                 this.app.ui.showToast('Success', successMessage, 'success');
@@ -179,6 +186,60 @@ export class ApiClient {
         }
     }
 
+    // runJob starts endpoint as a background job and polls the job until it
+    // finishes. The result is returned in the envelope of a direct request.
+    async runJob(endpoint, payload, {
+        fallbackMessage = 'Request failed',
+        onProgress = null,
+        pollIntervalMs = JOB_POLL_INTERVAL_MS
+    } = {}) {
+        const started = await this.postJson(endpoint, { ...payload, async: true }, { fallbackMessage });
+        if (!started?.jobUrl) throw new Error(fallbackMessage);
+        let failures = 0;
+        for (;;) {
+            await new Promise(resolve => setTimeout(resolve, pollIntervalMs));
+            let job;
+            try {
+                job = (await this.request(started.jobUrl, { validateSuccess: true, fallbackMessage })).data;
+                failures = 0;
+            } catch (error) {
+                // The job keeps running on the server; only give up on polling
+                // after repeated failures.
+                if (++failures >= MAX_JOB_POLL_FAILURES) throw error;
+                continue;
+            }
+            onProgress?.(job);
+            if (job.status === 'succeeded') return { success: true, data: job.result };
+            if (job.status === 'failed') throw new Error(job.error || fallbackMessage);
+            if (job.status === 'canceled') throw new Error(`${fallbackMessage}: canceled`);
+        }
+    }
+
+    // runLabeledJob runs a job while its progress is shown in the directory
+    // status, which is cleared before the caller refreshes the listing.
+    async runLabeledJob(endpoint, payload, label, fallbackMessage) {
+        try {
+            return await this.runJob(endpoint, payload, {
+                fallbackMessage,
+                onProgress: job => this.showJobProgress(label, job)
+            });
+        } finally {
+            this.app.ui.setDirectoryStatus('ready');
+        }
+    }
+
+    showJobProgress(label, job) {
+        let detail = '';
+        if (job.status === 'queued') {
+            detail = 'waiting';
+        } else if (job.total > 0) {
+            detail = `${job.done} / ${job.total}`;
+        } else if (job.done > 0) {
+            detail = this.app.ui.formatFileSize(job.done);
+        }
+        this.app.ui.setDirectoryStatus('loading', detail ? `${label} · ${detail}` : `${label}…`);
+    }
+
     async getFiles(path, { signal = null, timeoutMs = DEFAULT_REQUEST_TIMEOUT_MS, useCache = true, detailed = false, limit = 0, cursor = '', sort = '', direction = '' } = {}) {
         const requestSignal = createRequestSignal(signal, { timeoutMs });
         try {
@@ -349,6 +410,7 @@ export class ApiClient {
             successMessage: `Moved "${fileName}" successfully`,
             errorMessage: 'Failed to move file',
             showLoading: true,
+            jobLabel: `Moving "${fileName}"`,
             onSuccess: async () => {
                 await this.refreshCurrentDirectory([getParentPath(sourcePath), targetDir]);
             },
@@ -402,13 +464,8 @@ export class ApiClient {
                 }
                 
                 try {
-                    const result = await this.postJson('/api/files/move', { sourcePath, targetPath });
-                    
-                    if (result.success) {
-                        successCount++;
-                    } else {
-                        failCount++;
-                    }
+                    await this.runLabeledJob('/api/files/move', { sourcePath, targetPath }, `Moving "${fileName}"`, 'Failed to move file');
+                    successCount++;
                 } catch (error) {
                     failCount++;
                 }
@@ -513,7 +570,7 @@ export class ApiClient {
             successMessage: 'File deleted successfully',
             errorMessage: 'Failed to delete file',
             onSuccess: async () => this.refreshCurrentDirectory([], true),
-            timeoutMs: LONG_OPERATION_TIMEOUT_MS,
+            jobLabel: 'Deleting',
             logContext: 'deleting file'
         });
     }
@@ -534,7 +591,7 @@ export class ApiClient {
             successMessage: 'Files deleted successfully',
             errorMessage: 'Failed to delete files',
             onSuccess: async () => this.refreshCurrentDirectory([], true),
-            timeoutMs: LONG_OPERATION_TIMEOUT_MS,
+            jobLabel: 'Deleting',
             logContext: 'deleting files'
         });
     }
@@ -671,17 +728,59 @@ export class ApiClient {
         return result.data;
     }
 
-    async search(term, path, options, limit, cursor = '', signal) {
-        const body = {
-            term: term,
-            path: path,
+    // Streams a search from /api/search/stream. onFrame receives each
+    // "match" and "progress" frame; the result has the same shape as search().
+    async searchStream(term, path, options, limit, cursor = '', signal, onFrame = () => {}) {
+        const body = this.searchBody(term, path, options, limit, cursor);
+        const response = await fetch('/api/search/stream', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json', Accept: 'application/x-ndjson' },
+            body: JSON.stringify(body),
+            signal
+        });
+        if (!response.ok) {
+            const result = await response.json().catch(() => null);
+            return { success: false, message: this.getApiErrorMessage(result, `Search failed (status: ${response.status})`) };
+        }
+        const results = [];
+        const reader = response.body.getReader();
+        const decoder = new TextDecoder();
+        let buffered = '';
+        for (;;) {
+            const { value, done } = await reader.read();
+            buffered += decoder.decode(value, { stream: !done });
+            const lines = buffered.split('\n');
+            buffered = lines.pop();
+            for (const line of lines) {
+                if (!line) continue;
+                const frame = JSON.parse(line);
+                if (frame.event === 'match') results.push(frame.data);
+                if (frame.event === 'error') return { success: false, message: frame.data.message };
+                if (frame.event === 'done') {
+                    return { success: true, data: { data: results, nextCursor: frame.data.nextCursor, hasMore: frame.data.hasMore } };
+                }
+                onFrame(frame.event, frame.data, results);
+            }
+            if (done) return { success: false, message: 'Search ended unexpectedly' };
+        }
+    }
+
+    searchBody(term, path, options, limit, cursor) {
+        return {
+            term,
+            path,
             useRegex: options.useRegex,
             caseSensitive: options.caseSensitive,
             scope: options.scope,
+            mode: options.mode,
+            context: options.mode === 'content' ? 1 : 0,
             cursor,
             limit
         };
+    }
 
+    async search(term, path, options, limit, cursor = '', signal) {
+        const body = this.searchBody(term, path, options, limit, cursor);
         return await this.postJson('/api/search', body, { signal, timeoutMs: SEARCH_REQUEST_TIMEOUT_MS });
     }
 
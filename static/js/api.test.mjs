@@ -58,3 +58,41 @@ test('requestJson reports timeouts with a retryable message', async t => {
     assert.equal(await api.requestJson('/endpoint', { fallbackMessage: 'Directory request' }), null);
     assert.equal(toasts[0][1], 'Directory request timed out. Try again.');
 });
+
+test('runJob starts an async job and polls it until it finishes', async t => {
+    const requests = [];
+    const snapshots = [
+        { status: 'running', done: 5, total: 0 },
+        { status: 'succeeded', done: 10, total: 0, result: { path: '/b' } }
+    ];
+    t.mock.method(globalThis, 'fetch', async (url, options) => {
+        requests.push([url, options.method, options.body]);
+        const body = url === '/api/files/move'
+            ? { jobId: 'j1', jobUrl: '/api/jobs/j1' }
+            : { success: true, data: snapshots.shift() };
+        return new Response(JSON.stringify(body), {
+            status: url === '/api/files/move' ? 202 : 200,
+            headers: { 'Content-Type': 'application/json' }
+        });
+    });
+
+    const progress = [];
+    const result = await client().runJob('/api/files/move', { sourcePath: '/a', targetPath: '/b' }, {
+        onProgress: job => progress.push(job.done),
+        pollIntervalMs: 0
+    });
+    assert.deepEqual(result, { success: true, data: { path: '/b' } });
+    assert.deepEqual(progress, [5, 10]);
+    assert.equal(requests[0][2], JSON.stringify({ sourcePath: '/a', targetPath: '/b', async: true }));
+    assert.deepEqual(requests.slice(1).map(([url, method]) => [url, method]), [['/api/jobs/j1', 'GET'], ['/api/jobs/j1', 'GET']]);
+});
+
+test('runJob reports the error of a failed job', async t => {
+    t.mock.method(globalThis, 'fetch', async url => new Response(JSON.stringify(
+        url === '/api/files/delete'
+            ? { jobId: 'j2', jobUrl: '/api/jobs/j2' }
+            : { success: true, data: { status: 'failed', error: 'permission denied' } }
+    ), { status: 200, headers: { 'Content-Type': 'application/json' } }));
+
+    await assert.rejects(client().runJob('/api/files/delete', { paths: ['/a'] }, { pollIntervalMs: 0 }), /permission denied/);
+});
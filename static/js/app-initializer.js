@@ -24,6 +24,33 @@ function bindRoutes(app) {
     });
 }
 
+const FILE_CHANGE_EVENTS = ['files-created', 'files-deleted', 'files-moved', 'file-saved', 'archive-extracted'];
+
+const parentOf = path => path.replace(/\/[^/]*$/, '') || '/';
+
+// Changes made by other tabs and devices arrive as typed file events, and
+// changes made outside Pure Mania (scp, a shell) as directory-changed. Cached
+// listings of every affected directory are dropped; only the directory on
+// screen is reloaded.
+function bindDirectoryEvents(app) {
+    const onChange = directories => {
+        app.api.invalidateDirectories(directories);
+        const { page } = app.store.getState().route;
+        if (page === 'uploads' || page === 'aria2c' || app.searchHandler.isInSearchMode) return;
+        const currentPath = app.router.getCurrentPath();
+        if (directories.includes(currentPath)) void app.loadFiles(currentPath);
+    };
+    app.eventBus.addEventListener('server:directory-changed', event => {
+        if (event.detail?.path) onChange([event.detail.path]);
+    });
+    for (const type of FILE_CHANGE_EVENTS) {
+        app.eventBus.addEventListener(`server:${type}`, event => {
+            const { directory, from = [] } = event.detail ?? {};
+            if (directory) onChange([directory, ...from.map(parentOf)]);
+        });
+    }
+}
+
 export async function initializeApp(app) {
     app.ui.bindStore();
     app.progressManager.init();
@@ -48,4 +75,5 @@ export async function initializeApp(app) {
     app.ui.updateSpecificDirs(specificDirs);
     app.updateStorageInfo(storageResult);
     bindRoutes(app);
+    bindDirectoryEvents(app);
 }
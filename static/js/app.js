@@ -3,7 +3,7 @@ import { AppStateStore } from './state.js';
 import { ALL_TEMPLATES } from './template-registry.js';
 import { createAppServices } from './app-services.js';
 import { initializeApp } from './app-initializer.js';
-import { getParentPath, isEditableFile } from './util.js';
+import { getParentPath, isEditableFile, isPlaylistFile } from './util.js';
 
 class FileManagerApp {
     constructor() {
@@ -79,7 +79,9 @@ class FileManagerApp {
             }
         }
 
-        if (mimeType && mimeType.startsWith('image/')) {
+        if (isPlaylistFile(path)) {
+            this.mediaPlayer.playPlaylist(path);
+        } else if (mimeType && mimeType.startsWith('image/')) {
             this.imageViewer.open(path);
         } else if (mimeType && mimeType.startsWith('audio/')) {
             this.mediaPlayer.playAudio(path);
@@ -1,5 +1,5 @@
 import { createModalOverlay, bindModalClose, hideModalOverlay, showModalOverlay } from './modal.js';
-import { buildApiUrl } from './util.js';
+import { buildApiUrl, isPlaylistFile } from './util.js';
 
 export class MediaPlayer {
     constructor(app) {
@@ -210,6 +210,11 @@ export class MediaPlayer {
             const rect = e.currentTarget.getBoundingClientRect();
             this.seekTo((e.clientX - rect.left) / rect.width);
         });
+        this.playerElement.querySelector('.ui-progress').addEventListener('mousemove', (e) => {
+            const rect = e.currentTarget.getBoundingClientRect();
+            this.showStoryboardPreview((e.clientX - rect.left) / rect.width);
+        });
+        this.playerElement.querySelector('.ui-progress').addEventListener('mouseleave', () => this.hideStoryboardPreview());
         this.playerElement.querySelector('.ui-progress').addEventListener('keydown', (event) => {
             if (!['ArrowLeft', 'ArrowRight', 'Home', 'End'].includes(event.key)) return;
             event.preventDefault();
@@ -243,7 +248,7 @@ export class MediaPlayer {
     createPlaylistFromUI() {
         const mediaFiles = [];
         document.querySelectorAll('.file-item, .masonry-item').forEach(item => {
-            if (item.dataset.path && (item.dataset.mimeType || '').startsWith('audio/')) {
+            if (item.dataset.path && (item.dataset.mimeType || '').startsWith('audio/') && !isPlaylistFile(item.dataset.path)) {
                 mediaFiles.push({
                     path: item.dataset.path,
                     mime_type: item.dataset.mimeType,
@@ -268,6 +273,32 @@ export class MediaPlayer {
         this.playCurrentTrack();
     }
 
+    async playPlaylist(path) {
+        let entries;
+        try {
+            const response = await fetch(buildApiUrl('/api/playlists', { path }));
+            if (!response.ok) return;
+            ({ entries } = (await response.json()).data);
+        } catch {
+            return;
+        }
+        const tracks = entries.filter(entry => entry.mime_type.startsWith('audio/'));
+        if (tracks.length === 0) {
+            const video = entries.find(entry => entry.mime_type.startsWith('video/'));
+            if (video) this.playVideo(video.path);
+            return;
+        }
+        this.stop();
+        this.playlist = tracks.map(({ path: trackPath, mime_type, title, name }) => ({ path: trackPath, mime_type, name: title || name }));
+        this.originalPlaylist = [...this.playlist];
+        this.currentIndex = 0;
+        if (this.playbackMode.main === 'shuffle' || this.playbackMode.main === 'smart_shuffle') {
+            this.shufflePlaylist();
+        }
+        this.currentDirectory = path.split('/').slice(0, -1).join('/');
+        this.playCurrentTrack();
+    }
+
     playVideo(path) {
         this.stop();
         this.clearPlaylist();
@@ -365,7 +396,7 @@ export class MediaPlayer {
                 const dirResult = await dirResponse.json();
                 if (dirResult.success && dirResult.data) {
                     const audioFiles = dirResult.data
-                        .filter(f => f.mime_type?.startsWith('audio/'))
+                        .filter(f => f.mime_type?.startsWith('audio/') && !isPlaylistFile(f.path))
                         .map(f => ({ path: f.path, mime_type: f.mime_type, name: f.name }));
                     if (audioFiles.length > 0) {
                         this.playlist = audioFiles;
@@ -613,6 +644,118 @@ export class MediaPlayer {
     show() { this.playerElement.style.display = 'flex'; }
     hide() { this.playerElement.style.display = 'none'; }
 
+    async loadStoryboard(path) {
+        this.storyboard = null;
+        try {
+            const response = await fetch(buildApiUrl('/api/files/storyboard', { path }));
+            if (!response.ok || this.currentMedia?.path !== path) return;
+            this.storyboard = { path, cues: MediaPlayer.parseStoryboard(await response.text()) };
+        } catch {
+            // Seek previews are optional; the player works without them.
+        }
+    }
+
+    async loadMediaInfo(path, modal) {
+        try {
+            const response = await fetch(buildApiUrl('/api/files/media-info', { path }));
+            if (!response.ok || this.videoModal !== modal) return;
+            const { data } = await response.json();
+            const info = modal.querySelector('.video-modal-info');
+            info.textContent = this.describeMedia(data, modal.querySelector('video'));
+            info.hidden = !info.textContent;
+        } catch {
+            // Media details are informational only.
+        }
+    }
+
+    async loadSubtitles(path, modal) {
+        try {
+            const response = await fetch(buildApiUrl('/api/files/subtitles', { path }));
+            if (!response.ok || this.videoModal !== modal) return;
+            const { data } = await response.json();
+            const video = modal.querySelector('video');
+            for (const subtitle of data || []) {
+                const track = document.createElement('track');
+                track.kind = 'subtitles';
+                track.label = subtitle.label;
+                if (subtitle.language) track.srclang = subtitle.language;
+                track.src = subtitle.url;
+                track.default = Boolean(subtitle.default);
+                video.appendChild(track);
+            }
+        } catch {
+            // Subtitles are optional; the video plays without them.
+        }
+    }
+
+    describeMedia(info, video) {
+        const stream = info.streams?.find(item => item.type === 'video' && !item.attachedPicture);
+        const parts = [];
+        if (info.width && info.height) parts.push(`${info.width}×${info.height}`);
+        if (stream?.codec) parts.push(stream.codec.toUpperCase());
+        if (info.duration > 0) parts.push(this.formatTime(info.duration));
+        if (stream?.codec && !MediaPlayer.canPlayCodec(video, stream.codec)) {
+            parts.push(`this browser may not play ${stream.codec.toUpperCase()}`);
+        }
+        return parts.join(' / ');
+    }
+
+    static canPlayCodec(video, codec) {
+        const types = {
+            h264: 'video/mp4; codecs="avc1.640028"',
+            hevc: 'video/mp4; codecs="hvc1.1.6.L120.90"',
+            av1: 'video/mp4; codecs="av01.0.08M.08"',
+            vp9: 'video/webm; codecs="vp9"',
+            vp8: 'video/webm; codecs="vp8"',
+            theora: 'video/ogg; codecs="theora"',
+            mpeg4: 'video/mp4; codecs="mp4v.20.9"'
+        };
+        return Boolean(types[codec] && video?.canPlayType(types[codec]));
+    }
+
+    static parseStoryboard(text) {
+        const cues = [];
+        const toSeconds = (value) => value.split(':').reduce((total, part) => total * 60 + parseFloat(part), 0);
+        for (const block of text.replace(/\r/g, '').split('\n\n')) {
+            const lines = block.split('\n').filter(Boolean);
+            const timing = lines.findIndex(line => line.includes('-->'));
+            if (timing < 0 || !lines[timing + 1]) continue;
+            const [start, end] = lines[timing].split('-->').map(value => toSeconds(value.trim().split(' ')[0]));
+            const [url, fragment = ''] = lines[timing + 1].split('#xywh=');
+            const [x, y, w, h] = fragment.split(',').map(Number);
+            if ([start, end, x, y, w, h].some(Number.isNaN)) continue;
+            cues.push({ start, end, url, x, y, w, h });
+        }
+        return cues;
+    }
+
+    showStoryboardPreview(ratio) {
+        const media = this.modalVideoElement;
+        if (this.currentMedia?.type !== 'video' || !this.storyboard || !media || !Number.isFinite(media.duration)) return;
+        const time = Math.max(0, Math.min(1, ratio)) * media.duration;
+        const cue = this.storyboard.cues.find(item => time >= item.start && time < item.end) || this.storyboard.cues.at(-1);
+        if (!cue) return;
+        const progress = this.playerElement.querySelector('.ui-progress');
+        let preview = progress.querySelector('.storyboard-preview');
+        if (!preview) {
+            preview = document.createElement('div');
+            preview.className = 'storyboard-preview';
+            preview.setAttribute('aria-hidden', 'true');
+            progress.appendChild(preview);
+        }
+        preview.style.width = `${cue.w}px`;
+        preview.style.height = `${cue.h}px`;
+        preview.style.backgroundImage = `url("${cue.url}")`;
+        preview.style.backgroundPosition = `-${cue.x}px -${cue.y}px`;
+        preview.style.left = `${Math.max(0, Math.min(1, ratio)) * 100}%`;
+        preview.hidden = false;
+    }
+
+    hideStoryboardPreview() {
+        const preview = this.playerElement?.querySelector('.storyboard-preview');
+        if (preview) preview.hidden = true;
+    }
+
     showVideoModal() {
         if (this.isVideoModalOpen) return;
         const fileName = this.currentMedia.path.split('/').pop();
@@ -624,6 +767,7 @@ export class MediaPlayer {
                     <div class="video-modal-header-main">
                         <div class="dialog-title"></div>
                         <div class="video-modal-meta">Esc: close / Space: play-pause / ← →: seek 5s</div>
+                        <div class="video-modal-meta video-modal-info" hidden></div>
                     </div>
                     <button class="dialog-close" type="button" aria-label="Close">&times;</button>
                 </div>
@@ -650,6 +794,9 @@ export class MediaPlayer {
         document.addEventListener('keydown', this.boundVideoModalKeydown);
         video.focus();
         video.addEventListener('loadedmetadata', () => this.updateProgress());
+        this.loadStoryboard(this.currentMedia.path);
+        this.loadMediaInfo(this.currentMedia.path, modal);
+        this.loadSubtitles(this.currentMedia.path, modal);
         video.addEventListener('play', () => { this.isPlaying = true; this.updatePlayButton(); });
         video.addEventListener('pause', () => { this.isPlaying = false; this.updatePlayButton(); });
         video.addEventListener('timeupdate', () => this.updateProgress());
@@ -667,6 +814,8 @@ export class MediaPlayer {
             this.modalVideoElement.pause();
             this.modalVideoElement.src = '';
         }
+        this.storyboard = null;
+        this.hideStoryboardPreview();
         hideModalOverlay(this.videoModal);
         this.videoModal.remove();
         this.videoModal = null;
@@ -0,0 +1,29 @@
+import assert from 'node:assert/strict';
+import test from 'node:test';
+import { MediaPlayer } from './media-player.js';
+
+test('parseStoryboard reads thumbnail cues with sprite fragments', () => {
+    const cues = MediaPlayer.parseStoryboard([
+        'WEBVTT',
+        '',
+        '00:00:00.000 --> 00:00:06.000',
+        '/api/files/storyboard/sprite?frames=100&path=%2Fa.mp4#xywh=0,0,160,90',
+        '',
+        '01:00:06.000 --> 01:00:12.500',
+        '/api/files/storyboard/sprite?frames=100&path=%2Fa.mp4#xywh=160,90,160,90',
+        ''
+    ].join('\n'));
+
+    assert.deepEqual(cues.map(({ start, end, x, y, w, h }) => [start, end, x, y, w, h]), [
+        [0, 6, 0, 0, 160, 90],
+        [3606, 3612.5, 160, 90, 160, 90]
+    ]);
+    assert.equal(cues[0].url, '/api/files/storyboard/sprite?frames=100&path=%2Fa.mp4');
+});
+
+test('canPlayCodec asks the video element about known codecs only', () => {
+    const video = { canPlayType: type => (type.includes('avc1') ? 'probably' : '') };
+    assert.equal(MediaPlayer.canPlayCodec(video, 'h264'), true);
+    assert.equal(MediaPlayer.canPlayCodec(video, 'hevc'), false);
+    assert.equal(MediaPlayer.canPlayCodec(video, 'wmv3'), false);
+});
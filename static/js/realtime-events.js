@@ -5,13 +5,22 @@ export class RealtimeEvents {
         this.source = null;
         this.latestEvents = new Map();
         this.aria2Enabled = false;
+        this.lastEventId = '';
     }
 
     start() {
         if (this.source || typeof this.EventSourceClass !== 'function') return false;
-        const url = this.aria2Enabled ? '/api/events?aria2=1' : '/api/events';
-        this.source = new this.EventSourceClass(url);
-        for (const type of ['sync', 'aria2', 'upload']) {
+        // EventSource replays from Last-Event-ID on its own reconnects; a
+        // stream reopened here passes it explicitly so only missed events follow.
+        const params = new URLSearchParams();
+        if (this.aria2Enabled) params.set('aria2', '1');
+        if (this.lastEventId) params.set('lastEventId', this.lastEventId);
+        const query = params.toString();
+        this.source = new this.EventSourceClass(query ? `/api/events?${query}` : '/api/events');
+        for (const type of [
+            'sync', 'aria2', 'upload', 'move', 'job', 'directory-changed',
+            'files-created', 'files-deleted', 'files-moved', 'file-saved', 'archive-extracted'
+        ]) {
             this.source.addEventListener(type, event => this.handle(type, event));
         }
         return true;
@@ -20,6 +29,7 @@ export class RealtimeEvents {
     handle(type, event) {
         try {
             const detail = JSON.parse(event.data);
+            if (event.lastEventId) this.lastEventId = event.lastEventId;
             this.latestEvents.set(type, detail);
             this.app.emit(`server:${type}`, detail);
         } catch (error) {
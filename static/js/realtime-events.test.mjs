@@ -55,3 +55,12 @@ test('reconnects with aria2 updates only while requested', () => {
     assert.equal(aria2Source.closed, true);
     assert.equal(realtime.source.url, '/api/events');
 });
+
+test('reopened streams resume after the last received event', () => {
+    const realtime = new RealtimeEvents({ emit: () => {} }, FakeEventSource);
+    realtime.start();
+    realtime.source.listeners.get('files-created')({ data: '{"directory":"/"}', lastEventId: '42' });
+    realtime.setAria2Enabled(true);
+
+    assert.equal(realtime.source.url, '/api/events?aria2=1&lastEventId=42');
+});
@@ -7,7 +7,7 @@ export class SearchHandler {
     constructor(fileManager) {
         this.fileManager = fileManager;
         this.isSearchOpen = false;
-        this.searchOptions = { useRegex: false, caseSensitive: false, scope: 'current' };
+        this.searchOptions = { useRegex: false, caseSensitive: false, scope: 'current', mode: 'name' };
         this.lastSearchTerm = '';
         this.lastSearchResults = null;
         this.currentPage = 0;
@@ -367,6 +367,7 @@ export class SearchHandler {
             this.searchOptions.useRegex = this.searchModal.querySelector('#search-use-regex').checked;
             this.searchOptions.caseSensitive = this.searchModal.querySelector('#search-case-sensitive').checked;
             this.searchOptions.scope = this.searchModal.querySelector('#search-scope').value;
+            this.searchOptions.mode = this.searchModal.querySelector('#search-mode').value;
         }
     }
 
@@ -423,7 +424,20 @@ export class SearchHandler {
         this.searchController = controller;
         this.fileManager.ui.showLoading();
         try {
-            const result = await this.fileManager.api.search(searchTerm, this.fileManager.router.getCurrentPath(), this.searchOptions, this.pageSize, cursor, controller.signal);
+            let lastRender = 0;
+            const onFrame = (event, data, results) => {
+                if (requestId !== this.fileManager.store.getState().search.requestId) return;
+                // Show matches while deeper directories are still being scanned.
+                if (Date.now() - lastRender < 300) return;
+                lastRender = Date.now();
+                this.hasMore = false;
+                this.displaySearchResults(results, searchTerm, page);
+                if (event === 'progress') {
+                    const info = document.querySelector('.search-results-header .pagination-info');
+                    if (info) info.textContent = `Scanned ${data.directories} directories · ${data.matches} found`;
+                }
+            };
+            const result = await this.fileManager.api.searchStream(searchTerm, this.fileManager.router.getCurrentPath(), this.searchOptions, this.pageSize, cursor, controller.signal, onFrame);
             if (requestId !== this.fileManager.store.getState().search.requestId || controller.signal.aborted) return;
             if (result && result.success) {
                 const searchPage = result.data || {};
@@ -479,6 +493,7 @@ export class SearchHandler {
         if (this.searchOptions.useRegex) options.push('REGEX');
         if (this.searchOptions.caseSensitive) options.push('CASE-SENSITIVE');
         if (this.searchOptions.scope === 'recursive') options.push('RECURSIVE');
+        if (this.searchOptions.mode === 'content') options.push('CONTENT');
         if (options.length > 0) {
             headerTemplate.querySelector('.search-options-display').textContent = `[${options.join(', ')}]`;
         }
@@ -507,6 +522,9 @@ export class SearchHandler {
             this.sortDirection,
             (field) => this.setSort(field)
         );
+        if (results.some(file => file.matches)) {
+            container.appendChild(this.createContentMatchList(results));
+        }
 
         if (page > 0 || this.hasMore) {
             const footerPagination = document.createElement('div');
@@ -517,6 +535,36 @@ export class SearchHandler {
         container.scrollTop = 0;
     }
 
+    createContentMatchList(results) {
+        const list = document.createElement('div');
+        list.className = 'search-content-matches';
+        for (const file of results) {
+            const section = document.createElement('section');
+            const title = document.createElement('div');
+            title.className = 'search-content-path';
+            title.textContent = file.truncated ? `${file.path} (more matches not shown)` : file.path;
+            section.appendChild(title);
+            for (const match of file.matches || []) {
+                const pre = document.createElement('pre');
+                pre.className = 'search-content-snippet';
+                const lines = [
+                    ...(match.before || []).map((text, i, before) => [match.line - before.length + i, text, false]),
+                    [match.line, match.text, true],
+                    ...(match.after || []).map((text, i) => [match.line + i + 1, text, false])
+                ];
+                for (const [number, text, hit] of lines) {
+                    const line = document.createElement('div');
+                    line.className = hit ? 'search-content-line hit' : 'search-content-line';
+                    line.textContent = `${number}: ${text}`;
+                    pre.appendChild(line);
+                }
+                section.appendChild(pre);
+            }
+            list.appendChild(section);
+        }
+        return list;
+    }
+
     exitSearchMode(preventNavigation = false) {
         this.isInSearchMode = false;
         this.lastSearchResults = null;
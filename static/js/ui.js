@@ -589,7 +589,11 @@ export class UIManager {
         img.onload = () => item.style.gridRowEnd = `span ${Math.round((img.naturalHeight / img.naturalWidth) * 20)}`;
         img.onerror = () => img.style.display = 'none';
 
-        this.prepareLazyImage(img, buildApiUrl('/api/files/content', { path: file.path }));
+        const nativeThumbnail = /\.(jpe?g|png|gif|webp)$/i.test(file.name);
+        const imageUrl = nativeThumbnail
+            ? buildApiUrl('/api/files/thumbnail', { path: file.path, size: window.devicePixelRatio > 1 ? 640 : 320 })
+            : buildApiUrl('/api/files/content', { path: file.path });
+        this.prepareLazyImage(img, imageUrl);
 
         template.querySelector('.masonry-name').textContent = file.name;
         template.querySelector('.masonry-size').textContent = this.formatFileSize(file.size);
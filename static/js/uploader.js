@@ -377,6 +377,22 @@ export class Uploader {
         return sha256Hex(bytes);
     }
 
+    // The server discards a chunk whose Content-Digest does not match, so bytes
+    // damaged in transit are resent instead of completing a corrupt file. The
+    // synchronous fallback is too slow for every chunk; without Web Crypto the
+    // header is omitted.
+    async chunkDigest(blob) {
+        const subtle = globalThis.crypto?.subtle;
+        if (!subtle?.digest) return '';
+        try {
+            const digest = new Uint8Array(await subtle.digest('SHA-256', await blob.arrayBuffer()));
+            return `sha-256=:${btoa(String.fromCharCode(...digest))}:`;
+        } catch (error) {
+            console.warn('Web Crypto digest failed; sending the chunk without Content-Digest', error);
+            return '';
+        }
+    }
+
     async createUploadItems(fileList) {
         const items = new Array(fileList.length);
         for (let index = 0; index < fileList.length; index++) {
@@ -499,7 +515,7 @@ export class Uploader {
         }
         const created = await this.apiJSON('/api/files/upload-sessions', {
             method: 'POST', signal: session.signal,
-            body: JSON.stringify({ path: destination, relativePath: item.relativePath, size: item.file.size, fingerprint })
+            body: JSON.stringify({ path: destination, relativePath: item.relativePath, size: item.file.size, fingerprint, lastModified: item.file.lastModified || 0 })
         });
         const record = { key, id: created.uploadId, url: created.uploadURL, destination, relativePath: item.relativePath, size: item.file.size, fingerprint, updatedAt: Date.now() };
         try { await this.store.put(record); this.notifyJobsChanged(record.id); } catch (_) { /* upload itself must still work */ }
@@ -511,7 +527,7 @@ export class Uploader {
         return state.uploadedBytes;
     }
 
-    sendChunk(record, blob, start, end, total, session, onProgress, controller) {
+    sendChunk(record, blob, start, end, total, session, onProgress, controller, digest = '') {
         return new Promise((resolve, reject) => {
             const xhr = new XMLHttpRequest();
             const startedAt = performance.now();
@@ -543,6 +559,7 @@ export class Uploader {
             xhr.open('PUT', `${record.url}/chunks`);
             xhr.setRequestHeader('Content-Range', `bytes ${start}-${end}/${total}`);
             xhr.setRequestHeader('Content-Type', 'application/octet-stream');
+            if (digest) xhr.setRequestHeader('Content-Digest', digest);
             xhr.send(blob);
         });
     }
@@ -558,8 +575,9 @@ export class Uploader {
             for (let attempt = 0; attempt < MAX_RETRIES && !completed; attempt++) {
                 try {
                     // Blob.slice is lazy; it does not load the complete file into JS memory.
-                    const result = await this.sendChunk(record, item.file.slice(offset, end + 1), offset, end, item.file.size, session,
-                        uploaded => this.setFileProgress(record.key, item, uploaded, 'Uploading'), controller);
+                    const chunk = item.file.slice(offset, end + 1);
+                    const result = await this.sendChunk(record, chunk, offset, end, item.file.size, session,
+                        uploaded => this.setFileProgress(record.key, item, uploaded, 'Uploading'), controller, await this.chunkDigest(chunk));
                     offset = result.uploadedBytes ?? end + 1;
                     completed = true;
                 } catch (error) {
@@ -30,6 +30,21 @@ test('file fingerprints work without Web Crypto', async () => {
     }
 });
 
+test('chunk digests use the RFC 9530 Content-Digest format', async () => {
+    const uploader = Object.create(Uploader.prototype);
+    const contents = 'chunk contents';
+    assert.equal(await uploader.chunkDigest(mockFile(contents).slice(0, contents.length)),
+        `sha-256=:${createHash('sha256').update(contents).digest('base64')}:`);
+
+    const cryptoDescriptor = Object.getOwnPropertyDescriptor(globalThis, 'crypto');
+    try {
+        Object.defineProperty(globalThis, 'crypto', { configurable: true, value: undefined });
+        assert.equal(await uploader.chunkDigest(new Blob([contents])), '');
+    } finally {
+        Object.defineProperty(globalThis, 'crypto', cryptoDescriptor);
+    }
+});
+
 test('server concurrency capacity of one is not raised to the client minimum', () => {
     const controller = new AdaptiveUploadController();
     controller.target = 2;
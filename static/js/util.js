@@ -68,6 +68,10 @@ export function getBaseName(path) {
     return parts.length > 0 ? parts[parts.length - 1] : '';
 }
 
+export function isPlaylistFile(path) {
+    return ['m3u', 'm3u8', 'pls'].includes(path.split('.').pop().toLowerCase());
+}
+
 export function isEditableFile(path) {
     const ext = path.split('.').pop().toLowerCase();
     const editableExts = ['txt', 'md', 'json', 'xml', 'html', 'css', 'js', 'py', 'go', 'java', 'c', 'cpp', 'h', 'sh', 'bat', 'yaml', 'yml', 'toml', 'ini', 'conf', 'env'];
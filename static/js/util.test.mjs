@@ -1,6 +1,6 @@
 import assert from 'node:assert/strict';
 import test from 'node:test';
-import { createUniqueId, getBaseName, getParentPath, isEditableFile, isValidPath, normalizePath } from './util.js';
+import { createUniqueId, getBaseName, getParentPath, isEditableFile, isPlaylistFile, isValidPath, normalizePath } from './util.js';
 
 test('uses randomUUID when it is available', () => {
     assert.equal(createUniqueId({ randomUUID: () => 'native-id' }), 'native-id');
@@ -26,4 +26,7 @@ test('validates paths and editable extensions', () => {
     assert.equal(isValidPath('../outside'), false);
     assert.equal(isEditableFile('/notes/today.MD'), true);
     assert.equal(isEditableFile('/video/movie.mp4'), false);
+    assert.equal(isPlaylistFile('/music/album/Album.M3U8'), true);
+    assert.equal(isPlaylistFile('/music/radio.pls'), true);
+    assert.equal(isPlaylistFile('/music/track.mp3'), false);
 });